@@ -7,17 +7,40 @@ import (
 	"time"
 )
 
-var ErrInsufficientBalance = errors.New("Insufficient Balance")
+const (
+	ADJUSTMENT_REFUND           = "refund"
+	ADJUSTMENT_DISPUTE          = "dispute"
+	ADJUSTMENT_DISPUTE_REVERSAL = "dispute_reversal"
+)
+
+var (
+	ErrInsufficientBalance     = errors.New("Insufficient Balance")
+	ErrAwardAmountInvalid      = errors.New("Award Amount Must Be Positive")
+	ErrAwardsAlreadyRecorded   = errors.New("Awards Already Recorded")
+	ErrPurchaseAlreadyRecorded = errors.New("Purchase Already Recorded")
+	ErrPurchaseNotFound        = errors.New("Purchase Not Found")
+	ErrAdjustmentUnrecognized  = errors.New("Unrecognized Adjustment")
+	ErrBalanceNegative         = errors.New("Spending Blocked While Balance Is Negative")
+)
 
 type AccountDatabase interface {
 	SelectUser(string) (int64, string, []byte, time.Time, error)
+	SelectUserByID(int64) (string, string, time.Time, error)
 	SelectChargesForUser(int64) (int64, error)
 	SelectYieldsForUser(int64) (int64, error)
 	SelectPurchasesForUser(int64) (int64, error)
 	SelectAwardsForUser(int64) (int64, error)
 	SelectGiftsForUser(int64) (int64, error)
 	SelectGiftsFromUser(int64) (int64, error)
+	SelectReversalsForUser(int64) (int64, error)
+	SelectReversals(int64, func(int64, int64, int64, int64, string, int64, time.Time) error) error
+	SelectPayoutsForUser(int64) (int64, error)
+	SelectPurchaseAdjustmentsForUser(int64) (int64, error)
 	CreatePurchase(int64, string, string, string, string, int64, int64, time.Time) (int64, error)
+	SelectPurchaseByPaymentIntent(string) (int64, int64, string, int64, int64, error)
+	CreatePurchaseAdjustment(int64, int64, string, string, time.Time, func(int64) (int64, int64)) (int64, int64, error)
+	CreateAward(int64, string, int64, time.Time) (int64, error)
+	CreateEditionAwards(string, string, map[int64]int64, time.Time) error
 }
 
 func AccountBalance(db AccountDatabase, user int64) (int64, error) {
@@ -45,13 +68,30 @@ func AccountBalance(db AccountDatabase, user int64) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return received + awards + purchases + yields - charges - given, nil
+	reversals, err := db.SelectReversalsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	payouts, err := db.SelectPayoutsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	adjustments, err := db.SelectPurchaseAdjustmentsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	return received + awards + purchases + adjustments + yields + reversals - charges - given - payouts, nil
 }
 
 type AccountManager interface {
 	Account(string) (*authgo.Account, error)
+	AccountByID(int64) (*authgo.Account, error)
 	AccountBalance(int64) (int64, error)
 	NewPurchase(int64, string, string, string, string, int64, int64) error
+	NewAward(int64, string, int64) error
+	NewEditionAwards(string, string, map[int64]int64) error
+	AdjustPurchase(string, string, int64) (int64, int64, error)
+	LookupReversals(*authgo.Account, func(*Reversal) error) error
 }
 
 func NewAccountManager(db AccountDatabase) AccountManager {
@@ -77,6 +117,19 @@ func (m *accountManager) Account(username string) (*authgo.Account, error) {
 	}, nil
 }
 
+func (m *accountManager) AccountByID(id int64) (*authgo.Account, error) {
+	username, email, created, err := m.database.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return &authgo.Account{
+		ID:       id,
+		Username: username,
+		Email:    email,
+		Created:  created,
+	}, nil
+}
+
 func (m *accountManager) AccountBalance(user int64) (int64, error) {
 	return AccountBalance(m.database, user)
 }
@@ -90,3 +143,91 @@ func (m *accountManager) NewPurchase(user int64, sessionID, customerID, paymentI
 	log.Println("Created Purchase", purchase)
 	return nil
 }
+
+func (m *accountManager) NewAward(user int64, reason string, amount int64) error {
+	if amount <= 0 {
+		return ErrAwardAmountInvalid
+	}
+	created := time.Now()
+	award, err := m.database.CreateAward(user, reason, amount, created)
+	if err != nil {
+		return err
+	}
+	log.Println("Created Award", award)
+	return nil
+}
+
+// NewEditionAwards records the awards for a digest edition, keyed by user, all together or not at all, and only once per edition.
+func (m *accountManager) NewEditionAwards(edition, reason string, awards map[int64]int64) error {
+	for _, amount := range awards {
+		if amount <= 0 {
+			return ErrAwardAmountInvalid
+		}
+	}
+	created := time.Now()
+	if err := m.database.CreateEditionAwards(edition, reason, awards, created); err != nil {
+		return err
+	}
+	log.Println("Created", len(awards), "Awards for", edition)
+	return nil
+}
+
+// AdjustPurchase brings the adjustments of the given reason on the purchase made with the payment intent in line with the given amount of currency, which Stripe reports cumulatively, and returns the purchaser and the change in coins.
+func (m *accountManager) AdjustPurchase(paymentIntentID, reason string, amount int64) (int64, int64, error) {
+	var sign int64
+	switch reason {
+	case ADJUSTMENT_REFUND, ADJUSTMENT_DISPUTE:
+		sign = -1
+	case ADJUSTMENT_DISPUTE_REVERSAL:
+		sign = 1
+	default:
+		return 0, 0, ErrAdjustmentUnrecognized
+	}
+
+	purchase, user, currency, total, size, err := m.database.SelectPurchaseByPaymentIntent(paymentIntentID)
+	if err != nil {
+		log.Println(err)
+		return 0, 0, ErrPurchaseNotFound
+	}
+
+	// Convert currency into coins in proportion to the purchase
+	coins := size
+	if total > 0 && amount < total {
+		coins = size * amount / total
+	}
+
+	// The database locks the purchase while the existing adjustments are compared, so a redelivered event, on any server, cannot be applied twice
+	created := time.Now()
+	adjustment, delta, err := m.database.CreatePurchaseAdjustment(purchase, user, reason, currency, created, func(existing int64) (int64, int64) {
+		delta := sign*coins - existing
+		if size > 0 {
+			return delta * total / size, delta
+		}
+		return amount, delta
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if delta == 0 {
+		// Nothing to do
+		return user, 0, nil
+	}
+	log.Println("Created Purchase Adjustment", adjustment)
+	return user, delta, nil
+}
+
+// LookupReversals lists the coins returned to, or reclaimed from, the account when content was deleted, newest first.
+func (m *accountManager) LookupReversals(account *authgo.Account, callback func(*Reversal) error) error {
+	return m.database.SelectReversals(account.ID, func(id, conversation, message, gift int64, reason string, amount int64, created time.Time) error {
+		return callback(&Reversal{
+			ID:             id,
+			Account:        account,
+			ConversationID: conversation,
+			MessageID:      message,
+			GiftID:         gift,
+			Reason:         reason,
+			Amount:         amount,
+			Created:        created,
+		})
+	})
+}
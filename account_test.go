@@ -6,6 +6,7 @@ import (
 	"aletheiaware.com/conveyearthgo"
 	"aletheiaware.com/conveyearthgo/database"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,6 +25,23 @@ func TestAccount(t *testing.T) {
 	assert.Equal(t, now, a.Created)
 }
 
+func TestAccountByID(t *testing.T) {
+	now := time.Now()
+	db := database.NewInMemory()
+	id, err := db.CreateUser(authtest.TEST_EMAIL, authtest.TEST_USERNAME, []byte(authtest.TEST_PASSWORD), now)
+	assert.NoError(t, err)
+	am := conveyearthgo.NewAccountManager(db)
+	a, err := am.AccountByID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, a.ID)
+	assert.Equal(t, authtest.TEST_EMAIL, a.Email)
+	assert.Equal(t, authtest.TEST_USERNAME, a.Username)
+	assert.Equal(t, now, a.Created)
+
+	_, err = am.AccountByID(id + 1)
+	assert.Error(t, err)
+}
+
 func TestAccountBalance(t *testing.T) {
 	u1 := authtest.TEST_USER_ID
 	u2 := authtest.TEST_USER_ID + 1
@@ -33,6 +51,7 @@ func TestAccountBalance(t *testing.T) {
 		purchase1, purchase2,
 		award1, award2,
 		gift1, gift2,
+		reversal1, reversal2,
 		amount1, amount2 int64
 	}{
 		"Empty": {},
@@ -68,6 +87,12 @@ func TestAccountBalance(t *testing.T) {
 			amount1: -43,
 			amount2: 43,
 		},
+		"Reversal": {
+			reversal1: 34,
+			reversal2: -43,
+			amount1:   34,
+			amount2:   -43,
+		},
 		"Charge_Yield": {
 			charge1: 34,
 			yield1:  56,
@@ -195,11 +220,11 @@ func TestAccountBalance(t *testing.T) {
 			assert.NoError(t, err)
 
 			// User 1 purchases coins
-			_, err = db.CreatePurchase(u1, "", "", "", "", 0, tt.purchase1, now)
+			_, err = db.CreatePurchase(u1, "session1", "", "", "", 0, tt.purchase1, now)
 			assert.NoError(t, err)
 
 			// User 2 purchases coins
-			_, err = db.CreatePurchase(u2, "", "", "", "", 0, tt.purchase2, now)
+			_, err = db.CreatePurchase(u2, "session2", "", "", "", 0, tt.purchase2, now)
 			assert.NoError(t, err)
 
 			// User 1 receives an award
@@ -216,6 +241,14 @@ func TestAccountBalance(t *testing.T) {
 			db.AwardAmount[id] = tt.award2
 			db.AwardCreated[id] = now
 
+			// User 1 is refunded
+			_, err = db.CreateReversal(u1, c1, m1, 0, conveyearthgo.REVERSAL_CHARGE_REFUND, tt.reversal1, now)
+			assert.NoError(t, err)
+
+			// User 2 is debited
+			_, err = db.CreateReversal(u2, c1, m2, 0, conveyearthgo.REVERSAL_YIELD_REVERSAL, tt.reversal2, now)
+			assert.NoError(t, err)
+
 			am := conveyearthgo.NewAccountManager(db)
 
 			// Check User 1 balance
@@ -258,4 +291,180 @@ func TestNewPurchase(t *testing.T) {
 	assert.Equal(t, currency, db.PurchaseStripeCurrency[id])
 	assert.Equal(t, amount, db.PurchaseStripeAmount[id])
 	assert.Equal(t, size, db.PurchaseBundleSize[id])
+
+	// Recording the same session again fails
+	err = am.NewPurchase(authtest.TEST_USER_ID, sessionID, customerID, paymentIntentID, currency, amount, size)
+	assert.Equal(t, conveyearthgo.ErrPurchaseAlreadyRecorded, err)
+	balance, err := am.AccountBalance(authtest.TEST_USER_ID)
+	assert.NoError(t, err)
+	assert.Equal(t, size, balance)
+}
+
+func TestNewAward(t *testing.T) {
+	reason := "Convey Digest January 2022"
+	amount := int64(500)
+	db := database.NewInMemory()
+	am := conveyearthgo.NewAccountManager(db)
+	t.Run("Success", func(t *testing.T) {
+		err := am.NewAward(authtest.TEST_USER_ID, reason, amount)
+		assert.NoError(t, err)
+
+		// Pick first award id
+		var id int64
+		for i, ok := range db.AwardId {
+			if ok {
+				id = i
+				break
+			}
+		}
+		assert.Equal(t, authtest.TEST_USER_ID, db.AwardUser[id])
+		assert.Equal(t, reason, db.AwardReason[id])
+		assert.Equal(t, amount, db.AwardAmount[id])
+
+		balance, err := am.AccountBalance(authtest.TEST_USER_ID)
+		assert.NoError(t, err)
+		assert.Equal(t, amount, balance)
+	})
+	t.Run("Invalid Amount", func(t *testing.T) {
+		assert.Equal(t, conveyearthgo.ErrAwardAmountInvalid, am.NewAward(authtest.TEST_USER_ID, reason, 0))
+		assert.Equal(t, conveyearthgo.ErrAwardAmountInvalid, am.NewAward(authtest.TEST_USER_ID, reason, -1))
+	})
+}
+
+func TestNewEditionAwards(t *testing.T) {
+	edition := "2022-01"
+	reason := "Convey Digest January 2022"
+	db := database.NewInMemory()
+	am := conveyearthgo.NewAccountManager(db)
+	t.Run("Success", func(t *testing.T) {
+		assert.NoError(t, am.NewEditionAwards(edition, reason, map[int64]int64{
+			1: 500,
+			2: 250,
+		}))
+
+		b1, err := am.AccountBalance(1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(500), b1)
+		b2, err := am.AccountBalance(2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(250), b2)
+	})
+	t.Run("Already Recorded", func(t *testing.T) {
+		assert.Equal(t, conveyearthgo.ErrAwardsAlreadyRecorded, am.NewEditionAwards(edition, reason, map[int64]int64{
+			2: 250,
+			3: 100,
+		}))
+
+		// Nothing is recorded when any award is a duplicate
+		b3, err := am.AccountBalance(3)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), b3)
+	})
+	t.Run("Invalid Amount", func(t *testing.T) {
+		assert.Equal(t, conveyearthgo.ErrAwardAmountInvalid, am.NewEditionAwards("2022-02", reason, map[int64]int64{
+			1: 500,
+			2: 0,
+		}))
+	})
+}
+
+func TestAdjustPurchase(t *testing.T) {
+	type adjustment struct {
+		reason string
+		amount int64
+		delta  int64
+	}
+	for name, tt := range map[string]struct {
+		adjustments []adjustment
+		balance     int64
+	}{
+		"Full Refund": {
+			adjustments: []adjustment{
+				{conveyearthgo.ADJUSTMENT_REFUND, 500, -1000},
+			},
+			balance: 0,
+		},
+		"Partial Refunds": {
+			adjustments: []adjustment{
+				{conveyearthgo.ADJUSTMENT_REFUND, 100, -200},
+				{conveyearthgo.ADJUSTMENT_REFUND, 250, -300},
+			},
+			balance: 500,
+		},
+		"Redelivered Refund": {
+			adjustments: []adjustment{
+				{conveyearthgo.ADJUSTMENT_REFUND, 250, -500},
+				{conveyearthgo.ADJUSTMENT_REFUND, 250, 0},
+			},
+			balance: 500,
+		},
+		"Dispute Lost": {
+			adjustments: []adjustment{
+				{conveyearthgo.ADJUSTMENT_DISPUTE, 500, -1000},
+				{conveyearthgo.ADJUSTMENT_DISPUTE, 500, 0},
+			},
+			balance: 0,
+		},
+		"Dispute Won": {
+			adjustments: []adjustment{
+				{conveyearthgo.ADJUSTMENT_DISPUTE, 500, -1000},
+				{conveyearthgo.ADJUSTMENT_DISPUTE_REVERSAL, 500, 1000},
+			},
+			balance: 1000,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			am := conveyearthgo.NewAccountManager(db)
+			assert.NoError(t, am.NewPurchase(authtest.TEST_USER_ID, "session", "customer", "pi_test", "usd", 500, 1000))
+			for _, a := range tt.adjustments {
+				user, delta, err := am.AdjustPurchase("pi_test", a.reason, a.amount)
+				assert.NoError(t, err)
+				assert.Equal(t, authtest.TEST_USER_ID, user)
+				assert.Equal(t, a.delta, delta)
+			}
+			balance, err := am.AccountBalance(authtest.TEST_USER_ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance, balance)
+		})
+	}
+	t.Run("Concurrent Redelivery", func(t *testing.T) {
+		db := database.NewInMemory()
+		assert.NoError(t, conveyearthgo.NewAccountManager(db).NewPurchase(authtest.TEST_USER_ID, "session", "customer", "pi_test", "usd", 500, 1000))
+		// Each server has its own manager, so only the database can apply the refund once
+		var (
+			wg     sync.WaitGroup
+			deltas = make([]int64, 10)
+		)
+		for i := range deltas {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, delta, err := conveyearthgo.NewAccountManager(db).AdjustPurchase("pi_test", conveyearthgo.ADJUSTMENT_REFUND, 500)
+				assert.NoError(t, err)
+				deltas[i] = delta
+			}(i)
+		}
+		wg.Wait()
+		var total int64
+		for _, d := range deltas {
+			total += d
+		}
+		assert.Equal(t, int64(-1000), total)
+		balance, err := conveyearthgo.NewAccountManager(db).AccountBalance(authtest.TEST_USER_ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), balance)
+	})
+	t.Run("Unknown Payment Intent", func(t *testing.T) {
+		db := database.NewInMemory()
+		am := conveyearthgo.NewAccountManager(db)
+		_, _, err := am.AdjustPurchase("pi_unknown", conveyearthgo.ADJUSTMENT_REFUND, 500)
+		assert.Equal(t, conveyearthgo.ErrPurchaseNotFound, err)
+	})
+	t.Run("Unrecognized Reason", func(t *testing.T) {
+		db := database.NewInMemory()
+		am := conveyearthgo.NewAccountManager(db)
+		_, _, err := am.AdjustPurchase("pi_test", "unknown", 500)
+		assert.Equal(t, conveyearthgo.ErrAdjustmentUnrecognized, err)
+	})
 }
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MAXIMUM_ATTACHMENTS is the number of files which can be attached to a message, in addition to its text.
+const MAXIMUM_ATTACHMENTS = 10
+
+var ErrAttachmentsExceeded = &Error{Status: http.StatusBadRequest, Code: "too_many_attachments", Message: fmt.Sprintf("No More Than %d Attachments", MAXIMUM_ATTACHMENTS)}
+
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+type Conversation struct {
+	ID       int64      `json:"id"`
+	Author   string     `json:"author"`
+	Topic    string     `json:"topic"`
+	Cost     int64      `json:"cost"`
+	Yield    int64      `json:"yield"`
+	Messages []*Message `json:"messages,omitempty"`
+	Created  time.Time  `json:"created"`
+}
+
+type ConversationList struct {
+	Conversations []*Conversation `json:"conversations"`
+}
+
+type Message struct {
+	ID           int64     `json:"id"`
+	Conversation int64     `json:"conversation"`
+	Parent       int64     `json:"parent"`
+	Author       string    `json:"author"`
+	Cost         int64     `json:"cost"`
+	Yield        int64     `json:"yield"`
+	Files        []*File   `json:"files"`
+	Created      time.Time `json:"created"`
+}
+
+type File struct {
+	ID      int64     `json:"id"`
+	Message int64     `json:"message"`
+	Hash    string    `json:"hash"`
+	Mime    string    `json:"mime"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+}
+
+type Gift struct {
+	ID           int64     `json:"id"`
+	Conversation int64     `json:"conversation"`
+	Message      int64     `json:"message"`
+	Author       string    `json:"author"`
+	Amount       int64     `json:"amount"`
+	Created      time.Time `json:"created"`
+}
+
+type GiftList struct {
+	Gifts []*Gift `json:"gifts"`
+}
+
+type Balance struct {
+	Username string `json:"username"`
+	Balance  int64  `json:"balance"`
+}
+
+type Notification struct {
+	ID           int64     `json:"id"`
+	Kind         string    `json:"kind"`
+	Actor        string    `json:"actor"`
+	Conversation int64     `json:"conversation"`
+	Message      int64     `json:"message"`
+	Topic        string    `json:"topic"`
+	Amount       int64     `json:"amount"`
+	Read         bool      `json:"read"`
+	Created      time.Time `json:"created"`
+}
+
+type NotificationList struct {
+	Notifications []*Notification `json:"notifications"`
+	Unread        int64           `json:"unread"`
+}
+
+// Attachment is a file attached to a message, its data is base64 encoded in JSON.
+type Attachment struct {
+	Mime string `json:"mime"`
+	Data []byte `json:"data"`
+}
+
+type PublishRequest struct {
+	Topic       string        `json:"topic"`
+	Content     string        `json:"content"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+}
+
+type ReplyRequest struct {
+	Conversation int64         `json:"conversation"`
+	Parent       int64         `json:"parent"`
+	Content      string        `json:"content"`
+	Attachments  []*Attachment `json:"attachments,omitempty"`
+}
+
+type GiftRequest struct {
+	Conversation int64 `json:"conversation"`
+	Message      int64 `json:"message"`
+	Amount       int64 `json:"amount"`
+}
+
+type ReadRequest struct {
+	ID int64 `json:"id"`
+}
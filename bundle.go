@@ -1,7 +1,204 @@
 package conveyearthgo
 
 import (
+	"encoding/json"
 	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/product"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var ErrBundleUnrecognized = errors.New("Unrecognized Bundle")
+
+// Bundle is a number of coins sold at a Stripe Price.
+type Bundle struct {
+	Name      string
+	ProductID string
+	PriceID   string
+	Currency  string
+	Amount    int64
+	Size      int64
+}
+
+type BundleCatalog interface {
+	Bundles() []*Bundle
+	Bundle(string) (*Bundle, error)
+	Refresh() error
+}
+
+// RefreshBundleCatalog refreshes the catalog now and then at the given interval, until the returned function is called.
+func RefreshBundleCatalog(c BundleCatalog, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			if err := c.Refresh(); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// NewStripeBundleCatalog lists the active Prices of the active Products whose domain metadata matches the given domain, and caches them until the next refresh.
+func NewStripeBundleCatalog(domain string) BundleCatalog {
+	return &stripeBundleCatalog{
+		domain: domain,
+	}
+}
+
+type stripeBundleCatalog struct {
+	sync.RWMutex
+	domain  string
+	bundles []*Bundle
+}
+
+func (c *stripeBundleCatalog) Bundles() []*Bundle {
+	c.RLock()
+	defer c.RUnlock()
+	return c.bundles
+}
+
+func (c *stripeBundleCatalog) Bundle(id string) (*Bundle, error) {
+	return findBundle(c.Bundles(), id)
+}
+
+func (c *stripeBundleCatalog) Refresh() error {
+	var bundles []*Bundle
+	products := product.List(&stripe.ProductListParams{})
+	for products.Next() {
+		product := products.Product()
+		d, ok := product.Metadata["domain"]
+		if !ok || !strings.Contains(d, c.domain) {
+			continue
+		}
+		if !product.Active {
+			continue
+		}
+		prices := price.List(&stripe.PriceListParams{
+			Product: stripe.String(product.ID),
+		})
+		for prices.Next() {
+			price := prices.Price()
+			if !price.Active {
+				continue
+			}
+			size := int64(1)
+			s, ok := price.Metadata["bundle_size"]
+			if ok {
+				if i, err := strconv.ParseInt(s, 10, 64); err != nil {
+					log.Println(err)
+				} else {
+					size = i
+				}
+			}
+			bundles = append(bundles, &Bundle{
+				Name:      price.Nickname,
+				ProductID: product.ID,
+				PriceID:   price.ID,
+				Currency:  string(price.Currency),
+				Amount:    price.UnitAmount,
+				Size:      size,
+			})
+		}
+		if err := prices.Err(); err != nil {
+			return err
+		}
+	}
+	if err := products.Err(); err != nil {
+		// Keep serving the previous bundles
+		return err
+	}
+	sortBundles(bundles)
+
+	c.Lock()
+	c.bundles = bundles
+	c.Unlock()
+	log.Println("Refreshed Bundle Catalog", len(bundles))
+	return nil
+}
+
+// NewStaticBundleCatalog serves the given bundles, and is intended for development and tests.
+func NewStaticBundleCatalog(bundles ...*Bundle) BundleCatalog {
+	sortBundles(bundles)
+	return &staticBundleCatalog{
+		bundles: bundles,
+	}
+}
+
+// LoadStaticBundleCatalog serves the bundles listed in the given JSON file, which is read again on refresh.
+func LoadStaticBundleCatalog(path string) (BundleCatalog, error) {
+	c := &staticBundleCatalog{
+		path: path,
+	}
+	if err := c.Refresh(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type staticBundleCatalog struct {
+	sync.RWMutex
+	path    string
+	bundles []*Bundle
+}
+
+func (c *staticBundleCatalog) Bundles() []*Bundle {
+	c.RLock()
+	defer c.RUnlock()
+	return c.bundles
+}
+
+func (c *staticBundleCatalog) Bundle(id string) (*Bundle, error) {
+	return findBundle(c.Bundles(), id)
+}
+
+func (c *staticBundleCatalog) Refresh() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	var bundles []*Bundle
+	if err := json.Unmarshal(data, &bundles); err != nil {
+		return err
+	}
+	sortBundles(bundles)
+
+	c.Lock()
+	c.bundles = bundles
+	c.Unlock()
+	return nil
+}
+
+func findBundle(bundles []*Bundle, id string) (*Bundle, error) {
+	for _, b := range bundles {
+		if b.PriceID == id {
+			return b, nil
+		}
+	}
+	return nil, ErrBundleUnrecognized
+}
+
+func sortBundles(bundles []*Bundle) {
+	sort.SliceStable(bundles, func(i, j int) bool {
+		return bundles[i].Size < bundles[j].Size
+	})
+}
@@ -0,0 +1,85 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticBundleCatalog(t *testing.T) {
+	bc := conveyearthgo.NewStaticBundleCatalog(&conveyearthgo.Bundle{
+		PriceID: "price_large",
+		Size:    10000,
+	}, &conveyearthgo.Bundle{
+		PriceID: "price_small",
+		Size:    1000,
+	})
+	bundles := bc.Bundles()
+	assert.Equal(t, 2, len(bundles))
+	assert.Equal(t, "price_small", bundles[0].PriceID)
+	assert.Equal(t, "price_large", bundles[1].PriceID)
+
+	b, err := bc.Bundle("price_large")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10000), b.Size)
+
+	_, err = bc.Bundle("price_unknown")
+	assert.Equal(t, conveyearthgo.ErrBundleUnrecognized, err)
+
+	assert.NoError(t, bc.Refresh())
+}
+
+func TestLoadStaticBundleCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundles.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"PriceID":"price_small","Currency":"usd","Amount":100,"Size":1000}]`), 0600))
+
+	bc, err := conveyearthgo.LoadStaticBundleCatalog(path)
+	assert.NoError(t, err)
+	b, err := bc.Bundle("price_small")
+	assert.NoError(t, err)
+	assert.Equal(t, "usd", b.Currency)
+	assert.Equal(t, int64(100), b.Amount)
+
+	// Refresh reads the file again
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"PriceID":"price_large","Currency":"usd","Amount":900,"Size":10000}]`), 0600))
+	assert.NoError(t, bc.Refresh())
+	_, err = bc.Bundle("price_small")
+	assert.Equal(t, conveyearthgo.ErrBundleUnrecognized, err)
+	_, err = bc.Bundle("price_large")
+	assert.NoError(t, err)
+
+	_, err = conveyearthgo.LoadStaticBundleCatalog(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestStripeBundleCatalog(t *testing.T) {
+	fake := conveytest.NewFakeStripe(t)
+	small := fake.AddBundle("convey.earth", "usd", 100, 1000)
+	fake.AddBundle("example.com", "usd", 100, 1000)
+	inactive := fake.AddBundle("convey.earth", "usd", 900, 10000)
+	fake.Prices[2]["active"] = false
+
+	bc := conveyearthgo.NewStripeBundleCatalog("convey.earth")
+
+	// Nothing is listed before the first refresh
+	assert.Empty(t, bc.Bundles())
+
+	assert.NoError(t, bc.Refresh())
+	bundles := bc.Bundles()
+	assert.Equal(t, 1, len(bundles))
+	assert.Equal(t, small, bundles[0].PriceID)
+	assert.Equal(t, "usd", bundles[0].Currency)
+	assert.Equal(t, int64(100), bundles[0].Amount)
+	assert.Equal(t, int64(1000), bundles[0].Size)
+	_, err := bc.Bundle(inactive)
+	assert.Equal(t, conveyearthgo.ErrBundleUnrecognized, err)
+
+	// Bundles are still served when Stripe is unreachable
+	fake.Fail = true
+	assert.Error(t, bc.Refresh())
+	_, err = bc.Bundle(small)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,90 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/conveyearthgo/render"
+	"aletheiaware.com/cryptogo"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"log"
+)
+
+const (
+	CARD_WIDTH  = 1200
+	CARD_HEIGHT = 630
+	// Renames every card when the layout changes
+	CARD_VERSION = 1
+)
+
+type CardManager interface {
+	Open(string) (fs.File, error)
+	Card(*Conversation) (string, error)
+}
+
+// NewCardManager returns a CardManager which renders conversations onto copies of the given card.
+func NewCardManager(filesystem Filesystem, card *render.Card) CardManager {
+	return &cardManager{
+		filesystem: filesystem,
+		card:       card,
+	}
+}
+
+type cardManager struct {
+	filesystem Filesystem
+	card       *render.Card
+}
+
+func (m *cardManager) Open(name string) (fs.File, error) {
+	return m.filesystem.Open(name)
+}
+
+// Card returns the name of the PNG image of the conversation, which is addressed by the content drawn so it is only rendered again when the topic, author, or yield change.
+func (m *cardManager) Card(conversation *Conversation) (string, error) {
+	var author string
+	if conversation.Author != nil {
+		author = conversation.Author.Username
+	}
+
+	sum := sha512.Sum512([]byte(fmt.Sprintf("%d %q %q %d", CARD_VERSION, conversation.Topic, author, conversation.Yield)))
+
+	name := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if file, err := m.filesystem.Open(name); err == nil {
+		return name, file.Close()
+	}
+
+	card := *m.card
+	card.Topic = conversation.Topic
+	card.Author = author
+	card.Yield = conversation.Yield
+
+	// Create new file with random name
+	temp, err := cryptogo.RandomString(20)
+	if err != nil {
+		return "", err
+	}
+
+	destination, err := m.filesystem.Create(temp)
+	if err != nil {
+		return "", err
+	}
+
+	if err := png.Encode(destination, card.Image()); err != nil {
+		destination.Close()
+		return "", err
+	}
+
+	if err := destination.Close(); err != nil {
+		return "", err
+	}
+
+	// Rename file to name
+	if err := m.filesystem.Rename(temp, name); err != nil {
+		return "", err
+	}
+
+	log.Println("Created Card", name)
+	return name, nil
+}
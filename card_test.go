@@ -0,0 +1,80 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/render"
+	"github.com/stretchr/testify/assert"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+)
+
+func newCard(t *testing.T) *render.Card {
+	t.Helper()
+	topicFont, err := render.LoadFont("cmd/server/assets/html/static/NotoSerif-ExtraBold.ttf")
+	assert.NoError(t, err)
+	bylineFont, err := render.LoadFont("cmd/server/assets/html/static/NotoSerif-Regular.ttf")
+	assert.NoError(t, err)
+	return &render.Card{
+		Width:       conveyearthgo.CARD_WIDTH,
+		Height:      conveyearthgo.CARD_HEIGHT,
+		Margin:      60,
+		Background:  color.White,
+		TopicFont:   topicFont,
+		TopicSize:   64,
+		TopicColor:  color.Black,
+		TopicLines:  4,
+		BylineFont:  bylineFont,
+		BylineSize:  32,
+		BylineColor: color.Black,
+	}
+}
+
+func TestCardManager(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cm := conveyearthgo.NewCardManager(filesystem.NewOnDisk(dir), newCard(t))
+	conversation := &conveyearthgo.Conversation{
+		ID: 1,
+		Author: &authgo.Account{
+			ID:       1,
+			Username: "alice",
+		},
+		Topic: "FooBar",
+	}
+	name, err := cm.Card(conversation)
+	assert.NoError(t, err)
+
+	file, err := cm.Open(name)
+	assert.NoError(t, err)
+	img, err := png.Decode(file)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	assert.Equal(t, conveyearthgo.CARD_WIDTH, img.Bounds().Dx())
+	assert.Equal(t, conveyearthgo.CARD_HEIGHT, img.Bounds().Dy())
+
+	// Same content, same card
+	again, err := cm.Card(conversation)
+	assert.NoError(t, err)
+	assert.Equal(t, name, again)
+
+	// Different yield, different card
+	conversation.Yield = 10
+	yielded, err := cm.Card(conversation)
+	assert.NoError(t, err)
+	assert.NotEqual(t, name, yielded)
+
+	// Different topic, different card
+	conversation.Topic = "BarFoo"
+	renamed, err := cm.Card(conversation)
+	assert.NoError(t, err)
+	assert.NotEqual(t, yielded, renamed)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+}
@@ -0,0 +1,81 @@
+package conveyearthgo
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var ErrAddressForbidden = errors.New("Address Forbidden")
+
+var privateNetworks []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "This" network
+		"10.0.0.0/8",     // Private
+		"100.64.0.0/10",  // Carrier-grade NAT
+		"172.16.0.0/12",  // Private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // Private
+		"198.18.0.0/15",  // Benchmarking
+		"240.0.0.0/4",    // Reserved, including broadcast
+		"64:ff9b::/96",   // NAT64, which embeds an IPv4 address
+		"2002::/16",      // 6to4, which embeds an IPv4 address
+		"fc00::/7",       // Unique local
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		privateNetworks = append(privateNetworks, network)
+	}
+}
+
+// NewPublicClient returns a client for posting to user supplied URLs, which only connects to public addresses and doesn't follow redirects.
+// The address is checked after it is resolved, so a host name cannot be rebound to an internal address between validation and delivery.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return CheckPublicAddress(address)
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Proxies are not used, as the checked address must be the one connected to
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			// A redirect could point anywhere, so the response is returned as is
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CheckPublicAddress returns ErrAddressForbidden unless the host:port address is a public IP.
+func CheckPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrAddressForbidden
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrAddressForbidden
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return ErrAddressForbidden
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return ErrAddressForbidden
+		}
+	}
+	return nil
+}
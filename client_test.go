@@ -0,0 +1,37 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	for name, tt := range map[string]struct {
+		address string
+		err     error
+	}{
+		"Public IPv4":       {address: "93.184.216.34:443"},
+		"Public IPv6":       {address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		"Loopback":          {address: "127.0.0.1:443", err: conveyearthgo.ErrAddressForbidden},
+		"Loopback IPv6":     {address: "[::1]:443", err: conveyearthgo.ErrAddressForbidden},
+		"Private":           {address: "10.1.2.3:443", err: conveyearthgo.ErrAddressForbidden},
+		"Private 172":       {address: "172.16.0.1:443", err: conveyearthgo.ErrAddressForbidden},
+		"Private 192":       {address: "192.168.1.1:443", err: conveyearthgo.ErrAddressForbidden},
+		"Link Local":        {address: "169.254.169.254:80", err: conveyearthgo.ErrAddressForbidden},
+		"Unique Local IPv6": {address: "[fd00::1]:443", err: conveyearthgo.ErrAddressForbidden},
+		"IETF Protocol":     {address: "192.0.0.1:443", err: conveyearthgo.ErrAddressForbidden},
+		"Reserved":          {address: "240.0.0.1:443", err: conveyearthgo.ErrAddressForbidden},
+		"Broadcast":         {address: "255.255.255.255:443", err: conveyearthgo.ErrAddressForbidden},
+		"NAT64":             {address: "[64:ff9b::a00:1]:443", err: conveyearthgo.ErrAddressForbidden},
+		"6to4":              {address: "[2002:a00:1::1]:443", err: conveyearthgo.ErrAddressForbidden},
+		"Mapped Loopback":   {address: "[::ffff:127.0.0.1]:443", err: conveyearthgo.ErrAddressForbidden},
+		"Unspecified":       {address: "0.0.0.0:443", err: conveyearthgo.ErrAddressForbidden},
+		"Host Name":         {address: "localhost:443", err: conveyearthgo.ErrAddressForbidden},
+		"Missing Port":      {address: "93.184.216.34", err: conveyearthgo.ErrAddressForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.err, conveyearthgo.CheckPublicAddress(tt.address))
+		})
+	}
+}
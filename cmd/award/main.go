@@ -0,0 +1,61 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/netgo"
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+var (
+	username = flag.String("user", "", "Username of the recipient")
+	reason   = flag.String("reason", "", "Reason for the award")
+	amount   = flag.Int64("amount", 0, "Amount (coins)")
+)
+
+func main() {
+	flag.Parse()
+
+	if *username == "" {
+		log.Fatal(errors.New("Missing -user flag"))
+	}
+	if *reason == "" {
+		log.Fatal(errors.New("Missing -reason flag"))
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbSecure := netgo.IsSecure()
+	if dbHost == "" || dbHost == "localhost" {
+		// XXX FIXME Disable TLS for local connections
+		dbSecure = false
+	}
+	db, err := database.NewSql(dbName, dbUser, dbPassword, dbHost, dbPort, dbSecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	am := conveyearthgo.NewAccountManager(db)
+
+	account, err := am.Account(*username)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := am.NewAward(account.ID, *reason, *amount); err != nil {
+		log.Fatal(err)
+	}
+
+	balance, err := am.AccountBalance(account.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Awarded", *amount, "to", account.Username, "for", *reason, "- new balance", balance)
+}
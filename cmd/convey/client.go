@@ -0,0 +1,110 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo/api"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Client calls the API on behalf of the owner of a personal API token.
+type Client struct {
+	URL   string
+	Token string
+	HTTP  *http.Client
+}
+
+func NewClient(address, token string) *Client {
+	return &Client{
+		URL:   address,
+		Token: token,
+		HTTP:  http.DefaultClient,
+	}
+}
+
+func (c *Client) Conversations(sort, period string, limit int64) ([]*api.Conversation, error) {
+	query := url.Values{}
+	query.Set("sort", sort)
+	if period != "" {
+		query.Set("period", period)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	var list api.ConversationList
+	if err := c.do(http.MethodGet, "/conversations?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Conversations, nil
+}
+
+func (c *Client) Publish(request *api.PublishRequest) (*api.Conversation, error) {
+	var conversation api.Conversation
+	if err := c.do(http.MethodPost, "/conversations", request, &conversation); err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+func (c *Client) Reply(request *api.ReplyRequest) (*api.Message, error) {
+	var message api.Message
+	if err := c.do(http.MethodPost, "/messages", request, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (c *Client) Gift(request *api.GiftRequest) (*api.Gift, error) {
+	var gift api.Gift
+	if err := c.do(http.MethodPost, "/gifts", request, &gift); err != nil {
+		return nil, err
+	}
+	return &gift, nil
+}
+
+func (c *Client) Balance() (*api.Balance, error) {
+	var balance api.Balance
+	if err := c.do(http.MethodGet, "/balance", nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// do sends the request as JSON and decodes the response, or the error object returned in its place.
+func (c *Client) do(method, path string, request, response interface{}) error {
+	var body io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	r, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	result, err := c.HTTP.Do(r)
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+	if result.StatusCode >= 300 {
+		var e api.ErrorResponse
+		if err := json.NewDecoder(result.Body).Decode(&e); err != nil || e.Error == nil {
+			return fmt.Errorf("%s %s: %s", method, path, result.Status)
+		}
+		return e.Error
+	}
+	return json.NewDecoder(result.Body).Decode(response)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/api"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	acc := authtest.NewTestAccount(t, auth)
+	am := conveyearthgo.NewAccountManager(db)
+	cm := conveyearthgo.NewContentManager(db, filesystem.NewOnDisk(dir), conveyearthgo.FullRefund)
+	nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+	wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+	tm := conveyearthgo.NewTokenManager(db)
+	mux := http.NewServeMux()
+	handler.AttachAPIHandlers(mux, auth, tm, am, cm, nm, wm)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	_, token, err := tm.Create(acc.ID, "Client", conveyearthgo.TokenScopes)
+	assert.Nil(t, err)
+	client := NewClient(server.URL+"/api/v1", token)
+
+	conveytest.NewPurchase(t, am, acc)
+
+	b, err := client.Balance()
+	assert.Nil(t, err)
+	assert.Equal(t, authtest.TEST_USERNAME, b.Username)
+	assert.Equal(t, int64(conveytest.TEST_PURCHASE_SIZE), b.Balance)
+
+	content := filepath.Join(dir, "content.md")
+	assert.Nil(t, os.WriteFile(content, []byte("\nHello World!\n"), 0600))
+	image := filepath.Join(dir, "image.png")
+	assert.Nil(t, os.WriteFile(image, []byte("PNG"), 0600))
+
+	text, attachments, cost, err := readMessage(content, []string{image})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello World!", text)
+	assert.Equal(t, 1, len(attachments))
+	assert.Equal(t, conveyearthgo.MIME_IMAGE_PNG, attachments[0].Mime)
+	assert.Equal(t, int64(len("Hello World!")+len("PNG")), cost)
+
+	conversation, err := client.Publish(&api.PublishRequest{
+		Topic:       conveytest.TEST_TOPIC,
+		Content:     text,
+		Attachments: attachments,
+	})
+	assert.Nil(t, err)
+	// The previewed cost is the cost charged
+	assert.Equal(t, cost, conversation.Cost)
+	assert.Equal(t, 2, len(conversation.Messages[0].Files))
+
+	conversations, err := client.Conversations("recent", "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(conversations))
+	assert.Equal(t, conversation.ID, conversations[0].ID)
+
+	message, err := client.Reply(&api.ReplyRequest{
+		Conversation: conversation.ID,
+		Parent:       conversation.Messages[0].ID,
+		Content:      "Hi!",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, conversation.Messages[0].ID, message.Parent)
+
+	_, err = client.Gift(&api.GiftRequest{
+		Conversation: conversation.ID,
+		Message:      message.ID,
+		Amount:       10,
+	})
+	var e *api.Error
+	assert.True(t, errors.As(err, &e), err)
+	assert.Equal(t, "self_gifting_not_permitted", e.Code)
+
+	b, err = client.Balance()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(conveytest.TEST_PURCHASE_SIZE)-cost-int64(len("Hi!")), b.Balance)
+}
+
+func TestReadMessage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	content := filepath.Join(dir, "content.md")
+	assert.Nil(t, os.WriteFile(content, []byte("Hello World!"), 0600))
+	empty := filepath.Join(dir, "empty.md")
+	assert.Nil(t, os.WriteFile(empty, []byte(" \n"), 0600))
+	model := filepath.Join(dir, "teapot.stl")
+	assert.Nil(t, os.WriteFile(model, []byte("solid"), 0600))
+
+	_, _, _, err = readMessage(empty, nil)
+	assert.Equal(t, conveyearthgo.ErrContentTooShort, err)
+
+	_, _, _, err = readMessage(content, []string{model})
+	assert.True(t, errors.Is(err, conveyearthgo.ErrMimeUnrecognized), err)
+
+	var many []string
+	for i := 0; i <= api.MAXIMUM_ATTACHMENTS; i++ {
+		many = append(many, content)
+	}
+	_, _, _, err = readMessage(content, many)
+	assert.Equal(t, api.ErrAttachmentsExceeded, err)
+
+	_, _, _, err = readMessage(filepath.Join(dir, "missing.md"), nil)
+	assert.True(t, errors.Is(err, os.ErrNotExist), err)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/api"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: convey <command> [flags]
+
+Commands:
+  publish [-yes] -topic <topic> <content.md> [attachment...]
+  reply [-yes] -conversation <id> -message <id> <content.md> [attachment...]
+  gift [-yes] -conversation <id> -message <id> -amount <coins>
+  list [-sort recent|best] [-period day|week|month|year|all] [-limit n]
+  balance
+
+Content is read from standard input when the file is -, in which case -yes is
+needed as the cost cannot be confirmed interactively.
+
+The API is at CONVEY_URL, defaulting to https://convey.earth, and requests are
+authenticated with the personal API token in CONVEY_TOKEN.
+`
+
+var ErrCancelled = errors.New("Cancelled")
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	address, ok := os.LookupEnv("CONVEY_URL")
+	if !ok {
+		address = "https://convey.earth"
+	}
+	client := NewClient(strings.TrimSuffix(address, "/")+"/api/v1", os.Getenv("CONVEY_TOKEN"))
+
+	command, args := os.Args[1], os.Args[2:]
+	var err error
+	switch command {
+	case "publish":
+		err = publish(client, args)
+	case "reply":
+		err = reply(client, args)
+	case "gift":
+		err = gift(client, args)
+	case "list":
+		err = list(client, args)
+	case "balance":
+		err = balance(client, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func publish(client *Client, args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	topic := flags.String("topic", "", "Topic of the conversation")
+	yes := flags.Bool("yes", false, "Publish without confirming the cost")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return errors.New("Missing content file")
+	}
+
+	t := strings.TrimSpace(*topic)
+
+	// Check valid topic
+	if err := conveyearthgo.ValidateTopic(t); err != nil {
+		return err
+	}
+
+	content, attachments, cost, err := readMessage(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	if err := confirm(client, "Publishing", cost, *yes); err != nil {
+		return err
+	}
+
+	conversation, err := client.Publish(&api.PublishRequest{
+		Topic:       t,
+		Content:     content,
+		Attachments: attachments,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Published conversation %d for %d coins\n", conversation.ID, conversation.Cost)
+	return nil
+}
+
+func reply(client *Client, args []string) error {
+	flags := flag.NewFlagSet("reply", flag.ExitOnError)
+	conversation := flags.Int64("conversation", 0, "ID of the conversation")
+	message := flags.Int64("message", 0, "ID of the message being replied to")
+	yes := flags.Bool("yes", false, "Reply without confirming the cost")
+	flags.Parse(args)
+	if *conversation == 0 {
+		return errors.New("Missing -conversation flag")
+	}
+	if *message == 0 {
+		return errors.New("Missing -message flag")
+	}
+	if flags.NArg() < 1 {
+		return errors.New("Missing content file")
+	}
+
+	content, attachments, cost, err := readMessage(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	if err := confirm(client, "Replying", cost, *yes); err != nil {
+		return err
+	}
+
+	m, err := client.Reply(&api.ReplyRequest{
+		Conversation: *conversation,
+		Parent:       *message,
+		Content:      content,
+		Attachments:  attachments,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replied with message %d for %d coins\n", m.ID, m.Cost)
+	return nil
+}
+
+func gift(client *Client, args []string) error {
+	flags := flag.NewFlagSet("gift", flag.ExitOnError)
+	conversation := flags.Int64("conversation", 0, "ID of the conversation")
+	message := flags.Int64("message", 0, "ID of the message receiving the gift")
+	amount := flags.Int64("amount", 0, "Amount (coins)")
+	yes := flags.Bool("yes", false, "Gift without confirming the cost")
+	flags.Parse(args)
+	if *conversation == 0 {
+		return errors.New("Missing -conversation flag")
+	}
+	if *message == 0 {
+		return errors.New("Missing -message flag")
+	}
+	if *amount <= 0 {
+		return conveyearthgo.ErrGiftAmountInvalid
+	}
+
+	if err := confirm(client, "Gifting", *amount, *yes); err != nil {
+		return err
+	}
+
+	g, err := client.Gift(&api.GiftRequest{
+		Conversation: *conversation,
+		Message:      *message,
+		Amount:       *amount,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Gifted %d coins to message %d\n", g.Amount, g.Message)
+	return nil
+}
+
+func list(client *Client, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	sort := flags.String("sort", "recent", "Order of conversations, recent or best")
+	period := flags.String("period", "", "Period of best conversations, day, week, month, year, or all")
+	limit := flags.Int64("limit", 0, "Maximum number of conversations")
+	flags.Parse(args)
+
+	conversations, err := client.Conversations(*sort, *period, *limit)
+	if err != nil {
+		return err
+	}
+	for _, c := range conversations {
+		fmt.Printf("%d\t%s\t%s\t%d\t%d\t%s\n", c.ID, c.Created.Format("2006-01-02 15:04"), c.Author, c.Cost, c.Yield, c.Topic)
+	}
+	return nil
+}
+
+func balance(client *Client, args []string) error {
+	flags := flag.NewFlagSet("balance", flag.ExitOnError)
+	flags.Parse(args)
+
+	b, err := client.Balance()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s has %d coins\n", b.Username, b.Balance)
+	return nil
+}
+
+// readMessage reads and checks the content and attachments as the server would, so problems are found before anything is uploaded, and returns the cost of the message.
+func readMessage(path string, paths []string) (string, []*api.Attachment, int64, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", nil, 0, err
+	}
+	content := strings.ReplaceAll(strings.TrimSpace(string(data)), "\r\n", "\n")
+
+	// Check valid content
+	if err := conveyearthgo.ValidateContent([]byte(content)); err != nil {
+		return "", nil, 0, err
+	}
+
+	if len(paths) > api.MAXIMUM_ATTACHMENTS {
+		return "", nil, 0, api.ErrAttachmentsExceeded
+	}
+
+	cost := int64(len(content))
+	var attachments []*api.Attachment
+	for _, p := range paths {
+		mime, err := conveyearthgo.MimeTypeFromFilename(p)
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("%s: %w", p, err)
+		}
+		// Check valid mime
+		if err := conveyearthgo.ValidateMime(mime); err != nil {
+			return "", nil, 0, fmt.Errorf("%s: %w", p, err)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		attachments = append(attachments, &api.Attachment{
+			Mime: mime,
+			Data: data,
+		})
+		cost += int64(len(data))
+	}
+	return content, attachments, cost, nil
+}
+
+// confirm shows the cost, and the balance if the token can read it, then asks before continuing unless told not to.
+func confirm(client *Client, action string, cost int64, yes bool) error {
+	fmt.Printf("%s will cost %d coins\n", action, cost)
+	if b, err := client.Balance(); err != nil {
+		log.Println("Balance unavailable:", err)
+	} else {
+		// Spending is blocked while the balance is negative
+		if b.Balance < 0 {
+			return conveyearthgo.ErrBalanceNegative
+		}
+		// Check account balance
+		if cost > b.Balance {
+			return conveyearthgo.ErrInsufficientBalance
+		}
+		fmt.Printf("Balance will be %d coins\n", b.Balance-cost)
+	}
+	if yes {
+		return nil
+	}
+	fmt.Print("Continue? [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return ErrCancelled
+	}
+	return nil
+}
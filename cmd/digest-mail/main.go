@@ -0,0 +1,130 @@
+package main
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/netgo"
+	"embed"
+	"errors"
+	"flag"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	edition  = flag.String("edition", "", "Edition (YYYY-MM)")
+	interval = flag.Duration("interval", time.Second, "Minimum interval between emails")
+	limit    = flag.Int("limit", 0, "Maximum emails to send in this run (0 for unlimited)")
+
+	//go:embed assets
+	embeddedFS embed.FS
+)
+
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Fatal("Missing digest directory")
+	}
+
+	if *edition == "" {
+		log.Fatal(errors.New("Missing -edition flag"))
+	}
+
+	// Only announce editions which have been published
+	editions, err := conveyearthgo.ReadDigests(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	found := false
+	for _, e := range editions {
+		if e == *edition {
+			found = true
+		}
+	}
+	if !found {
+		log.Fatal(errors.New("Edition Not Found: " + *edition))
+	}
+
+	scheme := conveyearthgo.Scheme()
+	host := conveyearthgo.Host()
+	if host == "" {
+		log.Fatal(errors.New("Missing HOST environment variable"))
+	}
+
+	// Parse Templates
+	templateFS, err := fs.Sub(embeddedFS, "assets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates, err := template.ParseFS(templateFS, "*.go.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbSecure := netgo.IsSecure()
+	if dbHost == "" || dbHost == "localhost" {
+		// XXX FIXME Disable TLS for local connections
+		dbSecure = false
+	}
+	db, err := database.NewSql(dbName, dbUser, dbPassword, dbHost, dbPort, dbSecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	var ns conveyearthgo.NotificationSender
+	if netgo.IsSecure() {
+		server := os.Getenv("SMTP_SERVER")
+		sender := os.Getenv("SMTP_SENDER")
+		unsubscribe := []byte(os.Getenv("UNSUBSCRIBE_SECRET"))
+		if len(unsubscribe) == 0 {
+			log.Fatal(errors.New("Missing UNSUBSCRIBE_SECRET environment variable"))
+		}
+		ns = conveyearthgo.NewSmtpNotificationSender(scheme, host, server, host, sender, "", unsubscribe, templates)
+	} else {
+		ns = conveytest.NewNotificationSender()
+	}
+	nm := conveyearthgo.NewNotificationManager(db, ns)
+
+	// Users already sent this edition are excluded, so an interrupted run can be resumed
+	var recipients []*authgo.Account
+	if err := nm.DigestRecipients(*edition, func(a *authgo.Account) error {
+		recipients = append(recipients, a)
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Recipients:", len(recipients))
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	sent := 0
+	failed := 0
+	for _, a := range recipients {
+		if *limit > 0 && sent >= *limit {
+			log.Println("Reached limit of", *limit)
+			break
+		}
+		if err := nm.NotifyDigest(a, *edition); err != nil {
+			log.Println(err)
+			failed++
+		} else {
+			sent++
+		}
+		<-ticker.C
+	}
+	log.Println("Sent", sent, "Failed", failed, "Remaining", len(recipients)-sent-failed)
+}
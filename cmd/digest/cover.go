@@ -1,22 +1,16 @@
 package main
 
 import (
+	"aletheiaware.com/conveyearthgo/render"
 	"aletheiaware.com/netgo"
-	"fmt"
 	"github.com/goki/freetype"
 	"github.com/goki/freetype/truetype"
 	xdraw "golang.org/x/image/draw"
 	"golang.org/x/image/font"
-	"golang.org/x/image/math/fixed"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
-	"log"
 	"math"
-	"os"
-	"path"
-	"unicode"
 )
 
 type Cover struct {
@@ -73,25 +67,9 @@ func (c *Cover) Image() image.Image {
 		Size: c.TopicSize,
 		DPI:  96,
 	})
-	topicEmoji := func(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, bool) {
-		file, err := os.Open(path.Join(c.Emojis, fmt.Sprintf("emoji_u%x.png", r)))
-		if err != nil {
-			log.Println(err)
-			return image.Rectangle{}, nil, false
-		}
-		img, err := png.Decode(file)
-		if err != nil {
-			log.Println(err)
-			return image.Rectangle{}, nil, false
-		}
-		s := int(c.TopicSize)
-		bounds := image.Rect(0, 0, s, s)
-		out := image.NewRGBA(bounds)
-		xdraw.BiLinear.Scale(out, bounds, img, img.Bounds(), draw.Over, nil)
-		x := dot.X.Ceil()
-		y := dot.Y.Ceil()
-		rect := image.Rect(x, y-s, x+s, y)
-		return rect, out, true
+	topicEmojis := &render.Emojis{
+		Directory: c.Emojis,
+		Size:      int(c.TopicSize),
 	}
 	topicMetrics := topicFace.Metrics()
 	topicAscent := topicMetrics.Ascent.Ceil()
@@ -122,36 +100,28 @@ func (c *Cover) Image() image.Image {
 	draw.Draw(rgba, logoDest, c.Logo, image.Point{}, draw.Over)
 
 	// Title
-	DrawString(rgba, image.NewUniform(c.TitleColor), c.TitleFont, titleFace, nil, []rune(c.Title), freetype.Pt(titleX, titleAscent))
+	render.DrawString(rgba, image.NewUniform(c.TitleColor), c.TitleFont, titleFace, nil, []rune(c.Title), freetype.Pt(titleX, titleAscent))
 
 	// Edition
-	DrawString(rgba, image.NewUniform(c.EditionColor), c.EditionFont, editionFace, nil, []rune(c.Edition[0]), editionYearDot)
-	DrawString(rgba, image.NewUniform(c.EditionColor), c.EditionFont, editionFace, nil, []rune(c.Edition[1]), editionMonthDot)
+	render.DrawString(rgba, image.NewUniform(c.EditionColor), c.EditionFont, editionFace, nil, []rune(c.Edition[0]), editionYearDot)
+	render.DrawString(rgba, image.NewUniform(c.EditionColor), c.EditionFont, editionFace, nil, []rune(c.Edition[1]), editionMonthDot)
 
 	if !netgo.IsLive() {
 		betaText := "BETA"
 		betaDot := freetype.Pt((c.Width-font.MeasureString(editionFace, betaText).Ceil())/2., titleAscent+editionHeight)
-		DrawString(rgba, image.NewUniform(&color.RGBA{0xff, 0x40, 0, 0xff}), c.EditionFont, editionFace, nil, []rune(betaText), betaDot)
+		render.DrawString(rgba, image.NewUniform(&color.RGBA{0xff, 0x40, 0, 0xff}), c.EditionFont, editionFace, nil, []rune(betaText), betaDot)
 	}
 
 	// Topics
 	topicMeasurer := func(s []rune) int {
-		return MeasureString(c.TopicFont, topicFace, func(r rune) (fixed.Int26_6, bool) {
-			_, err := os.Stat(path.Join(c.Emojis, fmt.Sprintf("emoji_u%x.png", r)))
-			if err != nil {
-				if os.IsNotExist(err) {
-					return 0, false
-				}
-			}
-			return fixed.I(int(c.TopicSize)), true
-		}, s)
+		return render.MeasureString(c.TopicFont, topicFace, topicEmojis.Advance, s)
 	}
 	var (
-		topicLines   [DIGEST_LIMIT][]*Line
+		topicLines   [DIGEST_LIMIT][]*render.Line
 		topicHeights [DIGEST_LIMIT]int
 	)
 	for i := 0; i < DIGEST_LIMIT; i++ {
-		lines := SplitLines(c.Topics[i], topicBoxWidth, topicMeasurer)
+		lines := render.SplitLines(c.Topics[i], topicBoxWidth, topicMeasurer)
 		topicLines[i] = lines
 		topicHeights[i] = len(lines) * topicHeight
 	}
@@ -178,7 +148,7 @@ func (c *Cover) Image() image.Image {
 			x := leftTopicX
 			y := leftY + (j * topicHeight)
 			draw.Draw(rgba, image.Rect(x-bgX, y-topicAscent, x+l.Width+bgX, y-topicAscent+topicHeight), textBackground, image.Point{}, draw.Over)
-			DrawString(rgba, image.NewUniform(c.TopicColor), c.TopicFont, topicFace, topicEmoji, []rune(l.Text), freetype.Pt(x, y))
+			render.DrawString(rgba, image.NewUniform(c.TopicColor), c.TopicFont, topicFace, topicEmojis.Image, []rune(l.Text), freetype.Pt(x, y))
 		}
 		leftY += topicHeights[i]
 
@@ -191,131 +161,10 @@ func (c *Cover) Image() image.Image {
 			x := rightTopicX + (topicBoxWidth - l.Width)
 			y := rightY + (j * topicHeight)
 			draw.Draw(rgba, image.Rect(x-bgX, y-topicAscent, x+l.Width+bgX, y-topicAscent+topicHeight), textBackground, image.Point{}, draw.Over)
-			DrawString(rgba, image.NewUniform(c.TopicColor), c.TopicFont, topicFace, topicEmoji, []rune(l.Text), freetype.Pt(x, y))
+			render.DrawString(rgba, image.NewUniform(c.TopicColor), c.TopicFont, topicFace, topicEmojis.Image, []rune(l.Text), freetype.Pt(x, y))
 		}
 		rightY += topicHeights[i+1]
 	}
 
 	return rgba
 }
-
-func MeasureString(font *truetype.Font, face font.Face, emoji func(rune) (fixed.Int26_6, bool), runes []rune) int {
-	var advance fixed.Int26_6
-	previous := rune(-1)
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
-		if previous >= 0 {
-			advance += face.Kern(previous, r)
-		}
-		index := font.Index(r)
-		if index > 0 {
-			a, ok := face.GlyphAdvance(r)
-			if !ok {
-				log.Println("Couldn't find Glyph Advance for", r)
-				continue
-			}
-			advance += a
-		} else {
-			a, ok := emoji(r)
-			if !ok {
-				log.Println("Couldn't find Emoji for", r)
-			}
-			advance += a
-		}
-		previous = r
-	}
-	return advance.Ceil()
-}
-
-func DrawString(dest draw.Image, src image.Image, font *truetype.Font, face font.Face, emoji func(fixed.Point26_6, rune) (image.Rectangle, image.Image, bool), runes []rune, dot fixed.Point26_6) {
-	var (
-		ok        bool
-		rect      image.Rectangle
-		mask      image.Image
-		maskPoint image.Point
-		advance   fixed.Int26_6
-	)
-	previous := rune(-1)
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
-		if previous >= 0 {
-			dot.X += face.Kern(previous, r)
-		}
-		index := font.Index(r)
-		if index > 0 {
-			rect, mask, maskPoint, advance, ok = face.Glyph(dot, r)
-			if !ok {
-				log.Println("Couldn't find Glyph for", r)
-				continue
-			}
-			draw.DrawMask(dest, rect, src, image.Point{}, mask, maskPoint, draw.Over)
-		} else {
-			rect, src, ok := emoji(dot, r)
-			if !ok {
-				log.Println("Couldn't find Emoji for", r)
-				continue
-			}
-			draw.Draw(dest, rect, src, image.Point{}, draw.Over)
-			advance = fixed.I(rect.Dx())
-		}
-
-		dot.X += advance
-		previous = r
-	}
-}
-
-type Line struct {
-	Text  string
-	Width int
-}
-
-func SplitLines(text string, maxWidth int, measurer func([]rune) int) (lines []*Line) {
-	textWidth := measurer([]rune(text))
-	delta := maxWidth - textWidth
-	if delta < 0 {
-		// Split line
-		wrappoint := -1
-		start := 0
-		end := 0
-		runes := []rune(text)
-		for end < len(runes) {
-			c := runes[end]
-			if unicode.IsSpace(c) {
-				wrappoint = end
-			}
-			textWidth := measurer(runes[start : end+1])
-			delta := maxWidth - textWidth
-			if delta < 0 {
-				var substring []rune
-				if wrappoint == -1 {
-					substring = runes[start:end]
-					end++
-				} else {
-					substring = runes[start:wrappoint]
-					end = wrappoint + 1
-				}
-				lines = append(lines, &Line{
-					Text:  string(substring),
-					Width: measurer(substring),
-				})
-				start = end
-				wrappoint = -1
-			} else {
-				end++
-			}
-		}
-		if end-start > 0 {
-			substring := runes[start:end]
-			lines = append(lines, &Line{
-				Text:  string(substring),
-				Width: measurer(substring),
-			})
-		}
-	} else {
-		lines = append(lines, &Line{
-			Text:  text,
-			Width: textWidth,
-		})
-	}
-	return
-}
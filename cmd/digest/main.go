@@ -4,6 +4,8 @@ import (
 	"aletheiaware.com/conveyearthgo"
 	"aletheiaware.com/conveyearthgo/content/markdown"
 	"aletheiaware.com/conveyearthgo/content/plaintext"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/render"
 	"aletheiaware.com/netgo"
 	"bytes"
 	"database/sql"
@@ -14,9 +16,6 @@ import (
 	"github.com/anthonynsimon/bild/transform"
 	"github.com/bmaupin/go-epub"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/goki/freetype/truetype"
-	"github.com/srwiley/oksvg"
-	"github.com/srwiley/rasterx"
 	"image"
 	"image/color"
 	_ "image/jpeg"
@@ -49,6 +48,8 @@ var (
 	edits   = flag.String("edits", "edits", "Edits directory")
 	emojis  = flag.String("emojis", "emojis", "Emojis directory")
 	reward  = flag.Float64("reward", 12500., "Reward (cents)")
+	rate    = flag.Float64("rate", 1., "Award Rate (coins per cent of reward)")
+	commit  = flag.Bool("commit", false, "Record rewards as awards")
 	//    625 - 2021
 	//    625 - 2022
 	//   1250 - 2023
@@ -77,6 +78,10 @@ func main() {
 	}
 	output := args[0]
 
+	if *commit && !netgo.IsLive() {
+		log.Fatal(errors.New("Rewards are only calculated for live digests"))
+	}
+
 	host, ok := os.LookupEnv("HOST")
 	if !ok {
 		log.Fatal(errors.New("Missing HOST environment variable"))
@@ -177,6 +182,7 @@ func main() {
 	}
 
 	yields := make(map[string]int64)
+	users := make(map[string]int64)
 
 	// Lookup Conversations
 	i := 0
@@ -273,6 +279,7 @@ func main() {
 
 		for m := &c.Message; ; m = m.Replies[0] {
 			yields[m.Username] = yields[m.Username] + m.Yield
+			users[m.Username] = m.User
 			if len(m.Replies) == 0 {
 				break
 			}
@@ -293,6 +300,8 @@ func main() {
 		log.Fatal(err)
 	}
 
+	awards := make(map[string]int64)
+
 	if netgo.IsLive() {
 		var authors []string
 		for author := range yields {
@@ -326,6 +335,7 @@ func main() {
 					amount := (float64(yield) / float64(sum)) * *reward
 					// Round up to nearest cent
 					amount = math.Ceil(amount)
+					awards[author] = int64(math.Ceil(amount * *rate))
 					// Convert cents to dollars
 					amount /= 100.
 					total += amount
@@ -360,30 +370,25 @@ func main() {
 	}); err != nil {
 		log.Fatal(err)
 	}
-	icon, err := oksvg.ReadIconStream(&logoSvg)
+	logo, err := render.Rasterize(&logoSvg, 100, 100)
 	if err != nil {
 		log.Fatal(err)
 	}
-	logo := image.NewNRGBA(image.Rect(0, 0, 100, 100))
-	scanner := rasterx.NewScannerGV(int(icon.ViewBox.W), int(icon.ViewBox.H), logo, logo.Bounds())
-	raster := rasterx.NewDasher(100, 100, scanner)
-	icon.SetTarget(0, 0, 100, 100)
-	icon.Draw(raster, 1)
 	cover.Logo = logo
 
-	coverTitleFont, err := loadFont(path.Join(*fonts, titleFont))
+	coverTitleFont, err := render.LoadFont(path.Join(*fonts, titleFont))
 	if err != nil {
 		log.Fatal(err)
 	}
 	cover.TitleFont = coverTitleFont
 
-	coverEditionFont, err := loadFont(path.Join(*fonts, editionFont))
+	coverEditionFont, err := render.LoadFont(path.Join(*fonts, editionFont))
 	if err != nil {
 		log.Fatal(err)
 	}
 	cover.EditionFont = coverEditionFont
 
-	coverTopicFont, err := loadFont(path.Join(*fonts, topicFont))
+	coverTopicFont, err := render.LoadFont(path.Join(*fonts, topicFont))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -449,6 +454,13 @@ func main() {
 	if err := e.Write(path.Join(output, fmt.Sprintf(`Convey-Digest-%s-%s.epub`, start.Format("2006"), start.Format("01")))); err != nil {
 		log.Fatal(err)
 	}
+
+	if *commit {
+		reason := fmt.Sprintf("Convey Digest %s", start.Format("January 2006"))
+		if err := commitAwards(db, start.Format("2006-01"), reason, users, awards); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 type Conversation struct {
@@ -586,6 +598,30 @@ func openDatabase() (*sql.DB, error) {
 	return sql.Open("mysql", dsn)
 }
 
+func commitAwards(db *sql.DB, edition, reason string, users, awards map[string]int64) error {
+	recipients := make(map[int64]int64)
+	for author, amount := range awards {
+		if amount <= 0 {
+			continue
+		}
+		recipients[users[author]] = amount
+	}
+	// Awards are recorded in a single transaction, and the edition is unique per user and reason so they are only recorded once
+	am := conveyearthgo.NewAccountManager(&database.Sql{DB: db})
+	if err := am.NewEditionAwards(edition, reason, recipients); err != nil {
+		if err == conveyearthgo.ErrAwardsAlreadyRecorded {
+			return fmt.Errorf("Awards already recorded for %s", reason)
+		}
+		return err
+	}
+	for author, amount := range awards {
+		if amount > 0 {
+			log.Println("Awarded", amount, "to", author)
+		}
+	}
+	return nil
+}
+
 func queryConversations(db *sql.DB, start, end time.Time, callback func(int64, int64, string, string, time.Time, int64, int64) error) error {
 	rows, err := db.Query(`
         SELECT tbl_conversations.id, tbl_conversations.user, tbl_users.username, tbl_conversations.topic, tbl_conversations.created_unix, tbl_charges.amount, IFNULL(yields.yield,0)
@@ -686,19 +722,6 @@ func queryFiles(db *sql.DB, message int64, callback func(int64, string, string,
 	return rows.Err()
 }
 
-func loadFont(name string) (*truetype.Font, error) {
-	file, err := os.Open(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return truetype.Parse(data)
-}
-
 func uploadPath(hash string) string {
 	s := path.Join(*edits, hash)
 	if _, err := os.Stat(s); err != nil {
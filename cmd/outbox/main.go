@@ -0,0 +1,63 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/netgo"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	replay    = flag.Int64("replay", 0, "ID of the dead letter to return to the queue")
+	replayAll = flag.Bool("replay-all", false, "Return all dead letters to the queue")
+)
+
+func main() {
+	flag.Parse()
+
+	dbName := os.Getenv("DB_NAME")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbSecure := netgo.IsSecure()
+	if dbHost == "" || dbHost == "localhost" {
+		// XXX FIXME Disable TLS for local connections
+		dbSecure = false
+	}
+	db, err := database.NewSql(dbName, dbUser, dbPassword, dbHost, dbPort, dbSecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// Entries are only inspected and requeued here, the server's worker sends them
+	om := conveyearthgo.NewOutboxManager(db, nil, 0, 0)
+
+	if *replay != 0 {
+		if err := om.Replay(*replay); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var ids []int64
+	if err := om.DeadLetters(func(e *conveyearthgo.OutboxEntry) error {
+		fmt.Printf("%d\t%s\t%s\t%d\t%s\t%s\n", e.ID, e.Dead.Format("2006-01-02 15:04:05"), e.Kind, e.Attempts, e.LastError, e.Payload)
+		ids = append(ids, e.ID)
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	if *replayAll {
+		for _, id := range ids {
+			if err := om.Replay(id); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_reversals;
\ No newline at end of file
@@ -0,0 +1,38 @@
+CREATE TABLE tbl_reversals (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    conversation INT NULL,
+    message INT NULL,
+    gift INT NULL,
+    reason VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    FOREIGN KEY (conversation) REFERENCES tbl_conversations(id),
+    FOREIGN KEY (message) REFERENCES tbl_messages(id),
+    FOREIGN KEY (gift) REFERENCES tbl_gifts(id)
+);
+
+INSERT INTO tbl_reversals (user, conversation, message, reason, amount, created_unix)
+SELECT tbl_charges.user, tbl_charges.conversation, tbl_charges.message, 'Charge Refund', tbl_charges.amount, GREATEST(tbl_charges.deleted_at, tbl_messages.deleted_at)
+FROM tbl_charges
+INNER JOIN tbl_messages ON tbl_charges.message=tbl_messages.id
+WHERE tbl_charges.amount<>0 AND (tbl_charges.deleted_at<>0 OR tbl_messages.deleted_at<>0);
+
+INSERT INTO tbl_reversals (user, conversation, message, reason, amount, created_unix)
+SELECT tbl_messages.user, tbl_yields.conversation, tbl_yields.message, 'Yield Reversal', -tbl_yields.amount, GREATEST(tbl_yields.deleted_at, tbl_messages.deleted_at)
+FROM tbl_yields
+INNER JOIN tbl_messages ON tbl_yields.parent=tbl_messages.id
+WHERE tbl_yields.amount<>0 AND (tbl_yields.deleted_at<>0 OR tbl_messages.deleted_at<>0);
+
+INSERT INTO tbl_reversals (user, conversation, message, gift, reason, amount, created_unix)
+SELECT tbl_gifts.user, tbl_gifts.conversation, tbl_gifts.message, tbl_gifts.id, 'Gift Refund', tbl_gifts.amount, tbl_gifts.deleted_at
+FROM tbl_gifts
+WHERE tbl_gifts.amount<>0 AND tbl_gifts.deleted_at<>0;
+
+INSERT INTO tbl_reversals (user, conversation, message, gift, reason, amount, created_unix)
+SELECT tbl_messages.user, tbl_gifts.conversation, tbl_gifts.message, tbl_gifts.id, 'Gift Reversal', -tbl_gifts.amount, GREATEST(tbl_gifts.deleted_at, tbl_messages.deleted_at)
+FROM tbl_gifts
+INNER JOIN tbl_messages ON tbl_gifts.message=tbl_messages.id
+WHERE tbl_gifts.amount<>0 AND (tbl_gifts.deleted_at<>0 OR tbl_messages.deleted_at<>0);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_payouts;
\ No newline at end of file
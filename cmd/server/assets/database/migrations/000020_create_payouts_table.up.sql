@@ -0,0 +1,13 @@
+CREATE TABLE tbl_payouts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    stripe_account VARCHAR(255) NOT NULL,
+    stripe_transfer VARCHAR(255) NULL UNIQUE,
+    stripe_currency TEXT(3) NOT NULL,
+    stripe_amount INT NOT NULL,
+    size INT NOT NULL,
+    status VARCHAR(31) NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE tbl_purchases
+DROP INDEX stripe_session;
+
+INSERT INTO tbl_purchases (id, user, stripe_session, stripe_customer, stripe_payment_intent, stripe_currency, stripe_amount, bundle_size, created_unix, deleted_at)
+SELECT id, user, stripe_session, stripe_customer, stripe_payment_intent, stripe_currency, stripe_amount, bundle_size, created_unix, deleted_at
+FROM tbl_purchases_duplicates;
+
+DROP TABLE IF EXISTS tbl_purchases_duplicates;
\ No newline at end of file
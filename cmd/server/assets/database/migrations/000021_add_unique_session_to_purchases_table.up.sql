@@ -0,0 +1,31 @@
+CREATE TABLE tbl_purchases_duplicates (
+    id INT PRIMARY KEY,
+    original INT NOT NULL,
+    user INT NOT NULL,
+    stripe_session VARCHAR(255) NOT NULL,
+    stripe_customer VARCHAR(255) NOT NULL,
+    stripe_payment_intent VARCHAR(255) NOT NULL,
+    stripe_currency TEXT(3) NOT NULL,
+    stripe_amount INT NOT NULL,
+    bundle_size INT NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    moved_unix INT UNSIGNED NOT NULL,
+    FOREIGN KEY (original) REFERENCES tbl_purchases(id),
+    FOREIGN KEY (user) REFERENCES tbl_users(id)
+);
+
+-- Keep an audit copy of each purchase recorded more than once for the same checkout session
+INSERT INTO tbl_purchases_duplicates (id, original, user, stripe_session, stripe_customer, stripe_payment_intent, stripe_currency, stripe_amount, bundle_size, created_unix, deleted_at, moved_unix)
+SELECT duplicate.id, MIN(original.id), duplicate.user, duplicate.stripe_session, duplicate.stripe_customer, duplicate.stripe_payment_intent, duplicate.stripe_currency, duplicate.stripe_amount, duplicate.bundle_size, duplicate.created_unix, duplicate.deleted_at, UNIX_TIMESTAMP()
+FROM tbl_purchases AS duplicate
+INNER JOIN tbl_purchases AS original ON duplicate.stripe_session=original.stripe_session AND duplicate.id>original.id
+GROUP BY duplicate.id;
+
+-- Removing the duplicates from tbl_purchases reverses the coins they credited twice
+DELETE duplicate
+FROM tbl_purchases AS duplicate
+INNER JOIN tbl_purchases_duplicates ON duplicate.id=tbl_purchases_duplicates.id;
+
+ALTER TABLE tbl_purchases
+ADD UNIQUE (stripe_session);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_purchase_adjustments;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE tbl_purchase_adjustments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    purchase INT NOT NULL,
+    user INT NOT NULL,
+    reason VARCHAR(31) NOT NULL,
+    stripe_currency TEXT(3) NOT NULL,
+    stripe_amount INT NOT NULL,
+    size INT NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (purchase) REFERENCES tbl_purchases(id),
+    FOREIGN KEY (user) REFERENCES tbl_users(id)
+);
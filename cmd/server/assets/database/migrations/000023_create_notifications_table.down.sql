@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_notifications;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE tbl_notifications (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    kind VARCHAR(31) NOT NULL,
+    actor INT NOT NULL,
+    conversation INT NOT NULL,
+    message INT NOT NULL,
+    amount INT NOT NULL DEFAULT 0,
+    read_unix INT UNSIGNED NOT NULL DEFAULT 0,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    INDEX (user, read_unix)
+);
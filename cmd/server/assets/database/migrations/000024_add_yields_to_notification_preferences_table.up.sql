@@ -0,0 +1,2 @@
+ALTER TABLE tbl_notification_preferences
+ADD COLUMN yields BOOL DEFAULT TRUE
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE tbl_digest_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    edition VARCHAR(31) NOT NULL,
+    user INT NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    UNIQUE KEY (edition, user)
+);
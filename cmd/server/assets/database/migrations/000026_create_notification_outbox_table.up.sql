@@ -0,0 +1,13 @@
+CREATE TABLE tbl_notification_outbox (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(31) NOT NULL,
+    payload BLOB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_unix INT UNSIGNED NOT NULL,
+    last_error VARCHAR(1023) NOT NULL DEFAULT '',
+    sent_unix INT UNSIGNED NOT NULL DEFAULT 0,
+    dead_unix INT UNSIGNED NOT NULL DEFAULT 0,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    INDEX (sent_unix, dead_unix, next_attempt_unix)
+);
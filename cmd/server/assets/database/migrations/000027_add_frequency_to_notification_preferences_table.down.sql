@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+DROP COLUMN frequency,
+DROP COLUMN summary_unix;
\ No newline at end of file
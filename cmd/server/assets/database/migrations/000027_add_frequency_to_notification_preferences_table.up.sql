@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+ADD COLUMN frequency VARCHAR(15) NOT NULL DEFAULT 'immediate',
+ADD COLUMN summary_unix INT UNSIGNED NOT NULL DEFAULT 0
\ No newline at end of file
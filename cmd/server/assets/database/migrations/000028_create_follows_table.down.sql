@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_follows;
\ No newline at end of file
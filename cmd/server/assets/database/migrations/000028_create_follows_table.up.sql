@@ -0,0 +1,11 @@
+CREATE TABLE tbl_follows (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    kind VARCHAR(15) NOT NULL,
+    target INT NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    UNIQUE KEY (user, kind, target),
+    INDEX (kind, target)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+DROP COLUMN replies,
+DROP COLUMN publications;
\ No newline at end of file
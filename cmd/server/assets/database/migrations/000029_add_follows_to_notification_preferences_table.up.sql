@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+ADD COLUMN replies BOOL DEFAULT TRUE,
+ADD COLUMN publications BOOL DEFAULT TRUE
\ No newline at end of file
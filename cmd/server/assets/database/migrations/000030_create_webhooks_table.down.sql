@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_webhooks;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE tbl_webhooks (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL DEFAULT 0,
+    url VARCHAR(255) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    INDEX (user)
+);
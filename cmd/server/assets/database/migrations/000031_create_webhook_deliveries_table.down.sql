@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_webhook_deliveries;
\ No newline at end of file
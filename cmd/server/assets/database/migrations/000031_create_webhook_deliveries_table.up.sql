@@ -0,0 +1,16 @@
+CREATE TABLE tbl_webhook_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    webhook INT NOT NULL,
+    kind VARCHAR(31) NOT NULL,
+    payload BLOB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    status_code INT NOT NULL DEFAULT 0,
+    next_attempt_unix INT UNSIGNED NOT NULL,
+    last_error VARCHAR(1023) NOT NULL DEFAULT '',
+    delivered_unix INT UNSIGNED NOT NULL DEFAULT 0,
+    dead_unix INT UNSIGNED NOT NULL DEFAULT 0,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (webhook) REFERENCES tbl_webhooks(id),
+    INDEX (delivered_unix, dead_unix, next_attempt_unix)
+);
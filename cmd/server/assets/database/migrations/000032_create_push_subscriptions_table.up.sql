@@ -0,0 +1,11 @@
+CREATE TABLE tbl_push_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    endpoint VARCHAR(1023) NOT NULL,
+    p256dh VARCHAR(127) NOT NULL,
+    auth VARCHAR(63) NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    INDEX (endpoint(255))
+);
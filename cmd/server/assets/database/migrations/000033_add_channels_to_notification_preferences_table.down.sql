@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+DROP COLUMN email,
+DROP COLUMN push;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE tbl_notification_preferences
+ADD COLUMN email BOOL DEFAULT TRUE,
+ADD COLUMN push BOOL DEFAULT TRUE
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_tokens;
\ No newline at end of file
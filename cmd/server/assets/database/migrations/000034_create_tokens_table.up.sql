@@ -0,0 +1,12 @@
+CREATE TABLE tbl_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (user) REFERENCES tbl_users(id),
+    UNIQUE KEY (hash),
+    INDEX (user)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE tbl_awards
+DROP INDEX edition,
+DROP COLUMN edition;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE tbl_awards
+ADD COLUMN edition VARCHAR(7) NULL AFTER user;
+
+UPDATE tbl_awards
+SET edition=DATE_FORMAT(STR_TO_DATE(CONCAT('1 ', SUBSTRING(reason, 15)), '%e %M %Y'), '%Y-%m')
+WHERE reason LIKE 'Convey Digest %';
+
+ALTER TABLE tbl_awards
+ADD UNIQUE KEY (edition, user, reason);
\ No newline at end of file
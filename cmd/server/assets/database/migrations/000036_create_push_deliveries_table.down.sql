@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tbl_push_deliveries;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE tbl_push_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    subscription INT NOT NULL,
+    digest VARCHAR(64) NOT NULL,
+    created_unix INT UNSIGNED NOT NULL,
+    deleted_at INT UNSIGNED DEFAULT 0,
+    FOREIGN KEY (subscription) REFERENCES tbl_push_subscriptions(id),
+    INDEX (subscription, digest)
+);
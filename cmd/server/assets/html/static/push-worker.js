@@ -0,0 +1,18 @@
+self.addEventListener("push", function(event) {
+  if (!event.data) {
+    return;
+  }
+  const message = event.data.json();
+  event.waitUntil(self.registration.showNotification(message.title, {
+    body: message.body,
+    icon: "/static/logo.svg",
+    data: {
+      link: message.link,
+    },
+  }));
+});
+
+self.addEventListener("notificationclick", function(event) {
+  event.notification.close();
+  event.waitUntil(clients.openWindow(event.notification.data.link));
+});
@@ -0,0 +1,61 @@
+function SetupPush(button, status, key) {
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    status.innerHTML = "Push notifications are not supported by this browser.";
+    return;
+  }
+
+  // Convert the Application Server Key from URL Safe Base64
+  const decodeKey = function(k) {
+    const padding = "=".repeat((4 - k.length % 4) % 4);
+    const raw = atob((k + padding).replace(/-/g, "+").replace(/_/g, "/"));
+    const bytes = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) {
+      bytes[i] = raw.charCodeAt(i);
+    }
+    return bytes;
+  };
+
+  const register = function(subscription) {
+    return fetch("/push-subscription", {
+      method: "POST",
+      credentials: "same-origin",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(subscription),
+    }).then(function(response) {
+      if (!response.ok) {
+        throw new Error(response.statusText);
+      }
+      status.innerHTML = "Push notifications are enabled on this browser.";
+    });
+  };
+
+  navigator.serviceWorker.register("/static/push-worker.js").then(function(registration) {
+    return registration.pushManager.getSubscription().then(function(subscription) {
+      if (subscription) {
+        // Refresh the subscription in case it changed
+        return register(subscription);
+      }
+      button.style.display = "inline";
+      button.onclick = function() {
+        Notification.requestPermission().then(function(permission) {
+          if (permission !== "granted") {
+            throw new Error("Permission Denied");
+          }
+          return registration.pushManager.subscribe({
+            userVisibleOnly: true,
+            applicationServerKey: decodeKey(key),
+          });
+        }).then(function(subscription) {
+          button.style.display = "none";
+          return register(subscription);
+        }).catch(function(error) {
+          status.innerHTML = error.message;
+        });
+      };
+    });
+  }).catch(function(error) {
+    status.innerHTML = error.message;
+  });
+}
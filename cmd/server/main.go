@@ -10,8 +10,10 @@ import (
 	"aletheiaware.com/conveyearthgo/database"
 	"aletheiaware.com/conveyearthgo/filesystem"
 	"aletheiaware.com/conveyearthgo/handler"
+	"aletheiaware.com/conveyearthgo/render"
 	"aletheiaware.com/netgo"
 	nethandler "aletheiaware.com/netgo/handler"
+	"crypto/ecdsa"
 	"crypto/tls"
 	"embed"
 	"errors"
@@ -19,11 +21,13 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/stripe/stripe-go/v72"
 	"html/template"
+	"image/color"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -129,19 +133,72 @@ func main() {
 	// Create a Account Manager
 	am := conveyearthgo.NewAccountManager(db)
 
+	// Sign Unsubscribe Links
+	unsubscribe := []byte(os.Getenv("UNSUBSCRIBE_SECRET"))
+	if secure && len(unsubscribe) == 0 {
+		log.Fatal(errors.New("Missing UNSUBSCRIBE_SECRET environment variable"))
+	}
+
+	// Identify the Server to Push Services
+	var vapid *ecdsa.PrivateKey
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		vapid, err = conveyearthgo.ParseVapidKey(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if secure {
+		log.Fatal(errors.New("Missing VAPID_PRIVATE_KEY environment variable"))
+	} else {
+		// Subscriptions will not survive a restart
+		vapid, err = conveyearthgo.GenerateVapidKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	subject, ok := os.LookupEnv("VAPID_SUBJECT")
+	if !ok {
+		subject = fmt.Sprintf("%s://%s", scheme, host)
+	}
+	push := conveyearthgo.NewPushManager(db, conveyearthgo.NewPublicClient(10*time.Second), vapid, subject)
+
+	// Handle Push Subscriptions
+	handler.AttachPushSubscriptionHandler(mux, auth, push)
+
 	// Create a Notification Manager
 	var ns conveyearthgo.NotificationSender
 	if secure {
 		server := os.Getenv("SMTP_SERVER")
 		sender := os.Getenv("SMTP_SENDER")
-		ns = conveyearthgo.NewSmtpNotificationSender(scheme, host, server, host, sender, templates)
+		operator := os.Getenv("OPERATOR_EMAIL")
+		ns = conveyearthgo.NewSmtpNotificationSender(scheme, host, server, host, sender, operator, unsubscribe, templates)
 	} else {
 		ns = conveytest.NewNotificationSender()
 	}
-	nm := conveyearthgo.NewNotificationManager(db, ns)
+
+	// Send Notifications by Email and Push according to each User's Preferences
+	ns = conveyearthgo.NewFanoutNotificationSender(db, ns, conveyearthgo.NewPushNotificationSender(push, scheme, host))
+
+	// Queue Notifications so a Slow Sender doesn't Stall Requests
+	nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+	om := conveyearthgo.NewOutboxManager(db, ns, 10, time.Minute)
+	defer conveyearthgo.RunOutbox(om, 10*time.Second, 100)()
+	defer conveyearthgo.RunNotificationSummaries(nm, 5*time.Minute)()
 
 	// Handle Notification Preferences
-	handler.AttachNotificationPreferencesHandler(mux, auth, nm, templates)
+	handler.AttachNotificationPreferencesHandler(mux, auth, nm, push, templates)
+
+	// Handle Notifications
+	handler.AttachNotificationsHandler(mux, auth, nm, templates, 100)
+
+	// Handle Unsubscribe
+	handler.AttachUnsubscribeHandler(mux, nm, unsubscribe, templates)
+
+	// Deliver Webhooks in the Background so a Slow Endpoint doesn't Stall Requests
+	wm := conveyearthgo.NewWebhookManager(db, conveyearthgo.NewPublicClient(10*time.Second), 10, time.Minute)
+	defer conveyearthgo.RunWebhooks(wm, 10*time.Second, 100)()
+
+	// Handle Webhooks
+	handler.AttachWebhooksHandler(mux, auth, wm, templates, 10)
 
 	uploads, ok := os.LookupEnv("UPLOAD_DIRECTORY")
 	if !ok {
@@ -152,8 +209,13 @@ func main() {
 	}
 	log.Println("Uploads Directory:", uploads)
 
+	rp, err := conveyearthgo.ParseRefundPolicy(os.Getenv("REFUND_POLICY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Create a Content Manager
-	cm := conveyearthgo.NewContentManager(db, filesystem.NewOnDisk(uploads))
+	cm := conveyearthgo.NewContentManager(db, filesystem.NewOnDisk(uploads), rp)
 
 	// Handle Content
 	handler.AttachContentHandler(mux, cm, fmt.Sprintf("public, immutable, max-age=%d", 60*60*24*7*52)) // 52 week max-age
@@ -170,11 +232,26 @@ func main() {
 	// Handle Digest
 	handler.AttachDigestHandler(mux, auth, templates, digests, fmt.Sprintf("public, max-age=%d", 60*60*24*7*52)) // 52 week max-age
 
+	// Create a Token Manager
+	tm := conveyearthgo.NewTokenManager(db)
+
 	// Handle Account
-	handler.AttachAccountHandler(mux, auth, am, nm, templates)
+	handler.AttachAccountHandler(mux, auth, am, nm, tm, templates)
+
+	// Create a Bundle Catalog
+	var bc conveyearthgo.BundleCatalog
+	if catalog, ok := os.LookupEnv("BUNDLE_CATALOG"); ok {
+		bc, err = conveyearthgo.LoadStaticBundleCatalog(catalog)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		bc = conveyearthgo.NewStripeBundleCatalog(host)
+		defer conveyearthgo.RefreshBundleCatalog(bc, time.Hour)()
+	}
 
 	// Handle Buy Coins
-	handler.AttachCoinBuyHandler(mux, auth, am, templates)
+	handler.AttachCoinBuyHandler(mux, auth, am, bc, templates)
 
 	// Create a Stripe Manager
 	sm := conveyearthgo.NewStripeManager(db)
@@ -182,23 +259,107 @@ func main() {
 	// Handle Stripe
 	handler.AttachStripeHandler(mux, auth, sm, templates)
 
+	payoutCurrency, ok := os.LookupEnv("PAYOUT_CURRENCY")
+	if !ok {
+		payoutCurrency = string(stripe.CurrencyUSD)
+	}
+	payoutRate := int64(1)
+	if v, ok := os.LookupEnv("PAYOUT_RATE"); ok {
+		payoutRate, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || payoutRate <= 0 {
+			log.Fatal(errors.New("Invalid PAYOUT_RATE"))
+		}
+	}
+	payoutMinimum := int64(1000)
+	if v, ok := os.LookupEnv("PAYOUT_MINIMUM"); ok {
+		payoutMinimum, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Create a Payout Manager, Retrying Transfers whose Outcome is Unknown in the Background
+	pm := conveyearthgo.NewPayoutManager(db, payoutCurrency, payoutRate, payoutMinimum)
+	defer conveyearthgo.RunPayouts(pm, 10*time.Minute)()
+
+	// Handle Payouts
+	handler.AttachPayoutHandler(mux, auth, sm, pm, templates)
+
 	// Handle Stripe Webhook
-	handler.AttachStripeWebhookHandler(mux, am, os.Getenv("STRIPE_WEBHOOK_SECRET_KEY"), host)
+	handler.AttachStripeWebhookHandler(mux, am, nm, pm, bc, os.Getenv("STRIPE_WEBHOOK_SECRET_KEY"), host)
 
 	// Handle Conversation
-	handler.AttachConversationHandler(mux, auth, cm, templates)
+	handler.AttachConversationHandler(mux, auth, cm, nm, templates)
+
+	cards, ok := os.LookupEnv("CARD_DIRECTORY")
+	if !ok {
+		cards = "cards"
+	}
+	if err := os.MkdirAll(cards, os.ModePerm); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Cards Directory:", cards)
+
+	card := &render.Card{
+		Width:       conveyearthgo.CARD_WIDTH,
+		Height:      conveyearthgo.CARD_HEIGHT,
+		Margin:      60,
+		Background:  color.White,
+		Emojis:      os.Getenv("EMOJI_DIRECTORY"),
+		TopicSize:   64,
+		TopicColor:  color.RGBA{0x00, 0xbf, 0xff, 0xff}, // deepskyblue
+		TopicLines:  4,
+		BylineSize:  32,
+		BylineColor: color.Gray{0x80},
+	}
+	topicFont, err := staticFS.Open("NotoSerif-ExtraBold.ttf")
+	if err != nil {
+		log.Fatal(err)
+	}
+	card.TopicFont, err = render.ParseFont(topicFont)
+	topicFont.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bylineFont, err := staticFS.Open("NotoSerif-Regular.ttf")
+	if err != nil {
+		log.Fatal(err)
+	}
+	card.BylineFont, err = render.ParseFont(bylineFont)
+	bylineFont.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logo, err := staticFS.Open("convey.svg")
+	if err != nil {
+		log.Fatal(err)
+	}
+	card.Logo, err = render.Rasterize(logo, 144, 96)
+	logo.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Handle Conversation Cards
+	handler.AttachCardHandler(mux, cm, conveyearthgo.NewCardManager(filesystem.NewOnDisk(cards), card), fmt.Sprintf("public, max-age=%d", 60*60)) // 1 hour max-age
+
+	// Handle Embeds
+	handler.AttachEmbedHandlers(mux, cm, templates, fmt.Sprintf("public, max-age=%d", 60*5)) // 5 minute max-age
+
+	// Handle Follow
+	handler.AttachFollowHandler(mux, auth, cm, nm)
 
 	// Handle Publish
-	handler.AttachPublishHandler(mux, auth, am, cm, nm, templates)
+	handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, templates)
 
 	// Handle Reply
-	handler.AttachReplyHandler(mux, auth, am, cm, nm, templates)
+	handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, templates)
 
 	// Handle Gift
-	handler.AttachGiftHandler(mux, auth, am, cm, nm, templates)
+	handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, templates)
 
 	// Handle Delete
-	handler.AttachDeleteHandler(mux, auth, am, cm, templates)
+	handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, templates)
 
 	// Handle Best
 	handler.AttachBestHandler(mux, auth, cm, templates, 8, 100)
@@ -206,6 +367,12 @@ func main() {
 	// Handle Recent
 	handler.AttachRecentHandler(mux, auth, cm, templates, 8, 100)
 
+	// Handle Feeds
+	handler.AttachFeedHandlers(mux, am, cm, 20, fmt.Sprintf("public, max-age=%d", 60*5)) // 5 minute max-age
+
+	// Handle API
+	handler.AttachAPIHandlers(mux, auth, tm, am, cm, nm, wm)
+
 	// Handle About
 	handler.AttachAboutHandler(mux, templates)
 
@@ -219,9 +386,17 @@ func main() {
 		http.Redirect(w, r, "/static/robots.txt", http.StatusFound)
 	})))
 
+	// Handle sitemap.xml
+	handler.AttachSitemapHandler(mux, cm, []string{
+		"/",
+		"/about",
+		"/digest",
+		"/recent",
+	}, handler.MAXIMUM_SITEMAP_URLS, fmt.Sprintf("public, max-age=%d", 60*60)) // 1 hour max-age
+
 	// Handle sitemap.txt
 	mux.Handle("/sitemap.txt", nethandler.Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/static/sitemap.txt", http.StatusFound)
+		http.Redirect(w, r, "/sitemap.xml", http.StatusMovedPermanently)
 	})))
 
 	// Handle markdown
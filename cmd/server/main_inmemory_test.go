@@ -66,3 +66,7 @@ func TestInMemoryAccountRecoveryAccountPasswordAccount(t *testing.T) {
 func TestInMemory_AccountBalance(t *testing.T) {
 	AccountBalance(t, database.NewInMemory())
 }
+
+func TestInMemory_DeletionReversal(t *testing.T) {
+	DeletionReversal(t, database.NewInMemory())
+}
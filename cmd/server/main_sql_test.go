@@ -145,3 +145,11 @@ func TestSql_AccountBalance(t *testing.T) {
 
 	AccountBalance(t, NewSqlDatabase(t))
 }
+
+func TestSql_DeletionReversal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping SQL database test in short mode.")
+	}
+
+	DeletionReversal(t, NewSqlDatabase(t))
+}
@@ -83,3 +83,59 @@ func AccountBalance(t *testing.T, db DB) {
 	assertBalance(t, db, user1, 3000)
 	assertBalance(t, db, user2, 0)
 }
+
+func DeletionReversal(t *testing.T, db DB) {
+	t.Helper()
+	created := time.Now()
+
+	// Add 2 Users
+	hash, err := authgo.GeneratePasswordHash([]byte(authtest.TEST_PASSWORD))
+	assert.Nil(t, err)
+	user1, err := db.CreateUser("1"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"1", hash, created)
+	assert.Nil(t, err)
+	user2, err := db.CreateUser("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", hash, created)
+	assert.Nil(t, err)
+
+	// Add Conversation, Message, and Charge
+	conversation, err := db.CreateConversation(user1, "topic", created)
+	assert.Nil(t, err)
+	message, err := db.CreateMessage(user1, conversation, 0, created)
+	assert.Nil(t, err)
+	_, err = db.CreateCharge(user1, conversation, message, 500, created)
+	assert.Nil(t, err)
+
+	// Create Reply, Charge and Yield
+	reply, err := db.CreateMessage(user2, conversation, message, created)
+	assert.Nil(t, err)
+	_, err = db.CreateCharge(user2, conversation, reply, 1000, created)
+	assert.Nil(t, err)
+	_, err = db.CreateYield(user2, conversation, reply, message, 500, created)
+	assert.Nil(t, err)
+
+	assertBalance(t, db, user1, 0)
+	assertBalance(t, db, user2, -1000)
+
+	// Yields are listed against the ancestor's author
+	var yields int64
+	assert.Nil(t, db.SelectMessageYields(reply, func(a *authgo.Account, amount int64) error {
+		assert.Equal(t, user1, a.ID)
+		yields += amount
+		return nil
+	}))
+	assert.Equal(t, int64(500), yields)
+
+	// Deleting the reply records its reversals, which move the coins back
+	reversals := []*conveyearthgo.Reversal{
+		{Account: &authgo.Account{ID: user2}, ConversationID: conversation, MessageID: reply, Reason: conveyearthgo.REVERSAL_CHARGE_REFUND, Amount: 1000, Created: created},
+		{Account: &authgo.Account{ID: user1}, ConversationID: conversation, MessageID: reply, Reason: conveyearthgo.REVERSAL_YIELD_REVERSAL, Amount: -500, Created: created},
+	}
+	count, err := db.DeleteMessage(user2, reply, created, reversals)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	for _, r := range reversals {
+		assert.NotZero(t, r.ID)
+	}
+
+	assertBalance(t, db, user1, -500)
+	assertBalance(t, db, user2, 0)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"crypto/elliptic"
+	"encoding/base64"
+	"fmt"
+	"log"
+)
+
+// Generates a key pair identifying the server to push services, the private key is set as VAPID_PRIVATE_KEY
+func main() {
+	key, err := conveyearthgo.GenerateVapidKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Private Key:", conveyearthgo.EncodeVapidKey(key))
+	fmt.Println("Public Key:", base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y)))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/netgo"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	add        = flag.String("add", "", "URL of an operator webhook to register")
+	remove     = flag.Int64("remove", 0, "ID of the operator webhook to remove")
+	deliveries = flag.Int64("deliveries", 10, "Number of recent deliveries to list for each webhook")
+)
+
+func main() {
+	flag.Parse()
+
+	dbName := os.Getenv("DB_NAME")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbSecure := netgo.IsSecure()
+	if dbHost == "" || dbHost == "localhost" {
+		// XXX FIXME Disable TLS for local connections
+		dbSecure = false
+	}
+	db, err := database.NewSql(dbName, dbUser, dbPassword, dbHost, dbPort, dbSecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// Operator webhooks are only managed here, the server's worker delivers them
+	wm := conveyearthgo.NewWebhookManager(db, nil, 0, 0)
+
+	if *add != "" {
+		webhook, err := wm.Register(0, *add)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d\t%s\t%s\n", webhook.ID, webhook.URL, webhook.Secret)
+		return
+	}
+
+	if *remove != 0 {
+		if err := wm.Remove(0, *remove); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var webhooks []*conveyearthgo.Webhook
+	if err := wm.Webhooks(0, func(webhook *conveyearthgo.Webhook) error {
+		webhooks = append(webhooks, webhook)
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+	for _, webhook := range webhooks {
+		fmt.Printf("%d\t%s\t%s\t%s\n", webhook.ID, webhook.Created.Format("2006-01-02 15:04:05"), webhook.URL, webhook.Secret)
+		if err := wm.Deliveries(0, webhook.ID, *deliveries, func(d *conveyearthgo.WebhookDelivery) error {
+			fmt.Printf("\t%d\t%s\t%s\t%d\t%d\t%s\n", d.ID, d.Created.Format("2006-01-02 15:04:05"), d.Kind, d.Attempts, d.StatusCode, d.LastError)
+			return nil
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
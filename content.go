@@ -15,7 +15,9 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/url"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -93,6 +95,31 @@ func MimeTypeFromHeader(header *multipart.FileHeader) (string, error) {
 	return mediaType, nil
 }
 
+var extensions = map[string]string{
+	".gif":      MIME_IMAGE_GIF,
+	".jpeg":     MIME_IMAGE_JPEG,
+	".jpg":      MIME_IMAGE_JPEG,
+	".markdown": MIME_TEXT_MARKDOWN,
+	".md":       MIME_TEXT_MARKDOWN,
+	".mp4":      MIME_VIDEO_MP4,
+	".ogg":      MIME_VIDEO_OGG,
+	".ogv":      MIME_VIDEO_OGG,
+	".pdf":      MIME_APPLICATION_PDF,
+	".png":      MIME_IMAGE_PNG,
+	".svg":      MIME_IMAGE_SVG,
+	".txt":      MIME_TEXT_PLAIN,
+	".webm":     MIME_VIDEO_WEBM,
+	".webp":     MIME_IMAGE_WEBP,
+}
+
+// MimeTypeFromFilename returns the supported mime type of the file's extension.
+func MimeTypeFromFilename(name string) (string, error) {
+	if m, ok := extensions[strings.ToLower(filepath.Ext(name))]; ok {
+		return m, nil
+	}
+	return "", ErrMimeUnrecognized
+}
+
 var mentions = regexp.MustCompile(`(^|\s)@[[:alnum:]]{3,}`)
 
 func Mentions(input string) []string {
@@ -104,21 +131,27 @@ func Mentions(input string) []string {
 	for u := range usernames {
 		us = append(us, u)
 	}
+	sort.Strings(us)
 	return us
 }
 
 type ContentDatabase interface {
+	AccountDatabase
 	CreateConversation(int64, string, time.Time) (int64, error)
-	DeleteConversation(int64, int64, time.Time) (int64, error)
 	SelectConversation(int64) (*authgo.Account, string, time.Time, error)
 	SelectBestConversations(func(int64, *authgo.Account, string, time.Time, int64, int64) error, time.Time, int64) error
 	SelectRecentConversations(func(int64, *authgo.Account, string, time.Time, int64, int64) error, int64) error
+	SelectAuthorConversations(int64, func(int64, *authgo.Account, string, time.Time, int64, int64) error, int64) error
+	SelectConversationCount() (int64, error)
+	SelectConversationModifications(int64, int64, func(int64, time.Time) error) error
+	SelectConversationActivity(int64) (time.Time, error)
 
 	CreateMessage(int64, int64, int64, time.Time) (int64, error)
-	DeleteMessage(int64, int64, time.Time) (int64, error)
+	DeleteMessage(int64, int64, time.Time, []*Reversal) (int64, error)
 	SelectMessage(int64) (*authgo.Account, int64, int64, time.Time, int64, int64, error)
 	SelectMessages(int64, func(int64, *authgo.Account, int64, time.Time, int64, int64) error) error
 	SelectMessageParent(int64) (int64, error)
+	SelectOpeningMessage(int64) (int64, error)
 
 	CreateFile(int64, string, string, time.Time) (int64, error)
 	SelectFile(int64) (int64, string, string, time.Time, error)
@@ -126,9 +159,12 @@ type ContentDatabase interface {
 
 	CreateCharge(int64, int64, int64, int64, time.Time) (int64, error)
 	CreateYield(int64, int64, int64, int64, int64, time.Time) (int64, error)
+	SelectMessageYields(int64, func(*authgo.Account, int64) error) error
+
+	CreateReversal(int64, int64, int64, int64, string, int64, time.Time) (int64, error)
 
 	CreateGift(int64, int64, int64, int64, time.Time) (int64, error)
-	DeleteGift(int64, int64, time.Time) (int64, error)
+	DeleteGift(int64, int64, time.Time, []*Reversal) (int64, error)
 	SelectGift(int64) (int64, int64, *authgo.Account, int64, time.Time, error)
 	SelectGifts(int64, int64, func(int64, int64, int64, *authgo.Account, int64, time.Time) error) error
 }
@@ -142,28 +178,36 @@ type ContentManager interface {
 	LookupConversation(int64) (*Conversation, error)
 	LookupBestConversations(func(*Conversation) error, time.Time, int64) error
 	LookupRecentConversations(func(*Conversation) error, int64) error
+	LookupAuthorConversations(int64, func(*Conversation) error, int64) error
+	LookupConversationCount() (int64, error)
+	LookupConversationModifications(int64, int64, func(int64, time.Time) error) error
+	LookupConversationActivity(int64) (time.Time, error)
 	NewMessage(*authgo.Account, int64, int64, []string, []string, []int64) (*Message, []*File, error)
-	DeleteMessage(*authgo.Account, *Message) error
+	DeleteMessage(*authgo.Account, *Message) ([]*Reversal, error)
 	LookupMessage(int64) (*Message, error)
 	LookupMessages(int64, func(*Message) error) error
+	LookupOpeningMessage(int64) (*Message, error)
+	LookupYields(int64, func(*authgo.Account, int64) error) error
 	LookupFile(int64) (*File, error)
 	LookupFiles(int64, func(*File) error) error
 	NewGift(*authgo.Account, int64, int64, int64) (*Gift, error)
-	DeleteGift(*authgo.Account, *Gift) error
+	DeleteGift(*authgo.Account, *Gift) ([]*Reversal, error)
 	LookupGift(int64) (*Gift, error)
 	LookupGifts(int64, int64, func(*Gift) error) error
 }
 
-func NewContentManager(db ContentDatabase, fs Filesystem) ContentManager {
+func NewContentManager(db ContentDatabase, fs Filesystem, rp RefundPolicy) ContentManager {
 	return &contentManager{
 		database:   db,
 		filesystem: fs,
+		policy:     rp,
 	}
 }
 
 type contentManager struct {
 	database   ContentDatabase
 	filesystem Filesystem
+	policy     RefundPolicy
 }
 
 func (m *contentManager) Open(path string) (fs.File, error) {
@@ -281,6 +325,9 @@ func (m *contentManager) ToHTML(hash, mime string) (template.HTML, error) {
 }
 
 func (m *contentManager) NewConversation(account *authgo.Account, topic string, hashes, mimes []string, sizes []int64) (*Conversation, *Message, []*File, error) {
+	if err := m.checkBalance(account); err != nil {
+		return nil, nil, nil, err
+	}
 	created := time.Now()
 	conversation, err := m.database.CreateConversation(account.ID, topic, created)
 	if err != nil {
@@ -374,7 +421,38 @@ func (m *contentManager) LookupRecentConversations(callback func(*Conversation)
 	}, limit)
 }
 
+// LookupAuthorConversations calls the callback with the author's most recent conversations, newest first.
+func (m *contentManager) LookupAuthorConversations(author int64, callback func(*Conversation) error, limit int64) error {
+	return m.database.SelectAuthorConversations(author, func(id int64, author *authgo.Account, topic string, created time.Time, cost, yield int64) error {
+		return callback(&Conversation{
+			ID:      id,
+			Author:  author,
+			Topic:   topic,
+			Cost:    cost,
+			Yield:   yield,
+			Created: created,
+		})
+	}, limit)
+}
+
+func (m *contentManager) LookupConversationCount() (int64, error) {
+	return m.database.SelectConversationCount()
+}
+
+// LookupConversationModifications calls the callback with the ID of each conversation, in order, and the time of its latest message.
+func (m *contentManager) LookupConversationModifications(offset, limit int64, callback func(int64, time.Time) error) error {
+	return m.database.SelectConversationModifications(offset, limit, callback)
+}
+
+// LookupConversationActivity returns the latest time a message or gift in the conversation was created or deleted.
+func (m *contentManager) LookupConversationActivity(conversation int64) (time.Time, error) {
+	return m.database.SelectConversationActivity(conversation)
+}
+
 func (m *contentManager) NewMessage(account *authgo.Account, conversation, parent int64, hashes, mimes []string, sizes []int64) (*Message, []*File, error) {
+	if err := m.checkBalance(account); err != nil {
+		return nil, nil, err
+	}
 	created := time.Now()
 	message, err := m.database.CreateMessage(account.ID, conversation, parent, created)
 	if err != nil {
@@ -429,27 +507,39 @@ func (m *contentManager) NewMessage(account *authgo.Account, conversation, paren
 	}, files, nil
 }
 
-func (m *contentManager) DeleteMessage(account *authgo.Account, message *Message) error {
+func (m *contentManager) DeleteMessage(account *authgo.Account, message *Message) ([]*Reversal, error) {
 	deleted := time.Now()
-	count, err := m.database.DeleteMessage(account.ID, message.ID, deleted)
+	var reversals []*Reversal
+	if m.policy(message.Created, deleted) {
+		if message.Cost > 0 {
+			reversals = append(reversals, newReversal(account, message.ConversationID, message.ID, 0, REVERSAL_CHARGE_REFUND, message.Cost, deleted))
+		}
+		// Collect yields paid to ancestors before they are deleted
+		if err := m.database.SelectMessageYields(message.ID, func(recipient *authgo.Account, amount int64) error {
+			if amount != 0 {
+				reversals = append(reversals, newReversal(recipient, message.ConversationID, message.ID, 0, REVERSAL_YIELD_REVERSAL, -amount, deleted))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	// Reversals are recorded, and an opening message's conversation is deleted, in the same transaction as the message
+	count, err := m.database.DeleteMessage(account.ID, message.ID, deleted, reversals)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if count == 0 {
-		return ErrDeletionNotPermitted
+		return nil, ErrDeletionNotPermitted
 	}
 	log.Println("Deleted Message", message.ID)
+	for _, r := range reversals {
+		log.Println("Created Reversal", r.ID)
+	}
 	if message.ParentID == 0 {
-		count, err := m.database.DeleteConversation(account.ID, message.ConversationID, deleted)
-		if err != nil {
-			return err
-		}
-		if count == 0 {
-			return ErrDeletionNotPermitted
-		}
 		log.Println("Deleted Conversation", message.ConversationID)
 	}
-	return nil
+	return reversals, nil
 }
 
 func (m *contentManager) LookupMessage(id int64) (*Message, error) {
@@ -472,6 +562,16 @@ func (m *contentManager) LookupMessage(id int64) (*Message, error) {
 	}, nil
 }
 
+// LookupOpeningMessage returns the first message of the conversation, without loading its replies.
+func (m *contentManager) LookupOpeningMessage(conversation int64) (*Message, error) {
+	id, err := m.database.SelectOpeningMessage(conversation)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrMessageNotFound
+	}
+	return m.LookupMessage(id)
+}
+
 func (m *contentManager) LookupMessages(conversation int64, callback func(*Message) error) error {
 	return m.database.SelectMessages(conversation, func(id int64, author *authgo.Account, parent int64, created time.Time, cost, yield int64) error {
 		return callback(&Message{
@@ -486,6 +586,10 @@ func (m *contentManager) LookupMessages(conversation int64, callback func(*Messa
 	})
 }
 
+func (m *contentManager) LookupYields(message int64, callback func(*authgo.Account, int64) error) error {
+	return m.database.SelectMessageYields(message, callback)
+}
+
 func (m *contentManager) LookupFile(id int64) (*File, error) {
 	if id == 0 {
 		return nil, ErrFileNotFound
@@ -517,6 +621,9 @@ func (m *contentManager) LookupFiles(message int64, callback func(*File) error)
 }
 
 func (m *contentManager) NewGift(account *authgo.Account, conversation, message int64, amount int64) (*Gift, error) {
+	if err := m.checkBalance(account); err != nil {
+		return nil, err
+	}
 	created := time.Now()
 	gift, err := m.database.CreateGift(account.ID, conversation, message, amount, created)
 	if err != nil {
@@ -533,17 +640,32 @@ func (m *contentManager) NewGift(account *authgo.Account, conversation, message
 	}, nil
 }
 
-func (m *contentManager) DeleteGift(account *authgo.Account, gift *Gift) error {
+func (m *contentManager) DeleteGift(account *authgo.Account, gift *Gift) ([]*Reversal, error) {
 	deleted := time.Now()
-	count, err := m.database.DeleteGift(account.ID, gift.ID, deleted)
+	var reversals []*Reversal
+	if m.policy(gift.Created, deleted) && gift.Amount != 0 {
+		recipient, _, _, _, _, _, err := m.database.SelectMessage(gift.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		reversals = []*Reversal{
+			newReversal(account, gift.ConversationID, gift.MessageID, gift.ID, REVERSAL_GIFT_REFUND, gift.Amount, deleted),
+			newReversal(recipient, gift.ConversationID, gift.MessageID, gift.ID, REVERSAL_GIFT_REVERSAL, -gift.Amount, deleted),
+		}
+	}
+	// Reversals are recorded, and an opening message's conversation is deleted, in the same transaction as the message
+	count, err := m.database.DeleteGift(account.ID, gift.ID, deleted, reversals)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if count == 0 {
-		return ErrDeletionNotPermitted
+		return nil, ErrDeletionNotPermitted
 	}
 	log.Println("Deleted Gift", gift.ID)
-	return nil
+	for _, r := range reversals {
+		log.Println("Created Reversal", r.ID)
+	}
+	return reversals, nil
 }
 
 func (m *contentManager) LookupGift(id int64) (*Gift, error) {
@@ -577,3 +699,27 @@ func (m *contentManager) LookupGifts(conversation, message int64, callback func(
 		})
 	})
 }
+
+// checkBalance blocks spending while the account's balance is negative, such as after a purchase is refunded or disputed.
+func (m *contentManager) checkBalance(account *authgo.Account) error {
+	balance, err := AccountBalance(m.database, account.ID)
+	if err != nil {
+		return err
+	}
+	if balance < 0 {
+		return ErrBalanceNegative
+	}
+	return nil
+}
+
+func newReversal(account *authgo.Account, conversation, message, gift int64, reason string, amount int64, created time.Time) *Reversal {
+	return &Reversal{
+		Account:        account,
+		ConversationID: conversation,
+		MessageID:      message,
+		GiftID:         gift,
+		Reason:         reason,
+		Amount:         amount,
+		Created:        created,
+	}
+}
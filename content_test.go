@@ -70,6 +70,31 @@ func TestMimeTypeFromHeader(t *testing.T) {
 	// TODO
 }
 
+func TestMimeTypeFromFilename(t *testing.T) {
+	for name, tt := range map[string]struct {
+		filename string
+		expected string
+		err      error
+	}{
+		"markdown":  {filename: "post.md", expected: conveyearthgo.MIME_TEXT_MARKDOWN},
+		"plain":     {filename: "notes.txt", expected: conveyearthgo.MIME_TEXT_PLAIN},
+		"jpg":       {filename: "photo.jpg", expected: conveyearthgo.MIME_IMAGE_JPEG},
+		"uppercase": {filename: "PHOTO.PNG", expected: conveyearthgo.MIME_IMAGE_PNG},
+		"path":      {filename: "docs/paper.v2.pdf", expected: conveyearthgo.MIME_APPLICATION_PDF},
+		"none":      {filename: "README", err: conveyearthgo.ErrMimeUnrecognized},
+		"model":     {filename: "teapot.stl", err: conveyearthgo.ErrMimeUnrecognized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mime, err := conveyearthgo.MimeTypeFromFilename(tt.filename)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, mime)
+			if err == nil {
+				assert.NoError(t, conveyearthgo.ValidateMime(mime))
+			}
+		})
+	}
+}
+
 func TestMentions(t *testing.T) {
 	for name, tt := range map[string]struct {
 		input    string
@@ -131,7 +156,7 @@ func TestContentManager_Open(t *testing.T) {
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	contents := []byte("this is a test")
 	hash, size, err := cm.AddText(contents)
 	assert.NoError(t, err)
@@ -161,7 +186,7 @@ func TestContentManager_AddText(t *testing.T) {
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	contents := []byte("this is a test")
 	hash, size, err := cm.AddText(contents)
 	assert.NoError(t, err)
@@ -175,7 +200,7 @@ func TestContentManager_AddFile(t *testing.T) {
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	contents := "this is a test"
 
 	hash, size, err := cm.AddFile(strings.NewReader(contents))
@@ -190,7 +215,7 @@ func TestContentManager_ToHTML(t *testing.T) {
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	t.Run("Unrecognized", func(t *testing.T) {
 		_, err := cm.ToHTML("", "")
 		assert.Equal(t, conveyearthgo.ErrMimeUnrecognized, err)
@@ -288,11 +313,12 @@ func TestContentManager_NewConversation(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m, _ := conveytest.NewConversation(t, cm, acc)
 	t.Run("Lookup", func(t *testing.T) {
 		found, err := cm.LookupConversation(c.ID)
@@ -346,17 +372,17 @@ func TestContentManager_DeleteConversation(t *testing.T) {
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m, _ := conveytest.NewConversation(t, cm, acc)
 
 	// Cannot delete a message you did not create
 	acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 	assert.Nil(t, err)
-	err = cm.DeleteMessage(acc2, m)
+	_, err = cm.DeleteMessage(acc2, m)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 
 	// Can delete a message you created
-	err = cm.DeleteMessage(acc, m)
+	_, err = cm.DeleteMessage(acc, m)
 	assert.NoError(t, err)
 	t.Run("Lookup", func(t *testing.T) {
 		_, err := cm.LookupConversation(c.ID)
@@ -386,11 +412,12 @@ func TestContentManager_NewMessage(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m1, f1 := conveytest.NewConversation(t, cm, acc)
 	t.Run("LookupMessage", func(t *testing.T) {
 		found, err := cm.LookupMessage(m1.ID)
@@ -441,6 +468,15 @@ func TestContentManager_NewMessage(t *testing.T) {
 		assert.Equal(t, m2.Yield, found.Yield)
 		assert.Equal(t, m2.Created, found.Created)
 	})
+	t.Run("LookupOpeningMessage", func(t *testing.T) {
+		found, err := cm.LookupOpeningMessage(c.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, m1.ID, found.ID)
+		assert.Equal(t, int64(0), found.ParentID)
+
+		_, err = cm.LookupOpeningMessage(0)
+		assert.Equal(t, conveyearthgo.ErrMessageNotFound, err)
+	})
 	t.Run("LookupFile", func(t *testing.T) {
 		found, err := cm.LookupFile(f1[0].ID)
 		assert.NoError(t, err)
@@ -457,6 +493,19 @@ func TestContentManager_NewMessage(t *testing.T) {
 		assert.Equal(t, m1.ID, found.Message)
 		assert.Equal(t, m1.Created, found.Created)
 	})
+	t.Run("LookupConversationActivity", func(t *testing.T) {
+		m3, _ := conveytest.NewReply(t, cm, acc, c, m1)
+		activity, err := cm.LookupConversationActivity(c.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, m3.Created, activity)
+
+		// Deleting a reply is activity too
+		_, err = cm.DeleteMessage(acc, m3)
+		assert.NoError(t, err)
+		deleted, err := cm.LookupConversationActivity(c.ID)
+		assert.NoError(t, err)
+		assert.True(t, deleted.After(activity))
+	})
 }
 
 func TestContentManager_DeleteMessage(t *testing.T) {
@@ -464,11 +513,12 @@ func TestContentManager_DeleteMessage(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 
 	c, m1, _ := conveytest.NewConversation(t, cm, acc)
 	m2, f2 := conveytest.NewReply(t, cm, acc, c, m1)
@@ -476,11 +526,11 @@ func TestContentManager_DeleteMessage(t *testing.T) {
 	// Cannot delete a message you did not create
 	acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 	assert.Nil(t, err)
-	err = cm.DeleteMessage(acc2, m2)
+	_, err = cm.DeleteMessage(acc2, m2)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 
 	// Can delete a message you created
-	err = cm.DeleteMessage(acc, m2)
+	_, err = cm.DeleteMessage(acc, m2)
 	assert.NoError(t, err)
 	t.Run("LookupMessage", func(t *testing.T) {
 		_, err := cm.LookupMessage(m2.ID)
@@ -513,22 +563,23 @@ func TestContentManager_DeleteMessage_WithReply(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m1, f1 := conveytest.NewConversation(t, cm, acc)
 	m2, f2 := conveytest.NewReply(t, cm, acc, c, m1)
 
 	// Cannot delete a message you did not create
 	acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 	assert.Nil(t, err)
-	err = cm.DeleteMessage(acc2, m1)
+	_, err = cm.DeleteMessage(acc2, m1)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 
 	// Cannot delete a message you created if it has received a reply
-	err = cm.DeleteMessage(acc, m1)
+	_, err = cm.DeleteMessage(acc, m1)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 	t.Run("LookupMessage", func(t *testing.T) {
 		found, err := cm.LookupMessage(m1.ID)
@@ -609,22 +660,23 @@ func TestContentManager_DeleteMessage_WithGift(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m, f := conveytest.NewConversation(t, cm, acc)
 	conveytest.NewGift(t, cm, acc, c, m)
 
 	// Cannot delete a message you did not create
 	acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 	assert.Nil(t, err)
-	err = cm.DeleteMessage(acc2, m)
+	_, err = cm.DeleteMessage(acc2, m)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 
 	// Cannot delete a message you created if it has received a gift
-	err = cm.DeleteMessage(acc, m)
+	_, err = cm.DeleteMessage(acc, m)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 	t.Run("LookupMessage", func(t *testing.T) {
 		found, err := cm.LookupMessage(m.ID)
@@ -675,11 +727,12 @@ func TestContentManager_NewGift(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m, _ := conveytest.NewConversation(t, cm, acc)
 	g := conveytest.NewGift(t, cm, acc, c, m)
 	t.Run("LookupGift", func(t *testing.T) {
@@ -713,22 +766,23 @@ func TestContentManager_DeleteGift(t *testing.T) {
 	ev := authtest.NewEmailVerifier()
 	auth := authgo.NewAuthenticator(db, ev)
 	acc := authtest.NewTestAccount(t, auth)
+	conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	c, m, _ := conveytest.NewConversation(t, cm, acc)
 	g := conveytest.NewGift(t, cm, acc, c, m)
 
 	// Cannot delete a gift you did not create
 	acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 	assert.Nil(t, err)
-	err = cm.DeleteGift(acc2, g)
+	_, err = cm.DeleteGift(acc2, g)
 	assert.Error(t, conveyearthgo.ErrDeletionNotPermitted, err)
 
 	// Can delete a gift you created
-	err = cm.DeleteGift(acc, g)
+	_, err = cm.DeleteGift(acc, g)
 	assert.NoError(t, err)
 	t.Run("LookupGift", func(t *testing.T) {
 		_, err := cm.LookupGift(g.ID)
@@ -744,13 +798,137 @@ func TestContentManager_DeleteGift(t *testing.T) {
 	})
 }
 
+func TestContentManager_DeleteMessage_Refund(t *testing.T) {
+	for name, tt := range map[string]struct {
+		policy             conveyearthgo.RefundPolicy
+		reversals          int
+		balance1, balance2 int64
+	}{
+		"Full": {
+			policy:    conveyearthgo.FullRefund,
+			reversals: 2,
+			balance1:  -int64(len(conveytest.TEST_CONTENT)),
+			balance2:  0,
+		},
+		"None": {
+			policy:   conveyearthgo.NoRefund,
+			balance1: -int64(len(conveytest.TEST_CONTENT)) + int64(len(conveytest.TEST_REPLY))/2,
+			balance2: -int64(len(conveytest.TEST_REPLY)),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			ev := authtest.NewEmailVerifier()
+			auth := authgo.NewAuthenticator(db, ev)
+			acc := authtest.NewTestAccount(t, auth)
+			acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+			assert.Nil(t, err)
+			dir, err := os.MkdirTemp("", "test")
+			assert.NoError(t, err)
+			fs := filesystem.NewOnDisk(dir)
+			defer os.RemoveAll(dir)
+			am := conveyearthgo.NewAccountManager(db)
+			cm := conveyearthgo.NewContentManager(db, fs, tt.policy)
+
+			c, m1, _ := conveytest.NewConversation(t, cm, acc)
+			m2, _ := conveytest.NewReply(t, cm, acc2, c, m1)
+
+			reversals, err := cm.DeleteMessage(acc2, m2)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.reversals, len(reversals))
+
+			b1, err := am.AccountBalance(acc.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance1, b1)
+
+			b2, err := am.AccountBalance(acc2.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance2, b2)
+		})
+	}
+}
+
+func TestContentManager_DeleteGift_Refund(t *testing.T) {
+	for name, tt := range map[string]struct {
+		policy             conveyearthgo.RefundPolicy
+		reversals          int
+		balance1, balance2 int64
+	}{
+		"Full": {
+			policy:    conveyearthgo.FullRefund,
+			reversals: 2,
+			balance1:  -int64(len(conveytest.TEST_CONTENT)),
+			balance2:  0,
+		},
+		"None": {
+			policy:   conveyearthgo.NoRefund,
+			balance1: -int64(len(conveytest.TEST_CONTENT)) + 100,
+			balance2: -100,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			ev := authtest.NewEmailVerifier()
+			auth := authgo.NewAuthenticator(db, ev)
+			acc := authtest.NewTestAccount(t, auth)
+			acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+			assert.Nil(t, err)
+			dir, err := os.MkdirTemp("", "test")
+			assert.NoError(t, err)
+			fs := filesystem.NewOnDisk(dir)
+			defer os.RemoveAll(dir)
+			am := conveyearthgo.NewAccountManager(db)
+			cm := conveyearthgo.NewContentManager(db, fs, tt.policy)
+
+			c, m, _ := conveytest.NewConversation(t, cm, acc)
+			g := conveytest.NewGift(t, cm, acc2, c, m)
+
+			reversals, err := cm.DeleteGift(acc2, g)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.reversals, len(reversals))
+
+			b1, err := am.AccountBalance(acc.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance1, b1)
+
+			b2, err := am.AccountBalance(acc2.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance2, b2)
+		})
+	}
+}
+
+func TestContentManager_NegativeBalance(t *testing.T) {
+	db := database.NewInMemory()
+	ev := authtest.NewEmailVerifier()
+	auth := authgo.NewAuthenticator(db, ev)
+	acc := authtest.NewTestAccount(t, auth)
+	dir, err := os.MkdirTemp("", "test")
+	assert.NoError(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+
+	// The first conversation takes the balance below zero
+	c, m, _ := conveytest.NewConversation(t, cm, acc)
+
+	hash, size, err := cm.AddText([]byte(conveytest.TEST_CONTENT))
+	assert.NoError(t, err)
+	_, _, _, err = cm.NewConversation(acc, conveytest.TEST_TOPIC, []string{hash}, []string{conveyearthgo.MIME_TEXT_PLAIN}, []int64{size})
+	assert.Equal(t, conveyearthgo.ErrBalanceNegative, err)
+	_, _, err = cm.NewMessage(acc, c.ID, m.ID, []string{hash}, []string{conveyearthgo.MIME_TEXT_PLAIN}, []int64{size})
+	assert.Equal(t, conveyearthgo.ErrBalanceNegative, err)
+	_, err = cm.NewGift(acc, c.ID, m.ID, 1)
+	assert.Equal(t, conveyearthgo.ErrBalanceNegative, err)
+}
+
 func TestContentManager_Lookup_Zero(t *testing.T) {
 	db := database.NewInMemory()
 	dir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
 	fs := filesystem.NewOnDisk(dir)
 	defer os.RemoveAll(dir)
-	cm := conveyearthgo.NewContentManager(db, fs)
+	cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 	_, err = cm.LookupConversation(0)
 	assert.Error(t, conveyearthgo.ErrConversationNotFound, err)
 	_, err = cm.LookupMessage(0)
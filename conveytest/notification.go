@@ -3,26 +3,158 @@ package conveytest
 import (
 	"aletheiaware.com/authgo"
 	"aletheiaware.com/conveyearthgo"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 )
 
-func NewNotificationSender() conveyearthgo.NotificationSender {
-	return &notificationSender{}
+var ErrNotificationFailed = errors.New("Fake Notification Failure")
+
+func NewNotificationSender() *NotificationSender {
+	return &NotificationSender{}
+}
+
+// NotificationSender logs notifications, and records alerts, receipts, reversals, and digests so tests can inspect them.
+type NotificationSender struct {
+	sync.Mutex
+	Fail      bool
+	Alerts    []string
+	Receipts  []*Receipt
+	Reversals []*conveyearthgo.Reversal
+	Digests   []string
+	Summaries []*Summary
+}
+
+type Summary struct {
+	Account       *authgo.Account
+	Frequency     string
+	Notifications []*conveyearthgo.Notification
 }
 
-type notificationSender struct{}
+type Receipt struct {
+	Account  *authgo.Account
+	Size     int64
+	Amount   int64
+	Currency string
+	Balance  int64
+}
 
-func (s *notificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
+func (s *NotificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
 	log.Println("Response Notification", account.Email, account.Username, responder, topic, conversation, message)
 	return nil
 }
 
-func (s *notificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
+func (s *NotificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
 	log.Println("Mention Notification", account.Email, account.Username, mentioner, topic, conversation, message)
 	return nil
 }
 
-func (s *notificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
+func (s *NotificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
 	log.Println("Gift Notification", account.Email, account.Username, gifter, topic, conversation, message, amount)
 	return nil
 }
+
+func (s *NotificationSender) SendYieldNotification(account *authgo.Account, replier, topic string, conversation, message, amount int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Yield Notification", account.Email, account.Username, replier, topic, conversation, message, amount)
+	return nil
+}
+
+func (s *NotificationSender) SendReplyNotification(account *authgo.Account, replier, topic string, conversation, message int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Reply Notification", account.Email, account.Username, replier, topic, conversation, message)
+	return nil
+}
+
+func (s *NotificationSender) SendPublicationNotification(account *authgo.Account, author, topic string, conversation int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Publication Notification", account.Email, account.Username, author, topic, conversation)
+	return nil
+}
+
+func (s *NotificationSender) SendReversalNotification(account *authgo.Account, reason, topic string, conversation, message, amount int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Reversal Notification", account.Email, account.Username, reason, topic, conversation, message, amount)
+	s.Lock()
+	defer s.Unlock()
+	s.Reversals = append(s.Reversals, &conveyearthgo.Reversal{
+		Account:        account,
+		ConversationID: conversation,
+		MessageID:      message,
+		Reason:         reason,
+		Amount:         amount,
+	})
+	return nil
+}
+
+func (s *NotificationSender) SendNegativeBalanceAlert(user, balance int64, reason string) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Negative Balance Alert", user, balance, reason)
+	s.Lock()
+	defer s.Unlock()
+	s.Alerts = append(s.Alerts, fmt.Sprintf("%d %d %s", user, balance, reason))
+	return nil
+}
+
+func (s *NotificationSender) SendPurchaseReceipt(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Purchase Receipt", account.Email, account.Username, size, amount, currency, balance)
+	s.Lock()
+	defer s.Unlock()
+	s.Receipts = append(s.Receipts, &Receipt{
+		Account:  account,
+		Size:     size,
+		Amount:   amount,
+		Currency: currency,
+		Balance:  balance,
+	})
+	return nil
+}
+
+func (s *NotificationSender) SendDigestNotification(account *authgo.Account, edition string) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Digest Notification", account.Email, account.Username, edition)
+	s.Lock()
+	defer s.Unlock()
+	s.Digests = append(s.Digests, fmt.Sprintf("%s %s", account.Username, edition))
+	return nil
+}
+
+func (s *NotificationSender) SendNotificationSummary(account *authgo.Account, frequency string, notifications []*conveyearthgo.Notification) error {
+	if s.Fail {
+		return ErrNotificationFailed
+	}
+	log.Println("Notification Summary", account.Email, account.Username, frequency, len(notifications))
+	s.Lock()
+	defer s.Unlock()
+	s.Summaries = append(s.Summaries, &Summary{
+		Account:       account,
+		Frequency:     frequency,
+		Notifications: notifications,
+	})
+	return nil
+}
@@ -0,0 +1,166 @@
+package conveytest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeStripe is a local stand-in for the parts of the Stripe API used by Convey.
+type FakeStripe struct {
+	sync.Mutex
+	Transfers []url.Values
+	Products  []map[string]interface{}
+	Prices    []map[string]interface{}
+	Fail      bool
+	// Unavailable creates transfers but responds with a server error, so their outcome is unknown to the client.
+	Unavailable bool
+	idempotent  map[string]interface{}
+}
+
+// NewFakeStripe starts a FakeStripe and points the Stripe client at it until the test completes.
+func NewFakeStripe(t *testing.T) *FakeStripe {
+	t.Helper()
+	f := &FakeStripe{
+		idempotent: make(map[string]interface{}),
+	}
+	server := httptest.NewServer(f)
+	key := stripe.Key
+	stripe.Key = "sk_test_fake"
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL: stripe.String(server.URL),
+	}))
+	t.Cleanup(func() {
+		stripe.SetBackend(stripe.APIBackend, nil)
+		stripe.Key = key
+		server.Close()
+	})
+	return f
+}
+
+func (f *FakeStripe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if f.Fail {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"Fake Failure"}}`)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transfers":
+		// Requests with a seen idempotency key replay the original transfer
+		key := r.Header.Get("Idempotency-Key")
+		t, ok := f.idempotent[key]
+		if !ok {
+			if err := r.ParseForm(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.Transfers = append(f.Transfers, r.PostForm)
+			amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			metadata := make(map[string]string)
+			for k, v := range r.PostForm {
+				if strings.HasPrefix(k, "metadata[") && strings.HasSuffix(k, "]") {
+					metadata[strings.TrimSuffix(strings.TrimPrefix(k, "metadata["), "]")] = v[0]
+				}
+			}
+			t = map[string]interface{}{
+				"id":          fmt.Sprintf("tr_fake%d", len(f.Transfers)),
+				"object":      "transfer",
+				"amount":      amount,
+				"currency":    r.PostForm.Get("currency"),
+				"destination": r.PostForm.Get("destination"),
+				"metadata":    metadata,
+				"reversed":    false,
+			}
+			if key != "" {
+				f.idempotent[key] = t
+			}
+		}
+		if f.Unavailable {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":{"type":"api_error","message":"Fake Unavailable"}}`)
+			return
+		}
+		json.NewEncoder(w).Encode(t)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/accounts/acct_"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":                strings.TrimPrefix(r.URL.Path, "/v1/accounts/"),
+			"object":            "account",
+			"charges_enabled":   true,
+			"payouts_enabled":   true,
+			"details_submitted": true,
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/products":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object":   "list",
+			"url":      r.URL.Path,
+			"has_more": false,
+			"data":     f.Products,
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/prices":
+		prices := []map[string]interface{}{}
+		for _, p := range f.Prices {
+			if product := r.URL.Query().Get("product"); product == "" || p["product"] == product {
+				prices = append(prices, p)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object":   "list",
+			"url":      r.URL.Path,
+			"has_more": false,
+			"data":     prices,
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"Unrecognized Request"}}`)
+	}
+}
+
+// SignStripePayload returns a Stripe-Signature header value for the given webhook payload.
+func SignStripePayload(payload []byte, secret string) string {
+	timestamp := time.Now().Unix()
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// AddBundle adds an active Product for the given domain, with an active Price for a bundle of the given size.
+func (f *FakeStripe) AddBundle(domain, currency string, amount, size int64) string {
+	f.Lock()
+	defer f.Unlock()
+	product := fmt.Sprintf("prod_fake%d", len(f.Products)+1)
+	price := fmt.Sprintf("price_fake%d", len(f.Prices)+1)
+	f.Products = append(f.Products, map[string]interface{}{
+		"id":       product,
+		"object":   "product",
+		"active":   true,
+		"metadata": map[string]string{"domain": domain},
+	})
+	f.Prices = append(f.Prices, map[string]interface{}{
+		"id":          price,
+		"object":      "price",
+		"active":      true,
+		"currency":    currency,
+		"unit_amount": amount,
+		"product":     product,
+		"metadata":    map[string]string{"bundle_size": strconv.FormatInt(size, 10)},
+	})
+	return price
+}
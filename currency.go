@@ -0,0 +1,269 @@
+package conveyearthgo
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Currency describes how amounts of a Stripe-supported currency are represented and displayed.
+type Currency struct {
+	Code string
+	// Exponent is the number of decimal places in the minor unit used by the Stripe API.
+	Exponent int
+	Symbol   string
+	// Suffix places the symbol after the amount.
+	Suffix  bool
+	Decimal string
+	Group   string
+}
+
+var currencies = map[string]*Currency{}
+
+// ambiguous holds the codes of currencies whose symbol is shared with other currencies.
+var ambiguous = map[string]bool{}
+
+func init() {
+	for _, c := range []*Currency{
+		{"aed", 2, "د.إ", true, ".", ","},
+		{"afn", 2, "؋", false, ".", ","},
+		{"all", 2, "L", true, ",", "."},
+		{"amd", 2, "֏", true, ".", ","},
+		{"ang", 2, "ƒ", false, ",", "."},
+		{"aoa", 2, "Kz", true, ",", "."},
+		{"ars", 2, "$", false, ",", "."},
+		{"aud", 2, "A$", false, ".", ","},
+		{"awg", 2, "ƒ", false, ".", ","},
+		{"azn", 2, "₼", true, ",", "."},
+		{"bam", 2, "KM", true, ",", "."},
+		{"bbd", 2, "Bds$", false, ".", ","},
+		{"bdt", 2, "৳", false, ".", ","},
+		{"bgn", 2, "лв.", true, ",", " "},
+		{"bhd", 3, "BD", false, ".", ","},
+		{"bif", 0, "FBu", true, ",", "."},
+		{"bmd", 2, "BD$", false, ".", ","},
+		{"bnd", 2, "B$", false, ".", ","},
+		{"bob", 2, "Bs", false, ",", "."},
+		{"brl", 2, "R$", false, ",", "."},
+		{"bsd", 2, "B$", false, ".", ","},
+		{"bwp", 2, "P", false, ".", ","},
+		{"byn", 2, "Br", true, ",", " "},
+		{"bzd", 2, "BZ$", false, ".", ","},
+		{"cad", 2, "CA$", false, ".", ","},
+		{"cdf", 2, "FC", true, ",", "."},
+		{"chf", 2, "CHF", false, ".", "'"},
+		{"clp", 0, "$", false, ",", "."},
+		{"cny", 2, "¥", false, ".", ","},
+		{"cop", 2, "$", false, ",", "."},
+		{"crc", 2, "₡", false, ",", "."},
+		{"cve", 2, "Esc", true, ",", "."},
+		{"czk", 2, "Kč", true, ",", " "},
+		{"djf", 0, "Fdj", true, ",", "."},
+		{"dkk", 2, "kr.", true, ",", "."},
+		{"dop", 2, "RD$", false, ".", ","},
+		{"dzd", 2, "DA", true, ",", "."},
+		{"egp", 2, "E£", false, ".", ","},
+		{"etb", 2, "Br", false, ".", ","},
+		{"eur", 2, "€", false, ".", ","},
+		{"fjd", 2, "FJ$", false, ".", ","},
+		{"fkp", 2, "£", false, ".", ","},
+		{"gbp", 2, "£", false, ".", ","},
+		{"gel", 2, "₾", true, ",", " "},
+		{"gip", 2, "£", false, ".", ","},
+		{"gmd", 2, "D", false, ".", ","},
+		{"gnf", 0, "FG", true, ",", "."},
+		{"gtq", 2, "Q", false, ".", ","},
+		{"gyd", 2, "G$", false, ".", ","},
+		{"hkd", 2, "HK$", false, ".", ","},
+		{"hnl", 2, "L", false, ".", ","},
+		{"htg", 2, "G", true, ",", "."},
+		{"huf", 2, "Ft", true, ",", " "},
+		{"idr", 2, "Rp", false, ",", "."},
+		{"ils", 2, "₪", false, ".", ","},
+		{"inr", 2, "₹", false, ".", ","},
+		// Stripe represents ISK in two decimal places, though the fraction must be zero
+		{"isk", 2, "kr", true, ",", "."},
+		{"jmd", 2, "J$", false, ".", ","},
+		{"jod", 3, "JD", false, ".", ","},
+		{"jpy", 0, "¥", false, ".", ","},
+		{"kes", 2, "KSh", false, ".", ","},
+		{"kgs", 2, "сом", true, ",", " "},
+		{"khr", 2, "៛", true, ".", ","},
+		{"kmf", 0, "CF", true, ",", "."},
+		{"krw", 0, "₩", false, ".", ","},
+		{"kwd", 3, "KD", false, ".", ","},
+		{"kyd", 2, "CI$", false, ".", ","},
+		{"kzt", 2, "₸", true, ",", " "},
+		{"lak", 2, "₭", false, ",", "."},
+		{"lbp", 2, "L£", false, ".", ","},
+		{"lkr", 2, "Rs", false, ".", ","},
+		{"lrd", 2, "L$", false, ".", ","},
+		{"lsl", 2, "L", false, ".", ","},
+		{"mad", 2, "DH", true, ",", "."},
+		{"mdl", 2, "L", true, ",", "."},
+		{"mga", 0, "Ar", true, ",", "."},
+		{"mkd", 2, "ден", true, ",", "."},
+		{"mmk", 2, "K", false, ".", ","},
+		{"mnt", 2, "₮", false, ".", ","},
+		{"mop", 2, "MOP$", false, ".", ","},
+		{"mur", 2, "Rs", false, ".", ","},
+		{"mvr", 2, "Rf", false, ".", ","},
+		{"mwk", 2, "MK", false, ".", ","},
+		{"mxn", 2, "MX$", false, ".", ","},
+		{"myr", 2, "RM", false, ".", ","},
+		{"mzn", 2, "MT", true, ",", "."},
+		{"nad", 2, "N$", false, ".", ","},
+		{"ngn", 2, "₦", false, ".", ","},
+		{"nio", 2, "C$", false, ".", ","},
+		{"nok", 2, "kr", true, ",", " "},
+		{"npr", 2, "Rs", false, ".", ","},
+		{"nzd", 2, "NZ$", false, ".", ","},
+		{"omr", 3, "OMR", false, ".", ","},
+		{"pab", 2, "B/.", false, ".", ","},
+		{"pen", 2, "S/", false, ".", ","},
+		{"pgk", 2, "K", false, ".", ","},
+		{"php", 2, "₱", false, ".", ","},
+		{"pkr", 2, "Rs", false, ".", ","},
+		{"pln", 2, "zł", true, ",", " "},
+		{"pyg", 0, "₲", false, ",", "."},
+		{"qar", 2, "QR", true, ".", ","},
+		{"ron", 2, "lei", true, ",", "."},
+		{"rsd", 2, "дин.", true, ",", "."},
+		{"rub", 2, "₽", true, ",", " "},
+		{"rwf", 0, "FRw", false, ",", "."},
+		{"sar", 2, "SR", true, ".", ","},
+		{"sbd", 2, "SI$", false, ".", ","},
+		{"scr", 2, "SR", false, ".", ","},
+		{"sek", 2, "kr", true, ",", " "},
+		{"sgd", 2, "S$", false, ".", ","},
+		{"shp", 2, "£", false, ".", ","},
+		{"sle", 2, "Le", false, ".", ","},
+		{"sll", 2, "Le", false, ".", ","},
+		{"sos", 2, "Sh", false, ".", ","},
+		{"srd", 2, "$", false, ",", "."},
+		{"std", 2, "Db", true, ",", "."},
+		{"szl", 2, "E", false, ".", ","},
+		{"thb", 2, "฿", false, ".", ","},
+		{"tjs", 2, "SM", true, ",", " "},
+		{"tnd", 3, "DT", true, ",", "."},
+		{"top", 2, "T$", false, ".", ","},
+		{"try", 2, "₺", false, ",", "."},
+		{"ttd", 2, "TT$", false, ".", ","},
+		{"twd", 2, "NT$", false, ".", ","},
+		{"tzs", 2, "TSh", false, ".", ","},
+		{"uah", 2, "₴", true, ",", " "},
+		{"ugx", 0, "USh", false, ".", ","},
+		{"usd", 2, "$", false, ".", ","},
+		{"uyu", 2, "$U", false, ",", "."},
+		{"uzs", 2, "soʻm", true, ",", " "},
+		{"vnd", 0, "₫", true, ",", "."},
+		{"vuv", 0, "VT", true, ".", ","},
+		{"wst", 2, "WS$", false, ".", ","},
+		{"xaf", 0, "FCFA", true, ",", " "},
+		{"xcd", 2, "EC$", false, ".", ","},
+		{"xof", 0, "CFA", true, ",", " "},
+		{"xpf", 0, "CFPF", true, ",", " "},
+		{"yer", 2, "YR", true, ".", ","},
+		{"zar", 2, "R", false, ",", " "},
+		{"zmw", 2, "ZK", false, ".", ","},
+	} {
+		currencies[c.Code] = c
+	}
+	symbols := make(map[string]int)
+	for _, c := range currencies {
+		symbols[c.Symbol]++
+	}
+	for _, c := range currencies {
+		if symbols[c.Symbol] > 1 {
+			ambiguous[c.Code] = true
+		}
+	}
+}
+
+// LookupCurrency returns the Currency with the given ISO 4217 code.
+func LookupCurrency(code string) (*Currency, bool) {
+	c, ok := currencies[strings.ToLower(code)]
+	return c, ok
+}
+
+// Currencies returns the codes of all supported currencies in alphabetical order.
+func Currencies() []string {
+	var codes []string
+	for c := range currencies {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Ambiguous reports whether other currencies share the symbol of this currency, such as "$" for ARS, MXN, and USD.
+func (c *Currency) Ambiguous() bool {
+	return ambiguous[c.Code]
+}
+
+// Format renders an amount given in minor units, omitting the fraction when it is zero.
+// The symbol is replaced by the uppercase code when it is ambiguous.
+func (c *Currency) Format(amount int64) string {
+	return c.FormatLocal(amount, "")
+}
+
+// FormatLocal renders an amount given in minor units for a reader whose local currency has the given code.
+// An ambiguous symbol is only shown when this is the local currency, elsewhere the uppercase code follows the amount.
+func (c *Currency) FormatLocal(amount int64, local string) string {
+	symbol, suffix := c.Symbol, c.Suffix
+	if ambiguous[c.Code] && !strings.EqualFold(c.Code, local) {
+		symbol, suffix = strings.ToUpper(c.Code), true
+	}
+	var sb strings.Builder
+	if amount < 0 {
+		sb.WriteString("-")
+		amount = -amount
+	}
+	if !suffix {
+		sb.WriteString(symbol)
+	}
+	unit := int64(1)
+	for i := 0; i < c.Exponent; i++ {
+		unit *= 10
+	}
+	whole := strconv.FormatInt(amount/unit, 10)
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteString(c.Group)
+		}
+		sb.WriteRune(r)
+	}
+	if fraction := amount % unit; fraction != 0 {
+		sb.WriteString(c.Decimal)
+		f := strconv.FormatInt(fraction, 10)
+		sb.WriteString(strings.Repeat("0", c.Exponent-len(f)))
+		sb.WriteString(f)
+	}
+	if suffix {
+		sb.WriteString(" ")
+		sb.WriteString(symbol)
+	}
+	return sb.String()
+}
+
+// FormatAmount renders an amount given in the minor units of the currency with the given code.
+func FormatAmount(amount int64, code string) string {
+	return FormatLocalAmount(amount, code, "")
+}
+
+// FormatLocalAmount renders an amount given in the minor units of the currency with the given code, for a reader whose local currency has the code local.
+func FormatLocalAmount(amount int64, code, local string) string {
+	c, ok := LookupCurrency(code)
+	if !ok {
+		// Fall back to two decimal places and the code
+		c = &Currency{
+			Code:     code,
+			Exponent: 2,
+			Symbol:   strings.ToUpper(code),
+			Suffix:   true,
+			Decimal:  ".",
+			Group:    ",",
+		}
+	}
+	return c.FormatLocal(amount, local)
+}
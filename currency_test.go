@@ -0,0 +1,69 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	for name, tt := range map[string]struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		"USD Whole":     {500, "usd", "5 USD"},
+		"USD Fraction":  {505, "usd", "5.05 USD"},
+		"USD Grouping":  {123456789, "usd", "1,234,567.89 USD"},
+		"USD Negative":  {-250, "usd", "-2.50 USD"},
+		"ARS":           {500, "ars", "5 ARS"},
+		"GBP":           {1000, "gbp", "10 GBP"},
+		"BGN":           {1050, "bgn", "10,50 лв."},
+		"EUR":           {1999, "EUR", "€19.99"},
+		"JPY":           {500, "jpy", "500 JPY"},
+		"KRW":           {12000, "krw", "₩12,000"},
+		"KWD":           {1005, "kwd", "KD1.005"},
+		"Unrecognized":  {1234, "xyz", "12.34 XYZ"},
+		"Zero Decimal":  {5, "vnd", "5 ₫"},
+		"Small Amount":  {5, "eur", "€0.05"},
+		"Three Decimal": {1, "bhd", "BD0.001"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, conveyearthgo.FormatAmount(tt.amount, tt.currency))
+		})
+	}
+}
+
+func TestFormatLocalAmount(t *testing.T) {
+	for name, tt := range map[string]struct {
+		amount   int64
+		currency string
+		local    string
+		want     string
+	}{
+		"USD In US":         {500, "usd", "usd", "$5"},
+		"USD In Argentina":  {500, "usd", "ars", "5 USD"},
+		"ARS In Argentina":  {500, "ars", "ars", "$5"},
+		"MXN In Mexico":     {1999, "mxn", "mxn", "MX$19.99"},
+		"JPY In Japan":      {500, "JPY", "jpy", "¥500"},
+		"CNY In Japan":      {500, "cny", "jpy", "5 CNY"},
+		"Unambiguous":       {1999, "eur", "usd", "€19.99"},
+		"Unknown Locality":  {500, "usd", "", "5 USD"},
+		"Unrecognized Code": {1234, "xyz", "xyz", "12.34 XYZ"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, conveyearthgo.FormatLocalAmount(tt.amount, tt.currency, tt.local))
+		})
+	}
+}
+
+func TestLookupCurrency(t *testing.T) {
+	for _, code := range conveyearthgo.Currencies() {
+		c, ok := conveyearthgo.LookupCurrency(code)
+		assert.True(t, ok)
+		assert.Equal(t, code, c.Code)
+		assert.NotEmpty(t, c.Symbol)
+	}
+	_, ok := conveyearthgo.LookupCurrency("xyz")
+	assert.False(t, ok)
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"aletheiaware.com/authgo"
 	"aletheiaware.com/authgo/database"
+	"aletheiaware.com/conveyearthgo"
 	"sort"
 	"sync"
 	"time"
@@ -59,8 +60,13 @@ func NewInMemory() *InMemory {
 		NotificationPreferencesMentions:  make(map[int64]bool),
 		NotificationPreferencesGifts:     make(map[int64]bool),
 		NotificationPreferencesDigests:   make(map[int64]bool),
+		NotificationPreferencesYields:    make(map[int64]bool),
+		NotificationPreferencesFrequency: make(map[int64]string),
+		NotificationPreferencesSummary:   make(map[int64]time.Time),
 		AwardId:                          make(map[int64]bool),
 		AwardUser:                        make(map[int64]int64),
+		AwardEdition:                     make(map[int64]string),
+		AwardReason:                      make(map[int64]string),
 		AwardAmount:                      make(map[int64]int64),
 		AwardCreated:                     make(map[int64]time.Time),
 		AwardDeleted:                     make(map[int64]time.Time),
@@ -76,12 +82,113 @@ func NewInMemory() *InMemory {
 		GiftAmount:                       make(map[int64]int64),
 		GiftCreated:                      make(map[int64]time.Time),
 		GiftDeleted:                      make(map[int64]time.Time),
+		ReversalId:                       make(map[int64]bool),
+		ReversalUser:                     make(map[int64]int64),
+		ReversalConversation:             make(map[int64]int64),
+		ReversalMessage:                  make(map[int64]int64),
+		ReversalGift:                     make(map[int64]int64),
+		ReversalReason:                   make(map[int64]string),
+		ReversalAmount:                   make(map[int64]int64),
+		ReversalCreated:                  make(map[int64]time.Time),
+		ReversalDeleted:                  make(map[int64]time.Time),
+		PayoutId:                         make(map[int64]bool),
+		PayoutUser:                       make(map[int64]int64),
+		PayoutStripeAccount:              make(map[int64]string),
+		PayoutStripeTransfer:             make(map[int64]string),
+		PayoutStripeCurrency:             make(map[int64]string),
+		PayoutStripeAmount:               make(map[int64]int64),
+		PayoutSize:                       make(map[int64]int64),
+		PayoutStatus:                     make(map[int64]string),
+		PayoutCreated:                    make(map[int64]time.Time),
+		PayoutDeleted:                    make(map[int64]time.Time),
+		PurchaseAdjustmentId:             make(map[int64]bool),
+		PurchaseAdjustmentPurchase:       make(map[int64]int64),
+		PurchaseAdjustmentUser:           make(map[int64]int64),
+		PurchaseAdjustmentReason:         make(map[int64]string),
+		PurchaseAdjustmentStripeCurrency: make(map[int64]string),
+		PurchaseAdjustmentStripeAmount:   make(map[int64]int64),
+		PurchaseAdjustmentSize:           make(map[int64]int64),
+		PurchaseAdjustmentCreated:        make(map[int64]time.Time),
+		PurchaseAdjustmentDeleted:        make(map[int64]time.Time),
+		NotificationId:                   make(map[int64]bool),
+		NotificationUser:                 make(map[int64]int64),
+		NotificationKind:                 make(map[int64]string),
+		NotificationActor:                make(map[int64]int64),
+		NotificationConversation:         make(map[int64]int64),
+		NotificationMessage:              make(map[int64]int64),
+		NotificationAmount:               make(map[int64]int64),
+		NotificationRead:                 make(map[int64]time.Time),
+		NotificationCreated:              make(map[int64]time.Time),
+		DigestDeliveryId:                 make(map[int64]bool),
+		DigestDeliveryEdition:            make(map[int64]string),
+		DigestDeliveryUser:               make(map[int64]int64),
+		DigestDeliveryCreated:            make(map[int64]time.Time),
+		OutboxId:                         make(map[int64]bool),
+		OutboxKind:                       make(map[int64]string),
+		OutboxPayload:                    make(map[int64][]byte),
+		OutboxAttempts:                   make(map[int64]int64),
+		OutboxNext:                       make(map[int64]time.Time),
+		OutboxError:                      make(map[int64]string),
+		OutboxCreated:                    make(map[int64]time.Time),
+		OutboxSent:                       make(map[int64]time.Time),
+		OutboxDead:                       make(map[int64]time.Time),
+		FollowId:                         make(map[int64]bool),
+		FollowUser:                       make(map[int64]int64),
+		FollowKind:                       make(map[int64]string),
+		FollowTarget:                     make(map[int64]int64),
+		FollowCreated:                    make(map[int64]time.Time),
+		WebhookId:                        make(map[int64]bool),
+		WebhookUser:                      make(map[int64]int64),
+		WebhookURL:                       make(map[int64]string),
+		WebhookSecret:                    make(map[int64]string),
+		WebhookCreated:                   make(map[int64]time.Time),
+		WebhookDeleted:                   make(map[int64]time.Time),
+		WebhookDeliveryId:                make(map[int64]bool),
+		WebhookDeliveryWebhook:           make(map[int64]int64),
+		WebhookDeliveryKind:              make(map[int64]string),
+		WebhookDeliveryPayload:           make(map[int64][]byte),
+		WebhookDeliveryAttempts:          make(map[int64]int64),
+		WebhookDeliveryStatus:            make(map[int64]int64),
+		WebhookDeliveryNext:              make(map[int64]time.Time),
+		WebhookDeliveryError:             make(map[int64]string),
+		WebhookDeliveryCreated:           make(map[int64]time.Time),
+		WebhookDeliveryDelivered:         make(map[int64]time.Time),
+		WebhookDeliveryDead:              make(map[int64]time.Time),
+		FollowDeleted:                    make(map[int64]time.Time),
+		NotificationPreferencesEmail:     make(map[int64]bool),
+		NotificationPreferencesPush:      make(map[int64]bool),
+		PushSubscriptionId:               make(map[int64]bool),
+		PushSubscriptionUser:             make(map[int64]int64),
+		PushSubscriptionEndpoint:         make(map[int64]string),
+		PushSubscriptionP256dh:           make(map[int64]string),
+		PushSubscriptionAuth:             make(map[int64]string),
+		PushSubscriptionCreated:          make(map[int64]time.Time),
+		PushSubscriptionDeleted:          make(map[int64]time.Time),
+		PushDeliveryId:                   make(map[int64]bool),
+		PushDeliverySubscription:         make(map[int64]int64),
+		PushDeliveryDigest:               make(map[int64]string),
+		PushDeliveryCreated:              make(map[int64]time.Time),
+		PushDeliveryDeleted:              make(map[int64]time.Time),
+		TokenId:                          make(map[int64]bool),
+		TokenUser:                        make(map[int64]int64),
+		TokenName:                        make(map[int64]string),
+		TokenHash:                        make(map[int64]string),
+		TokenScopes:                      make(map[int64]string),
+		TokenCreated:                     make(map[int64]time.Time),
+		TokenDeleted:                     make(map[int64]time.Time),
+
+		// Preferences for notifications about follows
+		NotificationPreferencesReplies:      make(map[int64]bool),
+		NotificationPreferencesPublications: make(map[int64]bool),
 	}
 }
 
 type InMemory struct {
 	sync.RWMutex
 	*database.InMemory
+	// payouts serializes the eligibility check and creation of payouts, as the row lock does in Sql
+	payouts sync.Mutex
+
 	ConversationId                   map[int64]bool
 	ConversationUser                 map[int64]int64
 	ConversationTopic                map[int64]string
@@ -130,8 +237,13 @@ type InMemory struct {
 	NotificationPreferencesMentions  map[int64]bool
 	NotificationPreferencesGifts     map[int64]bool
 	NotificationPreferencesDigests   map[int64]bool
+	NotificationPreferencesYields    map[int64]bool
+	NotificationPreferencesFrequency map[int64]string
+	NotificationPreferencesSummary   map[int64]time.Time
 	AwardId                          map[int64]bool
 	AwardUser                        map[int64]int64
+	AwardEdition                     map[int64]string
+	AwardReason                      map[int64]string
 	AwardAmount                      map[int64]int64
 	AwardCreated                     map[int64]time.Time
 	AwardDeleted                     map[int64]time.Time
@@ -147,6 +259,104 @@ type InMemory struct {
 	GiftAmount                       map[int64]int64
 	GiftCreated                      map[int64]time.Time
 	GiftDeleted                      map[int64]time.Time
+	ReversalId                       map[int64]bool
+	ReversalUser                     map[int64]int64
+	ReversalConversation             map[int64]int64
+	ReversalMessage                  map[int64]int64
+	ReversalGift                     map[int64]int64
+	ReversalReason                   map[int64]string
+	ReversalAmount                   map[int64]int64
+	ReversalCreated                  map[int64]time.Time
+	ReversalDeleted                  map[int64]time.Time
+	PayoutId                         map[int64]bool
+	PayoutUser                       map[int64]int64
+	PayoutStripeAccount              map[int64]string
+	PayoutStripeTransfer             map[int64]string
+	PayoutStripeCurrency             map[int64]string
+	PayoutStripeAmount               map[int64]int64
+	PayoutSize                       map[int64]int64
+	PayoutStatus                     map[int64]string
+	PayoutCreated                    map[int64]time.Time
+	PayoutDeleted                    map[int64]time.Time
+	PurchaseAdjustmentId             map[int64]bool
+	PurchaseAdjustmentPurchase       map[int64]int64
+	PurchaseAdjustmentUser           map[int64]int64
+	PurchaseAdjustmentReason         map[int64]string
+	PurchaseAdjustmentStripeCurrency map[int64]string
+	PurchaseAdjustmentStripeAmount   map[int64]int64
+	PurchaseAdjustmentSize           map[int64]int64
+	PurchaseAdjustmentCreated        map[int64]time.Time
+	PurchaseAdjustmentDeleted        map[int64]time.Time
+	NotificationId                   map[int64]bool
+	NotificationUser                 map[int64]int64
+	NotificationKind                 map[int64]string
+	NotificationActor                map[int64]int64
+	NotificationConversation         map[int64]int64
+	NotificationMessage              map[int64]int64
+	NotificationAmount               map[int64]int64
+	NotificationRead                 map[int64]time.Time
+	NotificationCreated              map[int64]time.Time
+	DigestDeliveryId                 map[int64]bool
+	DigestDeliveryEdition            map[int64]string
+	DigestDeliveryUser               map[int64]int64
+	DigestDeliveryCreated            map[int64]time.Time
+	OutboxId                         map[int64]bool
+	OutboxKind                       map[int64]string
+	OutboxPayload                    map[int64][]byte
+	OutboxAttempts                   map[int64]int64
+	OutboxNext                       map[int64]time.Time
+	OutboxError                      map[int64]string
+	OutboxCreated                    map[int64]time.Time
+	OutboxSent                       map[int64]time.Time
+	OutboxDead                       map[int64]time.Time
+	FollowId                         map[int64]bool
+	FollowUser                       map[int64]int64
+	FollowKind                       map[int64]string
+	FollowTarget                     map[int64]int64
+	FollowCreated                    map[int64]time.Time
+	WebhookId                        map[int64]bool
+	WebhookUser                      map[int64]int64
+	WebhookURL                       map[int64]string
+	WebhookSecret                    map[int64]string
+	WebhookCreated                   map[int64]time.Time
+	WebhookDeleted                   map[int64]time.Time
+	WebhookDeliveryId                map[int64]bool
+	WebhookDeliveryWebhook           map[int64]int64
+	WebhookDeliveryKind              map[int64]string
+	WebhookDeliveryPayload           map[int64][]byte
+	WebhookDeliveryAttempts          map[int64]int64
+	WebhookDeliveryStatus            map[int64]int64
+	WebhookDeliveryNext              map[int64]time.Time
+	WebhookDeliveryError             map[int64]string
+	WebhookDeliveryCreated           map[int64]time.Time
+	WebhookDeliveryDelivered         map[int64]time.Time
+	WebhookDeliveryDead              map[int64]time.Time
+	FollowDeleted                    map[int64]time.Time
+	NotificationPreferencesEmail     map[int64]bool
+	NotificationPreferencesPush      map[int64]bool
+	PushSubscriptionId               map[int64]bool
+	PushSubscriptionUser             map[int64]int64
+	PushSubscriptionEndpoint         map[int64]string
+	PushSubscriptionP256dh           map[int64]string
+	PushSubscriptionAuth             map[int64]string
+	PushSubscriptionCreated          map[int64]time.Time
+	PushSubscriptionDeleted          map[int64]time.Time
+	PushDeliveryId                   map[int64]bool
+	PushDeliverySubscription         map[int64]int64
+	PushDeliveryDigest               map[int64]string
+	PushDeliveryCreated              map[int64]time.Time
+	PushDeliveryDeleted              map[int64]time.Time
+	TokenId                          map[int64]bool
+	TokenUser                        map[int64]int64
+	TokenName                        map[int64]string
+	TokenHash                        map[int64]string
+	TokenScopes                      map[int64]string
+	TokenCreated                     map[int64]time.Time
+	TokenDeleted                     map[int64]time.Time
+
+	// Preferences for notifications about follows
+	NotificationPreferencesReplies      map[int64]bool
+	NotificationPreferencesPublications map[int64]bool
 }
 
 func (db *InMemory) CreateConversation(user int64, topic string, created time.Time) (int64, error) {
@@ -160,14 +370,6 @@ func (db *InMemory) CreateConversation(user int64, topic string, created time.Ti
 	return id, nil
 }
 
-func (db *InMemory) DeleteConversation(user, id int64, deleted time.Time) (int64, error) {
-	if db.ConversationUser[id] != user {
-		return 0, nil
-	}
-	db.ConversationDeleted[id] = deleted
-	return 1, nil
-}
-
 func (db *InMemory) SelectConversation(id int64) (*authgo.Account, string, time.Time, error) {
 	db.Lock()
 	defer db.Unlock()
@@ -305,6 +507,117 @@ func (db *InMemory) SelectRecentConversations(callback func(int64, *authgo.Accou
 	return nil
 }
 
+func (db *InMemory) SelectAuthorConversations(author int64, callback func(int64, *authgo.Account, string, time.Time, int64, int64) error, limit int64) error {
+	db.Lock()
+	defer db.Unlock()
+	username := db.username(author)
+	if _, ok := db.AccountDeleted[username]; ok {
+		return nil
+	}
+	costs := make(map[int64]int64)
+	yields := make(map[int64]int64)
+	var results []int64
+	for cid := range db.ConversationId {
+		if db.ConversationUser[cid] != author {
+			continue
+		}
+		if _, ok := db.ConversationDeleted[cid]; ok {
+			continue
+		}
+		results = append(results, cid)
+		for mid := range db.MessageId {
+			if db.MessageConversation[mid] != cid || db.MessageParent[mid] != 0 {
+				continue
+			}
+			if _, ok := db.MessageDeleted[mid]; ok {
+				continue
+			}
+			costs[cid] = db.cost(mid)
+			yields[cid] = db.yield(mid)
+		}
+	}
+	// Sort results by decending creation time
+	sort.Slice(results, func(a, b int) bool {
+		return db.ConversationCreated[results[a]].After(db.ConversationCreated[results[b]])
+	})
+	account := &authgo.Account{
+		ID:       author,
+		Username: username,
+		Email:    db.AccountEmail[username],
+		Created:  db.AccountCreated[username],
+	}
+	count := int64(len(results))
+	for i := int64(0); i < limit && i < count; i++ {
+		cid := results[i]
+		if err := callback(cid, account, db.ConversationTopic[cid], db.ConversationCreated[cid], costs[cid], yields[cid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectConversationCount() (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	return int64(len(db.listedConversations())), nil
+}
+
+func (db *InMemory) SelectConversationModifications(offset, limit int64, callback func(int64, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	results := db.listedConversations()
+	sort.Slice(results, func(a, b int) bool {
+		return results[a] < results[b]
+	})
+	count := int64(len(results))
+	for i := offset; i < offset+limit && i < count; i++ {
+		cid := results[i]
+		modified := db.ConversationCreated[cid]
+		for mid := range db.MessageId {
+			if db.MessageConversation[mid] != cid {
+				continue
+			}
+			if _, ok := db.MessageDeleted[mid]; ok {
+				continue
+			}
+			if db.MessageCreated[mid].After(modified) {
+				modified = db.MessageCreated[mid]
+			}
+		}
+		if err := callback(cid, modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectConversationActivity(id int64) (time.Time, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.ConversationId[id]; !ok {
+		return time.Time{}, database.ErrNoSuchRecord
+	}
+	activity := db.ConversationCreated[id]
+	latest := func(t time.Time) {
+		if t.After(activity) {
+			activity = t
+		}
+	}
+	for mid := range db.MessageId {
+		if db.MessageConversation[mid] == id {
+			latest(db.MessageCreated[mid])
+			latest(db.MessageDeleted[mid])
+		}
+	}
+	for gid := range db.GiftId {
+		if db.GiftConversation[gid] == id {
+			latest(db.GiftCreated[gid])
+			latest(db.GiftDeleted[gid])
+		}
+	}
+	return activity, nil
+}
+
 func (db *InMemory) CreateMessage(user, conversation, parent int64, created time.Time) (int64, error) {
 	db.Lock()
 	defer db.Unlock()
@@ -317,7 +630,9 @@ func (db *InMemory) CreateMessage(user, conversation, parent int64, created time
 	return id, nil
 }
 
-func (db *InMemory) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
+func (db *InMemory) DeleteMessage(user, id int64, deleted time.Time, reversals []*conveyearthgo.Reversal) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
 	if db.MessageUser[id] != user {
 		return 0, nil
 	}
@@ -344,6 +659,15 @@ func (db *InMemory) DeleteMessage(user, id int64, deleted time.Time) (int64, err
 		return 0, nil
 	}
 
+	// Delete conversation if this is the opening message
+	if db.MessageParent[id] == 0 {
+		conversation := db.MessageConversation[id]
+		if db.ConversationUser[conversation] != user {
+			return 0, nil
+		}
+		db.ConversationDeleted[conversation] = deleted
+	}
+
 	db.MessageDeleted[id] = deleted
 	for f := range db.FileId {
 		if db.FileMessage[f] == id {
@@ -360,6 +684,7 @@ func (db *InMemory) DeleteMessage(user, id int64, deleted time.Time) (int64, err
 			db.YieldDeleted[y] = deleted
 		}
 	}
+	db.createReversals(reversals)
 	return 1, nil
 }
 
@@ -437,6 +762,21 @@ func (db *InMemory) SelectMessageParent(id int64) (int64, error) {
 	return db.MessageParent[id], nil
 }
 
+func (db *InMemory) SelectOpeningMessage(conversation int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	for mid := range db.MessageId {
+		if db.MessageConversation[mid] != conversation || db.MessageParent[mid] != 0 {
+			continue
+		}
+		if _, ok := db.MessageDeleted[mid]; ok {
+			continue
+		}
+		return mid, nil
+	}
+	return 0, database.ErrNoSuchRecord
+}
+
 func (db *InMemory) CreateFile(message int64, hash, mime string, created time.Time) (int64, error) {
 	db.Lock()
 	defer db.Unlock()
@@ -453,10 +793,10 @@ func (db *InMemory) SelectFile(id int64) (int64, string, string, time.Time, erro
 	db.Lock()
 	defer db.Unlock()
 	if _, ok := db.FileId[id]; !ok {
-		return 0, "", "", time.Time{}, database.ErrNoSuchRecord
+		return 0, "", "", time.Time{}, conveyearthgo.ErrWebhookNotFound
 	}
 	if _, ok := db.FileDeleted[id]; ok {
-		return 0, "", "", time.Time{}, database.ErrNoSuchRecord
+		return 0, "", "", time.Time{}, conveyearthgo.ErrWebhookNotFound
 	}
 	message := db.FileMessage[id]
 	hash := db.FileHash[id]
@@ -506,9 +846,6 @@ func (db *InMemory) SelectChargesForUser(user int64) (int64, error) {
 		if db.ChargeUser[cid] != user {
 			continue
 		}
-		if _, ok := db.ChargeDeleted[cid]; ok {
-			continue
-		}
 		charges += db.ChargeAmount[cid]
 	}
 	return charges, nil
@@ -536,25 +873,114 @@ func (db *InMemory) SelectYieldsForUser(user int64) (int64, error) {
 		if db.MessageUser[mid] != user {
 			continue
 		}
-		if _, ok := db.MessageDeleted[mid]; ok {
-			continue
-		}
 		for yid := range db.YieldId {
 			if db.YieldParent[yid] != mid {
 				continue
 			}
-			if _, ok := db.YieldDeleted[yid]; ok {
-				continue
-			}
 			yields += db.YieldAmount[yid]
 		}
 	}
 	return yields, nil
 }
 
+func (db *InMemory) SelectMessageYields(message int64, callback func(*authgo.Account, int64) error) error {
+	db.Lock()
+	defer db.Unlock()
+	for yid := range db.YieldId {
+		if db.YieldMessage[yid] != message {
+			continue
+		}
+		if _, ok := db.YieldDeleted[yid]; ok {
+			continue
+		}
+		parent := db.YieldParent[yid]
+		user := db.MessageUser[parent]
+		username := db.username(user)
+		if err := callback(&authgo.Account{
+			ID:       user,
+			Username: username,
+			Email:    db.AccountEmail[username],
+			Created:  db.AccountCreated[username],
+		}, db.YieldAmount[yid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) CreateReversal(user, conversation, message, gift int64, reason string, amount int64, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.createReversal(user, conversation, message, gift, reason, amount, created), nil
+}
+
+func (db *InMemory) createReversals(reversals []*conveyearthgo.Reversal) {
+	for _, r := range reversals {
+		r.ID = db.createReversal(r.Account.ID, r.ConversationID, r.MessageID, r.GiftID, r.Reason, r.Amount, r.Created)
+	}
+}
+
+func (db *InMemory) createReversal(user, conversation, message, gift int64, reason string, amount int64, created time.Time) int64 {
+	id := database.NextId()
+	db.ReversalId[id] = true
+	db.ReversalUser[id] = user
+	db.ReversalConversation[id] = conversation
+	db.ReversalMessage[id] = message
+	db.ReversalGift[id] = gift
+	db.ReversalReason[id] = reason
+	db.ReversalAmount[id] = amount
+	db.ReversalCreated[id] = created
+	return id
+}
+
+func (db *InMemory) SelectReversalsForUser(user int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var reversals int64
+	for rid := range db.ReversalId {
+		if db.ReversalUser[rid] != user {
+			continue
+		}
+		if _, ok := db.ReversalDeleted[rid]; ok {
+			continue
+		}
+		reversals += db.ReversalAmount[rid]
+	}
+	return reversals, nil
+}
+
+func (db *InMemory) SelectReversals(user int64, callback func(int64, int64, int64, int64, string, int64, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for rid := range db.ReversalId {
+		if db.ReversalUser[rid] != user {
+			continue
+		}
+		if _, ok := db.ReversalDeleted[rid]; ok {
+			continue
+		}
+		ids = append(ids, rid)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return db.ReversalCreated[ids[i]].After(db.ReversalCreated[ids[j]])
+	})
+	for _, rid := range ids {
+		if err := callback(rid, db.ReversalConversation[rid], db.ReversalMessage[rid], db.ReversalGift[rid], db.ReversalReason[rid], db.ReversalAmount[rid], db.ReversalCreated[rid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *InMemory) CreatePurchase(user int64, stripeSession, stripeCustomer, stripePaymentIntent, stripeCurrency string, stripeAmount, bundle_size int64, created time.Time) (int64, error) {
 	db.Lock()
 	defer db.Unlock()
+	for pid := range db.PurchaseId {
+		if db.PurchaseStripeSession[pid] == stripeSession {
+			return 0, conveyearthgo.ErrPurchaseAlreadyRecorded
+		}
+	}
 	id := database.NextId()
 	db.PurchaseId[id] = true
 	db.PurchaseUser[id] = user
@@ -584,32 +1010,185 @@ func (db *InMemory) SelectPurchasesForUser(user int64) (int64, error) {
 	return purchases, nil
 }
 
-func (db *InMemory) UpdateNotificationPreferences(id, user int64, responses, mentions, gifts, digests bool) (int64, error) {
+func (db *InMemory) SelectPurchaseByPaymentIntent(stripePaymentIntent string) (int64, int64, string, int64, int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	for pid := range db.PurchaseId {
+		if _, ok := db.PurchaseDeleted[pid]; ok {
+			continue
+		}
+		if db.PurchaseStripePaymentIntent[pid] == stripePaymentIntent {
+			return pid, db.PurchaseUser[pid], db.PurchaseStripeCurrency[pid], db.PurchaseStripeAmount[pid], db.PurchaseBundleSize[pid], nil
+		}
+	}
+	return 0, 0, "", 0, 0, database.ErrNoSuchRecord
+}
+
+func (db *InMemory) CreatePurchaseAdjustment(purchase, user int64, reason, stripeCurrency string, created time.Time, adjust func(int64) (int64, int64)) (int64, int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var existing int64
+	for aid := range db.PurchaseAdjustmentId {
+		if db.PurchaseAdjustmentPurchase[aid] != purchase || db.PurchaseAdjustmentReason[aid] != reason {
+			continue
+		}
+		if _, ok := db.PurchaseAdjustmentDeleted[aid]; ok {
+			continue
+		}
+		existing += db.PurchaseAdjustmentSize[aid]
+	}
+	stripeAmount, size := adjust(existing)
+	if size == 0 {
+		return 0, 0, nil
+	}
+	id := database.NextId()
+	db.PurchaseAdjustmentId[id] = true
+	db.PurchaseAdjustmentPurchase[id] = purchase
+	db.PurchaseAdjustmentUser[id] = user
+	db.PurchaseAdjustmentReason[id] = reason
+	db.PurchaseAdjustmentStripeCurrency[id] = stripeCurrency
+	db.PurchaseAdjustmentStripeAmount[id] = stripeAmount
+	db.PurchaseAdjustmentSize[id] = size
+	db.PurchaseAdjustmentCreated[id] = created
+	return id, size, nil
+}
+
+func (db *InMemory) SelectPurchaseAdjustmentsForUser(user int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var adjustments int64
+	for aid := range db.PurchaseAdjustmentId {
+		if db.PurchaseAdjustmentUser[aid] != user {
+			continue
+		}
+		if _, ok := db.PurchaseAdjustmentDeleted[aid]; ok {
+			continue
+		}
+		adjustments += db.PurchaseAdjustmentSize[aid]
+	}
+	return adjustments, nil
+}
+
+func (db *InMemory) UpdateNotificationPreferences(p *conveyearthgo.NotificationPreferences) (int64, error) {
+	id := p.ID
+	if id == 0 {
+		id = database.NextId()
+	}
 	db.NotificationPreferencesId[id] = true
-	db.NotificationPreferencesUser[id] = user
-	db.NotificationPreferencesResponses[id] = responses
-	db.NotificationPreferencesMentions[id] = mentions
-	db.NotificationPreferencesGifts[id] = gifts
-	db.NotificationPreferencesDigests[id] = digests
+	db.NotificationPreferencesUser[id] = p.User
+	db.NotificationPreferencesResponses[id] = p.Responses
+	db.NotificationPreferencesMentions[id] = p.Mentions
+	db.NotificationPreferencesGifts[id] = p.Gifts
+	db.NotificationPreferencesDigests[id] = p.Digests
+	db.NotificationPreferencesYields[id] = p.Yields
+	db.NotificationPreferencesReplies[id] = p.Replies
+	db.NotificationPreferencesPublications[id] = p.Publications
+	db.NotificationPreferencesFrequency[id] = p.Frequency
+	db.NotificationPreferencesEmail[id] = p.Email
+	db.NotificationPreferencesPush[id] = p.Push
 	return 1, nil
 }
 
-func (db *InMemory) SelectNotificationPreferences(user int64) (int64, bool, bool, bool, bool, error) {
-	var id int64
-	responses := true
-	mentions := true
-	gifts := true
-	digests := true
+func (db *InMemory) SelectNotificationPreferences(user int64) (*conveyearthgo.NotificationPreferences, error) {
+	// Notification preferences default to enabled
+	p := conveyearthgo.NewNotificationPreferences(user)
 	for i := range db.NotificationPreferencesId {
 		if db.NotificationPreferencesUser[i] == user {
-			id = i
-			responses = db.NotificationPreferencesResponses[i]
-			mentions = db.NotificationPreferencesMentions[i]
-			gifts = db.NotificationPreferencesGifts[i]
-			digests = db.NotificationPreferencesDigests[i]
+			p.ID = i
+			p.Responses = db.NotificationPreferencesResponses[i]
+			p.Mentions = db.NotificationPreferencesMentions[i]
+			p.Gifts = db.NotificationPreferencesGifts[i]
+			p.Digests = db.NotificationPreferencesDigests[i]
+			p.Yields = db.NotificationPreferencesYields[i]
+			p.Replies = db.NotificationPreferencesReplies[i]
+			p.Publications = db.NotificationPreferencesPublications[i]
+			p.Frequency = db.NotificationPreferencesFrequency[i]
+			p.Email = db.NotificationPreferencesEmail[i]
+			p.Push = db.NotificationPreferencesPush[i]
+		}
+	}
+	return p, nil
+}
+
+func (db *InMemory) SelectNotificationSummaryRecipients(frequency string, callback func(*authgo.Account, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for pid := range db.NotificationPreferencesId {
+		if db.NotificationPreferencesFrequency[pid] == frequency {
+			ids = append(ids, pid)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return db.NotificationPreferencesUser[ids[a]] < db.NotificationPreferencesUser[ids[b]]
+	})
+	for _, pid := range ids {
+		user := db.NotificationPreferencesUser[pid]
+		username := db.username(user)
+		if username == "" {
+			continue
+		}
+		if _, ok := db.AccountDeleted[username]; ok {
+			continue
+		}
+		if err := callback(&authgo.Account{
+			ID:       user,
+			Username: username,
+			Email:    db.AccountEmail[username],
+			Created:  db.AccountCreated[username],
+		}, db.NotificationPreferencesSummary[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) UpdateNotificationSummary(user int64, summary time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for pid := range db.NotificationPreferencesId {
+		if db.NotificationPreferencesUser[pid] == user {
+			db.NotificationPreferencesSummary[pid] = summary
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *InMemory) CreateAward(user int64, reason string, amount int64, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.AwardId[id] = true
+	db.AwardUser[id] = user
+	db.AwardReason[id] = reason
+	db.AwardAmount[id] = amount
+	db.AwardCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) CreateEditionAwards(edition, reason string, awards map[int64]int64, created time.Time) error {
+	db.Lock()
+	defer db.Unlock()
+	for aid := range db.AwardId {
+		if db.AwardEdition[aid] != edition || db.AwardReason[aid] != reason {
+			continue
+		}
+		if _, ok := awards[db.AwardUser[aid]]; ok {
+			return conveyearthgo.ErrAwardsAlreadyRecorded
 		}
 	}
-	return id, responses, mentions, gifts, digests, nil
+	for user, amount := range awards {
+		id := database.NextId()
+		db.AwardId[id] = true
+		db.AwardUser[id] = user
+		db.AwardEdition[id] = edition
+		db.AwardReason[id] = reason
+		db.AwardAmount[id] = amount
+		db.AwardCreated[id] = created
+	}
+	return nil
 }
 
 func (db *InMemory) SelectAwardsForUser(user int64) (int64, error) {
@@ -666,11 +1245,17 @@ func (db *InMemory) CreateGift(user, conversation, message, amount int64, create
 	return id, nil
 }
 
-func (db *InMemory) DeleteGift(user, id int64, deleted time.Time) (int64, error) {
+func (db *InMemory) DeleteGift(user, id int64, deleted time.Time, reversals []*conveyearthgo.Reversal) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
 	if db.GiftUser[id] != user {
 		return 0, nil
 	}
+	if _, ok := db.GiftDeleted[id]; ok {
+		return 0, nil
+	}
 	db.GiftDeleted[id] = deleted
+	db.createReversals(reversals)
 	return 1, nil
 }
 
@@ -746,16 +1331,10 @@ func (db *InMemory) SelectGiftsForUser(user int64) (int64, error) {
 		if db.MessageUser[mid] != user {
 			continue
 		}
-		if _, ok := db.MessageDeleted[mid]; ok {
-			continue
-		}
 		for gid := range db.GiftId {
 			if db.GiftMessage[gid] != mid {
 				continue
 			}
-			if _, ok := db.GiftDeleted[gid]; ok {
-				continue
-			}
 			gifts += db.GiftAmount[gid]
 		}
 	}
@@ -770,22 +1349,848 @@ func (db *InMemory) SelectGiftsFromUser(user int64) (int64, error) {
 		if db.GiftUser[gid] != user {
 			continue
 		}
-		if _, ok := db.GiftDeleted[gid]; ok {
-			continue
-		}
 		gifts += db.GiftAmount[gid]
 	}
 	return gifts, nil
 }
 
-func (db *InMemory) username(id int64) string {
-	for k, v := range db.AccountId {
-		if v == id {
-			return k
-		}
+func (db *InMemory) CreatePayout(user int64, stripeAccount, stripeCurrency string, stripeAmount, size int64, created time.Time, check func() error) (int64, error) {
+	db.payouts.Lock()
+	defer db.payouts.Unlock()
+	if err := check(); err != nil {
+		return 0, err
 	}
-	return ""
-}
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.PayoutId[id] = true
+	db.PayoutUser[id] = user
+	db.PayoutStripeAccount[id] = stripeAccount
+	db.PayoutStripeCurrency[id] = stripeCurrency
+	db.PayoutStripeAmount[id] = stripeAmount
+	db.PayoutSize[id] = size
+	db.PayoutStatus[id] = conveyearthgo.PAYOUT_PENDING
+	db.PayoutCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) UpdatePayoutTransfer(id int64, stripeTransfer string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.PayoutId[id]; !ok {
+		return 0, nil
+	}
+	db.PayoutStripeTransfer[id] = stripeTransfer
+	return 1, nil
+}
+
+func (db *InMemory) UpdatePayoutStatus(id int64, status string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.PayoutId[id]; !ok {
+		return 0, nil
+	}
+	db.PayoutStatus[id] = status
+	return 1, nil
+}
+
+func (db *InMemory) SelectPayout(id int64) (string, string, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.PayoutId[id]; !ok {
+		return "", "", database.ErrNoSuchRecord
+	}
+	if _, ok := db.PayoutDeleted[id]; ok {
+		return "", "", database.ErrNoSuchRecord
+	}
+	return db.PayoutStripeTransfer[id], db.PayoutStatus[id], nil
+}
+
+func (db *InMemory) SelectPayouts(user int64, callback func(int64, string, string, string, int64, int64, string, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	for pid := range db.PayoutId {
+		if db.PayoutUser[pid] != user {
+			continue
+		}
+		if _, ok := db.PayoutDeleted[pid]; ok {
+			continue
+		}
+		if err := callback(pid, db.PayoutStripeAccount[pid], db.PayoutStripeTransfer[pid], db.PayoutStripeCurrency[pid], db.PayoutStripeAmount[pid], db.PayoutSize[pid], db.PayoutStatus[pid], db.PayoutCreated[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectPendingPayouts(since, before time.Time, callback func(int64, int64, string, string, int64) error) error {
+	db.Lock()
+	defer db.Unlock()
+	for pid := range db.PayoutId {
+		if _, ok := db.PayoutDeleted[pid]; ok {
+			continue
+		}
+		if db.PayoutStatus[pid] != conveyearthgo.PAYOUT_PENDING || db.PayoutStripeTransfer[pid] != "" {
+			continue
+		}
+		if created := db.PayoutCreated[pid]; created.Before(since) || !created.Before(before) {
+			continue
+		}
+		if err := callback(pid, db.PayoutUser[pid], db.PayoutStripeAccount[pid], db.PayoutStripeCurrency[pid], db.PayoutStripeAmount[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectPayoutsForUser(user int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var payouts int64
+	for pid := range db.PayoutId {
+		if db.PayoutUser[pid] != user {
+			continue
+		}
+		if _, ok := db.PayoutDeleted[pid]; ok {
+			continue
+		}
+		switch db.PayoutStatus[pid] {
+		case conveyearthgo.PAYOUT_FAILED, conveyearthgo.PAYOUT_REVERSED:
+			// Failed and reversed payouts are credited back
+			continue
+		}
+		payouts += db.PayoutSize[pid]
+	}
+	return payouts, nil
+}
+
+func (db *InMemory) CreateNotification(user int64, kind string, actor, conversation, message, amount int64, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.NotificationId[id] = true
+	db.NotificationUser[id] = user
+	db.NotificationKind[id] = kind
+	db.NotificationActor[id] = actor
+	db.NotificationConversation[id] = conversation
+	db.NotificationMessage[id] = message
+	db.NotificationAmount[id] = amount
+	db.NotificationCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) SelectNotifications(user, limit int64, callback func(int64, string, string, int64, int64, string, int64, bool, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for nid := range db.NotificationId {
+		if db.NotificationUser[nid] == user {
+			ids = append(ids, nid)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return db.NotificationCreated[ids[i]].After(db.NotificationCreated[ids[j]])
+	})
+	if int64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+	for _, nid := range ids {
+		conversation := db.NotificationConversation[nid]
+		_, read := db.NotificationRead[nid]
+		if err := callback(nid, db.NotificationKind[nid], db.username(db.NotificationActor[nid]), conversation, db.NotificationMessage[nid], db.ConversationTopic[conversation], db.NotificationAmount[nid], read, db.NotificationCreated[nid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectUnreadNotificationCount(user int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for nid := range db.NotificationId {
+		if db.NotificationUser[nid] != user {
+			continue
+		}
+		if _, ok := db.NotificationRead[nid]; ok {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (db *InMemory) UpdateNotificationRead(id, user int64, read time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.NotificationId[id] || db.NotificationUser[id] != user {
+		return 0, nil
+	}
+	if _, ok := db.NotificationRead[id]; ok {
+		return 0, nil
+	}
+	db.NotificationRead[id] = read
+	return 1, nil
+}
+
+func (db *InMemory) UpdateAllNotificationsRead(user int64, read time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for nid := range db.NotificationId {
+		if db.NotificationUser[nid] != user {
+			continue
+		}
+		if _, ok := db.NotificationRead[nid]; ok {
+			continue
+		}
+		db.NotificationRead[nid] = read
+		count++
+	}
+	return count, nil
+}
+
+func (db *InMemory) SelectDigestRecipients(edition string, callback func(*authgo.Account) error) error {
+	db.Lock()
+	defer db.Unlock()
+	delivered := make(map[int64]bool)
+	for did := range db.DigestDeliveryId {
+		if db.DigestDeliveryEdition[did] == edition {
+			delivered[db.DigestDeliveryUser[did]] = true
+		}
+	}
+	disabled := make(map[int64]bool)
+	for pid := range db.NotificationPreferencesId {
+		if !db.NotificationPreferencesDigests[pid] {
+			disabled[db.NotificationPreferencesUser[pid]] = true
+		}
+	}
+	var accounts []*authgo.Account
+	for username, id := range db.AccountId {
+		if _, ok := db.AccountDeleted[username]; ok {
+			continue
+		}
+		if delivered[id] || disabled[id] {
+			continue
+		}
+		accounts = append(accounts, &authgo.Account{
+			ID:       id,
+			Username: username,
+			Email:    db.AccountEmail[username],
+			Created:  db.AccountCreated[username],
+		})
+	}
+	sort.Slice(accounts, func(a, b int) bool {
+		return accounts[a].ID < accounts[b].ID
+	})
+	for _, a := range accounts {
+		if err := callback(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) CreateDigestDelivery(edition string, user int64, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	for did := range db.DigestDeliveryId {
+		if db.DigestDeliveryEdition[did] == edition && db.DigestDeliveryUser[did] == user {
+			return 0, conveyearthgo.ErrDigestAlreadyDelivered
+		}
+	}
+	id := database.NextId()
+	db.DigestDeliveryId[id] = true
+	db.DigestDeliveryEdition[id] = edition
+	db.DigestDeliveryUser[id] = user
+	db.DigestDeliveryCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) CreateFollow(user int64, kind string, target int64, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	for fid := range db.FollowId {
+		if db.FollowUser[fid] == user && db.FollowKind[fid] == kind && db.FollowTarget[fid] == target {
+			// Following again restores the previous follow
+			delete(db.FollowDeleted, fid)
+			return fid, nil
+		}
+	}
+	id := database.NextId()
+	db.FollowId[id] = true
+	db.FollowUser[id] = user
+	db.FollowKind[id] = kind
+	db.FollowTarget[id] = target
+	db.FollowCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) DeleteFollow(user int64, kind string, target int64, deleted time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for fid := range db.FollowId {
+		if db.FollowUser[fid] != user || db.FollowKind[fid] != kind || db.FollowTarget[fid] != target {
+			continue
+		}
+		if _, ok := db.FollowDeleted[fid]; ok {
+			continue
+		}
+		db.FollowDeleted[fid] = deleted
+		count++
+	}
+	return count, nil
+}
+
+func (db *InMemory) SelectFollowing(user int64, kind string, target int64) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	for fid := range db.FollowId {
+		if db.FollowUser[fid] != user || db.FollowKind[fid] != kind || db.FollowTarget[fid] != target {
+			continue
+		}
+		if _, ok := db.FollowDeleted[fid]; ok {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (db *InMemory) SelectFollowers(kind string, target int64, callback func(*authgo.Account) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var accounts []*authgo.Account
+	for fid := range db.FollowId {
+		if db.FollowKind[fid] != kind || db.FollowTarget[fid] != target {
+			continue
+		}
+		if _, ok := db.FollowDeleted[fid]; ok {
+			continue
+		}
+		user := db.FollowUser[fid]
+		username := db.username(user)
+		if username == "" {
+			continue
+		}
+		if _, ok := db.AccountDeleted[username]; ok {
+			continue
+		}
+		accounts = append(accounts, &authgo.Account{
+			ID:       user,
+			Username: username,
+			Email:    db.AccountEmail[username],
+			Created:  db.AccountCreated[username],
+		})
+	}
+	sort.Slice(accounts, func(a, b int) bool {
+		return accounts[a].ID < accounts[b].ID
+	})
+	for _, a := range accounts {
+		if err := callback(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) CreateOutboxEntry(kind string, payload []byte, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.OutboxId[id] = true
+	db.OutboxKind[id] = kind
+	db.OutboxPayload[id] = payload
+	db.OutboxNext[id] = created
+	db.OutboxCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) ClaimDueOutboxEntries(now, lease time.Time, limit int64, callback func(int64, string, []byte, int64) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for oid := range db.OutboxId {
+		if _, ok := db.OutboxSent[oid]; ok {
+			continue
+		}
+		if _, ok := db.OutboxDead[oid]; ok {
+			continue
+		}
+		if db.OutboxNext[oid].After(now) {
+			continue
+		}
+		ids = append(ids, oid)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for i, oid := range ids {
+		if int64(i) >= limit {
+			break
+		}
+		db.OutboxNext[oid] = lease
+		if err := callback(oid, db.OutboxKind[oid], db.OutboxPayload[oid], db.OutboxAttempts[oid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectDeadOutboxEntries(callback func(int64, string, []byte, int64, string, time.Time, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for oid := range db.OutboxId {
+		if _, ok := db.OutboxDead[oid]; ok {
+			ids = append(ids, oid)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for _, oid := range ids {
+		if err := callback(oid, db.OutboxKind[oid], db.OutboxPayload[oid], db.OutboxAttempts[oid], db.OutboxError[oid], db.OutboxCreated[oid], db.OutboxDead[oid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) UpdateOutboxEntrySent(id int64, sent time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.OutboxId[id] {
+		return 0, nil
+	}
+	db.OutboxSent[id] = sent
+	return 1, nil
+}
+
+func (db *InMemory) UpdateOutboxEntryRetry(id, attempts int64, next time.Time, lastError string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.OutboxId[id] {
+		return 0, nil
+	}
+	db.OutboxAttempts[id] = attempts
+	db.OutboxNext[id] = next
+	db.OutboxError[id] = lastError
+	return 1, nil
+}
+
+func (db *InMemory) UpdateOutboxEntryDead(id, attempts int64, dead time.Time, lastError string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.OutboxId[id] {
+		return 0, nil
+	}
+	db.OutboxAttempts[id] = attempts
+	db.OutboxDead[id] = dead
+	db.OutboxError[id] = lastError
+	return 1, nil
+}
+
+func (db *InMemory) UpdateOutboxEntryReplay(id int64, next time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.OutboxDead[id]; !ok {
+		return 0, nil
+	}
+	delete(db.OutboxDead, id)
+	db.OutboxAttempts[id] = 0
+	db.OutboxNext[id] = next
+	return 1, nil
+}
+
+func (db *InMemory) CreateWebhook(user int64, url, secret string, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.WebhookId[id] = true
+	db.WebhookUser[id] = user
+	db.WebhookURL[id] = url
+	db.WebhookSecret[id] = secret
+	db.WebhookCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) DeleteWebhook(user, id int64, deleted time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.WebhookId[id] || db.WebhookUser[id] != user {
+		return 0, nil
+	}
+	if _, ok := db.WebhookDeleted[id]; ok {
+		return 0, nil
+	}
+	db.WebhookDeleted[id] = deleted
+	return 1, nil
+}
+
+func (db *InMemory) SelectWebhook(id int64) (int64, string, string, time.Time, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.WebhookId[id] {
+		return 0, "", "", time.Time{}, conveyearthgo.ErrWebhookNotFound
+	}
+	if _, ok := db.WebhookDeleted[id]; ok {
+		return 0, "", "", time.Time{}, conveyearthgo.ErrWebhookNotFound
+	}
+	return db.WebhookUser[id], db.WebhookURL[id], db.WebhookSecret[id], db.WebhookCreated[id], nil
+}
+
+func (db *InMemory) SelectWebhooks(user int64, callback func(int64, string, string, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for wid := range db.WebhookId {
+		if db.WebhookUser[wid] != user {
+			continue
+		}
+		if _, ok := db.WebhookDeleted[wid]; ok {
+			continue
+		}
+		ids = append(ids, wid)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for _, wid := range ids {
+		if err := callback(wid, db.WebhookURL[wid], db.WebhookSecret[wid], db.WebhookCreated[wid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectEventWebhooks(users []int64, callback func(int64) error) error {
+	db.Lock()
+	defer db.Unlock()
+	// Operator webhooks receive every event
+	recipients := map[int64]bool{
+		0: true,
+	}
+	for _, u := range users {
+		recipients[u] = true
+	}
+	var ids []int64
+	for wid := range db.WebhookId {
+		if !recipients[db.WebhookUser[wid]] {
+			continue
+		}
+		if _, ok := db.WebhookDeleted[wid]; ok {
+			continue
+		}
+		ids = append(ids, wid)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for _, wid := range ids {
+		if err := callback(wid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) CreateWebhookDelivery(webhook int64, kind string, payload []byte, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.WebhookDeliveryId[id] = true
+	db.WebhookDeliveryWebhook[id] = webhook
+	db.WebhookDeliveryKind[id] = kind
+	db.WebhookDeliveryPayload[id] = payload
+	db.WebhookDeliveryNext[id] = created
+	db.WebhookDeliveryCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) ClaimDueWebhookDeliveries(now, lease time.Time, limit int64, callback func(int64, string, string, string, []byte, int64) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for did := range db.WebhookDeliveryId {
+		if _, ok := db.WebhookDeliveryDelivered[did]; ok {
+			continue
+		}
+		if _, ok := db.WebhookDeliveryDead[did]; ok {
+			continue
+		}
+		if db.WebhookDeliveryNext[did].After(now) {
+			continue
+		}
+		// Deliveries to removed webhooks are never sent
+		if _, ok := db.WebhookDeleted[db.WebhookDeliveryWebhook[did]]; ok {
+			continue
+		}
+		ids = append(ids, did)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for i, did := range ids {
+		if int64(i) >= limit {
+			break
+		}
+		wid := db.WebhookDeliveryWebhook[did]
+		db.WebhookDeliveryNext[did] = lease
+		if err := callback(did, db.WebhookURL[wid], db.WebhookSecret[wid], db.WebhookDeliveryKind[did], db.WebhookDeliveryPayload[did], db.WebhookDeliveryAttempts[did]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectWebhookDeliveries(webhook, limit int64, callback func(int64, string, []byte, int64, int64, string, time.Time, time.Time, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for did := range db.WebhookDeliveryId {
+		if db.WebhookDeliveryWebhook[did] == webhook {
+			ids = append(ids, did)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] > ids[b]
+	})
+	for i, did := range ids {
+		if int64(i) >= limit {
+			break
+		}
+		if err := callback(did, db.WebhookDeliveryKind[did], db.WebhookDeliveryPayload[did], db.WebhookDeliveryAttempts[did], db.WebhookDeliveryStatus[did], db.WebhookDeliveryError[did], db.WebhookDeliveryCreated[did], db.WebhookDeliveryDelivered[did], db.WebhookDeliveryDead[did]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) UpdateWebhookDeliverySent(id, status int64, delivered time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.WebhookDeliveryId[id] {
+		return 0, nil
+	}
+	db.WebhookDeliveryStatus[id] = status
+	db.WebhookDeliveryDelivered[id] = delivered
+	return 1, nil
+}
+
+func (db *InMemory) UpdateWebhookDeliveryRetry(id, attempts, status int64, next time.Time, lastError string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.WebhookDeliveryId[id] {
+		return 0, nil
+	}
+	db.WebhookDeliveryAttempts[id] = attempts
+	db.WebhookDeliveryStatus[id] = status
+	db.WebhookDeliveryNext[id] = next
+	db.WebhookDeliveryError[id] = lastError
+	return 1, nil
+}
+
+func (db *InMemory) UpdateWebhookDeliveryDead(id, attempts, status int64, dead time.Time, lastError string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.WebhookDeliveryId[id] {
+		return 0, nil
+	}
+	db.WebhookDeliveryAttempts[id] = attempts
+	db.WebhookDeliveryStatus[id] = status
+	db.WebhookDeliveryDead[id] = dead
+	db.WebhookDeliveryError[id] = lastError
+	return 1, nil
+}
+
+func (db *InMemory) CreatePushSubscription(user int64, endpoint, p256dh, auth string, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.PushSubscriptionId[id] = true
+	db.PushSubscriptionUser[id] = user
+	db.PushSubscriptionEndpoint[id] = endpoint
+	db.PushSubscriptionP256dh[id] = p256dh
+	db.PushSubscriptionAuth[id] = auth
+	db.PushSubscriptionCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) DeletePushSubscription(endpoint string, deleted time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for sid := range db.PushSubscriptionId {
+		if db.PushSubscriptionEndpoint[sid] != endpoint {
+			continue
+		}
+		if _, ok := db.PushSubscriptionDeleted[sid]; ok {
+			continue
+		}
+		db.PushSubscriptionDeleted[sid] = deleted
+		count++
+	}
+	return count, nil
+}
+
+func (db *InMemory) SelectPushSubscriptions(user int64, callback func(int64, string, string, string, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for sid := range db.PushSubscriptionId {
+		if db.PushSubscriptionUser[sid] != user {
+			continue
+		}
+		if _, ok := db.PushSubscriptionDeleted[sid]; ok {
+			continue
+		}
+		ids = append(ids, sid)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for _, sid := range ids {
+		if err := callback(sid, db.PushSubscriptionEndpoint[sid], db.PushSubscriptionP256dh[sid], db.PushSubscriptionAuth[sid], db.PushSubscriptionCreated[sid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) CreatePushDelivery(subscription int64, digest string, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.PushDeliveryId[id] = true
+	db.PushDeliverySubscription[id] = subscription
+	db.PushDeliveryDigest[id] = digest
+	db.PushDeliveryCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) DeletePushDelivery(subscription int64, digest string, deleted time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for did := range db.PushDeliveryId {
+		if db.PushDeliverySubscription[did] != subscription || db.PushDeliveryDigest[did] != digest {
+			continue
+		}
+		if _, ok := db.PushDeliveryDeleted[did]; ok {
+			continue
+		}
+		db.PushDeliveryDeleted[did] = deleted
+		count++
+	}
+	return count, nil
+}
+
+func (db *InMemory) SelectPushDelivery(subscription int64, digest string, since time.Time) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	for did := range db.PushDeliveryId {
+		if db.PushDeliverySubscription[did] != subscription || db.PushDeliveryDigest[did] != digest {
+			continue
+		}
+		if _, ok := db.PushDeliveryDeleted[did]; ok {
+			continue
+		}
+		if db.PushDeliveryCreated[did].Before(since) {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (db *InMemory) CreateToken(user int64, name, hash, scopes string, created time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	id := database.NextId()
+	db.TokenId[id] = true
+	db.TokenUser[id] = user
+	db.TokenName[id] = name
+	db.TokenHash[id] = hash
+	db.TokenScopes[id] = scopes
+	db.TokenCreated[id] = created
+	return id, nil
+}
+
+func (db *InMemory) DeleteToken(user, id int64, deleted time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	if !db.TokenId[id] || db.TokenUser[id] != user {
+		return 0, nil
+	}
+	if _, ok := db.TokenDeleted[id]; ok {
+		return 0, nil
+	}
+	db.TokenDeleted[id] = deleted
+	return 1, nil
+}
+
+func (db *InMemory) SelectToken(hash string) (int64, int64, string, string, time.Time, error) {
+	db.Lock()
+	defer db.Unlock()
+	for tid := range db.TokenId {
+		if db.TokenHash[tid] != hash {
+			continue
+		}
+		if _, ok := db.TokenDeleted[tid]; ok {
+			continue
+		}
+		return tid, db.TokenUser[tid], db.TokenName[tid], db.TokenScopes[tid], db.TokenCreated[tid], nil
+	}
+	return 0, 0, "", "", time.Time{}, conveyearthgo.ErrTokenNotFound
+}
+
+func (db *InMemory) SelectTokens(user int64, callback func(int64, string, string, time.Time) error) error {
+	db.Lock()
+	defer db.Unlock()
+	var ids []int64
+	for tid := range db.TokenId {
+		if db.TokenUser[tid] != user {
+			continue
+		}
+		if _, ok := db.TokenDeleted[tid]; ok {
+			continue
+		}
+		ids = append(ids, tid)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	for _, tid := range ids {
+		if err := callback(tid, db.TokenName[tid], db.TokenScopes[tid], db.TokenCreated[tid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *InMemory) SelectUserByID(id int64) (string, string, time.Time, error) {
+	db.Lock()
+	defer db.Unlock()
+	username := db.username(id)
+	if username == "" {
+		return "", "", time.Time{}, authgo.ErrUsernameNotRegistered
+	}
+	if _, ok := db.AccountDeleted[username]; ok {
+		return "", "", time.Time{}, authgo.ErrUsernameNotRegistered
+	}
+	return username, db.AccountEmail[username], db.AccountCreated[username], nil
+}
+
+func (db *InMemory) username(id int64) string {
+	for k, v := range db.AccountId {
+		if v == id {
+			return k
+		}
+	}
+	return ""
+}
 
 func (db *InMemory) cost(id int64) (cost int64) {
 	for cid := range db.ChargeId {
@@ -812,3 +2217,26 @@ func (db *InMemory) yield(id int64) (yield int64) {
 	}
 	return
 }
+
+// listedConversations returns the conversations whose author and opening message have not been deleted.
+func (db *InMemory) listedConversations() []int64 {
+	var results []int64
+	for cid := range db.ConversationId {
+		if _, ok := db.ConversationDeleted[cid]; ok {
+			continue
+		}
+		if _, ok := db.AccountDeleted[db.username(db.ConversationUser[cid])]; ok {
+			continue
+		}
+		for mid := range db.MessageId {
+			if db.MessageConversation[mid] != cid || db.MessageParent[mid] != 0 {
+				continue
+			}
+			if _, ok := db.MessageDeleted[mid]; ok {
+				continue
+			}
+			results = append(results, cid)
+		}
+	}
+	return results
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
 	"database/sql"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
@@ -78,6 +79,14 @@ FROM tbl_purchases;
 SELECT *
 FROM tbl_awards;
 
+// Show all reversals
+SELECT *
+FROM tbl_reversals;
+
+// Show all payouts
+SELECT *
+FROM tbl_payouts;
+
 // Show best content
 SELECT tbl_conversations.id, tbl_conversations.user, tbl_users.username, tbl_conversations.topic, tbl_conversations.created_unix, tbl_charges.amount, IFNULL(yields.yield, 0)
 FROM tbl_conversations
@@ -92,6 +101,9 @@ LEFT JOIN (
 ORDER BY yields.yield DESC
 */
 
+// MAXIMUM_OUTBOX_ERROR_LENGTH matches the width of tbl_notification_outbox.last_error and tbl_webhook_deliveries.last_error
+const MAXIMUM_OUTBOX_ERROR_LENGTH = 1023
+
 func NewSql(dbname, username, password, host, port string, secure bool) (*Sql, error) {
 	if host == "" {
 		host = "localhost"
@@ -117,6 +129,11 @@ type Sql struct {
 	*sql.DB
 }
 
+// executor is satisfied by both *sql.DB and *sql.Tx.
+type executor interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}
+
 func (db *Sql) Migrator(migrations fs.FS) (*migrate.Migrate, error) {
 	source, err := iofs.New(migrations, ".")
 	if err != nil {
@@ -177,6 +194,107 @@ func (db *Sql) SelectUser(username string) (int64, string, []byte, time.Time, er
 	return id, email, password, time.Unix(created, 0), nil
 }
 
+func (db *Sql) CreateNotification(user int64, kind string, actor, conversation, message, amount int64, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_notifications
+		SET user=?, kind=?, actor=?, conversation=?, message=?, amount=?, created_unix=?`, user, kind, actor, conversation, message, amount, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) SelectNotifications(user, limit int64, callback func(int64, string, string, int64, int64, string, int64, bool, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_notifications.id, tbl_notifications.kind, IFNULL(tbl_users.username, ''), tbl_notifications.conversation, tbl_notifications.message, IFNULL(tbl_conversations.topic, ''), tbl_notifications.amount, tbl_notifications.read_unix, tbl_notifications.created_unix
+		FROM tbl_notifications
+		LEFT JOIN tbl_users ON tbl_notifications.actor=tbl_users.id
+		LEFT JOIN tbl_conversations ON tbl_notifications.conversation=tbl_conversations.id
+		WHERE tbl_notifications.deleted_at=0 AND tbl_notifications.user=?
+		ORDER BY tbl_notifications.created_unix DESC, tbl_notifications.id DESC
+		LIMIT ?`, user, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id           int64
+			kind         string
+			actor        string
+			conversation int64
+			message      int64
+			topic        string
+			amount       int64
+			read         int64
+			created      int64
+		)
+		if err := rows.Scan(&id, &kind, &actor, &conversation, &message, &topic, &amount, &read, &created); err != nil {
+			return err
+		}
+		if err := callback(id, kind, actor, conversation, message, topic, amount, read != 0, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectUnreadNotificationCount(user int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM tbl_notifications
+		WHERE deleted_at=0 AND read_unix=0 AND user=?`, user)
+	var (
+		count int64
+	)
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (db *Sql) UpdateNotificationRead(id, user int64, read time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_notifications
+		SET read_unix=?
+		WHERE deleted_at=0 AND read_unix=0 AND id=? AND user=?`, read.Unix(), id, user)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateAllNotificationsRead(user int64, read time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_notifications
+		SET read_unix=?
+		WHERE deleted_at=0 AND read_unix=0 AND user=?`, read.Unix(), user)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectUserByID(id int64) (string, string, time.Time, error) {
+	row := db.QueryRow(`
+		SELECT username, email, created_unix
+		FROM tbl_users
+		WHERE deleted_at=0 AND id=?`, id)
+
+	var (
+		username string
+		email    string
+		created  int64
+	)
+	if err := row.Scan(&username, &email, &created); err != nil {
+		if err == sql.ErrNoRows {
+			err = authgo.ErrUsernameNotRegistered
+		}
+		return "", "", time.Time{}, err
+	}
+	return username, email, time.Unix(created, 0), nil
+}
+
 func (db *Sql) SelectUsernameByEmail(email string) (string, error) {
 	row := db.QueryRow(`
 		SELECT username
@@ -561,17 +679,6 @@ func (db *Sql) CreateConversation(user int64, topic string, created time.Time) (
 	return result.LastInsertId()
 }
 
-func (db *Sql) DeleteConversation(user, id int64, deleted time.Time) (int64, error) {
-	result, err := db.Exec(`
-		UPDATE tbl_conversations
-		SET deleted_at=?
-		WHERE user=? AND id=?`, deleted.Unix(), user, id)
-	if err != nil {
-		return 0, err
-	}
-	return result.RowsAffected()
-}
-
 func (db *Sql) SelectConversation(id int64) (*authgo.Account, string, time.Time, error) {
 	row := db.QueryRow(`
 		SELECT tbl_conversations.user, tbl_users.username, tbl_users.email, tbl_users.created_unix, tbl_conversations.topic, tbl_conversations.created_unix
@@ -690,6 +797,131 @@ func (db *Sql) SelectRecentConversations(callback func(int64, *authgo.Account, s
 	return rows.Err()
 }
 
+func (db *Sql) SelectAuthorConversations(author int64, callback func(int64, *authgo.Account, string, time.Time, int64, int64) error, limit int64) error {
+	rows, err := db.Query(`
+		SELECT tbl_conversations.id, tbl_conversations.user, tbl_users.username, tbl_users.email, tbl_users.created_unix, tbl_conversations.topic, tbl_conversations.created_unix, tbl_charges.amount, IFNULL(yields.yield, 0)
+		FROM tbl_conversations
+		INNER JOIN tbl_users ON tbl_conversations.user=tbl_users.id
+		INNER JOIN tbl_messages ON tbl_conversations.id=tbl_messages.conversation AND tbl_messages.parent IS NULL
+		INNER JOIN tbl_charges ON tbl_messages.id=tbl_charges.message
+		LEFT JOIN (
+			SELECT parent, SUM(IFNULL(amount, 0)) AS yield
+			FROM tbl_yields
+			WHERE deleted_at=0
+			GROUP BY parent
+		) AS yields ON tbl_messages.id=yields.parent
+		WHERE tbl_users.deleted_at=0 AND tbl_conversations.deleted_at=0 AND tbl_messages.deleted_at=0 AND tbl_charges.deleted_at=0 AND tbl_conversations.user=?
+		ORDER BY tbl_conversations.created_unix DESC
+		LIMIT ?`, author, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			user     int64
+			username string
+			email    string
+			joined   int64
+			topic    string
+			created  int64
+			cost     int64
+			yield    int64
+		)
+		if err := rows.Scan(&id, &user, &username, &email, &joined, &topic, &created, &cost, &yield); err != nil {
+			return err
+		}
+		if err := callback(id, &authgo.Account{
+			ID:       user,
+			Username: username,
+			Email:    email,
+			Created:  time.Unix(joined, 0),
+		}, topic, time.Unix(created, 0), cost, yield); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectConversationCount() (int64, error) {
+	row := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM tbl_conversations
+		INNER JOIN tbl_users ON tbl_conversations.user=tbl_users.id
+		INNER JOIN tbl_messages ON tbl_conversations.id=tbl_messages.conversation AND tbl_messages.parent IS NULL
+		WHERE tbl_users.deleted_at=0 AND tbl_conversations.deleted_at=0 AND tbl_messages.deleted_at=0`)
+	var count int64
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (db *Sql) SelectConversationModifications(offset, limit int64, callback func(int64, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_conversations.id, tbl_conversations.created_unix, IFNULL(latest.created_unix, 0)
+		FROM tbl_conversations
+		INNER JOIN tbl_users ON tbl_conversations.user=tbl_users.id
+		INNER JOIN tbl_messages ON tbl_conversations.id=tbl_messages.conversation AND tbl_messages.parent IS NULL
+		LEFT JOIN (
+			SELECT conversation, MAX(created_unix) AS created_unix
+			FROM tbl_messages
+			WHERE deleted_at=0
+			GROUP BY conversation
+		) AS latest ON tbl_conversations.id=latest.conversation
+		WHERE tbl_users.deleted_at=0 AND tbl_conversations.deleted_at=0 AND tbl_messages.deleted_at=0
+		ORDER BY tbl_conversations.id
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			created  int64
+			modified int64
+		)
+		if err := rows.Scan(&id, &created, &modified); err != nil {
+			return err
+		}
+		if modified < created {
+			modified = created
+		}
+		if err := callback(id, time.Unix(modified, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectConversationActivity(id int64) (time.Time, error) {
+	row := db.QueryRow(`
+		SELECT GREATEST(
+			tbl_conversations.created_unix,
+			IFNULL((
+				SELECT MAX(GREATEST(created_unix, IFNULL(deleted_at, 0)))
+				FROM tbl_messages
+				WHERE conversation=tbl_conversations.id
+			), 0),
+			IFNULL((
+				SELECT MAX(GREATEST(created_unix, IFNULL(deleted_at, 0)))
+				FROM tbl_gifts
+				WHERE conversation=tbl_conversations.id
+			), 0)
+		)
+		FROM tbl_conversations
+		WHERE id=?`, id)
+	var (
+		activity int64
+	)
+	if err := row.Scan(&activity); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(activity, 0), nil
+}
+
 func (db *Sql) CreateMessage(user, conversation, parent int64, created time.Time) (int64, error) {
 	var (
 		result sql.Result
@@ -710,11 +942,16 @@ func (db *Sql) CreateMessage(user, conversation, parent int64, created time.Time
 	return result.LastInsertId()
 }
 
-func (db *Sql) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
+func (db *Sql) DeleteMessage(user, id int64, deleted time.Time, reversals []*conveyearthgo.Reversal) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 	d := deleted.Unix()
 	{
 		// Delete message
-		result, err := db.Exec(`
+		result, err := tx.Exec(`
 			UPDATE tbl_messages
 			SET deleted_at=?
 			WHERE user=? AND id=? AND id NOT IN (
@@ -734,9 +971,36 @@ func (db *Sql) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
 			return 0, err
 		}
 	}
+	{
+		// Delete conversation if this is the opening message
+		row := tx.QueryRow(`
+			SELECT conversation, IFNULL(parent, 0)
+			FROM tbl_messages
+			WHERE id=?`, id)
+		var (
+			conversation int64
+			parent       int64
+		)
+		if err := row.Scan(&conversation, &parent); err != nil {
+			return 0, err
+		}
+		if parent == 0 {
+			result, err := tx.Exec(`
+				UPDATE tbl_conversations
+				SET deleted_at=?
+				WHERE user=? AND id=?`, d, user, conversation)
+			if err != nil {
+				return 0, err
+			}
+			count, err := result.RowsAffected()
+			if err != nil || count == 0 {
+				return 0, err
+			}
+		}
+	}
 	{
 		// Delete associated files
-		result, err := db.Exec(`
+		result, err := tx.Exec(`
 			UPDATE tbl_files
 			SET deleted_at=?
 			WHERE message=?`, d, id)
@@ -750,7 +1014,7 @@ func (db *Sql) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
 	}
 	{
 		// Delete associated charge
-		result, err := db.Exec(`
+		result, err := tx.Exec(`
 			UPDATE tbl_charges
 			SET deleted_at=?
 			WHERE user=? AND message=?`, d, user, id)
@@ -764,7 +1028,7 @@ func (db *Sql) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
 	}
 	{
 		// Delete associated yields
-		result, err := db.Exec(`
+		result, err := tx.Exec(`
 			UPDATE tbl_yields
 			SET deleted_at=?
 			WHERE user=? AND message=?`, d, user, id)
@@ -775,6 +1039,12 @@ func (db *Sql) DeleteMessage(user, id int64, deleted time.Time) (int64, error) {
 			return 0, err
 		}
 	}
+	if err := createReversals(tx, reversals); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	return 1, nil
 }
 
@@ -881,6 +1151,20 @@ func (db *Sql) SelectMessageParent(id int64) (int64, error) {
 	return parent, nil
 }
 
+func (db *Sql) SelectOpeningMessage(conversation int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT id
+		FROM tbl_messages
+		WHERE deleted_at=0 AND conversation=? AND parent IS NULL`, conversation)
+	var (
+		id int64
+	)
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 func (db *Sql) SelectFile(id int64) (int64, string, string, time.Time, error) {
 	row := db.QueryRow(`
 		SELECT message, hash, mime, created_unix
@@ -941,12 +1225,10 @@ func (db *Sql) SelectChargesForUser(user int64) (int64, error) {
 		LEFT JOIN (
 			SELECT id, user
 			FROM tbl_messages
-			WHERE deleted_at=0
 		) AS ms ON ms.user=tbl_users.id
 		LEFT JOIN (
 			SELECT message, SUM(IFNULL(amount, 0)) AS total_charges
 			FROM tbl_charges
-			WHERE deleted_at=0
 			GROUP BY message
 		) AS cs ON cs.message=ms.id
 		WHERE tbl_users.deleted_at=0 AND tbl_users.id=?`, user)
@@ -976,12 +1258,10 @@ func (db *Sql) SelectYieldsForUser(user int64) (int64, error) {
 		LEFT JOIN (
 			SELECT id, user
 			FROM tbl_messages
-			WHERE deleted_at=0
 		) AS ms ON ms.user=tbl_users.id
 		LEFT JOIN (
 			SELECT parent, SUM(IFNULL(amount, 0)) AS total_yields
 			FROM tbl_yields
-			WHERE deleted_at=0
 			GROUP BY parent
 		) AS ys ON ys.parent=ms.id
 		WHERE tbl_users.deleted_at=0 AND tbl_users.id=?`, user)
@@ -994,67 +1274,68 @@ func (db *Sql) SelectYieldsForUser(user int64) (int64, error) {
 	return yields, nil
 }
 
-func (db *Sql) CreatePurchase(user int64, sessionID, customerID, paymentIntentID, currency string, amount, size int64, created time.Time) (int64, error) {
-	result, err := db.Exec(`
-		INSERT INTO tbl_purchases
-		SET user=?, stripe_session=?, stripe_customer=?, stripe_payment_intent=?, stripe_currency=?, stripe_amount=?, bundle_size=?, created_unix=?`, user, sessionID, customerID, paymentIntentID, currency, amount, size, created.Unix())
+func (db *Sql) SelectMessageYields(message int64, callback func(*authgo.Account, int64) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_messages.user, tbl_users.username, tbl_users.email, tbl_users.created_unix, tbl_yields.amount
+		FROM tbl_yields
+		INNER JOIN tbl_messages ON tbl_yields.parent=tbl_messages.id
+		INNER JOIN tbl_users ON tbl_messages.user=tbl_users.id
+		WHERE tbl_yields.deleted_at=0 AND tbl_yields.message=?`, message)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return result.LastInsertId()
-}
-
-func (db *Sql) SelectPurchasesForUser(user int64) (int64, error) {
-	row := db.QueryRow(`
-		SELECT IFNULL(SUM(IFNULL(bundle_size, 0)), 0)
-		FROM tbl_purchases
-		WHERE deleted_at=0 AND user=?`, user)
-	var (
-		purchases int64
-	)
-	if err := row.Scan(&purchases); err != nil {
-		return 0, err
+	for rows.Next() {
+		var (
+			user     int64
+			username string
+			email    string
+			joined   int64
+			amount   int64
+		)
+		if err := rows.Scan(&user, &username, &email, &joined, &amount); err != nil {
+			return err
+		}
+		if err := callback(&authgo.Account{
+			ID:       user,
+			Username: username,
+			Email:    email,
+			Created:  time.Unix(joined, 0),
+		}, amount); err != nil {
+			return err
+		}
 	}
-	return purchases, nil
+	return rows.Err()
 }
 
-func (db *Sql) SelectNotificationPreferences(user int64) (int64, bool, bool, bool, bool, error) {
-	row := db.QueryRow(`
-		SELECT id, responses, mentions, gifts, digests
-		FROM tbl_notification_preferences
-		WHERE user=?`, user)
+func (db *Sql) CreateReversal(user, conversation, message, gift int64, reason string, amount int64, created time.Time) (int64, error) {
+	return createReversal(db, user, conversation, message, gift, reason, amount, created)
+}
 
-	var (
-		id        int64
-		responses bool
-		mentions  bool
-		gifts     bool
-		digests   bool
-	)
-	if err := row.Scan(&id, &responses, &mentions, &gifts, &digests); err != nil {
-		if err == sql.ErrNoRows {
-			// Notification preferences default to enabled
-			return 0, true, true, true, true, nil
+// createReversals records the reversals with the given executor, so they can share a transaction with the deletion that caused them.
+func createReversals(e executor, reversals []*conveyearthgo.Reversal) error {
+	for _, r := range reversals {
+		id, err := createReversal(e, r.Account.ID, r.ConversationID, r.MessageID, r.GiftID, r.Reason, r.Amount, r.Created)
+		if err != nil {
+			return err
 		}
-		return 0, false, false, false, false, err
+		r.ID = id
 	}
-	return id, responses, mentions, gifts, digests, nil
+	return nil
 }
 
-func (db *Sql) UpdateNotificationPreferences(id, user int64, responses, mentions, gifts, digests bool) (int64, error) {
+func createReversal(e executor, user, conversation, message, gift int64, reason string, amount int64, created time.Time) (int64, error) {
 	var (
 		result sql.Result
 		err    error
 	)
-	if id == 0 {
-		result, err = db.Exec(`
-		INSERT INTO tbl_notification_preferences (user, responses, mentions, gifts, digests)
-		VALUES (?, ?, ?, ?, ?)`, user, responses, mentions, gifts, digests)
+	if gift == 0 {
+		result, err = e.Exec(`
+			INSERT INTO tbl_reversals
+			SET user=?, conversation=?, message=?, reason=?, amount=?, created_unix=?`, user, conversation, message, reason, amount, created.Unix())
 	} else {
-		result, err = db.Exec(`
-		UPDATE tbl_notification_preferences
-		SET user=?, responses=?, mentions=?, gifts=?, digests=?
-		WHERE id=?`, user, responses, mentions, gifts, digests, id)
+		result, err = e.Exec(`
+			INSERT INTO tbl_reversals
+			SET user=?, conversation=?, message=?, gift=?, reason=?, amount=?, created_unix=?`, user, conversation, message, gift, reason, amount, created.Unix())
 	}
 	if err != nil {
 		return 0, err
@@ -1062,22 +1343,944 @@ func (db *Sql) UpdateNotificationPreferences(id, user int64, responses, mentions
 	return result.LastInsertId()
 }
 
-func (db *Sql) SelectAwardsForUser(user int64) (int64, error) {
+func (db *Sql) SelectReversalsForUser(user int64) (int64, error) {
 	row := db.QueryRow(`
 		SELECT IFNULL(SUM(IFNULL(amount, 0)), 0)
-		FROM tbl_awards
+		FROM tbl_reversals
 		WHERE deleted_at=0 AND user=?`, user)
 	var (
-		awards int64
+		reversals int64
 	)
-	if err := row.Scan(&awards); err != nil {
+	if err := row.Scan(&reversals); err != nil {
 		return 0, err
 	}
-	return awards, nil
+	return reversals, nil
 }
 
-func (db *Sql) CreateStripeAccount(user int64, identity string, created time.Time) (int64, error) {
-	result, err := db.Exec(`
+func (db *Sql) SelectReversals(user int64, callback func(int64, int64, int64, int64, string, int64, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, IFNULL(conversation, 0), IFNULL(message, 0), IFNULL(gift, 0), reason, amount, created_unix
+		FROM tbl_reversals
+		WHERE deleted_at=0 AND user=?
+		ORDER BY created_unix DESC`, user)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			id           int64
+			conversation int64
+			message      int64
+			gift         int64
+			reason       string
+			amount       int64
+			created      int64
+		)
+		if err := rows.Scan(&id, &conversation, &message, &gift, &reason, &amount, &created); err != nil {
+			return err
+		}
+		if err := callback(id, conversation, message, gift, reason, amount, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) CreatePurchase(user int64, sessionID, customerID, paymentIntentID, currency string, amount, size int64, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_purchases
+		SET user=?, stripe_session=?, stripe_customer=?, stripe_payment_intent=?, stripe_currency=?, stripe_amount=?, bundle_size=?, created_unix=?`, user, sessionID, customerID, paymentIntentID, currency, amount, size, created.Unix())
+	if err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok {
+			switch driverErr.Number {
+			case 1062: // ER_DUP_ENTRY
+				return 0, conveyearthgo.ErrPurchaseAlreadyRecorded
+			}
+		}
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) SelectPurchasesForUser(user int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT IFNULL(SUM(IFNULL(bundle_size, 0)), 0)
+		FROM tbl_purchases
+		WHERE deleted_at=0 AND user=?`, user)
+	var (
+		purchases int64
+	)
+	if err := row.Scan(&purchases); err != nil {
+		return 0, err
+	}
+	return purchases, nil
+}
+
+func (db *Sql) SelectPurchaseByPaymentIntent(paymentIntentID string) (int64, int64, string, int64, int64, error) {
+	row := db.QueryRow(`
+		SELECT id, user, stripe_currency, stripe_amount, bundle_size
+		FROM tbl_purchases
+		WHERE deleted_at=0 AND stripe_payment_intent=?`, paymentIntentID)
+	var (
+		id       int64
+		user     int64
+		currency string
+		amount   int64
+		size     int64
+	)
+	if err := row.Scan(&id, &user, &currency, &amount, &size); err != nil {
+		return 0, 0, "", 0, 0, err
+	}
+	return id, user, currency, amount, size, nil
+}
+
+func (db *Sql) CreatePurchaseAdjustment(purchase, user int64, reason, currency string, created time.Time, adjust func(int64) (int64, int64)) (int64, int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	// Lock the purchase so concurrent adjustments, from any server, are applied one at a time
+	var locked int64
+	if err := tx.QueryRow(`
+		SELECT id
+		FROM tbl_purchases
+		WHERE id=?
+		FOR UPDATE`, purchase).Scan(&locked); err != nil {
+		return 0, 0, err
+	}
+	row := tx.QueryRow(`
+		SELECT IFNULL(SUM(IFNULL(size, 0)), 0)
+		FROM tbl_purchase_adjustments
+		WHERE deleted_at=0 AND purchase=? AND reason=?`, purchase, reason)
+	var (
+		existing int64
+	)
+	if err := row.Scan(&existing); err != nil {
+		return 0, 0, err
+	}
+	amount, size := adjust(existing)
+	if size == 0 {
+		return 0, 0, nil
+	}
+	result, err := tx.Exec(`
+		INSERT INTO tbl_purchase_adjustments
+		SET purchase=?, user=?, reason=?, stripe_currency=?, stripe_amount=?, size=?, created_unix=?`, purchase, user, reason, currency, amount, size, created.Unix())
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return id, size, nil
+}
+
+func (db *Sql) SelectPurchaseAdjustmentsForUser(user int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT IFNULL(SUM(IFNULL(size, 0)), 0)
+		FROM tbl_purchase_adjustments
+		WHERE deleted_at=0 AND user=?`, user)
+	var (
+		adjustments int64
+	)
+	if err := row.Scan(&adjustments); err != nil {
+		return 0, err
+	}
+	return adjustments, nil
+}
+
+func (db *Sql) SelectDigestRecipients(edition string, callback func(*authgo.Account) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_users.id, tbl_users.username, tbl_users.email, tbl_users.created_unix
+		FROM tbl_users
+		LEFT JOIN tbl_notification_preferences ON tbl_notification_preferences.user=tbl_users.id
+		LEFT JOIN tbl_digest_deliveries ON tbl_digest_deliveries.user=tbl_users.id AND tbl_digest_deliveries.edition=? AND tbl_digest_deliveries.deleted_at=0
+		WHERE tbl_users.deleted_at=0 AND IFNULL(tbl_notification_preferences.digests, TRUE) AND tbl_digest_deliveries.id IS NULL
+		ORDER BY tbl_users.id`, edition)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			username string
+			email    string
+			created  int64
+		)
+		if err := rows.Scan(&id, &username, &email, &created); err != nil {
+			return err
+		}
+		if err := callback(&authgo.Account{
+			ID:       id,
+			Username: username,
+			Email:    email,
+			Created:  time.Unix(created, 0),
+		}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) CreateDigestDelivery(edition string, user int64, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_digest_deliveries
+		SET edition=?, user=?, created_unix=?`, edition, user, created.Unix())
+	if err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok {
+			switch driverErr.Number {
+			case 1062: // ER_DUP_ENTRY
+				return 0, conveyearthgo.ErrDigestAlreadyDelivered
+			}
+		}
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) CreateFollow(user int64, kind string, target int64, created time.Time) (int64, error) {
+	// Following again restores the previous follow
+	result, err := db.Exec(`
+		INSERT INTO tbl_follows
+		SET user=?, kind=?, target=?, created_unix=?
+		ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id), deleted_at=0`, user, kind, target, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) DeleteFollow(user int64, kind string, target int64, deleted time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_follows
+		SET deleted_at=?
+		WHERE deleted_at=0 AND user=? AND kind=? AND target=?`, deleted.Unix(), user, kind, target)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectFollowing(user int64, kind string, target int64) (bool, error) {
+	row := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM tbl_follows
+		WHERE deleted_at=0 AND user=? AND kind=? AND target=?`, user, kind, target)
+	var count int64
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (db *Sql) SelectFollowers(kind string, target int64, callback func(*authgo.Account) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_users.id, tbl_users.username, tbl_users.email, tbl_users.created_unix
+		FROM tbl_follows
+		INNER JOIN tbl_users ON tbl_follows.user=tbl_users.id
+		WHERE tbl_users.deleted_at=0 AND tbl_follows.deleted_at=0 AND tbl_follows.kind=? AND tbl_follows.target=?
+		ORDER BY tbl_users.id`, kind, target)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			username string
+			email    string
+			created  int64
+		)
+		if err := rows.Scan(&id, &username, &email, &created); err != nil {
+			return err
+		}
+		if err := callback(&authgo.Account{
+			ID:       id,
+			Username: username,
+			Email:    email,
+			Created:  time.Unix(created, 0),
+		}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) CreateOutboxEntry(kind string, payload []byte, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_notification_outbox
+		SET kind=?, payload=?, next_attempt_unix=?, created_unix=?`, kind, payload, created.Unix(), created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) ClaimDueOutboxEntries(now, lease time.Time, limit int64, callback func(int64, string, []byte, int64) error) error {
+	rows, err := db.Query(`
+		SELECT id, kind, payload, attempts
+		FROM tbl_notification_outbox
+		WHERE deleted_at=0 AND sent_unix=0 AND dead_unix=0 AND next_attempt_unix<=?
+		ORDER BY id
+		LIMIT ?`, now.Unix(), limit)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id       int64
+		kind     string
+		payload  []byte
+		attempts int64
+	}
+	var entries []*entry
+	for rows.Next() {
+		e := &entry{}
+		if err := rows.Scan(&e.id, &e.kind, &e.payload, &e.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// Only the worker whose update succeeds holds the claim
+		result, err := db.Exec(`
+			UPDATE tbl_notification_outbox
+			SET next_attempt_unix=?
+			WHERE deleted_at=0 AND sent_unix=0 AND dead_unix=0 AND id=? AND next_attempt_unix<=?`, lease.Unix(), e.id, now.Unix())
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+		if err := callback(e.id, e.kind, e.payload, e.attempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Sql) SelectDeadOutboxEntries(callback func(int64, string, []byte, int64, string, time.Time, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, kind, payload, attempts, last_error, created_unix, dead_unix
+		FROM tbl_notification_outbox
+		WHERE deleted_at=0 AND dead_unix<>0
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id        int64
+			kind      string
+			payload   []byte
+			attempts  int64
+			lastError string
+			created   int64
+			dead      int64
+		)
+		if err := rows.Scan(&id, &kind, &payload, &attempts, &lastError, &created, &dead); err != nil {
+			return err
+		}
+		if err := callback(id, kind, payload, attempts, lastError, time.Unix(created, 0), time.Unix(dead, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) UpdateOutboxEntrySent(id int64, sent time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_notification_outbox
+		SET sent_unix=?
+		WHERE deleted_at=0 AND id=?`, sent.Unix(), id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateOutboxEntryRetry(id, attempts int64, next time.Time, lastError string) (int64, error) {
+	if len(lastError) > MAXIMUM_OUTBOX_ERROR_LENGTH {
+		lastError = lastError[:MAXIMUM_OUTBOX_ERROR_LENGTH]
+	}
+	result, err := db.Exec(`
+		UPDATE tbl_notification_outbox
+		SET attempts=?, next_attempt_unix=?, last_error=?
+		WHERE deleted_at=0 AND id=?`, attempts, next.Unix(), lastError, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateOutboxEntryDead(id, attempts int64, dead time.Time, lastError string) (int64, error) {
+	if len(lastError) > MAXIMUM_OUTBOX_ERROR_LENGTH {
+		lastError = lastError[:MAXIMUM_OUTBOX_ERROR_LENGTH]
+	}
+	result, err := db.Exec(`
+		UPDATE tbl_notification_outbox
+		SET attempts=?, dead_unix=?, last_error=?
+		WHERE deleted_at=0 AND id=?`, attempts, dead.Unix(), lastError, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateOutboxEntryReplay(id int64, next time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_notification_outbox
+		SET attempts=0, next_attempt_unix=?, dead_unix=0
+		WHERE deleted_at=0 AND dead_unix<>0 AND id=?`, next.Unix(), id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) CreateWebhook(user int64, url, secret string, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_webhooks
+		SET user=?, url=?, secret=?, created_unix=?`, user, url, secret, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) DeleteWebhook(user, id int64, deleted time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_webhooks
+		SET deleted_at=?
+		WHERE deleted_at=0 AND user=? AND id=?`, deleted.Unix(), user, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectWebhook(id int64) (int64, string, string, time.Time, error) {
+	row := db.QueryRow(`
+		SELECT user, url, secret, created_unix
+		FROM tbl_webhooks
+		WHERE deleted_at=0 AND id=?`, id)
+	var (
+		user    int64
+		url     string
+		secret  string
+		created int64
+	)
+	if err := row.Scan(&user, &url, &secret, &created); err != nil {
+		if err == sql.ErrNoRows {
+			err = conveyearthgo.ErrWebhookNotFound
+		}
+		return 0, "", "", time.Time{}, err
+	}
+	return user, url, secret, time.Unix(created, 0), nil
+}
+
+func (db *Sql) SelectWebhooks(user int64, callback func(int64, string, string, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, url, secret, created_unix
+		FROM tbl_webhooks
+		WHERE deleted_at=0 AND user=?
+		ORDER BY id`, user)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id      int64
+			url     string
+			secret  string
+			created int64
+		)
+		if err := rows.Scan(&id, &url, &secret, &created); err != nil {
+			return err
+		}
+		if err := callback(id, url, secret, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectEventWebhooks(users []int64, callback func(int64) error) error {
+	// Operator webhooks receive every event
+	query := `
+		SELECT id
+		FROM tbl_webhooks
+		WHERE deleted_at=0 AND user IN (?` + strings.Repeat(", ?", len(users)) + `)
+		ORDER BY id`
+	args := []interface{}{0}
+	for _, u := range users {
+		args = append(args, u)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if err := callback(id); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) CreateWebhookDelivery(webhook int64, kind string, payload []byte, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_webhook_deliveries
+		SET webhook=?, kind=?, payload=?, next_attempt_unix=?, created_unix=?`, webhook, kind, payload, created.Unix(), created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) ClaimDueWebhookDeliveries(now, lease time.Time, limit int64, callback func(int64, string, string, string, []byte, int64) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_webhook_deliveries.id, tbl_webhooks.url, tbl_webhooks.secret, tbl_webhook_deliveries.kind, tbl_webhook_deliveries.payload, tbl_webhook_deliveries.attempts
+		FROM tbl_webhook_deliveries
+		INNER JOIN tbl_webhooks ON tbl_webhook_deliveries.webhook=tbl_webhooks.id
+		WHERE tbl_webhooks.deleted_at=0 AND tbl_webhook_deliveries.deleted_at=0 AND tbl_webhook_deliveries.delivered_unix=0 AND tbl_webhook_deliveries.dead_unix=0 AND tbl_webhook_deliveries.next_attempt_unix<=?
+		ORDER BY tbl_webhook_deliveries.id
+		LIMIT ?`, now.Unix(), limit)
+	if err != nil {
+		return err
+	}
+	type delivery struct {
+		id       int64
+		url      string
+		secret   string
+		kind     string
+		payload  []byte
+		attempts int64
+	}
+	var deliveries []*delivery
+	for rows.Next() {
+		d := &delivery{}
+		if err := rows.Scan(&d.id, &d.url, &d.secret, &d.kind, &d.payload, &d.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		// Only the worker whose update succeeds holds the claim
+		result, err := db.Exec(`
+			UPDATE tbl_webhook_deliveries
+			SET next_attempt_unix=?
+			WHERE deleted_at=0 AND delivered_unix=0 AND dead_unix=0 AND id=? AND next_attempt_unix<=?`, lease.Unix(), d.id, now.Unix())
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+		if err := callback(d.id, d.url, d.secret, d.kind, d.payload, d.attempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Sql) SelectWebhookDeliveries(webhook, limit int64, callback func(int64, string, []byte, int64, int64, string, time.Time, time.Time, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, kind, payload, attempts, status_code, last_error, created_unix, delivered_unix, dead_unix
+		FROM tbl_webhook_deliveries
+		WHERE deleted_at=0 AND webhook=?
+		ORDER BY id DESC
+		LIMIT ?`, webhook, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id        int64
+			kind      string
+			payload   []byte
+			attempts  int64
+			status    int64
+			lastError string
+			created   int64
+			delivered int64
+			dead      int64
+		)
+		if err := rows.Scan(&id, &kind, &payload, &attempts, &status, &lastError, &created, &delivered, &dead); err != nil {
+			return err
+		}
+		var d, x time.Time
+		if delivered != 0 {
+			d = time.Unix(delivered, 0)
+		}
+		if dead != 0 {
+			x = time.Unix(dead, 0)
+		}
+		if err := callback(id, kind, payload, attempts, status, lastError, time.Unix(created, 0), d, x); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) UpdateWebhookDeliverySent(id, status int64, delivered time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_webhook_deliveries
+		SET status_code=?, delivered_unix=?
+		WHERE deleted_at=0 AND id=?`, status, delivered.Unix(), id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateWebhookDeliveryRetry(id, attempts, status int64, next time.Time, lastError string) (int64, error) {
+	if len(lastError) > MAXIMUM_OUTBOX_ERROR_LENGTH {
+		lastError = lastError[:MAXIMUM_OUTBOX_ERROR_LENGTH]
+	}
+	result, err := db.Exec(`
+		UPDATE tbl_webhook_deliveries
+		SET attempts=?, status_code=?, next_attempt_unix=?, last_error=?
+		WHERE deleted_at=0 AND id=?`, attempts, status, next.Unix(), lastError, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdateWebhookDeliveryDead(id, attempts, status int64, dead time.Time, lastError string) (int64, error) {
+	if len(lastError) > MAXIMUM_OUTBOX_ERROR_LENGTH {
+		lastError = lastError[:MAXIMUM_OUTBOX_ERROR_LENGTH]
+	}
+	result, err := db.Exec(`
+		UPDATE tbl_webhook_deliveries
+		SET attempts=?, status_code=?, dead_unix=?, last_error=?
+		WHERE deleted_at=0 AND id=?`, attempts, status, dead.Unix(), lastError, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) CreatePushSubscription(user int64, endpoint, p256dh, auth string, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_push_subscriptions
+		SET user=?, endpoint=?, p256dh=?, auth=?, created_unix=?`, user, endpoint, p256dh, auth, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) DeletePushSubscription(endpoint string, deleted time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_push_subscriptions
+		SET deleted_at=?
+		WHERE deleted_at=0 AND endpoint=?`, deleted.Unix(), endpoint)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectPushSubscriptions(user int64, callback func(int64, string, string, string, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, endpoint, p256dh, auth, created_unix
+		FROM tbl_push_subscriptions
+		WHERE deleted_at=0 AND user=?
+		ORDER BY id`, user)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			endpoint string
+			p256dh   string
+			auth     string
+			created  int64
+		)
+		if err := rows.Scan(&id, &endpoint, &p256dh, &auth, &created); err != nil {
+			return err
+		}
+		if err := callback(id, endpoint, p256dh, auth, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) CreatePushDelivery(subscription int64, digest string, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_push_deliveries
+		SET subscription=?, digest=?, created_unix=?`, subscription, digest, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) DeletePushDelivery(subscription int64, digest string, deleted time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_push_deliveries
+		SET deleted_at=?
+		WHERE deleted_at=0 AND subscription=? AND digest=?`, deleted.Unix(), subscription, digest)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectPushDelivery(subscription int64, digest string, since time.Time) (bool, error) {
+	row := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM tbl_push_deliveries
+		WHERE deleted_at=0 AND subscription=? AND digest=? AND created_unix>=?`, subscription, digest, since.Unix())
+	var count int64
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (db *Sql) CreateToken(user int64, name, hash, scopes string, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_tokens
+		SET user=?, name=?, hash=?, scopes=?, created_unix=?`, user, name, hash, scopes, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) DeleteToken(user, id int64, deleted time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_tokens
+		SET deleted_at=?
+		WHERE deleted_at=0 AND user=? AND id=?`, deleted.Unix(), user, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectToken(hash string) (int64, int64, string, string, time.Time, error) {
+	row := db.QueryRow(`
+		SELECT id, user, name, scopes, created_unix
+		FROM tbl_tokens
+		WHERE deleted_at=0 AND hash=?`, hash)
+	var (
+		id      int64
+		user    int64
+		name    string
+		scopes  string
+		created int64
+	)
+	if err := row.Scan(&id, &user, &name, &scopes, &created); err != nil {
+		if err == sql.ErrNoRows {
+			err = conveyearthgo.ErrTokenNotFound
+		}
+		return 0, 0, "", "", time.Time{}, err
+	}
+	return id, user, name, scopes, time.Unix(created, 0), nil
+}
+
+func (db *Sql) SelectTokens(user int64, callback func(int64, string, string, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, name, scopes, created_unix
+		FROM tbl_tokens
+		WHERE deleted_at=0 AND user=?
+		ORDER BY id`, user)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id      int64
+			name    string
+			scopes  string
+			created int64
+		)
+		if err := rows.Scan(&id, &name, &scopes, &created); err != nil {
+			return err
+		}
+		if err := callback(id, name, scopes, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectNotificationPreferences(user int64) (*conveyearthgo.NotificationPreferences, error) {
+	row := db.QueryRow(`
+		SELECT id, responses, mentions, gifts, digests, yields, replies, publications, frequency, email, push
+		FROM tbl_notification_preferences
+		WHERE user=?`, user)
+
+	p := &conveyearthgo.NotificationPreferences{
+		User: user,
+	}
+	if err := row.Scan(&p.ID, &p.Responses, &p.Mentions, &p.Gifts, &p.Digests, &p.Yields, &p.Replies, &p.Publications, &p.Frequency, &p.Email, &p.Push); err != nil {
+		if err == sql.ErrNoRows {
+			// Notification preferences default to enabled
+			return conveyearthgo.NewNotificationPreferences(user), nil
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+func (db *Sql) UpdateNotificationPreferences(p *conveyearthgo.NotificationPreferences) (int64, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+	if p.ID == 0 {
+		result, err = db.Exec(`
+		INSERT INTO tbl_notification_preferences (user, responses, mentions, gifts, digests, yields, replies, publications, frequency, email, push)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, p.User, p.Responses, p.Mentions, p.Gifts, p.Digests, p.Yields, p.Replies, p.Publications, p.Frequency, p.Email, p.Push)
+	} else {
+		result, err = db.Exec(`
+		UPDATE tbl_notification_preferences
+		SET user=?, responses=?, mentions=?, gifts=?, digests=?, yields=?, replies=?, publications=?, frequency=?, email=?, push=?
+		WHERE id=?`, p.User, p.Responses, p.Mentions, p.Gifts, p.Digests, p.Yields, p.Replies, p.Publications, p.Frequency, p.Email, p.Push, p.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) SelectNotificationSummaryRecipients(frequency string, callback func(*authgo.Account, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT tbl_users.id, tbl_users.username, tbl_users.email, tbl_users.created_unix, tbl_notification_preferences.summary_unix
+		FROM tbl_notification_preferences
+		INNER JOIN tbl_users ON tbl_notification_preferences.user=tbl_users.id
+		WHERE tbl_users.deleted_at=0 AND tbl_notification_preferences.frequency=?
+		ORDER BY tbl_users.id`, frequency)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			username string
+			email    string
+			created  int64
+			summary  int64
+		)
+		if err := rows.Scan(&id, &username, &email, &created, &summary); err != nil {
+			return err
+		}
+		var s time.Time
+		if summary != 0 {
+			s = time.Unix(summary, 0)
+		}
+		if err := callback(&authgo.Account{
+			ID:       id,
+			Username: username,
+			Email:    email,
+			Created:  time.Unix(created, 0),
+		}, s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) UpdateNotificationSummary(user int64, summary time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_notification_preferences
+		SET summary_unix=?
+		WHERE user=?`, summary.Unix(), user)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) CreateAward(user int64, reason string, amount int64, created time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO tbl_awards
+		SET user=?, reason=?, amount=?, created_unix=?`, user, reason, amount, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *Sql) CreateEditionAwards(edition, reason string, awards map[int64]int64, created time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for user, amount := range awards {
+		if _, err := tx.Exec(`
+			INSERT INTO tbl_awards
+			SET user=?, edition=?, reason=?, amount=?, created_unix=?`, user, edition, reason, amount, created.Unix()); err != nil {
+			if driverErr, ok := err.(*mysql.MySQLError); ok {
+				switch driverErr.Number {
+				case 1062: // ER_DUP_ENTRY
+					return conveyearthgo.ErrAwardsAlreadyRecorded
+				}
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Sql) SelectAwardsForUser(user int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT IFNULL(SUM(IFNULL(amount, 0)), 0)
+		FROM tbl_awards
+		WHERE deleted_at=0 AND user=?`, user)
+	var (
+		awards int64
+	)
+	if err := row.Scan(&awards); err != nil {
+		return 0, err
+	}
+	return awards, nil
+}
+
+func (db *Sql) CreateStripeAccount(user int64, identity string, created time.Time) (int64, error) {
+	result, err := db.Exec(`
 		INSERT INTO tbl_stripe_account
 		SET user=?, identity=?, created_unix=?`, user, identity, created.Unix())
 	if err != nil {
@@ -1111,15 +2314,30 @@ func (db *Sql) CreateGift(user, conversation, message, amount int64, created tim
 	return result.LastInsertId()
 }
 
-func (db *Sql) DeleteGift(user, id int64, deleted time.Time) (int64, error) {
-	result, err := db.Exec(`
+func (db *Sql) DeleteGift(user, id int64, deleted time.Time, reversals []*conveyearthgo.Reversal) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`
 		UPDATE tbl_gifts
 		SET deleted_at=?
-		WHERE user=? AND id=?`, deleted.Unix(), user, id)
+		WHERE deleted_at=0 AND user=? AND id=?`, deleted.Unix(), user, id)
 	if err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	count, err := result.RowsAffected()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	if err := createReversals(tx, reversals); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 func (db *Sql) SelectGift(id int64) (int64, int64, *authgo.Account, int64, time.Time, error) {
@@ -1204,12 +2422,10 @@ func (db *Sql) SelectGiftsForUser(user int64) (int64, error) {
 		LEFT JOIN (
 			SELECT id, user
 			FROM tbl_messages
-			WHERE deleted_at=0
 		) AS ms ON ms.user=tbl_users.id
 		LEFT JOIN (
 			SELECT message, SUM(IFNULL(amount, 0)) AS total_amounts
 			FROM tbl_gifts
-			WHERE deleted_at=0
 			GROUP BY message
 		) AS ys ON ys.message=ms.id
 		WHERE tbl_users.deleted_at=0 AND tbl_users.id=?`, user)
@@ -1226,7 +2442,7 @@ func (db *Sql) SelectGiftsFromUser(user int64) (int64, error) {
 	row := db.QueryRow(`
 		SELECT IFNULL(SUM(IFNULL(amount, 0)), 0)
 		FROM tbl_gifts
-		WHERE deleted_at=0 AND user=?`, user)
+		WHERE user=?`, user)
 	var (
 		gifts int64
 	)
@@ -1235,3 +2451,145 @@ func (db *Sql) SelectGiftsFromUser(user int64) (int64, error) {
 	}
 	return gifts, nil
 }
+
+func (db *Sql) CreatePayout(user int64, stripeAccount, stripeCurrency string, stripeAmount, size int64, created time.Time, check func() error) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// Lock the user so concurrent payouts, from any server, are checked one at a time
+	var locked int64
+	if err := tx.QueryRow(`
+		SELECT id
+		FROM tbl_users
+		WHERE id=?
+		FOR UPDATE`, user).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if err := check(); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`
+		INSERT INTO tbl_payouts
+		SET user=?, stripe_account=?, stripe_currency=?, stripe_amount=?, size=?, status=?, created_unix=?`, user, stripeAccount, stripeCurrency, stripeAmount, size, conveyearthgo.PAYOUT_PENDING, created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (db *Sql) UpdatePayoutTransfer(id int64, stripeTransfer string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_payouts
+		SET stripe_transfer=?
+		WHERE id=?`, stripeTransfer, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) UpdatePayoutStatus(id int64, status string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE tbl_payouts
+		SET status=?
+		WHERE id=?`, status, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *Sql) SelectPayout(id int64) (string, string, error) {
+	row := db.QueryRow(`
+		SELECT IFNULL(stripe_transfer, ''), status
+		FROM tbl_payouts
+		WHERE deleted_at=0 AND id=?`, id)
+	var (
+		stripeTransfer string
+		status         string
+	)
+	if err := row.Scan(&stripeTransfer, &status); err != nil {
+		return "", "", err
+	}
+	return stripeTransfer, status, nil
+}
+
+func (db *Sql) SelectPayouts(user int64, callback func(int64, string, string, string, int64, int64, string, time.Time) error) error {
+	rows, err := db.Query(`
+		SELECT id, stripe_account, IFNULL(stripe_transfer, ''), stripe_currency, stripe_amount, size, status, created_unix
+		FROM tbl_payouts
+		WHERE deleted_at=0 AND user=?
+		ORDER BY created_unix DESC`, user)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			id             int64
+			stripeAccount  string
+			stripeTransfer string
+			stripeCurrency string
+			stripeAmount   int64
+			size           int64
+			status         string
+			created        int64
+		)
+		if err := rows.Scan(&id, &stripeAccount, &stripeTransfer, &stripeCurrency, &stripeAmount, &size, &status, &created); err != nil {
+			return err
+		}
+		if err := callback(id, stripeAccount, stripeTransfer, stripeCurrency, stripeAmount, size, status, time.Unix(created, 0)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectPendingPayouts(since, before time.Time, callback func(int64, int64, string, string, int64) error) error {
+	rows, err := db.Query(`
+		SELECT id, user, stripe_account, stripe_currency, stripe_amount
+		FROM tbl_payouts
+		WHERE deleted_at=0 AND status=? AND stripe_transfer IS NULL AND created_unix>=? AND created_unix<?
+		ORDER BY created_unix`, conveyearthgo.PAYOUT_PENDING, since.Unix(), before.Unix())
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			id             int64
+			user           int64
+			stripeAccount  string
+			stripeCurrency string
+			stripeAmount   int64
+		)
+		if err := rows.Scan(&id, &user, &stripeAccount, &stripeCurrency, &stripeAmount); err != nil {
+			return err
+		}
+		if err := callback(id, user, stripeAccount, stripeCurrency, stripeAmount); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Sql) SelectPayoutsForUser(user int64) (int64, error) {
+	row := db.QueryRow(`
+		SELECT IFNULL(SUM(IFNULL(size, 0)), 0)
+		FROM tbl_payouts
+		WHERE deleted_at=0 AND status NOT IN (?, ?) AND user=?`, conveyearthgo.PAYOUT_FAILED, conveyearthgo.PAYOUT_REVERSED, user)
+	var (
+		payouts int64
+	)
+	if err := row.Scan(&payouts); err != nil {
+		return 0, err
+	}
+	return payouts, nil
+}
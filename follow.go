@@ -0,0 +1,92 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	FOLLOW_CONVERSATION = "conversation"
+	FOLLOW_AUTHOR       = "author"
+)
+
+var (
+	ErrFollowKindUnrecognized = errors.New("Unrecognized Follow Kind")
+	ErrFollowSelf             = errors.New("Cannot Follow Yourself")
+)
+
+type FollowDatabase interface {
+	CreateFollow(int64, string, int64, time.Time) (int64, error)
+	DeleteFollow(int64, string, int64, time.Time) (int64, error)
+	SelectFollowing(int64, string, int64) (bool, error)
+	SelectFollowers(string, int64, func(*authgo.Account) error) error
+}
+
+// Follow subscribes the user to new replies in a conversation, or to new conversations published by an author.
+func (m *notificationManager) Follow(account *authgo.Account, kind string, target int64) error {
+	switch kind {
+	case FOLLOW_CONVERSATION:
+	case FOLLOW_AUTHOR:
+		if target == account.ID {
+			return ErrFollowSelf
+		}
+	default:
+		return ErrFollowKindUnrecognized
+	}
+	id, err := m.database.CreateFollow(account.ID, kind, target, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Println("Created Follow", id)
+	return nil
+}
+
+func (m *notificationManager) Unfollow(account *authgo.Account, kind string, target int64) error {
+	_, err := m.database.DeleteFollow(account.ID, kind, target, time.Now())
+	return err
+}
+
+func (m *notificationManager) Following(account *authgo.Account, kind string, target int64) (bool, error) {
+	return m.database.SelectFollowing(account.ID, kind, target)
+}
+
+// Followers calls the callback with each user following the given conversation or author, in order of ID.
+func (m *notificationManager) Followers(kind string, target int64, callback func(*authgo.Account) error) error {
+	return m.database.SelectFollowers(kind, target, callback)
+}
+
+func (m *notificationManager) NotifyReply(follower, replier *authgo.Account, conversation int64, topic string, message int64) error {
+	m.record(follower.ID, NOTIFICATION_REPLY, replier.ID, conversation, message, 0)
+	p, err := m.database.SelectNotificationPreferences(follower.ID)
+	if err != nil {
+		return err
+	}
+	if !p.Replies {
+		// User disabled reply notifications
+		return nil
+	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
+	return m.sender.SendReplyNotification(follower, replier.Username, topic, conversation, message)
+}
+
+func (m *notificationManager) NotifyPublication(follower, author *authgo.Account, conversation int64, topic string) error {
+	m.record(follower.ID, NOTIFICATION_PUBLICATION, author.ID, conversation, 0, 0)
+	p, err := m.database.SelectNotificationPreferences(follower.ID)
+	if err != nil {
+		return err
+	}
+	if !p.Publications {
+		// User disabled publication notifications
+		return nil
+	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
+	return m.sender.SendPublicationNotification(follower, author.Username, topic, conversation)
+}
@@ -0,0 +1,104 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNotificationManager_Follow(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	author := authtest.NewTestAccount(t, auth)
+	follower, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+	assert.NoError(t, err)
+	conversation, err := db.CreateConversation(author.ID, "Test", author.Created)
+	assert.NoError(t, err)
+	nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+
+	followers := func(kind string, target int64) []int64 {
+		var ids []int64
+		assert.NoError(t, nm.Followers(kind, target, func(a *authgo.Account) error {
+			ids = append(ids, a.ID)
+			return nil
+		}))
+		return ids
+	}
+
+	assert.Equal(t, conveyearthgo.ErrFollowKindUnrecognized, nm.Follow(follower, "foobar", conversation))
+	assert.Equal(t, conveyearthgo.ErrFollowSelf, nm.Follow(author, conveyearthgo.FOLLOW_AUTHOR, author.ID))
+
+	// Authors can follow their own conversations
+	assert.NoError(t, nm.Follow(author, conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	assert.NoError(t, nm.Follow(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	assert.NoError(t, nm.Follow(follower, conveyearthgo.FOLLOW_AUTHOR, author.ID))
+	assert.Equal(t, []int64{author.ID, follower.ID}, followers(conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	assert.Equal(t, []int64{follower.ID}, followers(conveyearthgo.FOLLOW_AUTHOR, author.ID))
+
+	following, err := nm.Following(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation)
+	assert.NoError(t, err)
+	assert.True(t, following)
+
+	// Following twice is the same as following once
+	assert.NoError(t, nm.Follow(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	assert.Equal(t, []int64{author.ID, follower.ID}, followers(conveyearthgo.FOLLOW_CONVERSATION, conversation))
+
+	assert.NoError(t, nm.Unfollow(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	following, err = nm.Following(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation)
+	assert.NoError(t, err)
+	assert.False(t, following)
+	assert.Equal(t, []int64{author.ID}, followers(conveyearthgo.FOLLOW_CONVERSATION, conversation))
+
+	// Unfollowing one kind doesn't affect the other
+	assert.Equal(t, []int64{follower.ID}, followers(conveyearthgo.FOLLOW_AUTHOR, author.ID))
+
+	// Following again restores the follow
+	assert.NoError(t, nm.Follow(follower, conveyearthgo.FOLLOW_CONVERSATION, conversation))
+	assert.Equal(t, []int64{author.ID, follower.ID}, followers(conveyearthgo.FOLLOW_CONVERSATION, conversation))
+}
+
+func TestNotificationManager_NotifyFollowers(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	author := authtest.NewTestAccount(t, auth)
+	follower, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+	assert.NoError(t, err)
+	conversation, err := db.CreateConversation(author.ID, "Test", author.Created)
+	assert.NoError(t, err)
+	nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+
+	// Notifications are recorded even when emails are disabled
+	assert.NoError(t, nm.SetNotificationPreferences(&conveyearthgo.NotificationPreferences{
+		User:         follower.ID,
+		Responses:    true,
+		Mentions:     true,
+		Gifts:        true,
+		Digests:      true,
+		Yields:       true,
+		Replies:      false,
+		Publications: false,
+		Frequency:    conveyearthgo.FREQUENCY_IMMEDIATE,
+	}))
+	assert.NoError(t, nm.NotifyPublication(follower, author, conversation, "Test"))
+	assert.NoError(t, nm.NotifyReply(follower, author, conversation, "Test", 2))
+
+	var notifications []*conveyearthgo.Notification
+	assert.NoError(t, nm.Notifications(follower, 10, func(n *conveyearthgo.Notification) error {
+		notifications = append(notifications, n)
+		return nil
+	}))
+	assert.Equal(t, 2, len(notifications))
+	kinds := make(map[string]*conveyearthgo.Notification)
+	for _, n := range notifications {
+		assert.Equal(t, author.Username, n.Actor)
+		assert.Equal(t, "Test", n.Topic)
+		assert.Equal(t, conversation, n.ConversationID)
+		kinds[n.Kind] = n
+	}
+	assert.Equal(t, int64(0), kinds[conveyearthgo.NOTIFICATION_PUBLICATION].MessageID)
+	assert.Equal(t, int64(2), kinds[conveyearthgo.NOTIFICATION_REPLY].MessageID)
+}
@@ -6,7 +6,10 @@ import (
 	"time"
 )
 
-var ErrSelfGiftingNotPermitted = errors.New("Self-Gifting Not Permitted")
+var (
+	ErrSelfGiftingNotPermitted = errors.New("Self-Gifting Not Permitted")
+	ErrGiftAmountInvalid       = errors.New("Gift Amount Must Be Positive")
+)
 
 type Gift struct {
 	ID             int64
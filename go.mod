@@ -17,5 +17,6 @@ require (
 	github.com/stripe/stripe-go/v72 v72.63.0
 	github.com/yuin/goldmark v1.2.1
 	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
+	golang.org/x/text v0.3.6
 	rogchap.com/v8go v0.6.0
 )
@@ -9,13 +9,15 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
 )
 
-func AttachAccountHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, ts *template.Template) {
-	m.Handle("/account", handler.Log(handler.Compress(Account(a, am, nm, ts))))
+func AttachAccountHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, tm conveyearthgo.TokenManager, ts *template.Template) {
+	m.Handle("/account", handler.Log(handler.Compress(Account(a, am, nm, tm, ts))))
 }
 
-func Account(a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
+// Account shows the account's details and settings, and creates or revokes its API tokens.
+func Account(a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, tm conveyearthgo.TokenManager, ts *template.Template) http.Handler {
 	scheme := conveyearthgo.Scheme()
 	domain := conveyearthgo.Host()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -26,9 +28,33 @@ func Account(a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveye
 		}
 		data := &AccountData{
 			Account: account,
+			Unread:  unreadNotifications(nm, account),
 			Live:    netgo.IsLive(),
 			Scheme:  scheme,
 			Domain:  domain,
+			Scopes:  conveyearthgo.TokenScopes,
+		}
+		if r.Method == "POST" {
+			if revoke := netgo.ParseInt(r.FormValue("revoke")); revoke != 0 {
+				if err := tm.Revoke(account.ID, revoke); err != nil {
+					log.Println(err)
+					data.Error = err.Error()
+				} else {
+					http.Redirect(w, r, "/account", http.StatusFound)
+					return
+				}
+			} else {
+				name := strings.TrimSpace(r.FormValue("name"))
+				// The secret is only shown once, so the page is rendered rather than redirected
+				_, secret, err := tm.Create(account.ID, name, r.Form["scope"])
+				if err != nil {
+					log.Println(err)
+					data.Error = err.Error()
+					data.TokenName = name
+				} else {
+					data.TokenSecret = secret
+				}
+			}
 		}
 		balance, err := am.AccountBalance(account.ID)
 		if err != nil {
@@ -38,17 +64,37 @@ func Account(a authgo.Authenticator, am conveyearthgo.AccountManager, nm conveye
 			return
 		}
 		data.Balance = balance
-		_, responses, mentions, gifts, digests, err := nm.NotificationPreferences(account.ID)
+		if err := am.LookupReversals(account, func(r *conveyearthgo.Reversal) error {
+			data.Reversals = append(data.Reversals, r)
+			return nil
+		}); err != nil {
+			log.Println(err)
+			data.Error = err.Error()
+			executeAccountTemplate(w, ts, data)
+			return
+		}
+		preferences, err := nm.NotificationPreferences(account.ID)
 		if err != nil {
 			log.Println(err)
 			data.Error = err.Error()
 			executeAccountTemplate(w, ts, data)
 			return
 		}
-		data.NotificationResponses = responses
-		data.NotificationMentions = mentions
-		data.NotificationGifts = gifts
-		data.NotificationDigests = digests
+		data.NotificationResponses = preferences.Responses
+		data.NotificationMentions = preferences.Mentions
+		data.NotificationGifts = preferences.Gifts
+		data.NotificationDigests = preferences.Digests
+		data.NotificationYields = preferences.Yields
+		data.NotificationReplies = preferences.Replies
+		data.NotificationPublications = preferences.Publications
+		data.NotificationFrequency = preferences.Frequency
+		if err := tm.Tokens(account.ID, func(t *conveyearthgo.Token) error {
+			data.Tokens = append(data.Tokens, t)
+			return nil
+		}); err != nil {
+			log.Println(err)
+			data.Error = err.Error()
+		}
 		executeAccountTemplate(w, ts, data)
 	})
 }
@@ -60,14 +106,24 @@ func executeAccountTemplate(w http.ResponseWriter, ts *template.Template, data *
 }
 
 type AccountData struct {
-	Live                  bool
-	Error                 string
-	Account               *authgo.Account
-	Balance               int64
-	NotificationResponses bool
-	NotificationMentions  bool
-	NotificationGifts     bool
-	NotificationDigests   bool
-	Scheme                string
-	Domain                string
+	Live                     bool
+	Error                    string
+	Account                  *authgo.Account
+	Balance                  int64
+	Reversals                []*conveyearthgo.Reversal
+	NotificationResponses    bool
+	NotificationMentions     bool
+	NotificationGifts        bool
+	NotificationDigests      bool
+	NotificationYields       bool
+	NotificationReplies      bool
+	NotificationPublications bool
+	NotificationFrequency    string
+	Scheme                   string
+	Domain                   string
+	Tokens                   []*conveyearthgo.Token
+	Scopes                   []string
+	TokenName                string
+	TokenSecret              string
+	Unread                   int64
 }
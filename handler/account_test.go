@@ -7,12 +7,16 @@ import (
 	"aletheiaware.com/conveyearthgo/conveytest"
 	"aletheiaware.com/conveyearthgo/database"
 	"aletheiaware.com/conveyearthgo/handler"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"html/template"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAccount(t *testing.T) {
@@ -26,8 +30,9 @@ func TestAccount(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		tm := conveyearthgo.NewTokenManager(db)
 		mux := http.NewServeMux()
-		handler.AttachAccountHandler(mux, auth, am, nm, tmpl)
+		handler.AttachAccountHandler(mux, auth, am, nm, tm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/account", nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -45,8 +50,9 @@ func TestAccount(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		tm := conveyearthgo.NewTokenManager(db)
 		mux := http.NewServeMux()
-		handler.AttachAccountHandler(mux, auth, am, nm, tmpl)
+		handler.AttachAccountHandler(mux, auth, am, nm, tm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/account", nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -56,4 +62,121 @@ func TestAccount(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, "/sign-in?next=%2Faccount", u.String())
 	})
+	t.Run("Counts Unread Notifications", func(t *testing.T) {
+		tmpl, err := template.New("account.go.html").Parse(`{{.Error}}{{.Unread}}`)
+		assert.Nil(t, err)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		am := conveyearthgo.NewAccountManager(db)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		assert.Nil(t, nm.NotifyMention(acc, acc2, 1, "Test", 1))
+		tm := conveyearthgo.NewTokenManager(db)
+		mux := http.NewServeMux()
+		handler.AttachAccountHandler(mux, auth, am, nm, tm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/account", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", string(body))
+	})
+	t.Run("Creates And Revokes Tokens", func(t *testing.T) {
+		tmpl, err := template.New("account.go.html").Parse(`{{.Error}}{{.TokenSecret}}{{range .Tokens}}{{.ID}} {{.Name}} {{.Scopes}}{{end}}`)
+		assert.Nil(t, err)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		tm := conveyearthgo.NewTokenManager(db)
+		mux := http.NewServeMux()
+		handler.AttachAccountHandler(mux, auth, am, nm, tm, tmpl)
+		post := func(values url.Values) *http.Response {
+			request := httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(values.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request.AddCookie(auth.NewSignInSessionCookie(token))
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+			return response.Result()
+		}
+
+		result := post(url.Values{
+			"name":  {"Script"},
+			"scope": {"read", "gift"},
+		})
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		var tokens []*conveyearthgo.Token
+		assert.Nil(t, tm.Tokens(acc.ID, func(tk *conveyearthgo.Token) error {
+			tokens = append(tokens, tk)
+			return nil
+		}))
+		assert.Equal(t, 1, len(tokens))
+		assert.Equal(t, []string{"read", "gift"}, tokens[0].Scopes)
+		// The secret is shown once, followed by the list of tokens
+		secret := strings.TrimSuffix(string(body), fmt.Sprintf("%d Script [read gift]", tokens[0].ID))
+		assert.True(t, strings.HasPrefix(secret, conveyearthgo.TOKEN_PREFIX), string(body))
+		account, _, err := tm.Authenticate(secret)
+		assert.Nil(t, err)
+		assert.Equal(t, acc.ID, account.ID)
+
+		result = post(url.Values{
+			"name":  {"Admin"},
+			"scope": {"admin"},
+		})
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err = io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(body), conveyearthgo.ErrTokenScopeInvalid.Error()), string(body))
+
+		result = post(url.Values{
+			"revoke": {fmt.Sprintf("%d", tokens[0].ID)},
+		})
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		u, err := result.Location()
+		assert.Nil(t, err)
+		assert.Equal(t, "/account", u.String())
+		_, _, err = tm.Authenticate(secret)
+		assert.Equal(t, conveyearthgo.ErrTokenInvalid, err)
+	})
+	t.Run("Lists Reversals", func(t *testing.T) {
+		tmpl, err := template.New("account.go.html").Parse(`{{.Error}}{{.Balance}}{{range .Reversals}} {{.Reason}} {{.Amount}}{{end}}`)
+		assert.Nil(t, err)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		tm := conveyearthgo.NewTokenManager(db)
+		now := time.Now()
+		_, err = db.CreateReversal(acc.ID, 1, 2, 0, conveyearthgo.REVERSAL_CHARGE_REFUND, 100, now.Add(-time.Hour))
+		assert.Nil(t, err)
+		_, err = db.CreateReversal(acc.ID, 1, 2, 3, conveyearthgo.REVERSAL_GIFT_REVERSAL, -40, now)
+		assert.Nil(t, err)
+		mux := http.NewServeMux()
+		handler.AttachAccountHandler(mux, auth, am, nm, tm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/account", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		// Newest first
+		assert.Equal(t, "60 Gift Reversal -40 Charge Refund 100", string(body))
+	})
 }
@@ -0,0 +1,682 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/api"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	API_DEFAULT_LIMIT        = 8
+	API_MAXIMUM_LIMIT        = 100
+	MAXIMUM_API_REQUEST_SIZE = 64 << 10 // 64KB, enough for any request without attachments
+	MAXIMUM_API_UPLOAD_SIZE  = 48 << 20 // 48MB, enough for 32MB of base64 encoded attachments
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+var (
+	ErrAPIUnauthorized         = &api.Error{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "Unauthorized"}
+	ErrAPIScopeInsufficient    = &api.Error{Status: http.StatusForbidden, Code: "insufficient_scope", Message: "Token Not Granted Required Scope"}
+	ErrAPINotFound             = &api.Error{Status: http.StatusNotFound, Code: "not_found", Message: "Not Found"}
+	ErrAPIMethodNotAllowed     = &api.Error{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "Method Not Allowed"}
+	ErrAPIRequestInvalid       = &api.Error{Status: http.StatusBadRequest, Code: "invalid_request", Message: "Invalid Request"}
+	ErrAPIRequestTooLarge      = &api.Error{Status: http.StatusRequestEntityTooLarge, Code: "request_too_large", Message: "Request Too Large"}
+	ErrAPIMediaTypeUnsupported = &api.Error{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "Request Must Be application/json"}
+	ErrAPIInternal             = &api.Error{Status: http.StatusInternalServerError, Code: "internal_error", Message: "Internal Server Error"}
+)
+
+// apiErrors maps the errors returned by the managers onto the error objects returned by the API, any other error is reported as internal.
+var apiErrors = map[error]*api.Error{
+	conveyearthgo.ErrConversationNotFound:    newAPIError(http.StatusNotFound, "conversation_not_found", conveyearthgo.ErrConversationNotFound),
+	conveyearthgo.ErrMessageNotFound:         newAPIError(http.StatusNotFound, "message_not_found", conveyearthgo.ErrMessageNotFound),
+	conveyearthgo.ErrFileNotFound:            newAPIError(http.StatusNotFound, "file_not_found", conveyearthgo.ErrFileNotFound),
+	conveyearthgo.ErrGiftNotFound:            newAPIError(http.StatusNotFound, "gift_not_found", conveyearthgo.ErrGiftNotFound),
+	conveyearthgo.ErrTopicTooShort:           newAPIError(http.StatusBadRequest, "topic_too_short", conveyearthgo.ErrTopicTooShort),
+	conveyearthgo.ErrTopicTooLong:            newAPIError(http.StatusBadRequest, "topic_too_long", conveyearthgo.ErrTopicTooLong),
+	conveyearthgo.ErrContentTooShort:         newAPIError(http.StatusBadRequest, "content_too_short", conveyearthgo.ErrContentTooShort),
+	conveyearthgo.ErrMimeUnrecognized:        newAPIError(http.StatusBadRequest, "mime_unrecognized", conveyearthgo.ErrMimeUnrecognized),
+	conveyearthgo.ErrGiftAmountInvalid:       newAPIError(http.StatusBadRequest, "gift_amount_invalid", conveyearthgo.ErrGiftAmountInvalid),
+	conveyearthgo.ErrInsufficientBalance:     newAPIError(http.StatusPaymentRequired, "insufficient_balance", conveyearthgo.ErrInsufficientBalance),
+	conveyearthgo.ErrBalanceNegative:         newAPIError(http.StatusPaymentRequired, "balance_negative", conveyearthgo.ErrBalanceNegative),
+	conveyearthgo.ErrSelfGiftingNotPermitted: newAPIError(http.StatusForbidden, "self_gifting_not_permitted", conveyearthgo.ErrSelfGiftingNotPermitted),
+	conveyearthgo.ErrDeletionNotPermitted:    newAPIError(http.StatusForbidden, "deletion_not_permitted", conveyearthgo.ErrDeletionNotPermitted),
+}
+
+func AttachAPIHandlers(m *http.ServeMux, a authgo.Authenticator, tm conveyearthgo.TokenManager, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager) {
+	m.Handle("/api/v1/", handler.Log(handler.Compress(APINotFound())))
+	m.Handle("/api/v1/openapi.json", handler.Log(handler.Compress(APIDocument())))
+	m.Handle("/api/v1/conversations", handler.Log(handler.Compress(APIConversations(a, tm, am, cm, nm, wm))))
+	m.Handle("/api/v1/conversations/", handler.Log(handler.Compress(http.StripPrefix("/api/v1/conversations/", APIConversation(cm)))))
+	m.Handle("/api/v1/messages", handler.Log(handler.Compress(APIMessages(a, tm, am, cm, nm, wm))))
+	m.Handle("/api/v1/messages/", handler.Log(handler.Compress(http.StripPrefix("/api/v1/messages/", APIMessage(cm)))))
+	m.Handle("/api/v1/files/", handler.Log(handler.Compress(http.StripPrefix("/api/v1/files/", APIFile(cm)))))
+	m.Handle("/api/v1/gifts", handler.Log(handler.Compress(APIGifts(a, tm, am, cm, nm, wm))))
+	m.Handle("/api/v1/balance", handler.Log(handler.Compress(APIBalance(a, tm, am))))
+	m.Handle("/api/v1/notifications", handler.Log(handler.Compress(APINotifications(a, tm, nm))))
+	m.Handle("/api/v1/notifications/read", handler.Log(handler.Compress(APINotificationsRead(a, tm, nm))))
+}
+
+func APINotFound() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, ErrAPINotFound)
+	})
+}
+
+// APIDocument serves the OpenAPI description of the API.
+func APIDocument() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(openAPI); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// APIConversations lists the recent, or best, conversations and publishes new conversations.
+func APIConversations(a authgo.Authenticator, tm conveyearthgo.TokenManager, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			list := &api.ConversationList{
+				Conversations: []*api.Conversation{},
+			}
+			callback := func(c *conveyearthgo.Conversation) error {
+				list.Conversations = append(list.Conversations, apiConversation(c))
+				return nil
+			}
+			limit := apiLimit(r)
+			var err error
+			switch strings.TrimSpace(r.FormValue("sort")) {
+			case "best":
+				_, since := bestPeriod(r.FormValue("period"), time.Now())
+				err = cm.LookupBestConversations(callback, since, limit)
+			case "", "recent":
+				err = cm.LookupRecentConversations(callback, limit)
+			default:
+				err = ErrAPIRequestInvalid
+			}
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			writeAPI(w, http.StatusOK, list)
+		case "POST":
+			account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_PUBLISH)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			var request api.PublishRequest
+			if err := readAPI(r, &request, MAXIMUM_API_UPLOAD_SIZE); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			topic := strings.TrimSpace(request.Topic)
+			content := strings.ReplaceAll(strings.TrimSpace(request.Content), "\r\n", "\n")
+
+			// Check valid topic
+			if err := conveyearthgo.ValidateTopic(topic); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			bytes := []byte(content)
+
+			// Check valid content
+			if err := conveyearthgo.ValidateContent(bytes); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			// Check valid attachments
+			if err := validateAPIAttachments(request.Attachments); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			// Store content
+			hashes, mimes, sizes, err := addAPIContent(cm, bytes, request.Attachments)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			// Record conversation
+			conversation, message, files, err := publishConversation(am, cm, nm, wm, account, topic, content, hashes, mimes, sizes)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			c := apiConversation(conversation)
+			c.Messages = append(c.Messages, apiMessage(message, files))
+			writeAPI(w, http.StatusCreated, c)
+		default:
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+		}
+	})
+}
+
+// APIConversation returns the conversation with the ID in the path, and all its messages.
+func APIConversation(cm conveyearthgo.ContentManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		conversation, err := cm.LookupConversation(apiID(r))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		opening, err := cm.LookupOpeningMessage(conversation.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		conversation.Cost = opening.Cost
+		conversation.Yield = opening.Yield
+		var messages []*conveyearthgo.Message
+		if err := cm.LookupMessages(conversation.ID, func(m *conveyearthgo.Message) error {
+			messages = append(messages, m)
+			return nil
+		}); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		c := apiConversation(conversation)
+		for _, m := range messages {
+			files, err := apiFiles(cm, m.ID)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			c.Messages = append(c.Messages, apiMessage(m, files))
+		}
+		writeAPI(w, http.StatusOK, c)
+	})
+}
+
+// APIMessages replies to a message.
+func APIMessages(a authgo.Authenticator, tm conveyearthgo.TokenManager, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_REPLY)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		var request api.ReplyRequest
+		if err := readAPI(r, &request, MAXIMUM_API_UPLOAD_SIZE); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		conversation, parent, err := lookupAPIMessage(cm, request.Conversation, request.Parent)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		reply := strings.ReplaceAll(strings.TrimSpace(request.Content), "\r\n", "\n")
+
+		bytes := []byte(reply)
+
+		// Check valid reply
+		if err := conveyearthgo.ValidateContent(bytes); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		// Check valid attachments
+		if err := validateAPIAttachments(request.Attachments); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		// Store reply
+		hashes, mimes, sizes, err := addAPIContent(cm, bytes, request.Attachments)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		// Record message
+		response, files, err := replyToMessage(am, cm, nm, wm, account, conversation, parent, reply, hashes, mimes, sizes)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		writeAPI(w, http.StatusCreated, apiMessage(response, files))
+	})
+}
+
+// APIMessage returns the message with the ID in the path.
+func APIMessage(cm conveyearthgo.ContentManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		message, err := cm.LookupMessage(apiID(r))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		files, err := apiFiles(cm, message.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeAPI(w, http.StatusOK, apiMessage(message, files))
+	})
+}
+
+// APIFile returns the file with the ID in the path, the content itself is served from the file's URL.
+func APIFile(cm conveyearthgo.ContentManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		file, err := cm.LookupFile(apiID(r))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeAPI(w, http.StatusOK, apiFile(file))
+	})
+}
+
+// APIGifts lists the gifts in a conversation, optionally to a single message, and sends new gifts.
+func APIGifts(a authgo.Authenticator, tm conveyearthgo.TokenManager, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			conversation, err := cm.LookupConversation(netgo.ParseInt(strings.TrimSpace(r.FormValue("conversation"))))
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			list := &api.GiftList{
+				Gifts: []*api.Gift{},
+			}
+			if err := cm.LookupGifts(conversation.ID, netgo.ParseInt(strings.TrimSpace(r.FormValue("message"))), func(g *conveyearthgo.Gift) error {
+				list.Gifts = append(list.Gifts, apiGift(g))
+				return nil
+			}); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			writeAPI(w, http.StatusOK, list)
+		case "POST":
+			account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_GIFT)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			var request api.GiftRequest
+			if err := readAPI(r, &request, MAXIMUM_API_REQUEST_SIZE); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			conversation, message, err := lookupAPIMessage(cm, request.Conversation, request.Message)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			// Record gift
+			g, err := sendGift(am, cm, nm, wm, account, conversation, message, request.Amount)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+
+			writeAPI(w, http.StatusCreated, apiGift(g))
+		default:
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+		}
+	})
+}
+
+// APIBalance returns the balance of the current account.
+func APIBalance(a authgo.Authenticator, tm conveyearthgo.TokenManager, am conveyearthgo.AccountManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_READ)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		balance, err := am.AccountBalance(account.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeAPI(w, http.StatusOK, &api.Balance{
+			Username: account.Username,
+			Balance:  balance,
+		})
+	})
+}
+
+// APINotifications lists the most recent notifications of the current account.
+func APINotifications(a authgo.Authenticator, tm conveyearthgo.TokenManager, nm conveyearthgo.NotificationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_READ)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		unread, err := nm.UnreadNotifications(account.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		list := &api.NotificationList{
+			Notifications: []*api.Notification{},
+			Unread:        unread,
+		}
+		if err := nm.Notifications(account, apiLimit(r), func(n *conveyearthgo.Notification) error {
+			list.Notifications = append(list.Notifications, apiNotification(n))
+			return nil
+		}); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeAPI(w, http.StatusOK, list)
+	})
+}
+
+// APINotificationsRead marks a notification, or all notifications when the ID is zero, as read.
+func APINotificationsRead(a authgo.Authenticator, tm conveyearthgo.TokenManager, nm conveyearthgo.NotificationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeAPIError(w, ErrAPIMethodNotAllowed)
+			return
+		}
+		account, err := apiAccount(a, tm, w, r, conveyearthgo.TOKEN_SCOPE_WRITE)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		var request api.ReadRequest
+		if err := readAPI(r, &request, MAXIMUM_API_REQUEST_SIZE); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if request.ID == 0 {
+			err = nm.MarkAllNotificationsRead(account)
+		} else {
+			err = nm.MarkNotificationRead(account, request.ID)
+		}
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func newAPIError(status int, code string, err error) *api.Error {
+	return &api.Error{
+		Status:  status,
+		Code:    code,
+		Message: err.Error(),
+	}
+}
+
+func writeAPI(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// apiAccount returns the account identified by the bearer token, which must be granted the scope, or else by the session.
+func apiAccount(a authgo.Authenticator, tm conveyearthgo.TokenManager, w http.ResponseWriter, r *http.Request, scope string) (*authgo.Account, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret := strings.TrimPrefix(header, "Bearer ")
+		if secret == header {
+			return nil, ErrAPIUnauthorized
+		}
+		account, token, err := tm.Authenticate(strings.TrimSpace(secret))
+		if err != nil {
+			return nil, ErrAPIUnauthorized
+		}
+		if !token.HasScope(scope) {
+			return nil, ErrAPIScopeInsufficient
+		}
+		return account, nil
+	}
+	account := a.CurrentAccount(w, r)
+	if account == nil {
+		return nil, ErrAPIUnauthorized
+	}
+	return account, nil
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	e := apiError(err)
+	if e == ErrAPIInternal {
+		log.Println(err)
+	}
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeAPI(w, e.Status, &api.ErrorResponse{
+		Error: e,
+	})
+}
+
+// apiError returns the API error for the given error, which may wrap an error known to the managers, or else ErrAPIInternal.
+func apiError(err error) *api.Error {
+	var e *api.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for known, e := range apiErrors {
+		if errors.Is(err, known) {
+			return e
+		}
+	}
+	return ErrAPIInternal
+}
+
+// readAPI decodes the JSON body of the request, which must be declared as such so that it cannot be sent cross-site by a form, and be no larger than the limit.
+func readAPI(r *http.Request, v interface{}, limit int64) error {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		return ErrAPIMediaTypeUnsupported
+	}
+	if r.ContentLength > limit {
+		return ErrAPIRequestTooLarge
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, limit))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		log.Println(err)
+		return ErrAPIRequestInvalid
+	}
+	return nil
+}
+
+func apiID(r *http.Request) int64 {
+	return netgo.ParseInt(r.URL.Path)
+}
+
+func apiLimit(r *http.Request) int64 {
+	limit := netgo.ParseInt(strings.TrimSpace(r.FormValue("limit")))
+	if limit <= 0 {
+		return API_DEFAULT_LIMIT
+	}
+	if limit > API_MAXIMUM_LIMIT {
+		return API_MAXIMUM_LIMIT
+	}
+	return limit
+}
+
+// lookupAPIMessage returns the conversation and the message, which must belong to it.
+func lookupAPIMessage(cm conveyearthgo.ContentManager, conversation, message int64) (*conveyearthgo.Conversation, *conveyearthgo.Message, error) {
+	c, err := cm.LookupConversation(conversation)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := cm.LookupMessage(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.ConversationID != c.ID {
+		return nil, nil, conveyearthgo.ErrMessageNotFound
+	}
+	return c, m, nil
+}
+
+// validateAPIAttachments checks the attachments before anything is stored.
+func validateAPIAttachments(attachments []*api.Attachment) error {
+	if len(attachments) > api.MAXIMUM_ATTACHMENTS {
+		return api.ErrAttachmentsExceeded
+	}
+	for _, a := range attachments {
+		if a == nil {
+			return ErrAPIRequestInvalid
+		}
+		if err := conveyearthgo.ValidateMime(a.Mime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addAPIContent stores the text as markdown, followed by the attachments.
+func addAPIContent(cm conveyearthgo.ContentManager, text []byte, attachments []*api.Attachment) ([]string, []string, []int64, error) {
+	hash, size, err := cm.AddText(text)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hashes := []string{hash}
+	mimes := []string{conveyearthgo.MIME_TEXT_MARKDOWN}
+	sizes := []int64{size}
+	for _, a := range attachments {
+		hash, size, err := cm.AddFile(bytes.NewReader(a.Data))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		hashes = append(hashes, hash)
+		mimes = append(mimes, a.Mime)
+		sizes = append(sizes, size)
+	}
+	return hashes, mimes, sizes, nil
+}
+
+func apiFiles(cm conveyearthgo.ContentManager, message int64) ([]*conveyearthgo.File, error) {
+	var files []*conveyearthgo.File
+	if err := cm.LookupFiles(message, func(f *conveyearthgo.File) error {
+		files = append(files, f)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func apiUsername(account *authgo.Account) string {
+	if account == nil {
+		return ""
+	}
+	return account.Username
+}
+
+func apiConversation(c *conveyearthgo.Conversation) *api.Conversation {
+	return &api.Conversation{
+		ID:      c.ID,
+		Author:  apiUsername(c.Author),
+		Topic:   c.Topic,
+		Cost:    c.Cost,
+		Yield:   c.Yield,
+		Created: c.Created,
+	}
+}
+
+func apiMessage(m *conveyearthgo.Message, files []*conveyearthgo.File) *api.Message {
+	message := &api.Message{
+		ID:           m.ID,
+		Conversation: m.ConversationID,
+		Parent:       m.ParentID,
+		Author:       apiUsername(m.Author),
+		Cost:         m.Cost,
+		Yield:        m.Yield,
+		Files:        []*api.File{},
+		Created:      m.Created,
+	}
+	for _, f := range files {
+		message.Files = append(message.Files, apiFile(f))
+	}
+	return message
+}
+
+func apiFile(f *conveyearthgo.File) *api.File {
+	return &api.File{
+		ID:      f.ID,
+		Message: f.Message,
+		Hash:    f.Hash,
+		Mime:    f.Mime,
+		URL:     fmt.Sprintf("%s://%s/content/%s?mime=%s", conveyearthgo.Scheme(), conveyearthgo.Host(), f.Hash, url.QueryEscape(f.Mime)),
+		Created: f.Created,
+	}
+}
+
+func apiGift(g *conveyearthgo.Gift) *api.Gift {
+	return &api.Gift{
+		ID:           g.ID,
+		Conversation: g.ConversationID,
+		Message:      g.MessageID,
+		Author:       apiUsername(g.Author),
+		Amount:       g.Amount,
+		Created:      g.Created,
+	}
+}
+
+func apiNotification(n *conveyearthgo.Notification) *api.Notification {
+	return &api.Notification{
+		ID:           n.ID,
+		Kind:         n.Kind,
+		Actor:        n.Actor,
+		Conversation: n.ConversationID,
+		Message:      n.MessageID,
+		Topic:        n.Topic,
+		Amount:       n.Amount,
+		Read:         n.Read,
+		Created:      n.Created,
+	}
+}
@@ -0,0 +1,394 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/api"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	type fixture struct {
+		mux     *http.ServeMux
+		auth    authgo.Authenticator
+		am      conveyearthgo.AccountManager
+		cm      conveyearthgo.ContentManager
+		nm      conveyearthgo.NotificationManager
+		tm      conveyearthgo.TokenManager
+		account *authgo.Account
+		author  *authgo.Account
+		token   string
+	}
+	setup := func(t *testing.T) *fixture {
+		t.Helper()
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		author, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		am := conveyearthgo.NewAccountManager(db)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		tm := conveyearthgo.NewTokenManager(db)
+		mux := http.NewServeMux()
+		handler.AttachAPIHandlers(mux, auth, tm, am, cm, nm, wm)
+		return &fixture{
+			mux:     mux,
+			auth:    auth,
+			am:      am,
+			cm:      cm,
+			nm:      nm,
+			tm:      tm,
+			account: acc,
+			author:  author,
+			token:   token,
+		}
+	}
+	request := func(f *fixture, method, path, body string, signedIn bool) *http.Response {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		request := httptest.NewRequest(method, path, reader)
+		if body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		if signedIn {
+			request.AddCookie(f.auth.NewSignInSessionCookie(f.token))
+		}
+		response := httptest.NewRecorder()
+		f.mux.ServeHTTP(response, request)
+		return response.Result()
+	}
+	decode := func(t *testing.T, result *http.Response, v interface{}) {
+		t.Helper()
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(result.Body).Decode(v))
+	}
+	assertError := func(t *testing.T, result *http.Response, status int, code string) {
+		t.Helper()
+		assert.Equal(t, status, result.StatusCode)
+		e := &api.ErrorResponse{}
+		decode(t, result, e)
+		assert.Equal(t, status, e.Error.Status)
+		assert.Equal(t, code, e.Error.Code)
+		assert.NotEmpty(t, e.Error.Message)
+	}
+	t.Run("Unknown Path", func(t *testing.T) {
+		f := setup(t)
+		assertError(t, request(f, http.MethodGet, "/api/v1/foobar", "", false), http.StatusNotFound, "not_found")
+	})
+	t.Run("OpenAPI Document", func(t *testing.T) {
+		f := setup(t)
+		result := request(f, http.MethodGet, "/api/v1/openapi.json", "", false)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		document := &struct {
+			OpenAPI string                 `json:"openapi"`
+			Paths   map[string]interface{} `json:"paths"`
+		}{}
+		decode(t, result, document)
+		assert.True(t, strings.HasPrefix(document.OpenAPI, "3."))
+		for _, p := range []string{
+			"/conversations",
+			"/conversations/{id}",
+			"/messages",
+			"/messages/{id}",
+			"/files/{id}",
+			"/gifts",
+			"/balance",
+			"/notifications",
+			"/notifications/read",
+		} {
+			assert.Contains(t, document.Paths, p)
+		}
+	})
+	t.Run("Lists Recent And Best Conversations", func(t *testing.T) {
+		f := setup(t)
+		c, m, _ := conveytest.NewConversation(t, f.cm, f.author)
+		conveytest.NewReply(t, f.cm, f.account, c, m)
+		for _, path := range []string{
+			"/api/v1/conversations",
+			"/api/v1/conversations?sort=recent",
+			"/api/v1/conversations?sort=best&period=all",
+		} {
+			result := request(f, http.MethodGet, path, "", false)
+			assert.Equal(t, http.StatusOK, result.StatusCode, path)
+			list := &api.ConversationList{}
+			decode(t, result, list)
+			assert.Equal(t, 1, len(list.Conversations), path)
+			assert.Equal(t, c.ID, list.Conversations[0].ID)
+			assert.Equal(t, conveytest.TEST_TOPIC, list.Conversations[0].Topic)
+			assert.Equal(t, f.author.Username, list.Conversations[0].Author)
+		}
+		assertError(t, request(f, http.MethodGet, "/api/v1/conversations?sort=foobar", "", false), http.StatusBadRequest, "invalid_request")
+	})
+	t.Run("Gets Conversation, Message, And File", func(t *testing.T) {
+		f := setup(t)
+		c, m, files := conveytest.NewConversation(t, f.cm, f.author)
+		r, _ := conveytest.NewReply(t, f.cm, f.account, c, m)
+
+		result := request(f, http.MethodGet, fmt.Sprintf("/api/v1/conversations/%d", c.ID), "", false)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		conversation := &api.Conversation{}
+		decode(t, result, conversation)
+		assert.Equal(t, c.ID, conversation.ID)
+		assert.Equal(t, c.Cost, conversation.Cost)
+		assert.Equal(t, int64(1), conversation.Yield)
+		assert.Equal(t, 2, len(conversation.Messages))
+
+		result = request(f, http.MethodGet, fmt.Sprintf("/api/v1/messages/%d", r.ID), "", false)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		message := &api.Message{}
+		decode(t, result, message)
+		assert.Equal(t, r.ID, message.ID)
+		assert.Equal(t, c.ID, message.Conversation)
+		assert.Equal(t, m.ID, message.Parent)
+		assert.Equal(t, f.account.Username, message.Author)
+		assert.Equal(t, 1, len(message.Files))
+
+		result = request(f, http.MethodGet, fmt.Sprintf("/api/v1/files/%d", files[0].ID), "", false)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		file := &api.File{}
+		decode(t, result, file)
+		assert.Equal(t, files[0].Hash, file.Hash)
+		assert.Equal(t, conveyearthgo.MIME_TEXT_PLAIN, file.Mime)
+		assert.True(t, strings.HasSuffix(file.URL, "/content/"+files[0].Hash+"?mime=text%2Fplain"), file.URL)
+
+		assertError(t, request(f, http.MethodGet, "/api/v1/conversations/999", "", false), http.StatusNotFound, "conversation_not_found")
+		assertError(t, request(f, http.MethodGet, "/api/v1/messages/999", "", false), http.StatusNotFound, "message_not_found")
+		assertError(t, request(f, http.MethodGet, "/api/v1/files/999", "", false), http.StatusNotFound, "file_not_found")
+		assertError(t, request(f, http.MethodDelete, fmt.Sprintf("/api/v1/conversations/%d", c.ID), "", false), http.StatusMethodNotAllowed, "method_not_allowed")
+	})
+	t.Run("Publishes Conversation", func(t *testing.T) {
+		f := setup(t)
+		body := `{"topic":"FooBar","content":"Hello World!"}`
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", body, false), http.StatusUnauthorized, "unauthorized")
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", body, true), http.StatusPaymentRequired, "insufficient_balance")
+
+		conveytest.NewPurchase(t, f.am, f.account)
+
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", `{"topic":"","content":"Hello World!"}`, true), http.StatusBadRequest, "topic_too_short")
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", `{"topic":"FooBar","content":""}`, true), http.StatusBadRequest, "content_too_short")
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", `{"topic":`, true), http.StatusBadRequest, "invalid_request")
+
+		// Form submissions are rejected
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/conversations", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(f.auth.NewSignInSessionCookie(f.token))
+		response := httptest.NewRecorder()
+		f.mux.ServeHTTP(response, r)
+		assertError(t, response.Result(), http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+		result := request(f, http.MethodPost, "/api/v1/conversations", body, true)
+		assert.Equal(t, http.StatusCreated, result.StatusCode)
+		conversation := &api.Conversation{}
+		decode(t, result, conversation)
+		assert.NotZero(t, conversation.ID)
+		assert.Equal(t, "FooBar", conversation.Topic)
+		assert.Equal(t, authtest.TEST_USERNAME, conversation.Author)
+		assert.Equal(t, int64(len("Hello World!")), conversation.Cost)
+		assert.Equal(t, 1, len(conversation.Messages))
+		assert.Equal(t, conveyearthgo.MIME_TEXT_MARKDOWN, conversation.Messages[0].Files[0].Mime)
+
+		balance, err := f.am.AccountBalance(f.account.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(conveytest.TEST_PURCHASE_SIZE-len("Hello World!")), balance)
+	})
+	t.Run("Publishes Attachments", func(t *testing.T) {
+		f := setup(t)
+		conveytest.NewPurchase(t, f.am, f.account)
+		image := base64.StdEncoding.EncodeToString([]byte("PNG"))
+
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", `{"topic":"FooBar","content":"Hello World!","attachments":[{"mime":"model/stl","data":"`+image+`"}]}`, true), http.StatusBadRequest, "mime_unrecognized")
+		attachments := strings.Repeat(`{"mime":"image/png","data":"`+image+`"},`, api.MAXIMUM_ATTACHMENTS+1)
+		assertError(t, request(f, http.MethodPost, "/api/v1/conversations", `{"topic":"FooBar","content":"Hello World!","attachments":[`+strings.TrimSuffix(attachments, ",")+`]}`, true), http.StatusBadRequest, "too_many_attachments")
+
+		result := request(f, http.MethodPost, "/api/v1/conversations", `{"topic":"FooBar","content":"Hello World!","attachments":[{"mime":"image/png","data":"`+image+`"}]}`, true)
+		assert.Equal(t, http.StatusCreated, result.StatusCode)
+		conversation := &api.Conversation{}
+		decode(t, result, conversation)
+		assert.Equal(t, int64(len("Hello World!")+len("PNG")), conversation.Cost)
+		files := conversation.Messages[0].Files
+		assert.Equal(t, 2, len(files))
+		assert.Equal(t, conveyearthgo.MIME_TEXT_MARKDOWN, files[0].Mime)
+		assert.Equal(t, conveyearthgo.MIME_IMAGE_PNG, files[1].Mime)
+		file, err := f.cm.Open(files[1].Hash)
+		assert.Nil(t, err)
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		assert.Nil(t, err)
+		assert.Equal(t, "PNG", string(data))
+	})
+	t.Run("Replies To Message", func(t *testing.T) {
+		f := setup(t)
+		conveytest.NewPurchase(t, f.am, f.account)
+		assert.Nil(t, f.am.NewAward(f.author.ID, "Test", 1000))
+		c, m, _ := conveytest.NewConversation(t, f.cm, f.author)
+		_, other, _ := conveytest.NewConversation(t, f.cm, f.author)
+
+		assertError(t, request(f, http.MethodPost, "/api/v1/messages", fmt.Sprintf(`{"conversation":%d,"parent":%d,"content":"Hi!"}`, c.ID, other.ID), true), http.StatusNotFound, "message_not_found")
+
+		result := request(f, http.MethodPost, "/api/v1/messages", fmt.Sprintf(`{"conversation":%d,"parent":%d,"content":"Hi!"}`, c.ID, m.ID), true)
+		assert.Equal(t, http.StatusCreated, result.StatusCode)
+		message := &api.Message{}
+		decode(t, result, message)
+		assert.Equal(t, c.ID, message.Conversation)
+		assert.Equal(t, m.ID, message.Parent)
+		assert.Equal(t, int64(len("Hi!")), message.Cost)
+
+		// The parent's author is notified, as when replying with the form
+		unread, err := f.nm.UnreadNotifications(f.author.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), unread)
+	})
+	t.Run("Limits Request Size", func(t *testing.T) {
+		f := setup(t)
+		// Only publishing and replying accept attachments
+		body := fmt.Sprintf(`{"conversation":1,"message":1,"amount":1,"padding":"%s"}`, strings.Repeat("x", handler.MAXIMUM_API_REQUEST_SIZE))
+		assertError(t, request(f, http.MethodPost, "/api/v1/gifts", body, true), http.StatusRequestEntityTooLarge, "request_too_large")
+		body = fmt.Sprintf(`{"id":0,"padding":"%s"}`, strings.Repeat("x", handler.MAXIMUM_API_REQUEST_SIZE))
+		assertError(t, request(f, http.MethodPost, "/api/v1/notifications/read", body, true), http.StatusRequestEntityTooLarge, "request_too_large")
+	})
+	t.Run("Gifts To Message", func(t *testing.T) {
+		f := setup(t)
+		c, m, _ := conveytest.NewConversation(t, f.cm, f.author)
+		body := fmt.Sprintf(`{"conversation":%d,"message":%d,"amount":10}`, c.ID, m.ID)
+
+		assertError(t, request(f, http.MethodPost, "/api/v1/gifts", body, true), http.StatusPaymentRequired, "insufficient_balance")
+
+		conveytest.NewPurchase(t, f.am, f.account)
+
+		assertError(t, request(f, http.MethodPost, "/api/v1/gifts", fmt.Sprintf(`{"conversation":%d,"message":%d,"amount":0}`, c.ID, m.ID), true), http.StatusBadRequest, "gift_amount_invalid")
+
+		result := request(f, http.MethodPost, "/api/v1/gifts", body, true)
+		assert.Equal(t, http.StatusCreated, result.StatusCode)
+		gift := &api.Gift{}
+		decode(t, result, gift)
+		assert.Equal(t, int64(10), gift.Amount)
+		assert.Equal(t, authtest.TEST_USERNAME, gift.Author)
+
+		result = request(f, http.MethodGet, fmt.Sprintf("/api/v1/gifts?conversation=%d", c.ID), "", false)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		list := &api.GiftList{}
+		decode(t, result, list)
+		assert.Equal(t, 1, len(list.Gifts))
+		assert.Equal(t, gift.ID, list.Gifts[0].ID)
+
+		own, mine, _ := conveytest.NewConversation(t, f.cm, f.account)
+		assertError(t, request(f, http.MethodPost, "/api/v1/gifts", fmt.Sprintf(`{"conversation":%d,"message":%d,"amount":10}`, own.ID, mine.ID), true), http.StatusForbidden, "self_gifting_not_permitted")
+	})
+	t.Run("Returns Balance", func(t *testing.T) {
+		f := setup(t)
+		assertError(t, request(f, http.MethodGet, "/api/v1/balance", "", false), http.StatusUnauthorized, "unauthorized")
+		conveytest.NewPurchase(t, f.am, f.account)
+		result := request(f, http.MethodGet, "/api/v1/balance", "", true)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		balance := &api.Balance{}
+		decode(t, result, balance)
+		assert.Equal(t, authtest.TEST_USERNAME, balance.Username)
+		assert.Equal(t, int64(conveytest.TEST_PURCHASE_SIZE), balance.Balance)
+	})
+	t.Run("Lists And Reads Notifications", func(t *testing.T) {
+		f := setup(t)
+		c, m, _ := conveytest.NewConversation(t, f.cm, f.account)
+		r, _ := conveytest.NewReply(t, f.cm, f.author, c, m)
+		assert.Nil(t, f.nm.NotifyResponse(f.account, f.author, c.ID, c.Topic, r.ID))
+
+		assertError(t, request(f, http.MethodGet, "/api/v1/notifications", "", false), http.StatusUnauthorized, "unauthorized")
+
+		result := request(f, http.MethodGet, "/api/v1/notifications", "", true)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		list := &api.NotificationList{}
+		decode(t, result, list)
+		assert.Equal(t, int64(1), list.Unread)
+		assert.Equal(t, 1, len(list.Notifications))
+		assert.Equal(t, conveyearthgo.NOTIFICATION_RESPONSE, list.Notifications[0].Kind)
+		assert.Equal(t, c.ID, list.Notifications[0].Conversation)
+		assert.Equal(t, r.ID, list.Notifications[0].Message)
+		assert.False(t, list.Notifications[0].Read)
+
+		result = request(f, http.MethodPost, "/api/v1/notifications/read", `{"id":0}`, true)
+		assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+		unread, err := f.nm.UnreadNotifications(f.account.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), unread)
+	})
+	t.Run("Authenticates Bearer Tokens", func(t *testing.T) {
+		f := setup(t)
+		conveytest.NewPurchase(t, f.am, f.account)
+		_, read, err := f.tm.Create(f.account.ID, "Reader", []string{conveyearthgo.TOKEN_SCOPE_READ})
+		assert.Nil(t, err)
+		_, publish, err := f.tm.Create(f.account.ID, "Publisher", []string{conveyearthgo.TOKEN_SCOPE_PUBLISH})
+		assert.Nil(t, err)
+		bearer := func(method, path, body, secret string) *http.Response {
+			var reader io.Reader
+			if body != "" {
+				reader = strings.NewReader(body)
+			}
+			request := httptest.NewRequest(method, path, reader)
+			if body != "" {
+				request.Header.Set("Content-Type", "application/json")
+			}
+			request.Header.Set("Authorization", "Bearer "+secret)
+			response := httptest.NewRecorder()
+			f.mux.ServeHTTP(response, request)
+			return response.Result()
+		}
+		body := `{"topic":"FooBar","content":"Hello World!"}`
+
+		result := bearer(http.MethodGet, "/api/v1/balance", "", read)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		balance := &api.Balance{}
+		decode(t, result, balance)
+		assert.Equal(t, authtest.TEST_USERNAME, balance.Username)
+
+		assertError(t, bearer(http.MethodPost, "/api/v1/conversations", body, read), http.StatusForbidden, "insufficient_scope")
+		assertError(t, bearer(http.MethodGet, "/api/v1/balance", "", publish), http.StatusForbidden, "insufficient_scope")
+		assertError(t, bearer(http.MethodPost, "/api/v1/notifications/read", `{"id":0}`, read), http.StatusForbidden, "insufficient_scope")
+
+		result = bearer(http.MethodPost, "/api/v1/conversations", body, publish)
+		assert.Equal(t, http.StatusCreated, result.StatusCode)
+		conversation := &api.Conversation{}
+		decode(t, result, conversation)
+		assert.Equal(t, authtest.TEST_USERNAME, conversation.Author)
+
+		_, write, err := f.tm.Create(f.account.ID, "Writer", []string{conveyearthgo.TOKEN_SCOPE_WRITE})
+		assert.Nil(t, err)
+		result = bearer(http.MethodPost, "/api/v1/notifications/read", `{"id":0}`, write)
+		assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+		result = bearer(http.MethodGet, "/api/v1/balance", "", conveyearthgo.TOKEN_PREFIX+"foobar")
+		assert.Equal(t, "Bearer", result.Header.Get("WWW-Authenticate"))
+		assertError(t, result, http.StatusUnauthorized, "unauthorized")
+
+		// Other authorization schemes are not accepted
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/balance", nil)
+		r.Header.Set("Authorization", "Basic "+read)
+		response := httptest.NewRecorder()
+		f.mux.ServeHTTP(response, r)
+		assertError(t, response.Result(), http.StatusUnauthorized, "unauthorized")
+	})
+}
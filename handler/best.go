@@ -28,26 +28,7 @@ func Best(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *template.
 		}{
 			Live: netgo.IsLive(),
 		}
-		now := time.Now()
-		var since time.Time
-		period := r.FormValue("period")
-		switch period {
-		case "all":
-		case "year":
-			since = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
-		case "month":
-			since = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-		default:
-			period = "week"
-			fallthrough
-		case "week":
-			since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-			for since.Weekday() > time.Sunday {
-				since = since.AddDate(0, 0, -1)
-			}
-		case "day":
-			since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-		}
+		period, since := bestPeriod(r.FormValue("period"), time.Now())
 		data.Period = period
 		limit := count
 		if l := strings.TrimSpace(r.FormValue("limit")); l != "" {
@@ -78,3 +59,26 @@ func Best(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *template.
 		}
 	})
 }
+
+// bestPeriod returns the recognized period, defaulting to the current week, and the time it started.
+func bestPeriod(period string, now time.Time) (string, time.Time) {
+	var since time.Time
+	switch period {
+	case "all":
+	case "year":
+		since = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case "month":
+		since = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		period = "week"
+		fallthrough
+	case "week":
+		since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		for since.Weekday() > time.Sunday {
+			since = since.AddDate(0, 0, -1)
+		}
+	case "day":
+		since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return period, since
+}
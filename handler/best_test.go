@@ -32,7 +32,7 @@ func TestBest(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachBestHandler(mux, auth, cm, tmpl, 1, 1)
 		request := httptest.NewRequest(http.MethodGet, "/best", nil)
@@ -47,10 +47,11 @@ func TestBest(t *testing.T) {
 	})
 	t.Run("Returns 200 With One Conversation", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewReply(t, cm, acc, c, m)
@@ -68,10 +69,11 @@ func TestBest(t *testing.T) {
 	})
 	t.Run("Results Not Limited When Signed In", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
 
 		maximum := 2
@@ -105,10 +107,11 @@ func TestBest(t *testing.T) {
 	})
 	t.Run("Results Limited When Not Signed In", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 
 		maximum := 2
 		limit := maximum * 3
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func AttachCardHandler(m *http.ServeMux, cm conveyearthgo.ContentManager, cards conveyearthgo.CardManager, cache string) {
+	m.Handle("/conversation/card", handler.Log(handler.CacheControl(Card(cm, cards), cache)))
+}
+
+// Card serves a PNG image of the conversation for social platforms to show alongside shared links.
+func Card(cm conveyearthgo.ContentManager, cards conveyearthgo.CardManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := netgo.ParseInt(strings.TrimSpace(r.FormValue("id")))
+		c, err := cm.LookupConversation(id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		m, err := cm.LookupOpeningMessage(c.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		c.Yield = m.Yield
+		name, err := cards.Card(c)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		file, err := cards.Open(name)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content, ok := file.(io.ReadSeeker)
+		if !ok {
+			log.Println("Card Not Seekable:", name)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"`+name+`"`)
+		// Handles If-None-Match and If-Modified-Since
+		http.ServeContent(w, r, "", info.ModTime(), content)
+	})
+}
@@ -0,0 +1,90 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"aletheiaware.com/conveyearthgo/render"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCard(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	topicFont, err := render.LoadFont("../cmd/server/assets/html/static/NotoSerif-ExtraBold.ttf")
+	assert.NoError(t, err)
+	bylineFont, err := render.LoadFont("../cmd/server/assets/html/static/NotoSerif-Regular.ttf")
+	assert.NoError(t, err)
+	card := &render.Card{
+		Width:       conveyearthgo.CARD_WIDTH,
+		Height:      conveyearthgo.CARD_HEIGHT,
+		Margin:      60,
+		Background:  color.White,
+		TopicFont:   topicFont,
+		TopicSize:   64,
+		TopicColor:  color.Black,
+		TopicLines:  4,
+		BylineFont:  bylineFont,
+		BylineSize:  32,
+		BylineColor: color.Black,
+	}
+	t.Run("Returns 404 When Conversation Does Not Exist", func(t *testing.T) {
+		db := database.NewInMemory()
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		cards, err := os.MkdirTemp("", "test")
+		assert.Nil(t, err)
+		defer os.RemoveAll(cards)
+		mux := http.NewServeMux()
+		handler.AttachCardHandler(mux, cm, conveyearthgo.NewCardManager(filesystem.NewOnDisk(cards), card), "")
+		request := httptest.NewRequest(http.MethodGet, "/conversation/card?id=1", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("Returns 200 And PNG When Conversation Exists", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		cards, err := os.MkdirTemp("", "test")
+		assert.Nil(t, err)
+		defer os.RemoveAll(cards)
+		mux := http.NewServeMux()
+		handler.AttachCardHandler(mux, cm, conveyearthgo.NewCardManager(filesystem.NewOnDisk(cards), card), "")
+		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/card?id=%d", c.ID), nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "image/png", result.Header.Get("Content-Type"))
+		etag := result.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+		img, err := png.Decode(result.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, conveyearthgo.CARD_WIDTH, img.Bounds().Dx())
+		assert.Equal(t, conveyearthgo.CARD_HEIGHT, img.Bounds().Dy())
+
+		request = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/card?id=%d", c.ID), nil)
+		request.Header.Set("If-None-Match", etag)
+		response = httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result = response.Result()
+		assert.Equal(t, http.StatusNotModified, result.StatusCode)
+	})
+}
@@ -9,8 +9,8 @@ import (
 	"fmt"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/checkout/session"
-	"github.com/stripe/stripe-go/v72/price"
-	"github.com/stripe/stripe-go/v72/product"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
 	"html/template"
 	"log"
 	"net/http"
@@ -19,11 +19,11 @@ import (
 	"strings"
 )
 
-func AttachCoinBuyHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, ts *template.Template) {
-	m.Handle("/coin-buy", handler.Log(handler.Compress(CoinBuy(a, am, ts))))
+func AttachCoinBuyHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, bc conveyearthgo.BundleCatalog, ts *template.Template) {
+	m.Handle("/coin-buy", handler.Log(handler.Compress(CoinBuy(a, am, bc, ts))))
 }
 
-func CoinBuy(a authgo.Authenticator, am conveyearthgo.AccountManager, ts *template.Template) http.Handler {
+func CoinBuy(a authgo.Authenticator, am conveyearthgo.AccountManager, bc conveyearthgo.BundleCatalog, ts *template.Template) http.Handler {
 	scheme := conveyearthgo.Scheme()
 	domain := conveyearthgo.Host()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,48 +36,25 @@ func CoinBuy(a authgo.Authenticator, am conveyearthgo.AccountManager, ts *templa
 			Live:    netgo.IsLive(),
 			Account: account,
 		}
-		bundles := make(map[string]*BundleData)
-		products := product.List(&stripe.ProductListParams{})
-		for products.Next() {
-			product := products.Product()
-			d, ok := product.Metadata["domain"]
-			if !ok || !strings.Contains(d, domain) {
-				continue
-			}
-			if !product.Active {
-				continue
-			}
-			prices := price.List(&stripe.PriceListParams{
-				Product: stripe.String(product.ID),
-			})
-			for prices.Next() {
-				price := prices.Price()
-				if !price.Active {
-					continue
-				}
-				size := int64(1)
-				s, ok := price.Metadata["bundle_size"]
-				if ok {
-					if i, err := strconv.ParseInt(s, 10, 64); err != nil {
-						log.Println(err)
-					} else {
-						size = int64(i)
-					}
-				}
-				b := &BundleData{
-					Name:      price.Nickname,
-					PriceID:   price.ID,
-					ProductID: product.ID,
-					Size:      size,
-				}
-				b.Price = conveyearthgo.FormatStripeAmount(price.UnitAmountDecimal, price.Currency)
-				bundles[price.ID] = b
-				data.Bundles = append(data.Bundles, b)
+		bundles := bc.Bundles()
+		currencies := make(map[string]bool)
+		for _, b := range bundles {
+			currencies[b.Currency] = true
+		}
+		for c := range currencies {
+			data.Currencies = append(data.Currencies, c)
+		}
+		sort.Strings(data.Currencies)
+		data.Currency = PreferredCurrency(r, data.Currencies)
+		local := LocalCurrency(r)
+		for _, b := range bundles {
+			if b.Currency == data.Currency {
+				data.Bundles = append(data.Bundles, &BundleData{
+					Bundle: b,
+					Price:  conveyearthgo.FormatLocalAmount(b.Amount, b.Currency, local),
+				})
 			}
 		}
-		sort.Slice(data.Bundles, func(i, j int) bool {
-			return data.Bundles[i].Size < data.Bundles[j].Size
-		})
 		switch r.Method {
 		case "GET":
 			executeCoinBuyTemplate(w, ts, data)
@@ -85,9 +62,8 @@ func CoinBuy(a authgo.Authenticator, am conveyearthgo.AccountManager, ts *templa
 			id := strings.TrimSpace(r.FormValue("bundle"))
 			data.Bundle = id
 
-			bundle, ok := bundles[id]
-			if !ok {
-				err := conveyearthgo.ErrBundleUnrecognized
+			bundle, err := bc.Bundle(id)
+			if err != nil {
 				log.Println(err)
 				data.Error = err.Error()
 				executeCoinBuyTemplate(w, ts, data)
@@ -124,6 +100,60 @@ func CoinBuy(a authgo.Authenticator, am conveyearthgo.AccountManager, ts *templa
 	})
 }
 
+// PreferredCurrency chooses from the available currencies the one requested by the currency parameter, or else the one used in the region of the buyer's most preferred language.
+func PreferredCurrency(r *http.Request, available []string) string {
+	if len(available) == 0 {
+		return ""
+	}
+	has := func(code string) bool {
+		for _, a := range available {
+			if a == code {
+				return true
+			}
+		}
+		return false
+	}
+	if c := strings.ToLower(strings.TrimSpace(r.FormValue("currency"))); has(c) {
+		return c
+	}
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, t := range tags {
+		region, _ := t.Region()
+		unit, ok := currency.FromRegion(region)
+		if !ok {
+			continue
+		}
+		if c := strings.ToLower(unit.String()); has(c) {
+			return c
+		}
+	}
+	if c := string(stripe.CurrencyUSD); has(c) {
+		return c
+	}
+	return available[0]
+}
+
+// LocalCurrency returns the code of the currency used in the region of the buyer's most preferred language, or the empty string if it is unknown.
+func LocalCurrency(r *http.Request) string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, t := range tags {
+		region, confidence := t.Region()
+		if confidence == language.No {
+			continue
+		}
+		if unit, ok := currency.FromRegion(region); ok {
+			return strings.ToLower(unit.String())
+		}
+	}
+	return ""
+}
+
 func executeCoinBuyTemplate(w http.ResponseWriter, ts *template.Template, data *CoinBuyData) {
 	if err := ts.ExecuteTemplate(w, "coin-buy.go.html", data); err != nil {
 		log.Println(err)
@@ -131,17 +161,16 @@ func executeCoinBuyTemplate(w http.ResponseWriter, ts *template.Template, data *
 }
 
 type CoinBuyData struct {
-	Live    bool
-	Error   string
-	Account *authgo.Account
-	Bundles []*BundleData
-	Bundle  string
+	Live       bool
+	Error      string
+	Account    *authgo.Account
+	Bundles    []*BundleData
+	Bundle     string
+	Currency   string
+	Currencies []string
 }
 
 type BundleData struct {
-	Name      string
-	ProductID string
-	PriceID   string
-	Price     string
-	Size      int64
+	*conveyearthgo.Bundle
+	Price string
 }
@@ -0,0 +1,163 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCoinBuy(t *testing.T) {
+	tmpl, err := template.New("coin-buy.go.html").Parse(`{{.Error}}{{.Currency}}{{range .Bundles}} {{.PriceID}}={{.Price}}{{end}}`)
+	assert.Nil(t, err)
+	bc := conveyearthgo.NewStaticBundleCatalog(&conveyearthgo.Bundle{
+		PriceID:  "price_usd",
+		Currency: "usd",
+		Amount:   100,
+		Size:     1000,
+	}, &conveyearthgo.Bundle{
+		PriceID:  "price_jpy",
+		Currency: "jpy",
+		Amount:   150,
+		Size:     1000,
+	})
+	t.Run("Redirects When Not Signed In", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		am := conveyearthgo.NewAccountManager(db)
+		mux := http.NewServeMux()
+		handler.AttachCoinBuyHandler(mux, auth, am, bc, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/coin-buy", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+	})
+	for name, tt := range map[string]struct {
+		target   string
+		language string
+		want     string
+	}{
+		"Default Currency": {
+			target: "/coin-buy",
+			want:   "usd price_usd=1 USD",
+		},
+		"Local Symbol": {
+			target:   "/coin-buy",
+			language: "en-US",
+			want:     "usd price_usd=$1",
+		},
+		"Language Currency": {
+			target:   "/coin-buy",
+			language: "ja-JP",
+			want:     "jpy price_jpy=¥150",
+		},
+		"Requested Currency": {
+			target:   "/coin-buy?currency=jpy",
+			language: "en-US",
+			want:     "jpy price_jpy=150 JPY",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			ev := authtest.NewEmailVerifier()
+			auth := authgo.NewAuthenticator(db, ev)
+			authtest.NewTestAccount(t, auth)
+			token, _ := authtest.SignIn(t, auth)
+			am := conveyearthgo.NewAccountManager(db)
+			mux := http.NewServeMux()
+			handler.AttachCoinBuyHandler(mux, auth, am, bc, tmpl)
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.language != "" {
+				request.Header.Set("Accept-Language", tt.language)
+			}
+			request.AddCookie(auth.NewSignInSessionCookie(token))
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+			result := response.Result()
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+	t.Run("Unrecognized Bundle", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		mux := http.NewServeMux()
+		handler.AttachCoinBuyHandler(mux, auth, am, bc, tmpl)
+		values := url.Values{}
+		values.Add("bundle", "price_unknown")
+		request := httptest.NewRequest(http.MethodPost, "/coin-buy", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveyearthgo.ErrBundleUnrecognized.Error()+"usd price_usd=1 USD", string(body))
+	})
+}
+
+func TestPreferredCurrency(t *testing.T) {
+	available := []string{"eur", "gbp", "jpy", "usd"}
+	for name, tt := range map[string]struct {
+		target    string
+		language  string
+		available []string
+		want      string
+	}{
+		"Parameter":             {"/coin-buy?currency=JPY", "en-GB", available, "jpy"},
+		"Unavailable Parameter": {"/coin-buy?currency=krw", "en-GB", available, "gbp"},
+		"Language Region":       {"/coin-buy", "ja-JP,en;q=0.5", available, "jpy"},
+		"Second Language":       {"/coin-buy", "ko-KR,de-DE;q=0.8", available, "eur"},
+		"Default":               {"/coin-buy", "", available, "usd"},
+		"First Available":       {"/coin-buy", "", []string{"eur", "gbp"}, "eur"},
+		"None Available":        {"/coin-buy", "en-US", nil, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.language != "" {
+				request.Header.Set("Accept-Language", tt.language)
+			}
+			assert.Equal(t, tt.want, handler.PreferredCurrency(request, tt.available))
+		})
+	}
+}
+
+func TestLocalCurrency(t *testing.T) {
+	for name, tt := range map[string]struct {
+		language string
+		want     string
+	}{
+		"Region":       {"es-AR", "ars"},
+		"First Region": {"es-MX,en-US;q=0.5", "mxn"},
+		"Inferred":     {"ja", "jpy"},
+		"Unknown":      {"", ""},
+		"Unparseable":  {"!!", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/coin-buy", nil)
+			if tt.language != "" {
+				request.Header.Set("Accept-Language", tt.language)
+			}
+			assert.Equal(t, tt.want, handler.LocalCurrency(request))
+		})
+	}
+}
@@ -23,7 +23,7 @@ func TestContent(t *testing.T) {
 	defer os.RemoveAll(dir)
 	t.Run("Returns 404 For Empty Hash", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachContentHandler(mux, cm, "")
 		request := httptest.NewRequest(http.MethodGet, "/content", nil)
@@ -45,7 +45,7 @@ func TestContent(t *testing.T) {
 	})
 	t.Run("Returns 404 When Content Does Not Exist", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachContentHandler(mux, cm, "")
 		request := httptest.NewRequest(http.MethodGet, "/content/foobar", nil)
@@ -59,7 +59,7 @@ func TestContent(t *testing.T) {
 	})
 	t.Run("Returns 200 When Content Exists", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		contents := []byte("this is a test")
 		hash, size, err := cm.AddText(contents)
 		assert.Nil(t, err)
@@ -78,7 +78,7 @@ func TestContent(t *testing.T) {
 	})
 	t.Run("Content-Type set by URL Query", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		contents := []byte("this is a test")
 		hash, size, err := cm.AddText(contents)
 		assert.Nil(t, err)
@@ -6,20 +6,31 @@ import (
 	"aletheiaware.com/netgo"
 	"aletheiaware.com/netgo/handler"
 	"fmt"
+	"html"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func AttachConversationHandler(m *http.ServeMux, a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *template.Template) {
-	m.Handle("/conversation", handler.Log(handler.Compress(Conversation(a, cm, ts))))
+// Social platforms truncate longer descriptions
+const MAXIMUM_EXCERPT_LENGTH = 200
+
+var (
+	blocks = regexp.MustCompile(`</?(blockquote|br|div|h[1-6]|hr|li|p|pre|td|th|tr)\b[^>]*>`)
+	tags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+func AttachConversationHandler(m *http.ServeMux, a authgo.Authenticator, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) {
+	m.Handle("/conversation", handler.Log(handler.Compress(Conversation(a, cm, nm, ts))))
 }
 
-func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *template.Template) http.Handler {
+func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var id int64
 		if c := strings.TrimSpace(r.FormValue("id")); c != "" {
@@ -55,13 +66,20 @@ func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *t
 		}
 		data := struct {
 			MessageData
-			Live  bool
-			Topic string
-			Sort  string
+			Live            bool
+			Topic           string
+			Sort            string
+			Following       bool
+			FollowingAuthor bool
+			Description     string
+			Image           string
+			Posting         *SchemaDiscussionForumPosting
+			Unread          int64
 		}{
 			Live: netgo.IsLive(),
 		}
 		data.Account = a.CurrentAccount(w, r)
+		data.Unread = unreadNotifications(nm, data.Account)
 		data.ConversationID = id
 		data.Sort = strings.TrimSpace(r.FormValue("sort"))
 		c, err := cm.LookupConversation(id)
@@ -73,6 +91,19 @@ func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *t
 		data.Topic = c.Topic
 		data.Created = c.Created
 
+		if data.Account != nil {
+			following, err := nm.Following(data.Account, conveyearthgo.FOLLOW_CONVERSATION, c.ID)
+			if err != nil {
+				log.Println(err)
+			}
+			data.Following = following
+			following, err = nm.Following(data.Account, conveyearthgo.FOLLOW_AUTHOR, c.Author.ID)
+			if err != nil {
+				log.Println(err)
+			}
+			data.FollowingAuthor = following
+		}
+
 		scheme := conveyearthgo.Scheme()
 		host := conveyearthgo.Host()
 		data.ShareTitle = c.Topic
@@ -133,18 +164,53 @@ func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *t
 			return
 		}
 		// Set Content
+		var text template.HTML
 		if err := cm.LookupFiles(data.MessageID, func(f *conveyearthgo.File) error {
 			c, err := cm.ToHTML(f.Hash, f.Mime)
 			if err != nil {
 				return err
 			}
 			data.Content += c
+			switch f.Mime {
+			case conveyearthgo.MIME_TEXT_PLAIN,
+				conveyearthgo.MIME_TEXT_MARKDOWN:
+				text += c
+			case conveyearthgo.MIME_IMAGE_GIF,
+				conveyearthgo.MIME_IMAGE_JPG,
+				conveyearthgo.MIME_IMAGE_JPEG,
+				conveyearthgo.MIME_IMAGE_PNG,
+				conveyearthgo.MIME_IMAGE_WEBP:
+				// SVG is not supported as a preview image
+				if data.Image == "" {
+					data.Image = fmt.Sprintf("%s://%s/content/%s?mime=%s", scheme, host, f.Hash, url.QueryEscape(f.Mime))
+				}
+			}
 			return nil
 		}); err != nil {
 			log.Println(err)
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
 		}
+		if data.Image == "" {
+			data.Image = fmt.Sprintf("%s://%s/conversation/card?id=%d", scheme, host, id)
+		}
+		data.Description = excerpt(text, MAXIMUM_EXCERPT_LENGTH)
+		data.Posting = &SchemaDiscussionForumPosting{
+			Context:       "https://schema.org",
+			Type:          "DiscussionForumPosting",
+			Headline:      data.Topic,
+			Text:          data.Description,
+			URL:           data.ShareURL,
+			Image:         data.Image,
+			DatePublished: data.Created.UTC().Format(time.RFC3339),
+			CommentCount:  len(messages),
+		}
+		if data.Author != nil {
+			data.Posting.Author = &SchemaPerson{
+				Type: "Person",
+				Name: data.Author.Username,
+			}
+		}
 		// Set Content and Replies
 		for _, m := range messages {
 			if err := cm.LookupFiles(m.MessageID, func(f *conveyearthgo.File) error {
@@ -199,3 +265,36 @@ func Conversation(a authgo.Authenticator, cm conveyearthgo.ContentManager, ts *t
 		}
 	})
 }
+
+// excerpt returns the text of the given content, truncated at a word boundary to at most length characters.
+func excerpt(content template.HTML, length int) string {
+	text := blocks.ReplaceAllString(string(content), " ")
+	text = html.UnescapeString(tags.ReplaceAllString(text, ""))
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	text = string(runes[:length-1])
+	if i := strings.LastIndex(text, " "); i > 0 {
+		text = text[:i]
+	}
+	return text + "…"
+}
+
+type SchemaDiscussionForumPosting struct {
+	Context       string        `json:"@context"`
+	Type          string        `json:"@type"`
+	Headline      string        `json:"headline"`
+	Text          string        `json:"text,omitempty"`
+	URL           string        `json:"url"`
+	Image         string        `json:"image,omitempty"`
+	DatePublished string        `json:"datePublished"`
+	Author        *SchemaPerson `json:"author,omitempty"`
+	CommentCount  int           `json:"commentCount"`
+}
+
+type SchemaPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
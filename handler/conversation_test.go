@@ -8,12 +8,14 @@ import (
 	"aletheiaware.com/conveyearthgo/database"
 	"aletheiaware.com/conveyearthgo/filesystem"
 	"aletheiaware.com/conveyearthgo/handler"
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"html/template"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"testing"
@@ -31,10 +33,11 @@ func TestConversation(t *testing.T) {
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, _, _ := conveytest.NewConversation(t, cm, acc)
 		mux := http.NewServeMux()
-		handler.AttachConversationHandler(mux, auth, cm, tmpl)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation?id=%d", c.ID), nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -50,10 +53,11 @@ func TestConversation(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, _, _ := conveytest.NewConversation(t, cm, acc)
 		mux := http.NewServeMux()
-		handler.AttachConversationHandler(mux, auth, cm, tmpl)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation?id=%d", c.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -70,9 +74,10 @@ func TestConversation(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
-		handler.AttachConversationHandler(mux, auth, cm, tmpl)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/conversation", nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -88,12 +93,14 @@ func TestConversation(t *testing.T) {
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewReply(t, cm, acc, c, m)
 		mux := http.NewServeMux()
-		handler.AttachConversationHandler(mux, auth, cm, tmpl)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation?id=%d", c.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -109,12 +116,14 @@ func TestConversation(t *testing.T) {
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewGift(t, cm, acc, c, m)
 		mux := http.NewServeMux()
-		handler.AttachConversationHandler(mux, auth, cm, tmpl)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation?id=%d", c.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -125,4 +134,37 @@ func TestConversation(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, authtest.TEST_USERNAME+conveytest.TEST_TOPIC+authtest.TEST_USERNAME+strconv.FormatInt(c.Cost, 10)+"0"+`<p class="ucc">`+conveytest.TEST_CONTENT+`</p>100`, string(body))
 	})
+	t.Run("Conversation Metadata", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		text, textSize, err := cm.AddText([]byte("# Title\n\nHello **World** & friends!"))
+		assert.Nil(t, err)
+		image, imageSize, err := cm.AddFile(bytes.NewReader([]byte("PNG")))
+		assert.Nil(t, err)
+		c, m, _, err := cm.NewConversation(acc, conveytest.TEST_TOPIC, []string{text, image}, []string{conveyearthgo.MIME_TEXT_MARKDOWN, conveyearthgo.MIME_IMAGE_PNG}, []int64{textSize, imageSize})
+		assert.Nil(t, err)
+		conveytest.NewReply(t, cm, acc, c, m)
+		tmpl, err := template.New("conversation.go.html").Parse(`{{.Description}}|{{.Image}}|{{.Posting.Headline}}|{{.Posting.URL}}|{{.Posting.Author.Name}}|{{.Posting.CommentCount}}`)
+		assert.Nil(t, err)
+		mux := http.NewServeMux()
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		handler.AttachConversationHandler(mux, auth, cm, nm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation?id=%d", c.ID), nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+		assert.Equal(t, template.HTMLEscapeString("Title Hello World & friends!")+"|"+
+			template.HTMLEscapeString(base+"/content/"+image+"?mime="+url.QueryEscape(conveyearthgo.MIME_IMAGE_PNG))+"|"+
+			conveytest.TEST_TOPIC+"|"+
+			template.HTMLEscapeString(fmt.Sprintf("%s/conversation?id=%d", base, c.ID))+"|"+
+			authtest.TEST_USERNAME+"|1", string(body))
+	})
 }
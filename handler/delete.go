@@ -12,11 +12,11 @@ import (
 	"net/http"
 )
 
-func AttachDeleteHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, ts *template.Template) {
-	m.Handle("/delete", handler.Log(handler.Compress(Delete(a, am, cm, ts))))
+func AttachDeleteHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) {
+	m.Handle("/delete", handler.Log(handler.Compress(Delete(a, am, cm, nm, wm, ts))))
 }
 
-func Delete(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, ts *template.Template) http.Handler {
+func Delete(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		account := a.CurrentAccount(w, r)
 		if account == nil {
@@ -26,6 +26,7 @@ func Delete(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyea
 		data := &DeleteData{
 			Live:    netgo.IsLive(),
 			Account: account,
+			Unread:  unreadNotifications(nm, account),
 		}
 		switch r.Method {
 		case "GET":
@@ -51,11 +52,17 @@ func Delete(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyea
 				return
 			}
 
-			var err error
+			var (
+				reversals []*conveyearthgo.Reversal
+				link      int64
+				err       error
+			)
 			if message != 0 {
-				err = cm.DeleteMessage(account, data.Message)
+				reversals, err = cm.DeleteMessage(account, data.Message)
+				link = data.Message.ParentID
 			} else {
-				err = cm.DeleteGift(account, data.Gift)
+				reversals, err = cm.DeleteGift(account, data.Gift)
+				link = data.Gift.MessageID
 			}
 			if err != nil {
 				log.Println(err)
@@ -64,6 +71,32 @@ func Delete(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyea
 				return
 			}
 
+			for _, reversal := range reversals {
+				if reversal.Account.ID == account.ID {
+					// Don't notify the user of their own deletion
+					continue
+				}
+				if err := nm.NotifyReversal(reversal.Account, reversal.Reason, conversation, data.Conversation.Topic, link, reversal.Amount); err != nil {
+					log.Println(err)
+				}
+			}
+
+			// Send Webhook Events
+			users := []int64{account.ID}
+			for _, reversal := range reversals {
+				users = append(users, reversal.Account.ID)
+			}
+			if err := wm.Emit(&conveyearthgo.WebhookEvent{
+				Kind:         conveyearthgo.WEBHOOK_DELETION,
+				Actor:        account.Username,
+				Conversation: conversation,
+				Message:      message,
+				Gift:         gift,
+				Topic:        data.Conversation.Topic,
+			}, users...); err != nil {
+				log.Println(err)
+			}
+
 			if data.Message != nil {
 				if data.Message.ParentID == 0 {
 					// Entire conversation was deleted
@@ -129,4 +162,5 @@ type DeleteData struct {
 	Message      *conveyearthgo.Message
 	Content      template.HTML
 	Gift         *conveyearthgo.Gift
+	Unread       int64
 }
@@ -16,6 +16,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDelete(t *testing.T) {
@@ -32,10 +33,12 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -53,11 +56,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		g := conveytest.NewGift(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d&gift=%d", c.ID, g.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -74,10 +80,12 @@ func TestDelete(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID), nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -94,9 +102,11 @@ func TestDelete(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, "/delete?conversation=0&message=0", nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -125,10 +135,12 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID+1), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -157,11 +169,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		g := conveytest.NewGift(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d&gift=%d", c.ID, g.ID+1), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -190,10 +205,12 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/delete?conversation=%d", c.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -211,10 +228,12 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -232,11 +251,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		r, _ := conveytest.NewReply(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, r.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -255,11 +277,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewReply(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -277,11 +302,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewGift(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -299,11 +327,14 @@ func TestDelete(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		g := conveytest.NewGift(t, cm, acc, c, m)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachDeleteHandler(mux, auth, am, cm, tmpl)
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&gift=%d", c.ID, g.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -314,5 +345,37 @@ func TestDelete(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, fmt.Sprintf("/conversation?id=%d#message%d", c.ID, m.ID), u.String())
 	})
+	t.Run("Gift Notifies Recipient Of Reversal", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		am := conveyearthgo.NewAccountManager(db)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		c, m, _ := conveytest.NewConversation(t, cm, acc2)
+		g := conveytest.NewGift(t, cm, acc, c, m)
+		ns := conveytest.NewNotificationSender()
+		nm := conveyearthgo.NewNotificationManager(db, ns)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		mux := http.NewServeMux()
+		handler.AttachDeleteHandler(mux, auth, am, cm, nm, wm, tmpl)
+		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/delete?conversation=%d&gift=%d", c.ID, g.ID), nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		// Only the recipient is notified, the gifter made the deletion
+		assert.Equal(t, 1, len(ns.Reversals))
+		reversal := ns.Reversals[0]
+		assert.Equal(t, acc2.ID, reversal.Account.ID)
+		assert.Equal(t, conveyearthgo.REVERSAL_GIFT_REVERSAL, reversal.Reason)
+		assert.Equal(t, c.ID, reversal.ConversationID)
+		assert.Equal(t, m.ID, reversal.MessageID)
+		assert.Equal(t, -g.Amount, reversal.Amount)
+	})
 	// TODO Gift Not Author
 }
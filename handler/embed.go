@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Embeds may be framed by any site
+	EMBED_FRAME_ANCESTORS = "frame-ancestors *"
+	OEMBED_WIDTH          = 600
+	OEMBED_HEIGHT         = 400
+)
+
+func AttachEmbedHandlers(m *http.ServeMux, cm conveyearthgo.ContentManager, ts *template.Template, cache string) {
+	m.Handle("/embed", handler.Log(handler.Compress(handler.CacheControl(Embed(cm, ts), cache))))
+	m.Handle("/oembed", handler.Log(handler.Compress(handler.CacheControl(OEmbed(cm), cache))))
+}
+
+// Embed renders a single message, or the opening message when none is given, for framing on other sites.
+func Embed(cm conveyearthgo.ContentManager, ts *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		conversation := netgo.ParseInt(netgo.QueryParameter(query, "conversation"))
+		message := netgo.ParseInt(netgo.QueryParameter(query, "message"))
+		c, m, err := lookupEmbed(cm, conversation, message)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		data := &EmbedData{
+			Live:           netgo.IsLive(),
+			Topic:          c.Topic,
+			ConversationID: c.ID,
+			MessageID:      m.ID,
+			Author:         m.Author,
+			Yield:          m.Yield,
+			Created:        m.Created,
+			Link:           embedLink(c.ID, m),
+		}
+		if err := cm.LookupFiles(m.ID, func(f *conveyearthgo.File) error {
+			c, err := cm.ToHTML(f.Hash, f.Mime)
+			if err != nil {
+				return err
+			}
+			data.Content += c
+			return nil
+		}); err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", EMBED_FRAME_ANCESTORS)
+		if err := ts.ExecuteTemplate(w, "embed.go.html", data); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// OEmbed describes how to embed the conversation, or message, at the given url as specified by https://oembed.com
+func OEmbed(cm conveyearthgo.ContentManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if format := netgo.QueryParameter(query, "format"); format != "" && format != "json" {
+			http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+			return
+		}
+		u, err := url.Parse(netgo.QueryParameter(query, "url"))
+		if err != nil || u.Host != conveyearthgo.Host() || u.Path != "/conversation" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		conversation := netgo.ParseInt(u.Query().Get("id"))
+		var message int64
+		if f := strings.TrimPrefix(u.Fragment, "message"); f != u.Fragment {
+			message = netgo.ParseInt(f)
+		}
+		c, m, err := lookupEmbed(cm, conversation, message)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		width := OEMBED_WIDTH
+		if limit := int(netgo.ParseInt(netgo.QueryParameter(query, "maxwidth"))); limit > 0 && limit < width {
+			width = limit
+		}
+		height := OEMBED_HEIGHT
+		if limit := int(netgo.ParseInt(netgo.QueryParameter(query, "maxheight"))); limit > 0 && limit < height {
+			height = limit
+		}
+		base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+		src := fmt.Sprintf("%s/embed?conversation=%d&message=%d", base, c.ID, m.ID)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&OEmbedData{
+			Type:         "rich",
+			Version:      "1.0",
+			Title:        c.Topic,
+			AuthorName:   m.Author.Username,
+			ProviderName: "Convey",
+			ProviderURL:  base,
+			HTML:         fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" sandbox="allow-popups allow-popups-to-escape-sandbox"></iframe>`, html.EscapeString(src), width, height, html.EscapeString(c.Topic)),
+			Width:        width,
+			Height:       height,
+		}); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// lookupEmbed returns the conversation and the message, which must belong to it, or the opening message when message is zero.
+func lookupEmbed(cm conveyearthgo.ContentManager, conversation, message int64) (*conveyearthgo.Conversation, *conveyearthgo.Message, error) {
+	c, err := cm.LookupConversation(conversation)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message != 0 {
+		m, err := cm.LookupMessage(message)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.ConversationID != c.ID {
+			return nil, nil, conveyearthgo.ErrMessageNotFound
+		}
+		return c, m, nil
+	}
+	opening, err := cm.LookupOpeningMessage(c.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, opening, nil
+}
+
+func embedLink(conversation int64, message *conveyearthgo.Message) string {
+	link := fmt.Sprintf("%s://%s/conversation?id=%d", conveyearthgo.Scheme(), conveyearthgo.Host(), conversation)
+	if message.ParentID != 0 {
+		link += fmt.Sprintf("#message%d", message.ID)
+	}
+	return link
+}
+
+type EmbedData struct {
+	Live           bool
+	Topic          string
+	ConversationID int64
+	MessageID      int64
+	Author         *authgo.Account
+	Yield          int64
+	Content        template.HTML
+	Link           string
+	Created        time.Time
+}
+
+type OEmbedData struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
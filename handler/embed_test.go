@@ -0,0 +1,133 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEmbed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	tmpl, err := template.New("embed.go.html").Parse(`{{.Topic}}|{{.Author.Username}}|{{.Yield}}|{{.Content}}|{{.Link}}`)
+	assert.Nil(t, err)
+	base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+	setup := func(t *testing.T) (*http.ServeMux, conveyearthgo.ContentManager, *authgo.Account) {
+		t.Helper()
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		mux := http.NewServeMux()
+		handler.AttachEmbedHandlers(mux, cm, tmpl, "")
+		return mux, cm, acc
+	}
+	get := func(mux *http.ServeMux, path string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		return response.Result()
+	}
+	t.Run("Embed Returns 404 When Conversation Does Not Exist", func(t *testing.T) {
+		mux, _, _ := setup(t)
+		result := get(mux, "/embed?conversation=1")
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("Embed Returns Opening Message", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		result := get(mux, fmt.Sprintf("/embed?conversation=%d", c.ID))
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, handler.EMBED_FRAME_ANCESTORS, result.Header.Get("Content-Security-Policy"))
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveytest.TEST_TOPIC+"|"+authtest.TEST_USERNAME+"|0|"+`<p class="ucc">`+conveytest.TEST_CONTENT+`</p>|`+template.HTMLEscapeString(fmt.Sprintf("%s/conversation?id=%d", base, c.ID)), string(body))
+	})
+	t.Run("Embed Returns Reply", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		r, _ := conveytest.NewReply(t, cm, acc, c, m)
+		result := get(mux, fmt.Sprintf("/embed?conversation=%d&message=%d", c.ID, r.ID))
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveytest.TEST_TOPIC+"|"+authtest.TEST_USERNAME+"|0|"+`<p class="ucc">`+conveytest.TEST_REPLY+`</p>|`+template.HTMLEscapeString(fmt.Sprintf("%s/conversation?id=%d#message%d", base, c.ID, r.ID)), string(body))
+	})
+	t.Run("Embed Returns 404 When Message Is In Another Conversation", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c1, _, _ := conveytest.NewConversation(t, cm, acc)
+		_, m2, _ := conveytest.NewConversation(t, cm, acc)
+		result := get(mux, fmt.Sprintf("/embed?conversation=%d&message=%d", c1.ID, m2.ID))
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("OEmbed Describes Conversation", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		link := fmt.Sprintf("%s/conversation?id=%d", base, c.ID)
+		result := get(mux, "/oembed?format=json&url="+url.QueryEscape(link))
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+		data := &handler.OEmbedData{}
+		assert.Nil(t, json.NewDecoder(result.Body).Decode(data))
+		assert.Equal(t, "rich", data.Type)
+		assert.Equal(t, "1.0", data.Version)
+		assert.Equal(t, conveytest.TEST_TOPIC, data.Title)
+		assert.Equal(t, authtest.TEST_USERNAME, data.AuthorName)
+		assert.Equal(t, "Convey", data.ProviderName)
+		assert.Equal(t, handler.OEMBED_WIDTH, data.Width)
+		assert.Equal(t, handler.OEMBED_HEIGHT, data.Height)
+		assert.True(t, strings.Contains(data.HTML, fmt.Sprintf(`src="%s/embed?conversation=%d&amp;message=%d"`, base, c.ID, m.ID)), data.HTML)
+	})
+	t.Run("OEmbed Describes Reply And Respects Maximum Size", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		r, _ := conveytest.NewReply(t, cm, acc, c, m)
+		link := fmt.Sprintf("%s/conversation?id=%d#message%d", base, c.ID, r.ID)
+		result := get(mux, "/oembed?maxwidth=300&maxheight=200&url="+url.QueryEscape(link))
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		data := &handler.OEmbedData{}
+		assert.Nil(t, json.NewDecoder(result.Body).Decode(data))
+		assert.Equal(t, 300, data.Width)
+		assert.Equal(t, 200, data.Height)
+		assert.True(t, strings.Contains(data.HTML, fmt.Sprintf(`src="%s/embed?conversation=%d&amp;message=%d"`, base, c.ID, r.ID)), data.HTML)
+		assert.True(t, strings.Contains(data.HTML, `width="300" height="200"`), data.HTML)
+	})
+	t.Run("OEmbed Returns 501 When Format Is Not JSON", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		link := fmt.Sprintf("%s/conversation?id=%d", base, c.ID)
+		result := get(mux, "/oembed?format=xml&url="+url.QueryEscape(link))
+		assert.Equal(t, http.StatusNotImplemented, result.StatusCode)
+	})
+	t.Run("OEmbed Returns 404 When URL Is Not A Conversation", func(t *testing.T) {
+		mux, cm, acc := setup(t)
+		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		for _, link := range []string{
+			fmt.Sprintf("https://example.com/conversation?id=%d", c.ID),
+			base + "/recent",
+			base + "/conversation?id=0",
+		} {
+			result := get(mux, "/oembed?url="+url.QueryEscape(link))
+			assert.Equal(t, http.StatusNotFound, result.StatusCode, link)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo/handler"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ATOM_CONTENT_TYPE = "application/atom+xml; charset=utf-8"
+
+var bestTitles = map[string]string{
+	"all":   "All Time",
+	"year":  "the Year",
+	"month": "the Month",
+	"week":  "the Week",
+	"day":   "the Day",
+}
+
+func AttachFeedHandlers(m *http.ServeMux, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, limit int64, cache string) {
+	m.Handle("/feed/recent.atom", handler.Log(handler.Compress(handler.CacheControl(RecentFeed(cm, limit), cache))))
+	m.Handle("/feed/best.atom", handler.Log(handler.Compress(handler.CacheControl(BestFeed(cm, limit), cache))))
+	m.Handle("/feed/author/", handler.Log(handler.Compress(handler.CacheControl(http.StripPrefix("/feed/author/", AuthorFeed(am, cm, limit)), cache))))
+}
+
+// RecentFeed serves an Atom feed of the most recent conversations.
+func RecentFeed(cm conveyearthgo.ContentManager, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var conversations []*conveyearthgo.Conversation
+		if err := cm.LookupRecentConversations(func(c *conveyearthgo.Conversation) error {
+			conversations = append(conversations, c)
+			return nil
+		}, limit); err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		serveFeed(w, r, cm, "Convey - Recent", "/recent", conversations)
+	})
+}
+
+// BestFeed serves an Atom feed of the best conversations of the period, which defaults to the current week.
+func BestFeed(cm conveyearthgo.ContentManager, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		period, since := bestPeriod(r.FormValue("period"), time.Now())
+		var conversations []*conveyearthgo.Conversation
+		if err := cm.LookupBestConversations(func(c *conveyearthgo.Conversation) error {
+			conversations = append(conversations, c)
+			return nil
+		}, since, limit); err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		serveFeed(w, r, cm, "Convey - Best of "+bestTitles[period], "/best?period="+period, conversations)
+	})
+}
+
+// AuthorFeed serves an Atom feed of the conversations published by the author named in the path, eg. /feed/author/alice.atom
+func AuthorFeed(am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := strings.TrimSuffix(r.URL.Path, ".atom")
+		if username == "" || username == r.URL.Path || strings.Contains(username, "/") {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		author, err := am.Account(username)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		var conversations []*conveyearthgo.Conversation
+		if err := cm.LookupAuthorConversations(author.ID, func(c *conveyearthgo.Conversation) error {
+			conversations = append(conversations, c)
+			return nil
+		}, limit); err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		serveFeed(w, r, cm, "Convey - "+author.Username, "", conversations)
+	})
+}
+
+// serveFeed writes the conversations as an Atom feed, responding with Not Modified when the client's copy is current.
+func serveFeed(w http.ResponseWriter, r *http.Request, cm conveyearthgo.ContentManager, title, path string, conversations []*conveyearthgo.Conversation) {
+	base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+	feed := &AtomFeed{
+		ID:    base + r.URL.RequestURI(),
+		Title: title,
+		Links: []*AtomLink{
+			{
+				Rel:  "self",
+				Type: "application/atom+xml",
+				Href: base + r.URL.RequestURI(),
+			},
+		},
+	}
+	if path != "" {
+		feed.Links = append(feed.Links, &AtomLink{
+			Rel:  "alternate",
+			Type: "text/html",
+			Href: base + path,
+		})
+	}
+	var updated time.Time
+	for _, c := range conversations {
+		content, err := openingContent(cm, c.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		// Entries are updated by replies, gifts, and deletions, as well as their creation
+		activity, err := cm.LookupConversationActivity(c.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		link := fmt.Sprintf("%s/conversation?id=%d", base, c.ID)
+		feed.Entries = append(feed.Entries, &AtomEntry{
+			ID:        link,
+			Title:     c.Topic,
+			Published: c.Created.UTC().Format(time.RFC3339),
+			Updated:   activity.UTC().Format(time.RFC3339),
+			Author: &AtomPerson{
+				Name: c.Author.Username,
+				URI:  fmt.Sprintf("%s/feed/author/%s.atom", base, c.Author.Username),
+			},
+			Link: &AtomLink{
+				Rel:  "alternate",
+				Type: "text/html",
+				Href: link,
+			},
+			Content: &AtomContent{
+				Type: "html",
+				Base: base + "/",
+				Body: string(content),
+			},
+		})
+		if activity.After(updated) {
+			updated = activity
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buffer).Encode(feed); err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	hash := sha256.Sum256(buffer.Bytes())
+	w.Header().Set("Content-Type", ATOM_CONTENT_TYPE)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	// Handles If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, "", updated, bytes.NewReader(buffer.Bytes()))
+}
+
+// openingContent returns the rendered HTML of the first message in the conversation.
+func openingContent(cm conveyearthgo.ContentManager, conversation int64) (template.HTML, error) {
+	opening, err := cm.LookupOpeningMessage(conversation)
+	if err != nil {
+		return "", err
+	}
+	var content template.HTML
+	if err := cm.LookupFiles(opening.ID, func(f *conveyearthgo.File) error {
+		c, err := cm.ToHTML(f.Hash, f.Mime)
+		if err != nil {
+			return err
+		}
+		content += c
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+type AtomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Links   []*AtomLink  `xml:"link"`
+	Entries []*AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Author    *AtomPerson  `xml:"author"`
+	Link      *AtomLink    `xml:"link"`
+	Content   *AtomContent `xml:"content"`
+}
+
+type AtomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	// Resolves the relative links of attachments
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
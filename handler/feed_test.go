@@ -0,0 +1,142 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"encoding/xml"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	setup := func(t *testing.T) (*http.ServeMux, authgo.Authenticator, conveyearthgo.ContentManager, *authgo.Account) {
+		t.Helper()
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		conveytest.NewPurchase(t, am, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		mux := http.NewServeMux()
+		handler.AttachFeedHandlers(mux, am, cm, 10, "public, max-age=300")
+		return mux, auth, cm, acc
+	}
+	publish := func(t *testing.T, cm conveyearthgo.ContentManager, acc *authgo.Account, topic, text string) {
+		t.Helper()
+		hash, size, err := cm.AddText([]byte(text))
+		assert.NoError(t, err)
+		_, _, _, err = cm.NewConversation(acc, topic, []string{hash}, []string{conveyearthgo.MIME_TEXT_PLAIN}, []int64{size})
+		assert.NoError(t, err)
+	}
+	get := func(mux *http.ServeMux, path string, header map[string]string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		return response.Result()
+	}
+	decode := func(t *testing.T, result *http.Response) *handler.AtomFeed {
+		t.Helper()
+		feed := &handler.AtomFeed{}
+		assert.Nil(t, xml.NewDecoder(result.Body).Decode(feed))
+		return feed
+	}
+	t.Run("Recent", func(t *testing.T) {
+		mux, _, cm, acc := setup(t)
+		for i := 1; i <= 3; i++ {
+			publish(t, cm, acc, fmt.Sprintf("FooBar%d", i), fmt.Sprintf("Hello World%d!", i))
+		}
+		result := get(mux, "/feed/recent.atom", nil)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, handler.ATOM_CONTENT_TYPE, result.Header.Get("Content-Type"))
+		assert.NotEmpty(t, result.Header.Get("ETag"))
+		assert.NotEmpty(t, result.Header.Get("Last-Modified"))
+		feed := decode(t, result)
+		assert.Equal(t, "Convey - Recent", feed.Title)
+		assert.Equal(t, 3, len(feed.Entries))
+		for i, e := range feed.Entries {
+			n := 3 - i
+			assert.Equal(t, fmt.Sprintf("FooBar%d", n), e.Title)
+			assert.Equal(t, authtest.TEST_USERNAME, e.Author.Name)
+			assert.Equal(t, "html", e.Content.Type)
+			assert.True(t, strings.Contains(e.Content.Body, fmt.Sprintf("Hello World%d!", n)), e.Content.Body)
+		}
+	})
+	t.Run("Best", func(t *testing.T) {
+		mux, _, cm, acc := setup(t)
+		publish(t, cm, acc, "Short", "Hi!")
+		publish(t, cm, acc, "Long", "Hello World, this costs more!")
+		result := get(mux, "/feed/best.atom?period=all", nil)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		feed := decode(t, result)
+		assert.Equal(t, "Convey - Best of All Time", feed.Title)
+		assert.Equal(t, 2, len(feed.Entries))
+	})
+	t.Run("Author", func(t *testing.T) {
+		mux, auth, cm, acc := setup(t)
+		other, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		publish(t, cm, acc, "Mine", "Hello World!")
+		publish(t, cm, other, "Theirs", "Hello World!")
+		result := get(mux, "/feed/author/"+authtest.TEST_USERNAME+".atom", nil)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		feed := decode(t, result)
+		assert.Equal(t, 1, len(feed.Entries))
+		assert.Equal(t, "Mine", feed.Entries[0].Title)
+	})
+	t.Run("Unknown Author", func(t *testing.T) {
+		mux, _, _, _ := setup(t)
+		result := get(mux, "/feed/author/nobody.atom", nil)
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+		result = get(mux, "/feed/author/"+authtest.TEST_USERNAME, nil)
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("Not Modified", func(t *testing.T) {
+		mux, _, cm, acc := setup(t)
+		publish(t, cm, acc, "FooBar", "Hello World!")
+		result := get(mux, "/feed/recent.atom", nil)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		etag := result.Header.Get("ETag")
+		modified := result.Header.Get("Last-Modified")
+
+		result = get(mux, "/feed/recent.atom", map[string]string{
+			"If-None-Match": etag,
+		})
+		assert.Equal(t, http.StatusNotModified, result.StatusCode)
+
+		result = get(mux, "/feed/recent.atom", map[string]string{
+			"If-Modified-Since": modified,
+		})
+		assert.Equal(t, http.StatusNotModified, result.StatusCode)
+
+		result = get(mux, "/feed/recent.atom", map[string]string{
+			"If-Modified-Since": time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+		})
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		// Changes when a conversation is published
+		publish(t, cm, acc, "FooBar2", "Hello World!")
+		result = get(mux, "/feed/recent.atom", map[string]string{
+			"If-None-Match": etag,
+		})
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.NotEqual(t, etag, result.Header.Get("ETag"))
+	})
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	authredirect "aletheiaware.com/authgo/redirect"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/redirect"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func AttachFollowHandler(m *http.ServeMux, a authgo.Authenticator, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager) {
+	m.Handle("/follow", handler.Log(handler.Compress(Follow(a, cm, nm))))
+}
+
+// Follow follows, or unfollows, a conversation or the author of a conversation, and returns to the conversation.
+func Follow(a authgo.Authenticator, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account := a.CurrentAccount(w, r)
+		if account == nil {
+			authredirect.SignIn(w, r, r.URL.String())
+			return
+		}
+		switch r.Method {
+		case "POST":
+			id := netgo.ParseInt(r.FormValue("conversation"))
+			c, err := cm.LookupConversation(id)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			kind := strings.TrimSpace(r.FormValue("kind"))
+			var target int64
+			switch kind {
+			case conveyearthgo.FOLLOW_CONVERSATION:
+				target = c.ID
+			case conveyearthgo.FOLLOW_AUTHOR:
+				target = c.Author.ID
+			default:
+				log.Println(conveyearthgo.ErrFollowKindUnrecognized)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(r.FormValue("follow")) == "yes" {
+				err = nm.Follow(account, kind, target)
+			} else {
+				err = nm.Unfollow(account, kind, target)
+			}
+			if err != nil {
+				log.Println(err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			redirect.Conversation(w, r, c.ID, 0)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFollow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	t.Run("Redirects When Not Signed In", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		mux := http.NewServeMux()
+		handler.AttachFollowHandler(mux, auth, cm, nm)
+		request := httptest.NewRequest(http.MethodPost, "/follow", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		u, err := result.Location()
+		assert.Nil(t, err)
+		assert.Equal(t, "/sign-in?next=%2Ffollow", u.String())
+	})
+	for name, tt := range map[string]struct {
+		kind   string
+		follow string
+		status int
+	}{
+		"Follow Conversation": {
+			kind:   conveyearthgo.FOLLOW_CONVERSATION,
+			follow: "yes",
+			status: http.StatusFound,
+		},
+		"Follow Author": {
+			kind:   conveyearthgo.FOLLOW_AUTHOR,
+			follow: "yes",
+			status: http.StatusFound,
+		},
+		"Unfollow Conversation": {
+			kind:   conveyearthgo.FOLLOW_CONVERSATION,
+			follow: "no",
+			status: http.StatusFound,
+		},
+		"Unrecognized Kind": {
+			kind:   "foobar",
+			follow: "yes",
+			status: http.StatusBadRequest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+			acc := authtest.NewTestAccount(t, auth)
+			token, _ := authtest.SignIn(t, auth)
+			author, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+			assert.NoError(t, err)
+			cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+			c, _, _ := conveytest.NewConversation(t, cm, author)
+			nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+			assert.NoError(t, nm.Follow(acc, conveyearthgo.FOLLOW_CONVERSATION, c.ID))
+			mux := http.NewServeMux()
+			handler.AttachFollowHandler(mux, auth, cm, nm)
+			values := url.Values{}
+			values.Add("conversation", strconv.FormatInt(c.ID, 10))
+			values.Add("kind", tt.kind)
+			values.Add("follow", tt.follow)
+			request := httptest.NewRequest(http.MethodPost, "/follow", strings.NewReader(values.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request.AddCookie(auth.NewSignInSessionCookie(token))
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+			result := response.Result()
+			assert.Equal(t, tt.status, result.StatusCode)
+			if tt.status != http.StatusFound {
+				return
+			}
+			u, err := result.Location()
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("/conversation?id=%d", c.ID), u.String())
+
+			target := c.ID
+			if tt.kind == conveyearthgo.FOLLOW_AUTHOR {
+				target = author.ID
+			}
+			following, err := nm.Following(acc, tt.kind, target)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.follow == "yes", following)
+		})
+	}
+	t.Run("Returns 400 When Following Yourself", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		c, _, _ := conveytest.NewConversation(t, cm, acc)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		mux := http.NewServeMux()
+		handler.AttachFollowHandler(mux, auth, cm, nm)
+		values := url.Values{}
+		values.Add("conversation", strconv.FormatInt(c.ID, 10))
+		values.Add("kind", conveyearthgo.FOLLOW_AUTHOR)
+		values.Add("follow", "yes")
+		request := httptest.NewRequest(http.MethodPost, "/follow", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Result().StatusCode)
+	})
+}
@@ -12,11 +12,11 @@ import (
 	"net/http"
 )
 
-func AttachGiftHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) {
-	m.Handle("/gift", handler.Log(handler.Compress(Gift(a, am, cm, nm, ts))))
+func AttachGiftHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) {
+	m.Handle("/gift", handler.Log(handler.Compress(Gift(a, am, cm, nm, wm, ts))))
 }
 
-func Gift(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
+func Gift(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		account := a.CurrentAccount(w, r)
 		if account == nil {
@@ -26,6 +26,7 @@ func Gift(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyeart
 		data := &GiftData{
 			Live:    netgo.IsLive(),
 			Account: account,
+			Unread:  unreadNotifications(nm, account),
 			Gift:    1,
 		}
 		balance, err := am.AccountBalance(account.ID)
@@ -62,43 +63,60 @@ func Gift(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyeart
 				return
 			}
 
-			// Check account balance
-			if gift > balance {
-				err := conveyearthgo.ErrInsufficientBalance
-				log.Println(err)
-				data.Error = err.Error()
-				executeGiftTemplate(w, ts, data)
-				return
-			}
-
-			// Cannot gift to self
-			if account.ID == data.Message.Author.ID {
-				err := conveyearthgo.ErrSelfGiftingNotPermitted
-				log.Println(err)
-				data.Error = err.Error()
-				executeGiftTemplate(w, ts, data)
-				return
-			}
-
 			// Record gift
-			_, err := cm.NewGift(account, conversation, message, gift)
-			if err != nil {
+			if _, err := sendGift(am, cm, nm, wm, account, data.Conversation, data.Message, gift); err != nil {
 				log.Println(err)
 				data.Error = err.Error()
 				executeGiftTemplate(w, ts, data)
 				return
 			}
 
-			// Send Gift Notification
-			if err := nm.NotifyGift(data.Message.Author, account, conversation, data.Conversation.Topic, message, gift); err != nil {
-				log.Println(err)
-			}
-
 			redirect.Conversation(w, r, conversation, message)
 		}
 	})
 }
 
+// sendGift records the gift if it is positive, not to the account's own message, and covered by its balance, then notifies the recipient and emits webhook events.
+// Notification and webhook failures are only logged, as the gift has already been sent.
+func sendGift(am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, account *authgo.Account, conversation *conveyearthgo.Conversation, message *conveyearthgo.Message, amount int64) (*conveyearthgo.Gift, error) {
+	if amount <= 0 {
+		return nil, conveyearthgo.ErrGiftAmountInvalid
+	}
+
+	if err := checkBalance(am, account, amount); err != nil {
+		return nil, err
+	}
+
+	// Cannot gift to self
+	if account.ID == message.Author.ID {
+		return nil, conveyearthgo.ErrSelfGiftingNotPermitted
+	}
+
+	g, err := cm.NewGift(account, conversation.ID, message.ID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send Gift Notification
+	if err := nm.NotifyGift(message.Author, account, conversation.ID, conversation.Topic, message.ID, amount); err != nil {
+		log.Println(err)
+	}
+
+	// Send Webhook Events
+	if err := wm.Emit(&conveyearthgo.WebhookEvent{
+		Kind:         conveyearthgo.WEBHOOK_GIFT,
+		Actor:        account.Username,
+		Conversation: conversation.ID,
+		Message:      message.ID,
+		Gift:         g.ID,
+		Topic:        conversation.Topic,
+		Amount:       amount,
+	}, account.ID, message.Author.ID); err != nil {
+		log.Println(err)
+	}
+	return g, nil
+}
+
 func executeGiftTemplate(w http.ResponseWriter, ts *template.Template, data *GiftData) {
 	if err := ts.ExecuteTemplate(w, "gift.go.html", data); err != nil {
 		log.Println(err)
@@ -140,4 +158,5 @@ type GiftData struct {
 	Message      *conveyearthgo.Message
 	Content      template.HTML
 	Gift         int64
+	Unread       int64
 }
@@ -19,6 +19,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGift(t *testing.T) {
@@ -35,11 +36,12 @@ func TestGift(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/gift?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -56,11 +58,12 @@ func TestGift(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/gift?conversation=%d&message=%d", c.ID, m.ID), nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -77,11 +80,12 @@ func TestGift(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/gift?conversation=%d&message=%d", c.ID+1, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -110,11 +114,12 @@ func TestGift(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/gift?conversation=%d&message=%d", c.ID, m.ID+1), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -143,13 +148,14 @@ func TestGift(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 		assert.Nil(t, err)
 		c, m, _ := conveytest.NewConversation(t, cm, acc2)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		values := url.Values{}
 		values.Add("conversation", strconv.FormatInt(c.ID, 10))
 		values.Add("message", strconv.FormatInt(m.ID, 10))
@@ -166,6 +172,42 @@ func TestGift(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, conveyearthgo.ErrInsufficientBalance.Error()+authtest.TEST_USERNAME, string(body))
 	})
+	t.Run("Negative Balance", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		conveytest.NewPurchase(t, am, acc)
+		_, err := db.CreateCharge(acc.ID, 0, 0, conveytest.TEST_PURCHASE_SIZE/2, time.Now())
+		assert.Nil(t, err)
+		_, _, err = am.AdjustPurchase(conveytest.TEST_PAYMENT_INTENT_ID, conveyearthgo.ADJUSTMENT_REFUND, conveytest.TEST_PURCHASE_AMOUNT)
+		assert.Nil(t, err)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		c, m, _ := conveytest.NewConversation(t, cm, acc2)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		mux := http.NewServeMux()
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
+		values := url.Values{}
+		values.Add("conversation", strconv.FormatInt(c.ID, 10))
+		values.Add("message", strconv.FormatInt(m.ID, 10))
+		values.Add("gift", "1")
+		reader := strings.NewReader(values.Encode())
+		request := httptest.NewRequest(http.MethodPost, "/gift", reader)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveyearthgo.ErrBalanceNegative.Error()+authtest.TEST_USERNAME, string(body))
+	})
 	t.Run("Self Gift", func(t *testing.T) {
 		db := database.NewInMemory()
 		ev := authtest.NewEmailVerifier()
@@ -174,11 +216,12 @@ func TestGift(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		conveytest.NewPurchase(t, am, acc)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		values := url.Values{}
 		values.Add("conversation", strconv.FormatInt(c.ID, 10))
 		values.Add("message", strconv.FormatInt(m.ID, 10))
@@ -203,13 +246,14 @@ func TestGift(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		conveytest.NewPurchase(t, am, acc)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
 		assert.Nil(t, err)
 		c, m, _ := conveytest.NewConversation(t, cm, acc2)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachGiftHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachGiftHandler(mux, auth, am, cm, nm, wm, tmpl)
 		values := url.Values{}
 		values.Add("conversation", strconv.FormatInt(c.ID, 10))
 		values.Add("message", strconv.FormatInt(m.ID, 10))
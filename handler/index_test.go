@@ -31,7 +31,7 @@ func TestIndex(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachIndexHandler(mux, auth, cm, tmpl, dir)
 		request := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -48,7 +48,7 @@ func TestIndex(t *testing.T) {
 		db := database.NewInMemory()
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachIndexHandler(mux, auth, cm, tmpl, dir)
 		request := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -65,8 +65,9 @@ func TestIndex(t *testing.T) {
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		conveytest.NewReply(t, cm, acc, c, m)
 		mux := http.NewServeMux()
@@ -87,7 +88,7 @@ func TestIndex(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 
 		// Create Edition
 		file := filepath.Join(dir, "Convey-Digest-2006-01.epub")
@@ -9,14 +9,15 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 )
 
-func AttachNotificationPreferencesHandler(m *http.ServeMux, a authgo.Authenticator, nm conveyearthgo.NotificationManager, ts *template.Template) {
-	m.Handle("/account-notification-preferences", handler.Log(handler.Compress(NotificationPreferences(a, nm, ts))))
+func AttachNotificationPreferencesHandler(m *http.ServeMux, a authgo.Authenticator, nm conveyearthgo.NotificationManager, pm conveyearthgo.PushManager, ts *template.Template) {
+	m.Handle("/account-notification-preferences", handler.Log(handler.Compress(NotificationPreferences(a, nm, pm, ts))))
 }
 
-func NotificationPreferences(a authgo.Authenticator, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
+func NotificationPreferences(a authgo.Authenticator, nm conveyearthgo.NotificationManager, pm conveyearthgo.PushManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		account := a.CurrentAccount(w, r)
 		if account == nil {
@@ -26,33 +27,58 @@ func NotificationPreferences(a authgo.Authenticator, nm conveyearthgo.Notificati
 		data := &NotificationPreferencesData{
 			Account: account,
 			Live:    netgo.IsLive(),
+			PushKey: pm.PublicKey(),
+			Unread:  unreadNotifications(nm, account),
 		}
-		id, responses, mentions, gifts, digests, err := nm.NotificationPreferences(account.ID)
+		preferences, err := nm.NotificationPreferences(account.ID)
 		if err != nil {
 			log.Println(err)
 			data.Error = err.Error()
 			executeNotificationPreferencesTemplate(w, ts, data)
 			return
 		}
-		data.NotificationResponses = responses
-		data.NotificationMentions = mentions
-		data.NotificationGifts = gifts
-		data.NotificationDigests = digests
+		data.NotificationResponses = preferences.Responses
+		data.NotificationMentions = preferences.Mentions
+		data.NotificationGifts = preferences.Gifts
+		data.NotificationDigests = preferences.Digests
+		data.NotificationYields = preferences.Yields
+		data.NotificationReplies = preferences.Replies
+		data.NotificationPublications = preferences.Publications
+		data.NotificationFrequency = preferences.Frequency
+		data.NotificationEmail = preferences.Email
+		data.NotificationPush = preferences.Push
 		switch r.Method {
 		case "GET":
 			executeNotificationPreferencesTemplate(w, ts, data)
 		case "POST":
-			responses := strings.TrimSpace(r.FormValue("responses")) == "yes"
-			mentions := strings.TrimSpace(r.FormValue("mentions")) == "yes"
-			gifts := strings.TrimSpace(r.FormValue("gifts")) == "yes"
-			digests := strings.TrimSpace(r.FormValue("digests")) == "yes"
+			preferences.Responses = strings.TrimSpace(r.FormValue("responses")) == "yes"
+			preferences.Mentions = strings.TrimSpace(r.FormValue("mentions")) == "yes"
+			preferences.Gifts = strings.TrimSpace(r.FormValue("gifts")) == "yes"
+			preferences.Digests = strings.TrimSpace(r.FormValue("digests")) == "yes"
+			preferences.Yields = strings.TrimSpace(r.FormValue("yields")) == "yes"
+			preferences.Replies = strings.TrimSpace(r.FormValue("replies")) == "yes"
+			preferences.Publications = strings.TrimSpace(r.FormValue("publications")) == "yes"
+			preferences.Frequency = strings.TrimSpace(r.FormValue("frequency"))
+			switch preferences.Frequency {
+			case conveyearthgo.FREQUENCY_HOURLY, conveyearthgo.FREQUENCY_DAILY:
+			default:
+				preferences.Frequency = conveyearthgo.FREQUENCY_IMMEDIATE
+			}
+			preferences.Email = strings.TrimSpace(r.FormValue("email")) == "yes"
+			preferences.Push = strings.TrimSpace(r.FormValue("push")) == "yes"
 
-			data.NotificationResponses = responses
-			data.NotificationMentions = mentions
-			data.NotificationGifts = gifts
-			data.NotificationDigests = digests
+			data.NotificationResponses = preferences.Responses
+			data.NotificationMentions = preferences.Mentions
+			data.NotificationGifts = preferences.Gifts
+			data.NotificationDigests = preferences.Digests
+			data.NotificationYields = preferences.Yields
+			data.NotificationReplies = preferences.Replies
+			data.NotificationPublications = preferences.Publications
+			data.NotificationFrequency = preferences.Frequency
+			data.NotificationEmail = preferences.Email
+			data.NotificationPush = preferences.Push
 
-			if err := nm.SetNotificationPreferences(id, account.ID, responses, mentions, gifts, digests); err != nil {
+			if err := nm.SetNotificationPreferences(preferences); err != nil {
 				log.Println(err)
 				data.Error = err.Error()
 				executeNotificationPreferencesTemplate(w, ts, data)
@@ -70,11 +96,176 @@ func executeNotificationPreferencesTemplate(w http.ResponseWriter, ts *template.
 }
 
 type NotificationPreferencesData struct {
-	Live                  bool
-	Error                 string
-	Account               *authgo.Account
-	NotificationResponses bool
-	NotificationMentions  bool
-	NotificationGifts     bool
-	NotificationDigests   bool
+	Live                     bool
+	Error                    string
+	Account                  *authgo.Account
+	NotificationResponses    bool
+	NotificationMentions     bool
+	NotificationGifts        bool
+	NotificationDigests      bool
+	NotificationYields       bool
+	NotificationReplies      bool
+	NotificationPublications bool
+	NotificationFrequency    string
+	NotificationEmail        bool
+	NotificationPush         bool
+	PushKey                  string
+	Unread                   int64
+}
+
+func AttachNotificationsHandler(m *http.ServeMux, a authgo.Authenticator, nm conveyearthgo.NotificationManager, ts *template.Template, limit int64) {
+	m.Handle("/notifications", handler.Log(handler.Compress(Notifications(a, nm, ts, limit))))
+}
+
+func Notifications(a authgo.Authenticator, nm conveyearthgo.NotificationManager, ts *template.Template, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account := a.CurrentAccount(w, r)
+		if account == nil {
+			redirect.SignIn(w, r, r.URL.String())
+			return
+		}
+		data := &NotificationsData{
+			Account: account,
+			Live:    netgo.IsLive(),
+		}
+		switch r.Method {
+		case "GET":
+			if err := nm.Notifications(account, limit, func(n *conveyearthgo.Notification) error {
+				data.Notifications = append(data.Notifications, n)
+				if !n.Read {
+					data.Unread++
+				}
+				return nil
+			}); err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+			}
+			executeNotificationsTemplate(w, ts, data)
+		case "POST":
+			var err error
+			if strings.TrimSpace(r.FormValue("all")) == "yes" {
+				err = nm.MarkAllNotificationsRead(account)
+			} else {
+				err = nm.MarkNotificationRead(account, netgo.ParseInt(r.FormValue("notification")))
+			}
+			if err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+				executeNotificationsTemplate(w, ts, data)
+				return
+			}
+			http.Redirect(w, r, "/notifications", http.StatusFound)
+		}
+	})
+}
+
+func executeNotificationsTemplate(w http.ResponseWriter, ts *template.Template, data *NotificationsData) {
+	if err := ts.ExecuteTemplate(w, "notifications.go.html", data); err != nil {
+		log.Println(err)
+	}
+}
+
+// unreadNotifications counts the unread notifications of the given account, if there is one, for the page header.
+func unreadNotifications(nm conveyearthgo.NotificationManager, account *authgo.Account) int64 {
+	if account == nil {
+		return 0
+	}
+	count, err := nm.UnreadNotifications(account.ID)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return count
+}
+
+type NotificationsData struct {
+	Live          bool
+	Error         string
+	Account       *authgo.Account
+	Notifications []*conveyearthgo.Notification
+	Unread        int64
+}
+
+// notifyMentions notifies each user mentioned in the text, except those already notified.
+func notifyMentions(am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, account *authgo.Account, text string, conversation int64, topic string, message int64, notified map[int64]bool) {
+	for _, username := range conveyearthgo.Mentions(text) {
+		a, err := am.Account(username)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if notified[a.ID] {
+			continue
+		}
+		notified[a.ID] = true
+		if err := nm.NotifyMention(a, account, conversation, topic, message); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// notifyYields notifies the author of each ancestor of the yield they earned from the message, except those already notified.
+func notifyYields(cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, account *authgo.Account, conversation int64, topic string, message int64, notified map[int64]bool) {
+	var recipients []*authgo.Account
+	amounts := make(map[int64]int64)
+	if err := cm.LookupYields(message, func(recipient *authgo.Account, amount int64) error {
+		if _, ok := amounts[recipient.ID]; !ok {
+			recipients = append(recipients, recipient)
+		}
+		// An author may have written several ancestors
+		amounts[recipient.ID] += amount
+		return nil
+	}); err != nil {
+		log.Println(err)
+		return
+	}
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].ID < recipients[j].ID
+	})
+	for _, r := range recipients {
+		if notified[r.ID] || amounts[r.ID] == 0 {
+			continue
+		}
+		notified[r.ID] = true
+		if err := nm.NotifyYield(r, account, conversation, topic, message, amounts[r.ID]); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// notifyReplyFollowers notifies each follower of the conversation of the reply, except those already notified.
+func notifyReplyFollowers(nm conveyearthgo.NotificationManager, account *authgo.Account, conversation int64, topic string, message int64, notified map[int64]bool) {
+	for _, f := range followers(nm, conveyearthgo.FOLLOW_CONVERSATION, conversation) {
+		if notified[f.ID] {
+			continue
+		}
+		notified[f.ID] = true
+		if err := nm.NotifyReply(f, account, conversation, topic, message); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// notifyPublicationFollowers notifies each follower of the author of the new conversation, except those already notified.
+func notifyPublicationFollowers(nm conveyearthgo.NotificationManager, account *authgo.Account, conversation int64, topic string, notified map[int64]bool) {
+	for _, f := range followers(nm, conveyearthgo.FOLLOW_AUTHOR, account.ID) {
+		if notified[f.ID] {
+			continue
+		}
+		notified[f.ID] = true
+		if err := nm.NotifyPublication(f, account, conversation, topic); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func followers(nm conveyearthgo.NotificationManager, kind string, target int64) []*authgo.Account {
+	var accounts []*authgo.Account
+	if err := nm.Followers(kind, target, func(a *authgo.Account) error {
+		accounts = append(accounts, a)
+		return nil
+	}); err != nil {
+		log.Println(err)
+	}
+	return accounts
 }
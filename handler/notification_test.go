@@ -0,0 +1,92 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifications(t *testing.T) {
+	tmpl, err := template.New("notifications.go.html").Parse(`{{.Error}}{{with .Account}}{{.Username}}{{end}}{{.Unread}}{{range .Notifications}} {{.Kind}}:{{.Actor}}{{end}}`)
+	assert.Nil(t, err)
+	setup := func(t *testing.T) (*database.InMemory, authgo.Authenticator, *authgo.Account, string, conveyearthgo.NotificationManager) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		conversation, err := db.CreateConversation(acc.ID, "Test", time.Now())
+		assert.Nil(t, err)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		assert.Nil(t, nm.NotifyResponse(acc, acc2, conversation, "Test", 1))
+		return db, auth, acc, token, nm
+	}
+	t.Run("Redirects When Not Signed In", func(t *testing.T) {
+		_, auth, _, _, nm := setup(t)
+		mux := http.NewServeMux()
+		handler.AttachNotificationsHandler(mux, auth, nm, tmpl, 10)
+		request := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+	})
+	t.Run("Returns 200 When Signed In", func(t *testing.T) {
+		_, auth, _, token, nm := setup(t)
+		mux := http.NewServeMux()
+		handler.AttachNotificationsHandler(mux, auth, nm, tmpl, 10)
+		request := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, authtest.TEST_USERNAME+"1 response:"+authtest.TEST_USERNAME+"2", string(body))
+	})
+	for name, values := range map[string]func(*conveyearthgo.Notification) url.Values{
+		"Marks One As Read": func(n *conveyearthgo.Notification) url.Values {
+			return url.Values{"notification": {strconv.FormatInt(n.ID, 10)}}
+		},
+		"Marks All As Read": func(*conveyearthgo.Notification) url.Values {
+			return url.Values{"all": {"yes"}}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, auth, acc, token, nm := setup(t)
+			var notification *conveyearthgo.Notification
+			assert.Nil(t, nm.Notifications(acc, 10, func(n *conveyearthgo.Notification) error {
+				notification = n
+				return nil
+			}))
+			mux := http.NewServeMux()
+			handler.AttachNotificationsHandler(mux, auth, nm, tmpl, 10)
+			request := httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(values(notification).Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request.AddCookie(auth.NewSignInSessionCookie(token))
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+			result := response.Result()
+			assert.Equal(t, http.StatusFound, result.StatusCode)
+			unread, err := nm.UnreadNotifications(acc.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(0), unread)
+		})
+	}
+}
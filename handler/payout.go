@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/redirect"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+func AttachPayoutHandler(m *http.ServeMux, a authgo.Authenticator, sm conveyearthgo.StripeManager, pm conveyearthgo.PayoutManager, ts *template.Template) {
+	m.Handle("/stripe-payout", handler.Log(handler.Compress(Payout(a, sm, pm, ts))))
+}
+
+func Payout(a authgo.Authenticator, sm conveyearthgo.StripeManager, pm conveyearthgo.PayoutManager, ts *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc := a.CurrentAccount(w, r)
+		if acc == nil {
+			redirect.SignIn(w, r, r.URL.String())
+			return
+		}
+		sa, err := sm.StripeAccount(acc)
+		if err != nil {
+			log.Println(err)
+		}
+		if sa == nil || !sa.PayoutsEnabled {
+			// Account must be connected and onboarded
+			http.Redirect(w, r, "/stripe", http.StatusFound)
+			return
+		}
+		data := &PayoutData{
+			Live:    netgo.IsLive(),
+			Account: acc,
+			Minimum: pm.Minimum(),
+			Rate:    pm.Rate(),
+		}
+		switch r.Method {
+		case "GET":
+			if err := populatePayoutData(pm, acc, LocalCurrency(r), data); err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+			}
+			executePayoutTemplate(w, ts, data)
+		case "POST":
+			size := netgo.ParseInt(r.FormValue("size"))
+			if _, err := pm.NewPayout(acc, sa.ID, size); err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+				if err := populatePayoutData(pm, acc, LocalCurrency(r), data); err != nil {
+					log.Println(err)
+				}
+				executePayoutTemplate(w, ts, data)
+				return
+			}
+			http.Redirect(w, r, "/stripe-payout", http.StatusFound)
+		}
+	})
+}
+
+func executePayoutTemplate(w http.ResponseWriter, ts *template.Template, data *PayoutData) {
+	if err := ts.ExecuteTemplate(w, "payout.go.html", data); err != nil {
+		log.Println(err)
+	}
+}
+
+func populatePayoutData(pm conveyearthgo.PayoutManager, acc *authgo.Account, local string, data *PayoutData) error {
+	eligible, err := pm.EligibleBalance(acc.ID)
+	if err != nil {
+		return err
+	}
+	data.Eligible = eligible
+	data.EligibleAmount = conveyearthgo.FormatLocalAmount(eligible/pm.Rate(), pm.Currency(), local)
+	return pm.LookupPayouts(acc, func(p *conveyearthgo.Payout) error {
+		data.Payouts = append(data.Payouts, &PayoutEntry{
+			Payout: p,
+			Amount: conveyearthgo.FormatLocalAmount(p.Amount, p.Currency, local),
+		})
+		return nil
+	})
+}
+
+type PayoutData struct {
+	Live           bool
+	Error          string
+	Account        *authgo.Account
+	Eligible       int64
+	EligibleAmount string
+	Minimum        int64
+	Rate           int64
+	Payouts        []*PayoutEntry
+}
+
+type PayoutEntry struct {
+	*conveyearthgo.Payout
+	Amount string
+}
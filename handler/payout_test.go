@@ -0,0 +1,118 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayout(t *testing.T) {
+	tmpl, err := template.New("payout.go.html").Parse(`{{.Error}}{{with .Account}}{{.Username}}{{end}}{{.Eligible}}`)
+	assert.Nil(t, err)
+	t.Run("Returns 200 When Signed In And Connected", func(t *testing.T) {
+		conveytest.NewFakeStripe(t)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		_, err := db.CreateStripeAccount(acc.ID, "acct_fake", time.Now())
+		assert.Nil(t, err)
+		am := conveyearthgo.NewAccountManager(db)
+		assert.Nil(t, am.NewAward(acc.ID, "Test", 2000))
+		sm := conveyearthgo.NewStripeManager(db)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+		mux := http.NewServeMux()
+		handler.AttachPayoutHandler(mux, auth, sm, pm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/stripe-payout", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, authtest.TEST_USERNAME+"2000", string(body))
+	})
+	t.Run("Redirects When Not Connected", func(t *testing.T) {
+		conveytest.NewFakeStripe(t)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		sm := conveyearthgo.NewStripeManager(db)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+		mux := http.NewServeMux()
+		handler.AttachPayoutHandler(mux, auth, sm, pm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/stripe-payout", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		u, err := result.Location()
+		assert.Nil(t, err)
+		assert.Equal(t, "/stripe", u.String())
+	})
+	t.Run("Redirects When Not Signed In", func(t *testing.T) {
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		authtest.NewTestAccount(t, auth)
+		sm := conveyearthgo.NewStripeManager(db)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+		mux := http.NewServeMux()
+		handler.AttachPayoutHandler(mux, auth, sm, pm, tmpl)
+		request := httptest.NewRequest(http.MethodGet, "/stripe-payout", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		u, err := result.Location()
+		assert.Nil(t, err)
+		assert.Equal(t, "/sign-in?next=%2Fstripe-payout", u.String())
+	})
+	t.Run("Transfers When Eligible", func(t *testing.T) {
+		fake := conveytest.NewFakeStripe(t)
+		db := database.NewInMemory()
+		ev := authtest.NewEmailVerifier()
+		auth := authgo.NewAuthenticator(db, ev)
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		_, err := db.CreateStripeAccount(acc.ID, "acct_fake", time.Now())
+		assert.Nil(t, err)
+		am := conveyearthgo.NewAccountManager(db)
+		assert.Nil(t, am.NewAward(acc.ID, "Test", 2000))
+		sm := conveyearthgo.NewStripeManager(db)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+		mux := http.NewServeMux()
+		handler.AttachPayoutHandler(mux, auth, sm, pm, tmpl)
+		values := url.Values{}
+		values.Add("size", "1500")
+		request := httptest.NewRequest(http.MethodPost, "/stripe-payout", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		assert.Equal(t, 1, len(fake.Transfers))
+		assert.Equal(t, "150", fake.Transfers[0].Get("amount"))
+		balance, err := am.AccountBalance(acc.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(500), balance)
+	})
+}
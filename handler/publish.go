@@ -13,16 +13,13 @@ import (
 	"strings"
 )
 
-const (
-	MAXIMUM_ATTACHMENTS  = 10
-	MAXIMUM_PARSE_MEMORY = 32 << 20 // 32MB
-)
+const MAXIMUM_PARSE_MEMORY = 32 << 20 // 32MB
 
-func AttachPublishHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) {
-	m.Handle("/publish", handler.Log(handler.Compress(Publish(a, am, cm, nm, ts))))
+func AttachPublishHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) {
+	m.Handle("/publish", handler.Log(handler.Compress(Publish(a, am, cm, nm, wm, ts))))
 }
 
-func Publish(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
+func Publish(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		account := a.CurrentAccount(w, r)
 		if account == nil {
@@ -32,6 +29,7 @@ func Publish(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveye
 		data := &PublishData{
 			Live:    netgo.IsLive(),
 			Account: account,
+			Unread:  unreadNotifications(nm, account),
 		}
 		balance, err := am.AccountBalance(account.ID)
 		if err != nil {
@@ -138,17 +136,8 @@ func Publish(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveye
 				cost += fileSize
 			}
 
-			// Check account balance
-			if cost > balance {
-				err := conveyearthgo.ErrInsufficientBalance
-				log.Println(err)
-				data.Error = err.Error()
-				executePublishTemplate(w, ts, data)
-				return
-			}
-
 			// Record conversation
-			conversation, _, _, err := cm.NewConversation(account, topic, hashes, mimes, sizes)
+			conversation, _, _, err := publishConversation(am, cm, nm, wm, account, topic, content, hashes, mimes, sizes)
 			if err != nil {
 				log.Println(err)
 				data.Error = err.Error()
@@ -156,23 +145,66 @@ func Publish(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveye
 				return
 			}
 
-			// Send Mention Notifications
-			for _, username := range conveyearthgo.Mentions(content) {
-				a, err := am.Account(username)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				if err := nm.NotifyMention(a, account, conversation.ID, topic, 0); err != nil {
-					log.Println(err)
-				}
-			}
-
 			redirect.Conversation(w, r, conversation.ID, 0)
 		}
 	})
 }
 
+// publishConversation records the conversation if the account's balance covers its cost, then notifies mentioned users and followers and emits webhook events.
+// Notification and webhook failures are only logged, as the conversation has already been published.
+func publishConversation(am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, account *authgo.Account, topic, content string, hashes, mimes []string, sizes []int64) (*conveyearthgo.Conversation, *conveyearthgo.Message, []*conveyearthgo.File, error) {
+	if err := checkBalance(am, account, totalSize(sizes)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	conversation, message, files, err := cm.NewConversation(account, topic, hashes, mimes, sizes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Each user is notified at most once per conversation
+	notified := map[int64]bool{
+		account.ID: true,
+	}
+
+	// Send Mention Notifications
+	notifyMentions(am, nm, account, content, conversation.ID, topic, 0, notified)
+
+	// Send Follower Notifications
+	notifyPublicationFollowers(nm, account, conversation.ID, topic, notified)
+
+	// Send Webhook Events
+	if err := wm.Emit(&conveyearthgo.WebhookEvent{
+		Kind:         conveyearthgo.WEBHOOK_CONVERSATION,
+		Actor:        account.Username,
+		Conversation: conversation.ID,
+		Topic:        topic,
+	}, account.ID); err != nil {
+		log.Println(err)
+	}
+	return conversation, message, files, nil
+}
+
+// checkBalance returns ErrInsufficientBalance if the account cannot afford the cost, a negative balance is left for the content manager to refuse.
+func checkBalance(am conveyearthgo.AccountManager, account *authgo.Account, cost int64) error {
+	balance, err := am.AccountBalance(account.ID)
+	if err != nil {
+		return err
+	}
+	if balance >= 0 && cost > balance {
+		return conveyearthgo.ErrInsufficientBalance
+	}
+	return nil
+}
+
+func totalSize(sizes []int64) int64 {
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	return total
+}
+
 func executePublishTemplate(w http.ResponseWriter, ts *template.Template, data *PublishData) {
 	if err := ts.ExecuteTemplate(w, "publish.go.html", data); err != nil {
 		log.Println(err)
@@ -186,4 +218,5 @@ type PublishData struct {
 	Balance int64
 	Topic   string
 	Content string
+	Unread  int64
 }
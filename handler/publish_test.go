@@ -18,6 +18,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPublish(t *testing.T) {
@@ -34,10 +35,11 @@ func TestPublish(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/publish", nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -54,10 +56,11 @@ func TestPublish(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/publish", nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -74,10 +77,11 @@ func TestPublish(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("topic", strings.Repeat("x", conveyearthgo.MINIMUM_TOPIC_LENGTH-1))
@@ -101,10 +105,11 @@ func TestPublish(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("topic", strings.Repeat("x", conveyearthgo.MAXIMUM_TOPIC_LENGTH+1))
@@ -128,10 +133,11 @@ func TestPublish(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("topic", conveytest.TEST_TOPIC)
@@ -157,10 +163,11 @@ func TestPublish(t *testing.T) {
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("topic", conveytest.TEST_TOPIC)
@@ -185,10 +192,11 @@ func TestPublish(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		conveytest.NewPurchase(t, am, acc)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachPublishHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachPublishHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("topic", conveytest.TEST_TOPIC)
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo/handler"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+func AttachPushSubscriptionHandler(m *http.ServeMux, a authgo.Authenticator, pm conveyearthgo.PushManager) {
+	m.Handle("/push-subscription", handler.Log(handler.Compress(PushSubscription(a, pm))))
+}
+
+// PushSubscription registers, or removes, the browser subscription posted by the service worker.
+func PushSubscription(a authgo.Authenticator, pm conveyearthgo.PushManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account := a.CurrentAccount(w, r)
+		if account == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "POST", "DELETE":
+			var subscription PushSubscriptionData
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<12)).Decode(&subscription); err != nil {
+				log.Println(err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var err error
+			if r.Method == "POST" {
+				err = pm.Subscribe(account.ID, subscription.Endpoint, subscription.Keys.P256dh, subscription.Keys.Auth)
+			} else {
+				err = pm.Unsubscribe(account.ID, subscription.Endpoint)
+			}
+			switch err {
+			case nil:
+				w.WriteHeader(http.StatusNoContent)
+			case conveyearthgo.ErrPushSubscriptionInvalid:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case conveyearthgo.ErrPushSubscriptionNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				log.Println(err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}
+	})
+}
+
+// PushSubscriptionData matches the JSON serialization of a browser's PushSubscription.
+type PushSubscriptionData struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
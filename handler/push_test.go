@@ -0,0 +1,113 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"crypto/elliptic"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushSubscription(t *testing.T) {
+	receiver, err := conveyearthgo.GenerateVapidKey()
+	assert.Nil(t, err)
+	p256dh := base64.RawURLEncoding.EncodeToString(elliptic.Marshal(receiver.Curve, receiver.X, receiver.Y))
+	secret := base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef"))
+	subscription := fmt.Sprintf(`{"endpoint":"https://push.example.com/abc","keys":{"p256dh":%q,"auth":%q}}`, p256dh, secret)
+	for name, tt := range map[string]struct {
+		signedIn bool
+		method   string
+		body     string
+		status   int
+		count    int
+	}{
+		"Not Signed In": {
+			method: http.MethodPost,
+			body:   subscription,
+			status: http.StatusUnauthorized,
+		},
+		"Subscribe": {
+			signedIn: true,
+			method:   http.MethodPost,
+			body:     subscription,
+			status:   http.StatusNoContent,
+			count:    1,
+		},
+		"Invalid Subscription": {
+			signedIn: true,
+			method:   http.MethodPost,
+			body:     `{"endpoint":"https://push.example.com/abc","keys":{"p256dh":"abc","auth":"abc"}}`,
+			status:   http.StatusBadRequest,
+		},
+		"Malformed": {
+			signedIn: true,
+			method:   http.MethodPost,
+			body:     `{`,
+			status:   http.StatusBadRequest,
+		},
+		"Unsubscribe Not Found": {
+			signedIn: true,
+			method:   http.MethodDelete,
+			body:     subscription,
+			status:   http.StatusNotFound,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+			acc := authtest.NewTestAccount(t, auth)
+			key, err := conveyearthgo.GenerateVapidKey()
+			assert.Nil(t, err)
+			pm := conveyearthgo.NewPushManager(db, http.DefaultClient, key, "mailto:operator@example.com")
+			mux := http.NewServeMux()
+			handler.AttachPushSubscriptionHandler(mux, auth, pm)
+			request := httptest.NewRequest(tt.method, "/push-subscription", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			if tt.signedIn {
+				token, _ := authtest.SignIn(t, auth)
+				request.AddCookie(auth.NewSignInSessionCookie(token))
+			}
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+			result := response.Result()
+			assert.Equal(t, tt.status, result.StatusCode)
+			var count int
+			assert.Nil(t, pm.Subscriptions(acc.ID, func(*conveyearthgo.PushSubscription) error {
+				count++
+				return nil
+			}))
+			assert.Equal(t, tt.count, count)
+		})
+	}
+	t.Run("Unsubscribe", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, http.DefaultClient, key, "mailto:operator@example.com")
+		assert.Nil(t, pm.Subscribe(acc.ID, "https://push.example.com/abc", p256dh, secret))
+		mux := http.NewServeMux()
+		handler.AttachPushSubscriptionHandler(mux, auth, pm)
+		request := httptest.NewRequest(http.MethodDelete, "/push-subscription", strings.NewReader(subscription))
+		request.Header.Set("Content-Type", "application/json")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusNoContent, result.StatusCode)
+		assert.Nil(t, pm.Subscriptions(acc.ID, func(s *conveyearthgo.PushSubscription) error {
+			t.Fatal("Unexpected Subscription", s.ID)
+			return nil
+		}))
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"aletheiaware.com/authgo"
 	"aletheiaware.com/authgo/authtest"
 	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
 	"aletheiaware.com/conveyearthgo/database"
 	"aletheiaware.com/conveyearthgo/filesystem"
 	"aletheiaware.com/conveyearthgo/handler"
@@ -32,7 +33,7 @@ func TestRecent(t *testing.T) {
 		auth := authgo.NewAuthenticator(db, ev)
 		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachRecentHandler(mux, auth, cm, tmpl, 1, 1)
 		request := httptest.NewRequest(http.MethodGet, "/recent", nil)
@@ -53,7 +54,7 @@ func TestRecent(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		_, err := db.CreateConversation(acc.ID, "FooBar", time.Now())
 		assert.Nil(t, err)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		mux := http.NewServeMux()
 		handler.AttachRecentHandler(mux, auth, cm, tmpl, 1, 1)
 		request := httptest.NewRequest(http.MethodGet, "/recent", nil)
@@ -68,10 +69,11 @@ func TestRecent(t *testing.T) {
 	})
 	t.Run("Results Not Limited When Signed In", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 		token, _ := authtest.SignIn(t, auth)
 
 		maximum := 2
@@ -105,10 +107,11 @@ func TestRecent(t *testing.T) {
 	})
 	t.Run("Results Limited When Not Signed In", func(t *testing.T) {
 		db := database.NewInMemory()
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
 
 		maximum := 2
 		limit := maximum * 3
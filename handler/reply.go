@@ -13,11 +13,11 @@ import (
 	"strings"
 )
 
-func AttachReplyHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) {
-	m.Handle("/reply", handler.Log(handler.Compress(Reply(a, am, cm, nm, ts))))
+func AttachReplyHandler(m *http.ServeMux, a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) {
+	m.Handle("/reply", handler.Log(handler.Compress(Reply(a, am, cm, nm, wm, ts))))
 }
 
-func Reply(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, ts *template.Template) http.Handler {
+func Reply(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, ts *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		account := a.CurrentAccount(w, r)
 		if account == nil {
@@ -27,6 +27,7 @@ func Reply(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyear
 		data := &ReplyData{
 			Live:    netgo.IsLive(),
 			Account: account,
+			Unread:  unreadNotifications(nm, account),
 		}
 		balance, err := am.AccountBalance(account.ID)
 		if err != nil {
@@ -139,17 +140,8 @@ func Reply(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyear
 				cost += fileSize
 			}
 
-			// Check account balance
-			if cost > balance {
-				err := conveyearthgo.ErrInsufficientBalance
-				log.Println(err)
-				data.Error = err.Error()
-				executeReplyTemplate(w, ts, data)
-				return
-			}
-
 			// Record message
-			response, _, err := cm.NewMessage(account, conversation, message, hashes, mimes, sizes)
+			response, _, err := replyToMessage(am, cm, nm, wm, account, data.Conversation, data.Message, reply, hashes, mimes, sizes)
 			if err != nil {
 				log.Println(err)
 				data.Error = err.Error()
@@ -157,30 +149,58 @@ func Reply(a authgo.Authenticator, am conveyearthgo.AccountManager, cm conveyear
 				return
 			}
 
-			// Send Reply Notification
-			if account.ID != data.Message.Author.ID {
-				if err := nm.NotifyResponse(data.Message.Author, account, conversation, data.Conversation.Topic, response.ID); err != nil {
-					log.Println(err)
-				}
-			}
-
-			// Send Mention Notifications
-			for _, username := range conveyearthgo.Mentions(reply) {
-				a, err := am.Account(username)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				if err := nm.NotifyMention(a, account, conversation, data.Conversation.Topic, response.ID); err != nil {
-					log.Println(err)
-				}
-			}
-
 			redirect.Conversation(w, r, conversation, response.ID)
 		}
 	})
 }
 
+// replyToMessage records the reply if the account's balance covers its cost, then notifies the parent's author, mentioned users, yield recipients, and followers, and emits webhook events.
+// Notification and webhook failures are only logged, as the reply has already been published.
+func replyToMessage(am conveyearthgo.AccountManager, cm conveyearthgo.ContentManager, nm conveyearthgo.NotificationManager, wm conveyearthgo.WebhookManager, account *authgo.Account, conversation *conveyearthgo.Conversation, parent *conveyearthgo.Message, reply string, hashes, mimes []string, sizes []int64) (*conveyearthgo.Message, []*conveyearthgo.File, error) {
+	if err := checkBalance(am, account, totalSize(sizes)); err != nil {
+		return nil, nil, err
+	}
+
+	response, files, err := cm.NewMessage(account, conversation.ID, parent.ID, hashes, mimes, sizes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Each user is notified at most once per message
+	notified := map[int64]bool{
+		account.ID: true,
+	}
+
+	// Send Reply Notification
+	if !notified[parent.Author.ID] {
+		notified[parent.Author.ID] = true
+		if err := nm.NotifyResponse(parent.Author, account, conversation.ID, conversation.Topic, response.ID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Send Mention Notifications
+	notifyMentions(am, nm, account, reply, conversation.ID, conversation.Topic, response.ID, notified)
+
+	// Send Yield Notifications
+	notifyYields(cm, nm, account, conversation.ID, conversation.Topic, response.ID, notified)
+
+	// Send Follower Notifications
+	notifyReplyFollowers(nm, account, conversation.ID, conversation.Topic, response.ID, notified)
+
+	// Send Webhook Events
+	if err := wm.Emit(&conveyearthgo.WebhookEvent{
+		Kind:         conveyearthgo.WEBHOOK_REPLY,
+		Actor:        account.Username,
+		Conversation: conversation.ID,
+		Message:      response.ID,
+		Topic:        conversation.Topic,
+	}, account.ID, parent.Author.ID); err != nil {
+		log.Println(err)
+	}
+	return response, files, nil
+}
+
 func executeReplyTemplate(w http.ResponseWriter, ts *template.Template, data *ReplyData) {
 	if err := ts.ExecuteTemplate(w, "reply.go.html", data); err != nil {
 		log.Println(err)
@@ -222,4 +242,5 @@ type ReplyData struct {
 	Message      *conveyearthgo.Message
 	Content      template.HTML
 	Reply        string
+	Unread       int64
 }
@@ -20,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReply(t *testing.T) {
@@ -36,11 +37,12 @@ func TestReply(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/reply?conversation=%d&message=%d", c.ID, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
 		response := httptest.NewRecorder()
@@ -58,11 +60,12 @@ func TestReply(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/reply?conversation=%d&message=%d", c.ID+1, m.ID), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -98,11 +101,12 @@ func TestReply(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		// Get
 		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/reply?conversation=%d&message=%d", c.ID, m.ID+1), nil)
 		request.AddCookie(auth.NewSignInSessionCookie(token))
@@ -136,10 +140,11 @@ func TestReply(t *testing.T) {
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		request := httptest.NewRequest(http.MethodGet, "/reply?conversation=10&message=10", nil)
 		response := httptest.NewRecorder()
 		mux.ServeHTTP(response, request)
@@ -156,11 +161,12 @@ func TestReply(t *testing.T) {
 		acc := authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("conversation", strconv.FormatInt(c.ID, 10))
@@ -184,14 +190,17 @@ func TestReply(t *testing.T) {
 		db := database.NewInMemory()
 		ev := authtest.NewEmailVerifier()
 		auth := authgo.NewAuthenticator(db, ev)
-		acc := authtest.NewTestAccount(t, auth)
+		authtest.NewTestAccount(t, auth)
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
-		cm := conveyearthgo.NewContentManager(db, fs)
-		c, m, _ := conveytest.NewConversation(t, cm, acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		acc2, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.Nil(t, err)
+		c, m, _ := conveytest.NewConversation(t, cm, acc2)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("conversation", strconv.FormatInt(c.ID, 10))
@@ -217,11 +226,12 @@ func TestReply(t *testing.T) {
 		token, _ := authtest.SignIn(t, auth)
 		am := conveyearthgo.NewAccountManager(db)
 		conveytest.NewPurchase(t, am, acc)
-		cm := conveyearthgo.NewContentManager(db, fs)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
 		c, m, _ := conveytest.NewConversation(t, cm, acc)
 		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
 		mux := http.NewServeMux()
-		handler.AttachReplyHandler(mux, auth, am, cm, nm, tmpl)
+		handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
 		var buffer bytes.Buffer
 		writer := multipart.NewWriter(&buffer)
 		_ = writer.WriteField("conversation", strconv.FormatInt(c.ID, 10))
@@ -239,8 +249,106 @@ func TestReply(t *testing.T) {
 		assert.Nil(t, err)
 		assert.True(t, strings.HasPrefix(u.String(), fmt.Sprintf("/conversation?id=%d#message", c.ID)))
 	})
-	// TODO Success Reply Notification
-	// TODO Success Mention Notification
+	t.Run("Success Notifies Parent And Ancestors", func(t *testing.T) {
+		for name, tt := range map[string]struct {
+			reply    string
+			follow   []string
+			expected map[string][]string
+		}{
+			"Response And Yield": {
+				reply: "Hi there!",
+				expected: map[string][]string{
+					"grand":  {conveyearthgo.NOTIFICATION_YIELD},
+					"parent": {conveyearthgo.NOTIFICATION_RESPONSE},
+				},
+			},
+			"Mention Supersedes Yield": {
+				reply: "Hi @" + authtest.TEST_USERNAME + "2!",
+				expected: map[string][]string{
+					"grand":  {conveyearthgo.NOTIFICATION_MENTION},
+					"parent": {conveyearthgo.NOTIFICATION_RESPONSE},
+				},
+			},
+			"Mention Of Parent Notified Once": {
+				reply: "Hi @" + authtest.TEST_USERNAME + "3!",
+				expected: map[string][]string{
+					"grand":  {conveyearthgo.NOTIFICATION_YIELD},
+					"parent": {conveyearthgo.NOTIFICATION_RESPONSE},
+				},
+			},
+			"Followers Notified Once": {
+				reply:  "Hi there!",
+				follow: []string{"grand", "follower"},
+				expected: map[string][]string{
+					"grand":    {conveyearthgo.NOTIFICATION_YIELD},
+					"parent":   {conveyearthgo.NOTIFICATION_RESPONSE},
+					"follower": {conveyearthgo.NOTIFICATION_REPLY},
+				},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := database.NewInMemory()
+				ev := authtest.NewEmailVerifier()
+				auth := authgo.NewAuthenticator(db, ev)
+				acc := authtest.NewTestAccount(t, auth)
+				token, _ := authtest.SignIn(t, auth)
+				grand, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+				assert.NoError(t, err)
+				parent, err := auth.NewAccount("3"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"3", []byte(authtest.TEST_PASSWORD))
+				assert.NoError(t, err)
+				follower, err := auth.NewAccount("4"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"4", []byte(authtest.TEST_PASSWORD))
+				assert.NoError(t, err)
+				accounts := map[string]*authgo.Account{
+					"grand":    grand,
+					"parent":   parent,
+					"follower": follower,
+				}
+				am := conveyearthgo.NewAccountManager(db)
+				conveytest.NewPurchase(t, am, acc)
+				cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+				c, m, _ := conveytest.NewConversation(t, cm, grand)
+				r, _ := conveytest.NewReply(t, cm, parent, c, m)
+				nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+				for _, f := range tt.follow {
+					assert.NoError(t, nm.Follow(accounts[f], conveyearthgo.FOLLOW_CONVERSATION, c.ID))
+				}
+				wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+				mux := http.NewServeMux()
+				handler.AttachReplyHandler(mux, auth, am, cm, nm, wm, tmpl)
+				var buffer bytes.Buffer
+				writer := multipart.NewWriter(&buffer)
+				_ = writer.WriteField("conversation", strconv.FormatInt(c.ID, 10))
+				_ = writer.WriteField("message", strconv.FormatInt(r.ID, 10))
+				_ = writer.WriteField("reply", tt.reply)
+				assert.NoError(t, writer.Close())
+				request := httptest.NewRequest(http.MethodPost, "/reply", &buffer)
+				request.Header.Set("Content-Type", writer.FormDataContentType())
+				request.AddCookie(auth.NewSignInSessionCookie(token))
+				response := httptest.NewRecorder()
+				mux.ServeHTTP(response, request)
+				result := response.Result()
+				assert.Equal(t, http.StatusFound, result.StatusCode)
+
+				for n, a := range accounts {
+					var kinds []string
+					assert.NoError(t, nm.Notifications(a, 10, func(notification *conveyearthgo.Notification) error {
+						assert.Equal(t, acc.Username, notification.Actor)
+						if notification.Kind == conveyearthgo.NOTIFICATION_YIELD {
+							assert.True(t, notification.Amount > 0)
+						}
+						kinds = append(kinds, notification.Kind)
+						return nil
+					}))
+					assert.Equal(t, tt.expected[n], kinds, n)
+				}
+
+				// Repliers are never notified of their own activity
+				unread, err := nm.UnreadNotifications(acc.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(0), unread)
+			})
+		}
+	})
 	// TODO Success Attachment
 	// TODO Success Content Carriage Return Removed
 }
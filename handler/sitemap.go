@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Search engines reject sitemaps with more URLs
+const MAXIMUM_SITEMAP_URLS = 50000
+
+func AttachSitemapHandler(m *http.ServeMux, cm conveyearthgo.ContentManager, pages []string, limit int64, cache string) {
+	m.Handle("/sitemap.xml", handler.Log(handler.Compress(handler.CacheControl(Sitemap(cm, pages, limit), cache))))
+}
+
+// Sitemap lists the given pages and every conversation. When there are more than limit URLs, it serves a sitemap index where page 0 lists the given pages, and each following page lists up to limit conversations.
+func Sitemap(cm conveyearthgo.ContentManager, pages []string, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+		count, err := cm.LookupConversationCount()
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		set := &SitemapURLSet{}
+		if page := strings.TrimSpace(r.FormValue("page")); page != "" {
+			p := netgo.ParseInt(page)
+			switch {
+			case p == 0 && page == "0":
+				set.URLs = sitemapPages(base, pages)
+			case p > 0 && (p-1)*limit < count:
+				set.URLs, err = sitemapConversations(cm, base, (p-1)*limit, limit)
+			default:
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+		} else if int64(len(pages))+count > limit {
+			index := &SitemapIndex{}
+			for p := int64(0); p == 0 || (p-1)*limit < count; p++ {
+				index.Sitemaps = append(index.Sitemaps, &SitemapLocation{
+					Location: fmt.Sprintf("%s/sitemap.xml?page=%d", base, p),
+				})
+			}
+			writeSitemap(w, index)
+			return
+		} else {
+			set.URLs = sitemapPages(base, pages)
+			var urls []*SitemapURL
+			urls, err = sitemapConversations(cm, base, 0, count)
+			set.URLs = append(set.URLs, urls...)
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeSitemap(w, set)
+	})
+}
+
+func sitemapPages(base string, pages []string) []*SitemapURL {
+	var urls []*SitemapURL
+	for _, p := range pages {
+		urls = append(urls, &SitemapURL{
+			Location: base + p,
+		})
+	}
+	return urls
+}
+
+func sitemapConversations(cm conveyearthgo.ContentManager, base string, offset, limit int64) ([]*SitemapURL, error) {
+	var urls []*SitemapURL
+	if err := cm.LookupConversationModifications(offset, limit, func(id int64, modified time.Time) error {
+		urls = append(urls, &SitemapURL{
+			Location:     fmt.Sprintf("%s/conversation?id=%d", base, id),
+			LastModified: modified.UTC().Format(time.RFC3339),
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func writeSitemap(w http.ResponseWriter, sitemap interface{}) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(sitemap); err != nil {
+		log.Println(err)
+	}
+}
+
+type SitemapIndex struct {
+	XMLName  xml.Name           `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []*SitemapLocation `xml:"sitemap"`
+}
+
+type SitemapLocation struct {
+	Location string `xml:"loc"`
+}
+
+type SitemapURLSet struct {
+	XMLName xml.Name      `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []*SitemapURL `xml:"url"`
+}
+
+type SitemapURL struct {
+	Location     string `xml:"loc"`
+	LastModified string `xml:"lastmod,omitempty"`
+}
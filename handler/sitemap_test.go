@@ -0,0 +1,146 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/filesystem"
+	"aletheiaware.com/conveyearthgo/handler"
+	"encoding/xml"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSitemap(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test")
+	assert.Nil(t, err)
+	fs := filesystem.NewOnDisk(dir)
+	defer os.RemoveAll(dir)
+	pages := []string{"/", "/about"}
+	base := fmt.Sprintf("%s://%s", conveyearthgo.Scheme(), conveyearthgo.Host())
+	setup := func(t *testing.T, conversations int, limit int64) (*http.ServeMux, conveyearthgo.ContentManager, []*conveyearthgo.Conversation, []*conveyearthgo.Message) {
+		t.Helper()
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		conveytest.NewPurchase(t, conveyearthgo.NewAccountManager(db), acc)
+		cm := conveyearthgo.NewContentManager(db, fs, conveyearthgo.FullRefund)
+		var (
+			cs []*conveyearthgo.Conversation
+			ms []*conveyearthgo.Message
+		)
+		for i := 0; i < conversations; i++ {
+			hash, size, err := cm.AddText([]byte(fmt.Sprintf("Hello World%d!", i)))
+			assert.NoError(t, err)
+			c, m, _, err := cm.NewConversation(acc, fmt.Sprintf("FooBar%d", i), []string{hash}, []string{conveyearthgo.MIME_TEXT_PLAIN}, []int64{size})
+			assert.NoError(t, err)
+			cs = append(cs, c)
+			ms = append(ms, m)
+		}
+		mux := http.NewServeMux()
+		handler.AttachSitemapHandler(mux, cm, pages, limit, "public, max-age=3600")
+		return mux, cm, cs, ms
+	}
+	get := func(mux *http.ServeMux, path string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		return response.Result()
+	}
+	locations := func(t *testing.T, result *http.Response) []string {
+		t.Helper()
+		set := &handler.SitemapURLSet{}
+		assert.Nil(t, xml.NewDecoder(result.Body).Decode(set))
+		var ls []string
+		for _, u := range set.URLs {
+			ls = append(ls, u.Location)
+		}
+		return ls
+	}
+	t.Run("Lists Pages And Conversations", func(t *testing.T) {
+		mux, _, cs, _ := setup(t, 2, 10)
+		result := get(mux, "/sitemap.xml")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, []string{
+			base + "/",
+			base + "/about",
+			fmt.Sprintf("%s/conversation?id=%d", base, cs[0].ID),
+			fmt.Sprintf("%s/conversation?id=%d", base, cs[1].ID),
+		}, locations(t, result))
+	})
+	t.Run("Last Modified By Latest Reply", func(t *testing.T) {
+		mux, cm, cs, ms := setup(t, 1, 10)
+		acc := cs[0].Author
+		hash, size, err := cm.AddText([]byte("Hi!"))
+		assert.NoError(t, err)
+		reply, _, err := cm.NewMessage(acc, cs[0].ID, ms[0].ID, []string{hash}, []string{conveyearthgo.MIME_TEXT_PLAIN}, []int64{size})
+		assert.NoError(t, err)
+		result := get(mux, "/sitemap.xml")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		set := &handler.SitemapURLSet{}
+		assert.Nil(t, xml.NewDecoder(result.Body).Decode(set))
+		assert.Equal(t, 3, len(set.URLs))
+		assert.Equal(t, reply.Created.UTC().Format(time.RFC3339), set.URLs[2].LastModified)
+	})
+	t.Run("Excludes Deleted Conversations", func(t *testing.T) {
+		mux, cm, cs, ms := setup(t, 2, 10)
+		_, err := cm.DeleteMessage(cs[0].Author, ms[0])
+		assert.NoError(t, err)
+		result := get(mux, "/sitemap.xml")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, []string{
+			base + "/",
+			base + "/about",
+			fmt.Sprintf("%s/conversation?id=%d", base, cs[1].ID),
+		}, locations(t, result))
+	})
+	t.Run("Index When Limit Exceeded", func(t *testing.T) {
+		mux, _, cs, _ := setup(t, 5, 2)
+		result := get(mux, "/sitemap.xml")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		index := &handler.SitemapIndex{}
+		assert.Nil(t, xml.NewDecoder(result.Body).Decode(index))
+		var ls []string
+		for _, s := range index.Sitemaps {
+			ls = append(ls, s.Location)
+		}
+		assert.Equal(t, []string{
+			base + "/sitemap.xml?page=0",
+			base + "/sitemap.xml?page=1",
+			base + "/sitemap.xml?page=2",
+			base + "/sitemap.xml?page=3",
+		}, ls)
+
+		assert.Equal(t, []string{base + "/", base + "/about"}, locations(t, get(mux, "/sitemap.xml?page=0")))
+		var all []string
+		for p := 1; p <= 3; p++ {
+			ls := locations(t, get(mux, fmt.Sprintf("/sitemap.xml?page=%d", p)))
+			assert.True(t, len(ls) <= 2)
+			all = append(all, ls...)
+		}
+		var expected []string
+		for _, c := range cs {
+			expected = append(expected, fmt.Sprintf("%s/conversation?id=%d", base, c.ID))
+		}
+		assert.Equal(t, expected, all)
+
+		result = get(mux, "/sitemap.xml?page=4")
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+		result = get(mux, "/sitemap.xml?page=abc")
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("Content Type", func(t *testing.T) {
+		mux, _, _, _ := setup(t, 0, 10)
+		result := get(mux, "/sitemap.xml")
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.True(t, strings.HasPrefix(result.Header.Get("Content-Type"), "application/xml"))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"aletheiaware.com/netgo"
 	"aletheiaware.com/netgo/handler"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/account"
@@ -56,16 +57,10 @@ func Stripe(a authgo.Authenticator, sm conveyearthgo.StripeManager, ts *template
 				log.Println(err)
 			} else {
 				for _, a := range bal.Available {
-					s := conveyearthgo.FormatStripeAmount(float64(a.Value), a.Currency)
-					if s != "" {
-						data.StripeAvailableBalance = append(data.StripeAvailableBalance, s)
-					}
+					data.StripeAvailableBalance = append(data.StripeAvailableBalance, conveyearthgo.FormatAmount(a.Value, string(a.Currency)))
 				}
 				for _, a := range bal.Pending {
-					s := conveyearthgo.FormatStripeAmount(float64(a.Value), a.Currency)
-					if s != "" {
-						data.StripePendingBalance = append(data.StripePendingBalance, s)
-					}
+					data.StripePendingBalance = append(data.StripePendingBalance, conveyearthgo.FormatAmount(a.Value, string(a.Currency)))
 				}
 			}
 
@@ -159,11 +154,11 @@ type StripeData struct {
 	StripeLoginLink        string
 }
 
-func AttachStripeWebhookHandler(m *http.ServeMux, am conveyearthgo.AccountManager, webhookSecretKey string, domain string) {
-	m.Handle("/stripe-webhook", handler.Log(StripeWebhook(am, webhookSecretKey, domain)))
+func AttachStripeWebhookHandler(m *http.ServeMux, am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, pm conveyearthgo.PayoutManager, bc conveyearthgo.BundleCatalog, webhookSecretKey string, domain string) {
+	m.Handle("/stripe-webhook", handler.Log(StripeWebhook(am, nm, pm, bc, webhookSecretKey, domain)))
 }
 
-func StripeWebhook(am conveyearthgo.AccountManager, webhookSecretKey string, domain string) http.Handler {
+func StripeWebhook(am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, pm conveyearthgo.PayoutManager, bc conveyearthgo.BundleCatalog, webhookSecretKey string, domain string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
 		if err != nil {
@@ -179,58 +174,119 @@ func StripeWebhook(am conveyearthgo.AccountManager, webhookSecretKey string, dom
 			return
 		}
 
-		fmt.Printf("Stripe Event: %+v\n", event)
+		log.Println("Stripe Event:", event.ID, event.Type)
 
 		// Unmarshal the event data into an appropriate struct depending on its Type
 		switch event.Type {
-		case "checkout.session.completed":
+		case "checkout.session.completed", "checkout.session.async_payment_succeeded":
 			var session stripe.CheckoutSession
 			if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
 				log.Println(err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			fmt.Printf("Checkout Session Completed: %+v\n", session)
-			// TODO
-			// - Send the customer a receipt email.
+			log.Println("Checkout Session:", session.ID)
 
-			// TODO check payment status == "paid"
-
-			d, ok := session.Metadata["domain"]
-			if !ok || !strings.Contains(d, domain) {
-				log.Println("Incorrect Domain")
+			if session.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+				// Delayed payment methods complete the session before the payment succeeds
+				log.Println("Awaiting Payment:", session.ID, session.PaymentStatus)
+				break
+			}
+			if err := recordPurchase(am, nm, &session, domain); err != nil {
+				log.Println(err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-
-			user := int64(0)
-			u, ok := session.Metadata["account_id"]
-			if !ok {
-				log.Println("Missing Account ID")
+		case "checkout.session.async_payment_failed":
+			var session stripe.CheckoutSession
+			if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+				log.Println(err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
-			} else {
-				if i, err := strconv.ParseInt(u, 10, 64); err != nil {
+			}
+			log.Println("Payment Failed:", session.ID)
+		case "charge.refunded":
+			var charge stripe.Charge
+			if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			log.Println("Charge:", charge.ID, charge.AmountRefunded)
+			if charge.PaymentIntent == nil {
+				log.Println("Missing Payment Intent:", charge.ID)
+				break
+			}
+			if err := adjustPurchase(am, nm, charge.PaymentIntent.ID, conveyearthgo.ADJUSTMENT_REFUND, charge.AmountRefunded); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		case "charge.dispute.created", "charge.dispute.closed":
+			var dispute stripe.Dispute
+			if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			log.Println("Dispute:", dispute.ID, dispute.Status)
+			if dispute.PaymentIntent == nil {
+				log.Println("Missing Payment Intent:", dispute.ID)
+				break
+			}
+			// Disputed coins are removed until the dispute is won
+			if err := adjustPurchase(am, nm, dispute.PaymentIntent.ID, conveyearthgo.ADJUSTMENT_DISPUTE, dispute.Amount); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			switch dispute.Status {
+			case stripe.DisputeStatusWon, stripe.DisputeStatusWarningClosed:
+				if err := adjustPurchase(am, nm, dispute.PaymentIntent.ID, conveyearthgo.ADJUSTMENT_DISPUTE_REVERSAL, dispute.Amount); err != nil {
 					log.Println(err)
-				} else {
-					user = int64(i)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
 				}
 			}
+		case "product.created", "product.updated", "product.deleted", "price.created", "price.updated", "price.deleted":
+			if err := bc.Refresh(); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		case "transfer.created", "transfer.updated", "transfer.reversed", "transfer.failed":
+			var transfer stripe.Transfer
+			if err := json.Unmarshal(event.Data.Raw, &transfer); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			log.Println("Transfer:", transfer.ID, event.Type, transfer.Reversed)
 
-			size := int64(1)
-			s, ok := session.Metadata["bundle_size"]
-			if !ok {
-				log.Println("Missing Bundle Size")
+			d, ok := transfer.Metadata["domain"]
+			if !ok || !strings.Contains(d, domain) {
+				log.Println("Incorrect Domain")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			payout, err := strconv.ParseInt(transfer.Metadata["payout_id"], 10, 64)
+			if err != nil {
+				log.Println("Missing Payout:", transfer.ID)
 				w.WriteHeader(http.StatusBadRequest)
 				return
-			} else {
-				if i, err := strconv.ParseInt(s, 10, 64); err != nil {
-					log.Println(err)
-				} else {
-					size = int64(i)
-				}
 			}
-			if err := am.NewPurchase(user, session.ID, session.Customer.ID, session.PaymentIntent.ID, string(session.Currency), session.AmountTotal, size); err != nil {
+
+			status := conveyearthgo.PAYOUT_PAID
+			if transfer.Reversed || event.Type == "transfer.reversed" {
+				status = conveyearthgo.PAYOUT_REVERSED
+			} else if event.Type == "transfer.failed" {
+				status = conveyearthgo.PAYOUT_FAILED
+			} else if event.Type == "transfer.updated" {
+				// Only reversals change the state of a payout
+				break
+			}
+			if err := pm.ReconcileTransfer(payout, transfer.ID, status); err != nil {
 				log.Println(err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -242,3 +298,85 @@ func StripeWebhook(am conveyearthgo.AccountManager, webhookSecretKey string, dom
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+func recordPurchase(am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, session *stripe.CheckoutSession, domain string) error {
+	d, ok := session.Metadata["domain"]
+	if !ok || !strings.Contains(d, domain) {
+		return errors.New("Incorrect Domain")
+	}
+
+	u, ok := session.Metadata["account_id"]
+	if !ok {
+		return errors.New("Missing Account ID")
+	}
+	user, err := strconv.ParseInt(u, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s, ok := session.Metadata["bundle_size"]
+	if !ok {
+		return errors.New("Missing Bundle Size")
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	var customer, paymentIntent string
+	if session.Customer != nil {
+		customer = session.Customer.ID
+	}
+	if session.PaymentIntent != nil {
+		paymentIntent = session.PaymentIntent.ID
+	}
+	if err := am.NewPurchase(user, session.ID, customer, paymentIntent, string(session.Currency), session.AmountTotal, size); err != nil {
+		if err == conveyearthgo.ErrPurchaseAlreadyRecorded {
+			// Stripe redelivered an event which has already been handled
+			log.Println("Duplicate Purchase:", session.ID)
+			return nil
+		}
+		return err
+	}
+
+	// Send the customer a receipt
+	account, err := am.AccountByID(user)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	balance, err := am.AccountBalance(user)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if err := nm.NotifyPurchase(account, size, session.AmountTotal, string(session.Currency), balance); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+func adjustPurchase(am conveyearthgo.AccountManager, nm conveyearthgo.NotificationManager, paymentIntent, reason string, amount int64) error {
+	user, delta, err := am.AdjustPurchase(paymentIntent, reason, amount)
+	if err != nil {
+		if err == conveyearthgo.ErrPurchaseNotFound {
+			// Payment was not for coins
+			log.Println("Unrecognized Payment Intent:", paymentIntent)
+			return nil
+		}
+		return err
+	}
+	if delta >= 0 {
+		return nil
+	}
+	balance, err := am.AccountBalance(user)
+	if err != nil {
+		return err
+	}
+	if balance < 0 {
+		if err := nm.NotifyNegativeBalance(user, balance, reason); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testWebhookSecret = "whsec_test"
+	testDomain        = "convey.earth"
+)
+
+func newCheckoutEvent(t *testing.T, kind, status, domain, account string) []byte {
+	t.Helper()
+	metadata := map[string]string{
+		"domain":      domain,
+		"bundle_size": "1000",
+	}
+	if account != "" {
+		metadata["account_id"] = account
+	}
+	return newStripeEvent(t, kind, map[string]interface{}{
+		"id":             "cs_test",
+		"object":         "checkout.session",
+		"amount_total":   500,
+		"currency":       "usd",
+		"customer":       "cus_test",
+		"payment_intent": "pi_test",
+		"payment_status": status,
+		"metadata":       metadata,
+	})
+}
+
+func newStripeEvent(t *testing.T, kind string, object map[string]interface{}) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          "evt_test",
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"type":        kind,
+		"data": map[string]interface{}{
+			"object": object,
+		},
+	})
+	assert.Nil(t, err)
+	return payload
+}
+
+func TestStripeWebhook(t *testing.T) {
+	for name, tt := range map[string]struct {
+		kind       string
+		status     string
+		domain     string
+		noAccount  bool
+		badSecret  bool
+		deliveries int
+		code       int
+		balance    int64
+	}{
+		"Completed And Paid": {
+			kind:       "checkout.session.completed",
+			status:     "paid",
+			deliveries: 1,
+			code:       http.StatusOK,
+			balance:    1000,
+		},
+		"Completed And Unpaid": {
+			kind:       "checkout.session.completed",
+			status:     "unpaid",
+			deliveries: 1,
+			code:       http.StatusOK,
+		},
+		"Async Payment Succeeded": {
+			kind:       "checkout.session.async_payment_succeeded",
+			status:     "paid",
+			deliveries: 1,
+			code:       http.StatusOK,
+			balance:    1000,
+		},
+		"Async Payment Failed": {
+			kind:       "checkout.session.async_payment_failed",
+			status:     "unpaid",
+			deliveries: 1,
+			code:       http.StatusOK,
+		},
+		"Duplicate Delivery": {
+			kind:       "checkout.session.completed",
+			status:     "paid",
+			deliveries: 3,
+			code:       http.StatusOK,
+			balance:    1000,
+		},
+		"Bad Signature": {
+			kind:       "checkout.session.completed",
+			status:     "paid",
+			badSecret:  true,
+			deliveries: 1,
+			code:       http.StatusBadRequest,
+		},
+		"Wrong Domain": {
+			kind:       "checkout.session.completed",
+			status:     "paid",
+			domain:     "example.com",
+			deliveries: 1,
+			code:       http.StatusBadRequest,
+		},
+		"Missing Account ID": {
+			kind:       "checkout.session.completed",
+			status:     "paid",
+			noAccount:  true,
+			deliveries: 1,
+			code:       http.StatusBadRequest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			ev := authtest.NewEmailVerifier()
+			auth := authgo.NewAuthenticator(db, ev)
+			acc := authtest.NewTestAccount(t, auth)
+			am := conveyearthgo.NewAccountManager(db)
+			ns := conveytest.NewNotificationSender()
+			nm := conveyearthgo.NewNotificationManager(db, ns)
+			pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+			mux := http.NewServeMux()
+			handler.AttachStripeWebhookHandler(mux, am, nm, pm, conveyearthgo.NewStaticBundleCatalog(), testWebhookSecret, testDomain)
+
+			domain := tt.domain
+			if domain == "" {
+				domain = testDomain
+			}
+			account := strconv.FormatInt(acc.ID, 10)
+			if tt.noAccount {
+				account = ""
+			}
+			payload := newCheckoutEvent(t, tt.kind, tt.status, domain, account)
+			secret := testWebhookSecret
+			if tt.badSecret {
+				secret = "whsec_wrong"
+			}
+
+			for i := 0; i < tt.deliveries; i++ {
+				request := httptest.NewRequest(http.MethodPost, "/stripe-webhook", strings.NewReader(string(payload)))
+				request.Header.Set("Stripe-Signature", conveytest.SignStripePayload(payload, secret))
+				response := httptest.NewRecorder()
+				mux.ServeHTTP(response, request)
+				assert.Equal(t, tt.code, response.Result().StatusCode)
+			}
+
+			balance, err := am.AccountBalance(acc.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.balance, balance)
+
+			// A receipt is sent once for each recorded purchase
+			if tt.balance > 0 {
+				assert.Equal(t, 1, len(ns.Receipts))
+				r := ns.Receipts[0]
+				assert.Equal(t, acc.ID, r.Account.ID)
+				assert.Equal(t, authtest.TEST_USERNAME, r.Account.Username)
+				assert.Equal(t, int64(1000), r.Size)
+				assert.Equal(t, int64(500), r.Amount)
+				assert.Equal(t, "usd", r.Currency)
+				assert.Equal(t, int64(1000), r.Balance)
+			} else {
+				assert.Empty(t, ns.Receipts)
+			}
+		})
+	}
+}
+
+func TestStripeWebhook_Adjustments(t *testing.T) {
+	refund := func(refunded int64) []byte {
+		return newStripeEvent(t, "charge.refunded", map[string]interface{}{
+			"id":              "ch_test",
+			"object":          "charge",
+			"amount":          500,
+			"amount_refunded": refunded,
+			"currency":        "usd",
+			"payment_intent":  "pi_test",
+		})
+	}
+	dispute := func(kind, status string) []byte {
+		return newStripeEvent(t, kind, map[string]interface{}{
+			"id":             "dp_test",
+			"object":         "dispute",
+			"amount":         500,
+			"currency":       "usd",
+			"payment_intent": "pi_test",
+			"status":         status,
+		})
+	}
+	for name, tt := range map[string]struct {
+		spent   int64
+		events  [][]byte
+		balance int64
+		alerts  int
+	}{
+		"Full Refund": {
+			events:  [][]byte{refund(500)},
+			balance: 0,
+		},
+		"Partial Refund Redelivered": {
+			events:  [][]byte{refund(250), refund(250)},
+			balance: 500,
+		},
+		"Refund After Spending": {
+			spent:   600,
+			events:  [][]byte{refund(500)},
+			balance: -600,
+			alerts:  1,
+		},
+		"Dispute Lost": {
+			events:  [][]byte{dispute("charge.dispute.created", "needs_response"), dispute("charge.dispute.closed", "lost")},
+			balance: 0,
+		},
+		"Dispute Won": {
+			spent:   600,
+			events:  [][]byte{dispute("charge.dispute.created", "needs_response"), dispute("charge.dispute.closed", "won")},
+			balance: 400,
+			alerts:  1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			ev := authtest.NewEmailVerifier()
+			auth := authgo.NewAuthenticator(db, ev)
+			acc := authtest.NewTestAccount(t, auth)
+			am := conveyearthgo.NewAccountManager(db)
+			ns := conveytest.NewNotificationSender()
+			nm := conveyearthgo.NewNotificationManager(db, ns)
+			pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+			mux := http.NewServeMux()
+			handler.AttachStripeWebhookHandler(mux, am, nm, pm, conveyearthgo.NewStaticBundleCatalog(), testWebhookSecret, testDomain)
+
+			assert.Nil(t, am.NewPurchase(acc.ID, "cs_test", "cus_test", "pi_test", "usd", 500, 1000))
+			if tt.spent > 0 {
+				_, err := db.CreateCharge(acc.ID, 0, 0, tt.spent, time.Now())
+				assert.Nil(t, err)
+			}
+
+			for _, payload := range tt.events {
+				request := httptest.NewRequest(http.MethodPost, "/stripe-webhook", strings.NewReader(string(payload)))
+				request.Header.Set("Stripe-Signature", conveytest.SignStripePayload(payload, testWebhookSecret))
+				response := httptest.NewRecorder()
+				mux.ServeHTTP(response, request)
+				assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+			}
+
+			balance, err := am.AccountBalance(acc.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.balance, balance)
+			assert.Equal(t, tt.alerts, len(ns.Alerts))
+		})
+	}
+}
+
+func TestStripeWebhook_Catalog(t *testing.T) {
+	fake := conveytest.NewFakeStripe(t)
+	db := database.NewInMemory()
+	am := conveyearthgo.NewAccountManager(db)
+	nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+	pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+	bc := conveyearthgo.NewStripeBundleCatalog(testDomain)
+	assert.Nil(t, bc.Refresh())
+	assert.Empty(t, bc.Bundles())
+	mux := http.NewServeMux()
+	handler.AttachStripeWebhookHandler(mux, am, nm, pm, bc, testWebhookSecret, testDomain)
+
+	id := fake.AddBundle(testDomain, "usd", 100, 1000)
+	payload := newStripeEvent(t, "price.created", map[string]interface{}{
+		"id":     id,
+		"object": "price",
+	})
+	request := httptest.NewRequest(http.MethodPost, "/stripe-webhook", strings.NewReader(string(payload)))
+	request.Header.Set("Stripe-Signature", conveytest.SignStripePayload(payload, testWebhookSecret))
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+
+	b, err := bc.Bundle(id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), b.Size)
+}
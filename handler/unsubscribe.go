@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func AttachUnsubscribeHandler(m *http.ServeMux, nm conveyearthgo.NotificationManager, secret []byte, ts *template.Template) {
+	m.Handle("/unsubscribe", handler.Log(handler.Compress(Unsubscribe(nm, secret, ts))))
+}
+
+// Unsubscribe disables a category of notifications for the user identified by a signed token, without requiring a session.
+func Unsubscribe(nm conveyearthgo.NotificationManager, secret []byte, ts *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// One-click requests post the form to the link, so the token may be in the query or the body
+		token := strings.TrimSpace(r.FormValue("token"))
+		user, category, err := conveyearthgo.ParseUnsubscribeToken(secret, token, time.Now())
+		if err == conveyearthgo.ErrUnsubscribeTokenExpired {
+			log.Println(err)
+			// Direct the user to their preferences, which require signing in
+			executeUnsubscribeTemplate(w, ts, &UnsubscribeData{
+				Live:    netgo.IsLive(),
+				Error:   err.Error(),
+				Expired: true,
+			})
+			return
+		} else if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		data := &UnsubscribeData{
+			Live:     netgo.IsLive(),
+			Token:    token,
+			Category: category,
+		}
+		switch r.Method {
+		case "GET":
+			// Confirm first, so link scanners don't unsubscribe users
+			executeUnsubscribeTemplate(w, ts, data)
+		case "POST":
+			if err := nm.Unsubscribe(user, category); err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+				executeUnsubscribeTemplate(w, ts, data)
+				return
+			}
+			data.Unsubscribed = true
+			executeUnsubscribeTemplate(w, ts, data)
+		}
+	})
+}
+
+func executeUnsubscribeTemplate(w http.ResponseWriter, ts *template.Template, data *UnsubscribeData) {
+	if err := ts.ExecuteTemplate(w, "unsubscribe.go.html", data); err != nil {
+		log.Println(err)
+	}
+}
+
+type UnsubscribeData struct {
+	Live         bool
+	Error        string
+	Token        string
+	Category     string
+	Unsubscribed bool
+	Expired      bool
+}
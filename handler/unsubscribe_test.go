@@ -0,0 +1,116 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	tmpl, err := template.New("unsubscribe.go.html").Parse(`{{.Error}}{{.Category}}:{{.Unsubscribed}}`)
+	assert.Nil(t, err)
+	secret := []byte("secret")
+	setup := func(t *testing.T) (*authgo.Account, conveyearthgo.NotificationManager, *http.ServeMux) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+		mux := http.NewServeMux()
+		handler.AttachUnsubscribeHandler(mux, nm, secret, tmpl)
+		return acc, nm, mux
+	}
+	t.Run("Returns 400 When Token Invalid", func(t *testing.T) {
+		_, _, mux := setup(t)
+		request := httptest.NewRequest(http.MethodPost, "/unsubscribe?token=1.gifts.forged", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Result().StatusCode)
+	})
+	t.Run("Does Not Unsubscribe When Token Expired", func(t *testing.T) {
+		acc, nm, mux := setup(t)
+		token := conveyearthgo.UnsubscribeToken(secret, acc.ID, conveyearthgo.UNSUBSCRIBE_GIFTS, time.Now().Add(-time.Hour))
+		values := url.Values{}
+		values.Add("token", token)
+		request := httptest.NewRequest(http.MethodPost, "/unsubscribe", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveyearthgo.ErrUnsubscribeTokenExpired.Error()+":false", string(body))
+		preferences, err := nm.NotificationPreferences(acc.ID)
+		assert.Nil(t, err)
+		assert.True(t, preferences.Gifts)
+	})
+	t.Run("Confirms Before Unsubscribing", func(t *testing.T) {
+		acc, nm, mux := setup(t)
+		token := conveyearthgo.UnsubscribeToken(secret, acc.ID, conveyearthgo.UNSUBSCRIBE_GIFTS, time.Now().Add(time.Hour))
+		request := httptest.NewRequest(http.MethodGet, "/unsubscribe?token="+token, nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "gifts:false", string(body))
+		preferences, err := nm.NotificationPreferences(acc.ID)
+		assert.Nil(t, err)
+		assert.True(t, preferences.Gifts)
+	})
+	t.Run("Unsubscribes From Form", func(t *testing.T) {
+		acc, nm, mux := setup(t)
+		token := conveyearthgo.UnsubscribeToken(secret, acc.ID, conveyearthgo.UNSUBSCRIBE_GIFTS, time.Now().Add(time.Hour))
+		values := url.Values{}
+		values.Add("token", token)
+		request := httptest.NewRequest(http.MethodPost, "/unsubscribe", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "gifts:true", string(body))
+		preferences, err := nm.NotificationPreferences(acc.ID)
+		assert.Nil(t, err)
+		assert.True(t, preferences.Responses)
+		assert.True(t, preferences.Mentions)
+		assert.False(t, preferences.Gifts)
+		assert.True(t, preferences.Digests)
+		assert.True(t, preferences.Yields)
+		assert.True(t, preferences.Replies)
+		assert.True(t, preferences.Publications)
+	})
+	t.Run("Unsubscribes From One-Click Post", func(t *testing.T) {
+		acc, nm, mux := setup(t)
+		token := conveyearthgo.UnsubscribeToken(secret, acc.ID, conveyearthgo.UNSUBSCRIBE_SUMMARIES, time.Now().Add(time.Hour))
+		request := httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+token, strings.NewReader("List-Unsubscribe=One-Click"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		preferences, err := nm.NotificationPreferences(acc.ID)
+		assert.Nil(t, err)
+		assert.False(t, preferences.Responses)
+		assert.False(t, preferences.Mentions)
+		assert.False(t, preferences.Gifts)
+		assert.True(t, preferences.Digests)
+		assert.False(t, preferences.Yields)
+		assert.False(t, preferences.Replies)
+		assert.False(t, preferences.Publications)
+	})
+}
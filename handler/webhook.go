@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/redirect"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/netgo"
+	"aletheiaware.com/netgo/handler"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func AttachWebhooksHandler(m *http.ServeMux, a authgo.Authenticator, wm conveyearthgo.WebhookManager, ts *template.Template, limit int64) {
+	m.Handle("/webhooks", handler.Log(handler.Compress(Webhooks(a, wm, ts, limit))))
+}
+
+// Webhooks lists the user's webhooks with their most recent deliveries, and registers or removes webhooks.
+func Webhooks(a authgo.Authenticator, wm conveyearthgo.WebhookManager, ts *template.Template, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account := a.CurrentAccount(w, r)
+		if account == nil {
+			redirect.SignIn(w, r, r.URL.String())
+			return
+		}
+		data := &WebhooksData{
+			Account: account,
+			Live:    netgo.IsLive(),
+		}
+		switch r.Method {
+		case "GET":
+			if err := populateWebhooksData(wm, account, limit, data); err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+			}
+			executeWebhooksTemplate(w, ts, data)
+		case "POST":
+			var err error
+			if remove := netgo.ParseInt(r.FormValue("remove")); remove != 0 {
+				err = wm.Remove(account.ID, remove)
+			} else {
+				address := strings.TrimSpace(r.FormValue("url"))
+				data.URL = address
+				_, err = wm.Register(account.ID, address)
+			}
+			if err != nil {
+				log.Println(err)
+				data.Error = err.Error()
+				if err := populateWebhooksData(wm, account, limit, data); err != nil {
+					log.Println(err)
+				}
+				executeWebhooksTemplate(w, ts, data)
+				return
+			}
+			http.Redirect(w, r, "/webhooks", http.StatusFound)
+		}
+	})
+}
+
+func executeWebhooksTemplate(w http.ResponseWriter, ts *template.Template, data *WebhooksData) {
+	if err := ts.ExecuteTemplate(w, "webhooks.go.html", data); err != nil {
+		log.Println(err)
+	}
+}
+
+func populateWebhooksData(wm conveyearthgo.WebhookManager, account *authgo.Account, limit int64, data *WebhooksData) error {
+	data.Webhooks = nil
+	if err := wm.Webhooks(account.ID, func(webhook *conveyearthgo.Webhook) error {
+		data.Webhooks = append(data.Webhooks, &WebhookData{
+			Webhook: webhook,
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, d := range data.Webhooks {
+		if err := wm.Deliveries(account.ID, d.ID, limit, func(delivery *conveyearthgo.WebhookDelivery) error {
+			d.Deliveries = append(d.Deliveries, delivery)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type WebhooksData struct {
+	Live     bool
+	Error    string
+	Account  *authgo.Account
+	URL      string
+	Webhooks []*WebhookData
+}
+
+type WebhookData struct {
+	*conveyearthgo.Webhook
+	Deliveries []*conveyearthgo.WebhookDelivery
+}
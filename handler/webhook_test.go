@@ -0,0 +1,135 @@
+package handler_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"aletheiaware.com/conveyearthgo/handler"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	tmpl, err := template.New("webhooks.go.html").Parse(`{{.Error}}{{range .Webhooks}}{{.URL}}:{{len .Deliveries}}{{end}}`)
+	assert.Nil(t, err)
+	t.Run("Redirects When Not Signed In", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		mux := http.NewServeMux()
+		handler.AttachWebhooksHandler(mux, auth, wm, tmpl, 10)
+		request := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		u, err := result.Location()
+		assert.Nil(t, err)
+		assert.Equal(t, "/sign-in?next=%2Fwebhooks", u.String())
+	})
+	t.Run("Lists Webhooks And Deliveries", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		_, err := wm.Register(acc.ID, "https://example.com/hook")
+		assert.NoError(t, err)
+		// Operator webhooks are not listed
+		_, err = wm.Register(0, "https://example.com/operator")
+		assert.NoError(t, err)
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_CONVERSATION,
+			Conversation: 1,
+		}, acc.ID))
+		mux := http.NewServeMux()
+		handler.AttachWebhooksHandler(mux, auth, wm, tmpl, 10)
+		request := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "https://example.com/hook:1", string(body))
+	})
+	t.Run("Adds Webhook", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		mux := http.NewServeMux()
+		handler.AttachWebhooksHandler(mux, auth, wm, tmpl, 10)
+		values := url.Values{}
+		values.Add("url", "https://example.com/hook")
+		request := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		var urls []string
+		assert.NoError(t, wm.Webhooks(acc.ID, func(w *conveyearthgo.Webhook) error {
+			urls = append(urls, w.URL)
+			return nil
+		}))
+		assert.Equal(t, []string{"https://example.com/hook"}, urls)
+	})
+	t.Run("Returns Error When URL Invalid", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		mux := http.NewServeMux()
+		handler.AttachWebhooksHandler(mux, auth, wm, tmpl, 10)
+		values := url.Values{}
+		values.Add("url", "example.com")
+		request := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		body, err := io.ReadAll(result.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, conveyearthgo.ErrWebhookURLInvalid.Error(), string(body))
+	})
+	t.Run("Removes Webhook", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		token, _ := authtest.SignIn(t, auth)
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		webhook, err := wm.Register(acc.ID, "https://example.com/hook")
+		assert.NoError(t, err)
+		mux := http.NewServeMux()
+		handler.AttachWebhooksHandler(mux, auth, wm, tmpl, 10)
+		values := url.Values{}
+		values.Add("remove", strconv.FormatInt(webhook.ID, 10))
+		request := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(auth.NewSignInSessionCookie(token))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		result := response.Result()
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		assert.NoError(t, wm.Webhooks(acc.ID, func(w *conveyearthgo.Webhook) error {
+			t.Fatal("Unexpected Webhook", w.ID)
+			return nil
+		}))
+	})
+}
@@ -3,28 +3,147 @@ package conveyearthgo
 import (
 	"aletheiaware.com/authgo"
 	authemail "aletheiaware.com/authgo/email"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net/url"
+	"strings"
+	"time"
 )
 
+const (
+	NOTIFICATION_RESPONSE    = "response"
+	NOTIFICATION_MENTION     = "mention"
+	NOTIFICATION_GIFT        = "gift"
+	NOTIFICATION_YIELD       = "yield"
+	NOTIFICATION_REPLY       = "reply"
+	NOTIFICATION_PUBLICATION = "publication"
+)
+
+const (
+	FREQUENCY_IMMEDIATE = "immediate"
+	FREQUENCY_HOURLY    = "hourly"
+	FREQUENCY_DAILY     = "daily"
+
+	MAXIMUM_SUMMARY_NOTIFICATIONS = 100
+)
+
+// summaryPeriods maps each batched frequency to the time between summaries.
+var summaryPeriods = map[string]time.Duration{
+	FREQUENCY_HOURLY: time.Hour,
+	FREQUENCY_DAILY:  24 * time.Hour,
+}
+
+var ErrDigestAlreadyDelivered = errors.New("Digest Already Delivered")
+
+// Notification is an entry in a user's inbox.
+type Notification struct {
+	ID             int64
+	Kind           string
+	Actor          string
+	ConversationID int64
+	MessageID      int64
+	Topic          string
+	Amount         int64
+	Read           bool
+	Created        time.Time
+}
+
+// Link returns the path of the conversation, or message, the notification is about.
+func (n *Notification) Link() string {
+	if n.MessageID == 0 {
+		return fmt.Sprintf("/conversation?id=%d", n.ConversationID)
+	}
+	return fmt.Sprintf("/conversation?id=%d#message%d", n.ConversationID, n.MessageID)
+}
+
+// NotificationPreferences records which kinds of notification a user receives, how often, and by which channels.
+type NotificationPreferences struct {
+	ID           int64
+	User         int64
+	Responses    bool
+	Mentions     bool
+	Gifts        bool
+	Digests      bool
+	Yields       bool
+	Replies      bool
+	Publications bool
+	Frequency    string
+	Email        bool
+	Push         bool
+}
+
+// NewNotificationPreferences returns the preferences of a user who has not chosen any, with every notification enabled and sent immediately.
+func NewNotificationPreferences(user int64) *NotificationPreferences {
+	return &NotificationPreferences{
+		User:         user,
+		Responses:    true,
+		Mentions:     true,
+		Gifts:        true,
+		Digests:      true,
+		Yields:       true,
+		Replies:      true,
+		Publications: true,
+		Frequency:    FREQUENCY_IMMEDIATE,
+		Email:        true,
+		Push:         true,
+	}
+}
+
 type NotificationDatabase interface {
-	SelectNotificationPreferences(int64) (int64, bool, bool, bool, bool, error)
-	UpdateNotificationPreferences(int64, int64, bool, bool, bool, bool) (int64, error)
+	FollowDatabase
+	SelectNotificationPreferences(int64) (*NotificationPreferences, error)
+	UpdateNotificationPreferences(*NotificationPreferences) (int64, error)
+	SelectNotificationSummaryRecipients(string, func(*authgo.Account, time.Time) error) error
+	UpdateNotificationSummary(int64, time.Time) (int64, error)
+	CreateNotification(int64, string, int64, int64, int64, int64, time.Time) (int64, error)
+	SelectNotifications(int64, int64, func(int64, string, string, int64, int64, string, int64, bool, time.Time) error) error
+	SelectUnreadNotificationCount(int64) (int64, error)
+	UpdateNotificationRead(int64, int64, time.Time) (int64, error)
+	UpdateAllNotificationsRead(int64, time.Time) (int64, error)
+	SelectDigestRecipients(string, func(*authgo.Account) error) error
+	CreateDigestDelivery(string, int64, time.Time) (int64, error)
 }
 
 type NotificationManager interface {
-	NotificationPreferences(int64) (int64, bool, bool, bool, bool, error)
-	SetNotificationPreferences(int64, int64, bool, bool, bool, bool) error
+	NotificationPreferences(int64) (*NotificationPreferences, error)
+	SetNotificationPreferences(*NotificationPreferences) error
+	Unsubscribe(int64, string) error
+	Follow(*authgo.Account, string, int64) error
+	Unfollow(*authgo.Account, string, int64) error
+	Following(*authgo.Account, string, int64) (bool, error)
+	Followers(string, int64, func(*authgo.Account) error) error
 	NotifyResponse(*authgo.Account, *authgo.Account, int64, string, int64) error
 	NotifyMention(*authgo.Account, *authgo.Account, int64, string, int64) error
 	NotifyGift(*authgo.Account, *authgo.Account, int64, string, int64, int64) error
+	NotifyYield(*authgo.Account, *authgo.Account, int64, string, int64, int64) error
+	NotifyReply(*authgo.Account, *authgo.Account, int64, string, int64) error
+	NotifyPublication(*authgo.Account, *authgo.Account, int64, string) error
+	NotifyReversal(*authgo.Account, string, int64, string, int64, int64) error
+	NotifyNegativeBalance(int64, int64, string) error
+	NotifyPurchase(*authgo.Account, int64, int64, string, int64) error
+	NotifyDigest(*authgo.Account, string) error
+	DigestRecipients(string, func(*authgo.Account) error) error
+	SendSummaries(time.Time) (int64, error)
+	Notifications(*authgo.Account, int64, func(*Notification) error) error
+	UnreadNotifications(int64) (int64, error)
+	MarkNotificationRead(*authgo.Account, int64) error
+	MarkAllNotificationsRead(*authgo.Account) error
 }
 
 type NotificationSender interface {
 	SendResponseNotification(*authgo.Account, string, string, int64, int64) error
 	SendMentionNotification(*authgo.Account, string, string, int64, int64) error
 	SendGiftNotification(*authgo.Account, string, string, int64, int64, int64) error
+	SendYieldNotification(*authgo.Account, string, string, int64, int64, int64) error
+	SendReplyNotification(*authgo.Account, string, string, int64, int64) error
+	SendPublicationNotification(*authgo.Account, string, string, int64) error
+	SendReversalNotification(*authgo.Account, string, string, int64, int64, int64) error
+	SendNegativeBalanceAlert(int64, int64, string) error
+	SendPurchaseReceipt(*authgo.Account, int64, int64, string, int64) error
+	SendDigestNotification(*authgo.Account, string) error
+	SendNotificationSummary(*authgo.Account, string, []*Notification) error
 }
 
 func NewNotificationManager(db NotificationDatabase, sender NotificationSender) NotificationManager {
@@ -39,58 +158,283 @@ type notificationManager struct {
 	sender   NotificationSender
 }
 
-func (m *notificationManager) NotificationPreferences(user int64) (int64, bool, bool, bool, bool, error) {
+func (m *notificationManager) NotificationPreferences(user int64) (*NotificationPreferences, error) {
 	return m.database.SelectNotificationPreferences(user)
 }
 
-func (m *notificationManager) SetNotificationPreferences(id, user int64, responses, mentions, gifts, digests bool) error {
-	_, err := m.database.UpdateNotificationPreferences(id, user, responses, mentions, gifts, digests)
+func (m *notificationManager) SetNotificationPreferences(preferences *NotificationPreferences) error {
+	_, err := m.database.UpdateNotificationPreferences(preferences)
 	return err
 }
 
+func (m *notificationManager) Unsubscribe(user int64, category string) error {
+	p, err := m.database.SelectNotificationPreferences(user)
+	if err != nil {
+		return err
+	}
+	switch category {
+	case UNSUBSCRIBE_RESPONSES:
+		p.Responses = false
+	case UNSUBSCRIBE_MENTIONS:
+		p.Mentions = false
+	case UNSUBSCRIBE_GIFTS:
+		p.Gifts = false
+	case UNSUBSCRIBE_YIELDS:
+		p.Yields = false
+	case UNSUBSCRIBE_DIGESTS:
+		p.Digests = false
+	case UNSUBSCRIBE_REPLIES:
+		p.Replies = false
+	case UNSUBSCRIBE_PUBLICATIONS:
+		p.Publications = false
+	case UNSUBSCRIBE_SUMMARIES:
+		// Summaries include every batched category
+		p.Responses = false
+		p.Mentions = false
+		p.Gifts = false
+		p.Yields = false
+		p.Replies = false
+		p.Publications = false
+	default:
+		return ErrUnsubscribeCategoryUnrecognized
+	}
+	if _, err := m.database.UpdateNotificationPreferences(p); err != nil {
+		return err
+	}
+	log.Println("Unsubscribed", user, "from", category)
+	return nil
+}
+
 func (m *notificationManager) NotifyResponse(author, responder *authgo.Account, conversation int64, topic string, message int64) error {
-	_, responses, _, _, _, err := m.database.SelectNotificationPreferences(author.ID)
+	m.record(author.ID, NOTIFICATION_RESPONSE, responder.ID, conversation, message, 0)
+	p, err := m.database.SelectNotificationPreferences(author.ID)
 	if err != nil {
 		return err
 	}
-	if !responses {
+	if !p.Responses {
 		// User disabled reponse notifications
 		return nil
 	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
 	return m.sender.SendResponseNotification(author, responder.Username, topic, conversation, message)
 }
 
 func (m *notificationManager) NotifyMention(author, mentioner *authgo.Account, conversation int64, topic string, message int64) error {
-	_, _, mentions, _, _, err := m.database.SelectNotificationPreferences(author.ID)
+	m.record(author.ID, NOTIFICATION_MENTION, mentioner.ID, conversation, message, 0)
+	p, err := m.database.SelectNotificationPreferences(author.ID)
 	if err != nil {
 		return err
 	}
-	if !mentions {
+	if !p.Mentions {
 		// User disabled mention notifications
 		return nil
 	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
 	return m.sender.SendMentionNotification(author, mentioner.Username, topic, conversation, message)
 }
 
 func (m *notificationManager) NotifyGift(author, mentioner *authgo.Account, conversation int64, topic string, message, amount int64) error {
-	_, _, _, gifts, _, err := m.database.SelectNotificationPreferences(author.ID)
+	m.record(author.ID, NOTIFICATION_GIFT, mentioner.ID, conversation, message, amount)
+	p, err := m.database.SelectNotificationPreferences(author.ID)
 	if err != nil {
 		return err
 	}
-	if !gifts {
+	if !p.Gifts {
 		// User disabled gift notifications
 		return nil
 	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
 	return m.sender.SendGiftNotification(author, mentioner.Username, topic, conversation, message, amount)
 }
 
-func NewSmtpNotificationSender(scheme, host, server, identity, sender string, templates *template.Template) NotificationSender {
+func (m *notificationManager) NotifyYield(author, replier *authgo.Account, conversation int64, topic string, message, amount int64) error {
+	m.record(author.ID, NOTIFICATION_YIELD, replier.ID, conversation, message, amount)
+	p, err := m.database.SelectNotificationPreferences(author.ID)
+	if err != nil {
+		return err
+	}
+	if !p.Yields {
+		// User disabled yield notifications
+		return nil
+	}
+	if p.Frequency != FREQUENCY_IMMEDIATE {
+		// Included in the user's next summary
+		return nil
+	}
+	return m.sender.SendYieldNotification(author, replier.Username, topic, conversation, message, amount)
+}
+
+func (m *notificationManager) NotifyReversal(account *authgo.Account, reason string, conversation int64, topic string, message, amount int64) error {
+	// Changes to a user's balance are always notified
+	return m.sender.SendReversalNotification(account, reason, topic, conversation, message, amount)
+}
+
+func (m *notificationManager) NotifyNegativeBalance(user, balance int64, reason string) error {
+	// Operators are always alerted
+	return m.sender.SendNegativeBalanceAlert(user, balance, reason)
+}
+
+func (m *notificationManager) NotifyPurchase(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	// Receipts are always sent
+	return m.sender.SendPurchaseReceipt(account, size, amount, currency, balance)
+}
+
+func (m *notificationManager) NotifyDigest(account *authgo.Account, edition string) error {
+	p, err := m.database.SelectNotificationPreferences(account.ID)
+	if err != nil {
+		return err
+	}
+	if !p.Digests {
+		// User disabled digest notifications
+		return nil
+	}
+	if err := m.sender.SendDigestNotification(account, edition); err != nil {
+		return err
+	}
+	// Record the delivery so an interrupted send can be resumed
+	id, err := m.database.CreateDigestDelivery(edition, account.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Println("Created Digest Delivery", id)
+	return nil
+}
+
+// DigestRecipients calls the callback with each user who opted in to digests and has not yet been sent the given edition.
+func (m *notificationManager) DigestRecipients(edition string, callback func(*authgo.Account) error) error {
+	return m.database.SelectDigestRecipients(edition, callback)
+}
+
+// SendSummaries sends each user who batches their notifications a summary of the unread notifications received since their last summary, once their period has elapsed.
+func (m *notificationManager) SendSummaries(now time.Time) (int64, error) {
+	var sent int64
+	for _, frequency := range []string{FREQUENCY_HOURLY, FREQUENCY_DAILY} {
+		period := summaryPeriods[frequency]
+		var (
+			accounts []*authgo.Account
+			sinces   []time.Time
+		)
+		if err := m.database.SelectNotificationSummaryRecipients(frequency, func(account *authgo.Account, last time.Time) error {
+			if last.Add(period).After(now) {
+				// Not yet due
+				return nil
+			}
+			if last.IsZero() {
+				last = now.Add(-period)
+			}
+			accounts = append(accounts, account)
+			sinces = append(sinces, last)
+			return nil
+		}); err != nil {
+			return sent, err
+		}
+		for i, account := range accounts {
+			notifications, err := m.pending(account, sinces[i], now)
+			if err != nil {
+				return sent, err
+			}
+			if len(notifications) > 0 {
+				if err := m.sender.SendNotificationSummary(account, frequency, notifications); err != nil {
+					// Retried with the next summary
+					log.Println(err)
+					continue
+				}
+				sent++
+			}
+			if _, err := m.database.UpdateNotificationSummary(account.ID, now); err != nil {
+				return sent, err
+			}
+		}
+	}
+	return sent, nil
+}
+
+// pending returns the unread notifications, of the kinds the user has enabled, received in the given period.
+func (m *notificationManager) pending(account *authgo.Account, since, until time.Time) ([]*Notification, error) {
+	p, err := m.database.SelectNotificationPreferences(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := map[string]bool{
+		NOTIFICATION_RESPONSE:    p.Responses,
+		NOTIFICATION_MENTION:     p.Mentions,
+		NOTIFICATION_GIFT:        p.Gifts,
+		NOTIFICATION_YIELD:       p.Yields,
+		NOTIFICATION_REPLY:       p.Replies,
+		NOTIFICATION_PUBLICATION: p.Publications,
+	}
+	var notifications []*Notification
+	if err := m.Notifications(account, MAXIMUM_SUMMARY_NOTIFICATIONS, func(n *Notification) error {
+		if n.Read || !enabled[n.Kind] || !n.Created.After(since) || n.Created.After(until) {
+			return nil
+		}
+		notifications = append(notifications, n)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (m *notificationManager) Notifications(account *authgo.Account, limit int64, callback func(*Notification) error) error {
+	return m.database.SelectNotifications(account.ID, limit, func(id int64, kind, actor string, conversation, message int64, topic string, amount int64, read bool, created time.Time) error {
+		return callback(&Notification{
+			ID:             id,
+			Kind:           kind,
+			Actor:          actor,
+			ConversationID: conversation,
+			MessageID:      message,
+			Topic:          topic,
+			Amount:         amount,
+			Read:           read,
+			Created:        created,
+		})
+	})
+}
+
+func (m *notificationManager) UnreadNotifications(user int64) (int64, error) {
+	return m.database.SelectUnreadNotificationCount(user)
+}
+
+func (m *notificationManager) MarkNotificationRead(account *authgo.Account, id int64) error {
+	_, err := m.database.UpdateNotificationRead(id, account.ID, time.Now())
+	return err
+}
+
+func (m *notificationManager) MarkAllNotificationsRead(account *authgo.Account) error {
+	_, err := m.database.UpdateAllNotificationsRead(account.ID, time.Now())
+	return err
+}
+
+// record adds a notification to the user's inbox, regardless of their email preferences.
+func (m *notificationManager) record(user int64, kind string, actor, conversation, message, amount int64) {
+	id, err := m.database.CreateNotification(user, kind, actor, conversation, message, amount, time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println("Created Notification", id)
+}
+
+// NewSmtpNotificationSender sends notifications by email, with unsubscribe links signed by the given secret.
+func NewSmtpNotificationSender(scheme, host, server, identity, sender, operator string, secret []byte, templates *template.Template) NotificationSender {
 	return &smtpNotificationSender{
 		scheme:    scheme,
 		host:      host,
 		server:    server,
 		identity:  identity,
 		sender:    sender,
+		operator:  operator,
+		secret:    secret,
 		templates: templates,
 	}
 }
@@ -100,26 +444,30 @@ type smtpNotificationSender struct {
 	host,
 	server,
 	identity,
-	sender string
+	sender,
+	operator string
+	secret    []byte
 	templates *template.Template
 }
 
 func (s *smtpNotificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
 	log.Println("Notifying", account.Email, "of response")
 	data := struct {
-		From      string
-		To        string
-		Topic     string
-		Username  string
-		Responder string
-		Link      string
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Responder   string
+		Link        string
+		Unsubscribe string
 	}{
-		From:      s.sender,
-		To:        account.Email,
-		Topic:     topic,
-		Username:  account.Username,
-		Responder: responder,
-		Link:      createLink(s.scheme, s.host, conversation, message),
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Responder:   responder,
+		Link:        createLink(s.scheme, s.host, conversation, message),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_RESPONSES),
 	}
 	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-response.go.html"), data)
 }
@@ -127,19 +475,21 @@ func (s *smtpNotificationSender) SendResponseNotification(account *authgo.Accoun
 func (s *smtpNotificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
 	log.Println("Notifying", account.Email, "of mention")
 	data := struct {
-		From      string
-		To        string
-		Topic     string
-		Username  string
-		Mentioner string
-		Link      string
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Mentioner   string
+		Link        string
+		Unsubscribe string
 	}{
-		From:      s.sender,
-		To:        account.Email,
-		Topic:     topic,
-		Username:  account.Username,
-		Mentioner: mentioner,
-		Link:      createLink(s.scheme, s.host, conversation, message),
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Mentioner:   mentioner,
+		Link:        createLink(s.scheme, s.host, conversation, message),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_MENTIONS),
 	}
 	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-mention.go.html"), data)
 }
@@ -147,23 +497,244 @@ func (s *smtpNotificationSender) SendMentionNotification(account *authgo.Account
 func (s *smtpNotificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
 	log.Println("Notifying", account.Email, "of gift")
 	data := struct {
-		From     string
-		To       string
-		Topic    string
-		Username string
-		Gifter   string
-		Amount   int64
-		Link     string
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Gifter      string
+		Amount      int64
+		Link        string
+		Unsubscribe string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Gifter:      gifter,
+		Amount:      amount,
+		Link:        createLink(s.scheme, s.host, conversation, message),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_GIFTS),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-gift.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendYieldNotification(account *authgo.Account, replier, topic string, conversation, message, amount int64) error {
+	log.Println("Notifying", account.Email, "of yield")
+	data := struct {
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Replier     string
+		Amount      int64
+		Link        string
+		Unsubscribe string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Replier:     replier,
+		Amount:      amount,
+		Link:        createLink(s.scheme, s.host, conversation, message),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_YIELDS),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-yield.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendReplyNotification(account *authgo.Account, replier, topic string, conversation, message int64) error {
+	log.Println("Notifying", account.Email, "of reply")
+	data := struct {
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Replier     string
+		Link        string
+		Unsubscribe string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Replier:     replier,
+		Link:        createLink(s.scheme, s.host, conversation, message),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_REPLIES),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-reply.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendPublicationNotification(account *authgo.Account, author, topic string, conversation int64) error {
+	log.Println("Notifying", account.Email, "of publication")
+	data := struct {
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Author      string
+		Link        string
+		Unsubscribe string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Author:      author,
+		Link:        createLink(s.scheme, s.host, conversation, 0),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_PUBLICATIONS),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-publication.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendReversalNotification(account *authgo.Account, reason, topic string, conversation, message, amount int64) error {
+	log.Println("Notifying", account.Email, "of reversal")
+	credit := amount > 0
+	if !credit {
+		amount = -amount
+	}
+	data := struct {
+		From        string
+		To          string
+		Topic       string
+		Username    string
+		Reason      string
+		Credit      bool
+		Amount      int64
+		Link        string
+		Preferences string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Topic:       topic,
+		Username:    account.Username,
+		Reason:      reason,
+		Credit:      credit,
+		Amount:      amount,
+		Link:        reversalsLink(s.scheme, s.host),
+		Preferences: preferencesLink(s.scheme, s.host),
+	}
+	if message != 0 {
+		// Otherwise the opening message was deleted along with its conversation
+		data.Link = createLink(s.scheme, s.host, conversation, message)
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-reversal.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendNegativeBalanceAlert(user, balance int64, reason string) error {
+	log.Println("Alerting", s.operator, "of negative balance", user, balance, reason)
+	if s.operator == "" {
+		// No operator to alert
+		return nil
+	}
+	data := struct {
+		From    string
+		To      string
+		Host    string
+		User    int64
+		Balance int64
+		Reason  string
+	}{
+		From:    s.sender,
+		To:      s.operator,
+		Host:    s.host,
+		User:    user,
+		Balance: balance,
+		Reason:  reason,
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, s.operator, s.templates.Lookup("email-alert-negative-balance.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendPurchaseReceipt(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	log.Println("Notifying", account.Email, "of purchase")
+	data := struct {
+		From        string
+		To          string
+		Username    string
+		Size        int64
+		Amount      string
+		Currency    string
+		Balance     int64
+		Link        string
+		Preferences string
 	}{
 		From:     s.sender,
 		To:       account.Email,
-		Topic:    topic,
 		Username: account.Username,
-		Gifter:   gifter,
-		Amount:   amount,
-		Link:     createLink(s.scheme, s.host, conversation, message),
+		Size:     size,
+		// The code follows the amount, so the symbol cannot be mistaken for another currency
+		Amount:      FormatLocalAmount(amount, currency, currency),
+		Currency:    strings.ToUpper(currency),
+		Balance:     balance,
+		Link:        fmt.Sprintf("%s://%s/account", s.scheme, s.host),
+		Preferences: preferencesLink(s.scheme, s.host),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-receipt.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendDigestNotification(account *authgo.Account, edition string) error {
+	log.Println("Notifying", account.Email, "of digest", edition)
+	data := struct {
+		From        string
+		To          string
+		Username    string
+		Edition     string
+		Link        string
+		Unsubscribe string
+	}{
+		From:        s.sender,
+		To:          account.Email,
+		Username:    account.Username,
+		Edition:     edition,
+		Link:        fmt.Sprintf("%s://%s/digest?edition=%s", s.scheme, s.host, url.QueryEscape(edition)),
+		Unsubscribe: UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_DIGESTS),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-digest.go.html"), data)
+}
+
+func (s *smtpNotificationSender) SendNotificationSummary(account *authgo.Account, frequency string, notifications []*Notification) error {
+	log.Println("Notifying", account.Email, "of", len(notifications), frequency, "notifications")
+	data := struct {
+		From          string
+		To            string
+		Username      string
+		Frequency     string
+		Notifications []*Notification
+		Base          string
+		Link          string
+		Unsubscribe   string
+	}{
+		From:          s.sender,
+		To:            account.Email,
+		Username:      account.Username,
+		Frequency:     frequency,
+		Notifications: notifications,
+		Base:          fmt.Sprintf("%s://%s", s.scheme, s.host),
+		Link:          fmt.Sprintf("%s://%s/notifications", s.scheme, s.host),
+		Unsubscribe:   UnsubscribeLink(s.scheme, s.host, s.secret, account.ID, UNSUBSCRIBE_SUMMARIES),
+	}
+	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-summary.go.html"), data)
+}
+
+// RunNotificationSummaries sends due summaries at the given interval, until the returned function is called.
+func RunNotificationSummaries(m NotificationManager, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := m.SendSummaries(time.Now()); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
 	}
-	return authemail.SendEmail(s.server, s.identity, s.sender, account.Email, s.templates.Lookup("email-notification-gift.go.html"), data)
 }
 
 func createLink(scheme, host string, conversation, message int64) string {
@@ -172,3 +743,13 @@ func createLink(scheme, host string, conversation, message int64) string {
 	}
 	return fmt.Sprintf("%s://%s/conversation?id=%d#message%d", scheme, host, conversation, message)
 }
+
+// reversalsLink points to the list of reversals on the account page.
+func reversalsLink(scheme, host string) string {
+	return fmt.Sprintf("%s://%s/account#reversals", scheme, host)
+}
+
+// preferencesLink is included in transactional emails, which are sent regardless of preferences, so users can still manage the rest.
+func preferencesLink(scheme, host string) string {
+	return fmt.Sprintf("%s://%s/account-notification-preferences", scheme, host)
+}
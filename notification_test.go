@@ -0,0 +1,202 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNotificationManager_Inbox(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	author := authtest.NewTestAccount(t, auth)
+	actor, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+	assert.NoError(t, err)
+	conversation, err := db.CreateConversation(author.ID, "Test", author.Created)
+	assert.NoError(t, err)
+	nm := conveyearthgo.NewNotificationManager(db, conveytest.NewNotificationSender())
+
+	// Notifications are recorded even when emails are disabled
+	assert.NoError(t, nm.SetNotificationPreferences(&conveyearthgo.NotificationPreferences{
+		User:         author.ID,
+		Responses:    false,
+		Mentions:     false,
+		Gifts:        false,
+		Digests:      false,
+		Yields:       false,
+		Replies:      false,
+		Publications: false,
+		Frequency:    conveyearthgo.FREQUENCY_IMMEDIATE,
+	}))
+	assert.NoError(t, nm.NotifyResponse(author, actor, conversation, "Test", 1))
+	assert.NoError(t, nm.NotifyMention(author, actor, conversation, "Test", 2))
+	assert.NoError(t, nm.NotifyGift(author, actor, conversation, "Test", 3, 50))
+
+	unread, err := nm.UnreadNotifications(author.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), unread)
+
+	var notifications []*conveyearthgo.Notification
+	assert.NoError(t, nm.Notifications(author, 10, func(n *conveyearthgo.Notification) error {
+		notifications = append(notifications, n)
+		return nil
+	}))
+	assert.Equal(t, 3, len(notifications))
+	kinds := make(map[string]*conveyearthgo.Notification)
+	for _, n := range notifications {
+		assert.Equal(t, actor.Username, n.Actor)
+		assert.Equal(t, "Test", n.Topic)
+		assert.Equal(t, conversation, n.ConversationID)
+		assert.False(t, n.Read)
+		kinds[n.Kind] = n
+	}
+	assert.Equal(t, int64(50), kinds[conveyearthgo.NOTIFICATION_GIFT].Amount)
+
+	// Mark one as read
+	assert.NoError(t, nm.MarkNotificationRead(author, kinds[conveyearthgo.NOTIFICATION_RESPONSE].ID))
+	unread, err = nm.UnreadNotifications(author.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), unread)
+
+	// Others cannot mark a user's notifications as read
+	assert.NoError(t, nm.MarkNotificationRead(actor, kinds[conveyearthgo.NOTIFICATION_MENTION].ID))
+	unread, err = nm.UnreadNotifications(author.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), unread)
+
+	// Mark all as read
+	assert.NoError(t, nm.MarkAllNotificationsRead(author))
+	unread, err = nm.UnreadNotifications(author.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), unread)
+
+	// Limit
+	count := 0
+	assert.NoError(t, nm.Notifications(author, 2, func(n *conveyearthgo.Notification) error {
+		assert.True(t, n.Read)
+		count++
+		return nil
+	}))
+	assert.Equal(t, 2, count)
+}
+
+func TestNotificationManager_Digest(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	subscriber := authtest.NewTestAccount(t, auth)
+	unsubscriber, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+	assert.NoError(t, err)
+	ns := conveytest.NewNotificationSender()
+	nm := conveyearthgo.NewNotificationManager(db, ns)
+
+	assert.NoError(t, nm.SetNotificationPreferences(&conveyearthgo.NotificationPreferences{
+		User:         unsubscriber.ID,
+		Responses:    true,
+		Mentions:     true,
+		Gifts:        true,
+		Digests:      false,
+		Yields:       true,
+		Replies:      true,
+		Publications: true,
+		Frequency:    conveyearthgo.FREQUENCY_IMMEDIATE,
+	}))
+
+	recipients := func() (usernames []string) {
+		assert.NoError(t, nm.DigestRecipients("2021-07", func(a *authgo.Account) error {
+			usernames = append(usernames, a.Username)
+			return nil
+		}))
+		return
+	}
+
+	// Only opted-in users are recipients
+	assert.Equal(t, []string{subscriber.Username}, recipients())
+
+	// Delivered users are no longer recipients
+	assert.NoError(t, nm.NotifyDigest(subscriber, "2021-07"))
+	assert.Equal(t, []string{subscriber.Username + " 2021-07"}, ns.Digests)
+	assert.Empty(t, recipients())
+
+	// Other editions are unaffected
+	assert.NoError(t, nm.DigestRecipients("2021-08", func(a *authgo.Account) error {
+		assert.Equal(t, subscriber.Username, a.Username)
+		return nil
+	}))
+
+	// Opted-out users are never sent the digest
+	assert.NoError(t, nm.NotifyDigest(unsubscriber, "2021-07"))
+	assert.Equal(t, 1, len(ns.Digests))
+}
+
+func TestNotificationManager_SendSummaries(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	author := authtest.NewTestAccount(t, auth)
+	actor, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+	assert.NoError(t, err)
+	conversation, err := db.CreateConversation(author.ID, "Test", author.Created)
+	assert.NoError(t, err)
+	ns := conveytest.NewNotificationSender()
+	nm := conveyearthgo.NewNotificationManager(db, ns)
+
+	// Gifts are disabled, others are batched hourly
+	assert.NoError(t, nm.SetNotificationPreferences(&conveyearthgo.NotificationPreferences{
+		User:         author.ID,
+		Responses:    true,
+		Mentions:     true,
+		Gifts:        false,
+		Digests:      true,
+		Yields:       true,
+		Replies:      true,
+		Publications: true,
+		Frequency:    conveyearthgo.FREQUENCY_HOURLY,
+	}))
+
+	assert.NoError(t, nm.NotifyResponse(author, actor, conversation, "Test", 1))
+	assert.NoError(t, nm.NotifyMention(author, actor, conversation, "Test", 2))
+	assert.NoError(t, nm.NotifyGift(author, actor, conversation, "Test", 3, 50))
+	assert.NoError(t, nm.NotifyYield(author, actor, conversation, "Test", 4, 5))
+
+	// Immediate users are not summarised
+	assert.NoError(t, nm.NotifyResponse(actor, author, conversation, "Test", 5))
+
+	// Read notifications are not summarised
+	var kinds []string
+	assert.NoError(t, nm.Notifications(author, 10, func(n *conveyearthgo.Notification) error {
+		if n.Kind == conveyearthgo.NOTIFICATION_MENTION {
+			assert.NoError(t, nm.MarkNotificationRead(author, n.ID))
+		}
+		return nil
+	}))
+
+	now := time.Now()
+	sent, err := nm.SendSummaries(now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sent)
+	assert.Equal(t, 1, len(ns.Summaries))
+	assert.Equal(t, author.Username, ns.Summaries[0].Account.Username)
+	assert.Equal(t, conveyearthgo.FREQUENCY_HOURLY, ns.Summaries[0].Frequency)
+	for _, n := range ns.Summaries[0].Notifications {
+		kinds = append(kinds, n.Kind)
+	}
+	assert.ElementsMatch(t, []string{conveyearthgo.NOTIFICATION_RESPONSE, conveyearthgo.NOTIFICATION_YIELD}, kinds)
+
+	// Not due until the period has elapsed
+	assert.NoError(t, nm.NotifyResponse(author, actor, conversation, "Test", 6))
+	sent, err = nm.SendSummaries(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), sent)
+
+	// Only notifications since the last summary are included
+	sent, err = nm.SendSummaries(now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sent)
+	assert.Equal(t, 2, len(ns.Summaries))
+	assert.Equal(t, 1, len(ns.Summaries[1].Notifications))
+	assert.Equal(t, int64(6), ns.Summaries[1].Notifications[0].MessageID)
+}
@@ -0,0 +1,373 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	OUTBOX_RESPONSE    = "response"
+	OUTBOX_MENTION     = "mention"
+	OUTBOX_GIFT        = "gift"
+	OUTBOX_YIELD       = "yield"
+	OUTBOX_REPLY       = "reply"
+	OUTBOX_PUBLICATION = "publication"
+	OUTBOX_REVERSAL    = "reversal"
+	OUTBOX_ALERT       = "alert"
+	OUTBOX_RECEIPT     = "receipt"
+	OUTBOX_DIGEST      = "digest"
+	OUTBOX_SUMMARY     = "summary"
+)
+
+// OUTBOX_LEASE is how long a claimed entry is hidden from other workers while it is being sent.
+const OUTBOX_LEASE = 10 * time.Minute
+
+var (
+	ErrOutboxEntryNotFound     = errors.New("Outbox Entry Not Found")
+	ErrOutboxEntryUnrecognized = errors.New("Unrecognized Outbox Entry")
+)
+
+// OutboxEntry is a notification waiting to be sent, or which has been abandoned after repeated failures.
+type OutboxEntry struct {
+	ID        int64
+	Kind      string
+	Payload   []byte
+	Attempts  int64
+	LastError string
+	Created   time.Time
+	Dead      time.Time
+}
+
+type OutboxDatabase interface {
+	SelectUserByID(int64) (string, string, time.Time, error)
+	CreateOutboxEntry(string, []byte, time.Time) (int64, error)
+	ClaimDueOutboxEntries(time.Time, time.Time, int64, func(int64, string, []byte, int64) error) error
+	SelectDeadOutboxEntries(func(int64, string, []byte, int64, string, time.Time, time.Time) error) error
+	UpdateOutboxEntrySent(int64, time.Time) (int64, error)
+	UpdateOutboxEntryRetry(int64, int64, time.Time, string) (int64, error)
+	UpdateOutboxEntryDead(int64, int64, time.Time, string) (int64, error)
+	UpdateOutboxEntryReplay(int64, time.Time) (int64, error)
+}
+
+// outboxPayload holds the arguments of a queued NotificationSender call.
+// Recipients are stored by ID and looked up when sent, so a changed email address is respected.
+type outboxPayload struct {
+	Recipient     int64           `json:",omitempty"`
+	Actor         string          `json:",omitempty"`
+	Topic         string          `json:",omitempty"`
+	Conversation  int64           `json:",omitempty"`
+	Message       int64           `json:",omitempty"`
+	Amount        int64           `json:",omitempty"`
+	Reason        string          `json:",omitempty"`
+	User          int64           `json:",omitempty"`
+	Balance       int64           `json:",omitempty"`
+	Size          int64           `json:",omitempty"`
+	Currency      string          `json:",omitempty"`
+	Edition       string          `json:",omitempty"`
+	Frequency     string          `json:",omitempty"`
+	Notifications []*Notification `json:",omitempty"`
+}
+
+// NewOutboxNotificationSender queues notifications in the outbox, to be sent by an OutboxManager.
+func NewOutboxNotificationSender(db OutboxDatabase) NotificationSender {
+	return &outboxNotificationSender{
+		database: db,
+	}
+}
+
+type outboxNotificationSender struct {
+	database OutboxDatabase
+}
+
+func (s *outboxNotificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
+	return s.enqueue(OUTBOX_RESPONSE, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        responder,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+	})
+}
+
+func (s *outboxNotificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
+	return s.enqueue(OUTBOX_MENTION, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        mentioner,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+	})
+}
+
+func (s *outboxNotificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
+	return s.enqueue(OUTBOX_GIFT, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        gifter,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+		Amount:       amount,
+	})
+}
+
+func (s *outboxNotificationSender) SendYieldNotification(account *authgo.Account, replier, topic string, conversation, message, amount int64) error {
+	return s.enqueue(OUTBOX_YIELD, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        replier,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+		Amount:       amount,
+	})
+}
+
+func (s *outboxNotificationSender) SendReplyNotification(account *authgo.Account, replier, topic string, conversation, message int64) error {
+	return s.enqueue(OUTBOX_REPLY, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        replier,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+	})
+}
+
+func (s *outboxNotificationSender) SendPublicationNotification(account *authgo.Account, author, topic string, conversation int64) error {
+	return s.enqueue(OUTBOX_PUBLICATION, &outboxPayload{
+		Recipient:    account.ID,
+		Actor:        author,
+		Topic:        topic,
+		Conversation: conversation,
+	})
+}
+
+func (s *outboxNotificationSender) SendReversalNotification(account *authgo.Account, reason, topic string, conversation, message, amount int64) error {
+	return s.enqueue(OUTBOX_REVERSAL, &outboxPayload{
+		Recipient:    account.ID,
+		Reason:       reason,
+		Topic:        topic,
+		Conversation: conversation,
+		Message:      message,
+		Amount:       amount,
+	})
+}
+
+func (s *outboxNotificationSender) SendNegativeBalanceAlert(user, balance int64, reason string) error {
+	return s.enqueue(OUTBOX_ALERT, &outboxPayload{
+		User:    user,
+		Balance: balance,
+		Reason:  reason,
+	})
+}
+
+func (s *outboxNotificationSender) SendPurchaseReceipt(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	return s.enqueue(OUTBOX_RECEIPT, &outboxPayload{
+		Recipient: account.ID,
+		Size:      size,
+		Amount:    amount,
+		Currency:  currency,
+		Balance:   balance,
+	})
+}
+
+func (s *outboxNotificationSender) SendDigestNotification(account *authgo.Account, edition string) error {
+	return s.enqueue(OUTBOX_DIGEST, &outboxPayload{
+		Recipient: account.ID,
+		Edition:   edition,
+	})
+}
+
+func (s *outboxNotificationSender) SendNotificationSummary(account *authgo.Account, frequency string, notifications []*Notification) error {
+	return s.enqueue(OUTBOX_SUMMARY, &outboxPayload{
+		Recipient:     account.ID,
+		Frequency:     frequency,
+		Notifications: notifications,
+	})
+}
+
+func (s *outboxNotificationSender) enqueue(kind string, payload *outboxPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	id, err := s.database.CreateOutboxEntry(kind, data, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Println("Created Outbox Entry", id, kind)
+	return nil
+}
+
+type OutboxManager interface {
+	Process(int64) (int64, error)
+	DeadLetters(func(*OutboxEntry) error) error
+	Replay(int64) error
+}
+
+// NewOutboxManager sends queued notifications with the given sender, retrying failures with exponential backoff until the maximum attempts are reached.
+func NewOutboxManager(db OutboxDatabase, sender NotificationSender, attempts int64, backoff time.Duration) OutboxManager {
+	return &outboxManager{
+		database: db,
+		sender:   sender,
+		attempts: attempts,
+		backoff:  backoff,
+	}
+}
+
+type outboxManager struct {
+	database OutboxDatabase
+	sender   NotificationSender
+	attempts int64
+	backoff  time.Duration
+}
+
+// Process claims and sends up to limit entries that are due, and returns the number sent.
+// Claimed entries are hidden from other workers until they are sent, rescheduled, or the lease expires.
+func (m *outboxManager) Process(limit int64) (int64, error) {
+	now := time.Now()
+	var entries []*OutboxEntry
+	if err := m.database.ClaimDueOutboxEntries(now, now.Add(OUTBOX_LEASE), limit, func(id int64, kind string, payload []byte, attempts int64) error {
+		entries = append(entries, &OutboxEntry{
+			ID:       id,
+			Kind:     kind,
+			Payload:  payload,
+			Attempts: attempts,
+		})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	var sent int64
+	for _, e := range entries {
+		err := m.dispatch(e.Kind, e.Payload)
+		if err == nil {
+			if _, err := m.database.UpdateOutboxEntrySent(e.ID, time.Now()); err != nil {
+				return sent, err
+			}
+			sent++
+			continue
+		}
+		log.Println("Outbox Entry", e.ID, "Failed:", err)
+		reason := err.Error()
+		attempts := e.Attempts + 1
+		if attempts >= m.attempts || err == ErrOutboxEntryUnrecognized || err == authgo.ErrUsernameNotRegistered {
+			if _, err := m.database.UpdateOutboxEntryDead(e.ID, attempts, time.Now(), reason); err != nil {
+				return sent, err
+			}
+			log.Println("Outbox Entry", e.ID, "Abandoned After", attempts, "Attempts")
+			continue
+		}
+		if _, err := m.database.UpdateOutboxEntryRetry(e.ID, attempts, now.Add(Backoff(m.backoff, attempts)), reason); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (m *outboxManager) DeadLetters(callback func(*OutboxEntry) error) error {
+	return m.database.SelectDeadOutboxEntries(func(id int64, kind string, payload []byte, attempts int64, lastError string, created, dead time.Time) error {
+		return callback(&OutboxEntry{
+			ID:        id,
+			Kind:      kind,
+			Payload:   payload,
+			Attempts:  attempts,
+			LastError: lastError,
+			Created:   created,
+			Dead:      dead,
+		})
+	})
+}
+
+// Replay returns a dead entry to the queue with its attempts reset.
+func (m *outboxManager) Replay(id int64) error {
+	count, err := m.database.UpdateOutboxEntryReplay(id, time.Now())
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	log.Println("Replayed Outbox Entry", id)
+	return nil
+}
+
+func (m *outboxManager) dispatch(kind string, data []byte) error {
+	p := &outboxPayload{}
+	if err := json.Unmarshal(data, p); err != nil {
+		log.Println(err)
+		return ErrOutboxEntryUnrecognized
+	}
+	if kind == OUTBOX_ALERT {
+		// Alerts are sent to operators, not the user
+		return m.sender.SendNegativeBalanceAlert(p.User, p.Balance, p.Reason)
+	}
+	username, email, created, err := m.database.SelectUserByID(p.Recipient)
+	if err != nil {
+		return err
+	}
+	account := &authgo.Account{
+		ID:       p.Recipient,
+		Username: username,
+		Email:    email,
+		Created:  created,
+	}
+	switch kind {
+	case OUTBOX_RESPONSE:
+		return m.sender.SendResponseNotification(account, p.Actor, p.Topic, p.Conversation, p.Message)
+	case OUTBOX_MENTION:
+		return m.sender.SendMentionNotification(account, p.Actor, p.Topic, p.Conversation, p.Message)
+	case OUTBOX_GIFT:
+		return m.sender.SendGiftNotification(account, p.Actor, p.Topic, p.Conversation, p.Message, p.Amount)
+	case OUTBOX_YIELD:
+		return m.sender.SendYieldNotification(account, p.Actor, p.Topic, p.Conversation, p.Message, p.Amount)
+	case OUTBOX_REPLY:
+		return m.sender.SendReplyNotification(account, p.Actor, p.Topic, p.Conversation, p.Message)
+	case OUTBOX_PUBLICATION:
+		return m.sender.SendPublicationNotification(account, p.Actor, p.Topic, p.Conversation)
+	case OUTBOX_REVERSAL:
+		return m.sender.SendReversalNotification(account, p.Reason, p.Topic, p.Conversation, p.Message, p.Amount)
+	case OUTBOX_RECEIPT:
+		return m.sender.SendPurchaseReceipt(account, p.Size, p.Amount, p.Currency, p.Balance)
+	case OUTBOX_DIGEST:
+		return m.sender.SendDigestNotification(account, p.Edition)
+	case OUTBOX_SUMMARY:
+		return m.sender.SendNotificationSummary(account, p.Frequency, p.Notifications)
+	}
+	return ErrOutboxEntryUnrecognized
+}
+
+// Backoff returns the delay before the given attempt, doubling the base delay after each failure, up to a day.
+func Backoff(base time.Duration, attempts int64) time.Duration {
+	delay := base
+	for i := int64(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= 24*time.Hour {
+			return 24 * time.Hour
+		}
+	}
+	return delay
+}
+
+// RunOutbox processes the outbox at the given interval, until the returned function is called.
+func RunOutbox(m OutboxManager, interval time.Duration, limit int64) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := m.Process(limit); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,161 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for name, tt := range map[string]struct {
+		attempts int64
+		expected time.Duration
+	}{
+		"First":   {attempts: 1, expected: time.Minute},
+		"Second":  {attempts: 2, expected: 2 * time.Minute},
+		"Fifth":   {attempts: 5, expected: 16 * time.Minute},
+		"Capped":  {attempts: 20, expected: 24 * time.Hour},
+		"Initial": {attempts: 0, expected: time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, conveyearthgo.Backoff(time.Minute, tt.attempts))
+		})
+	}
+}
+
+func TestOutboxManager(t *testing.T) {
+	t.Run("Sends Queued Notifications", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		ns := conveytest.NewNotificationSender()
+		nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+		om := conveyearthgo.NewOutboxManager(db, ns, 3, time.Minute)
+
+		assert.NoError(t, nm.NotifyPurchase(acc, 1000, 500, "usd", 1000))
+		assert.Empty(t, ns.Receipts)
+
+		sent, err := om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), sent)
+		assert.Equal(t, 1, len(ns.Receipts))
+		assert.Equal(t, acc.Username, ns.Receipts[0].Account.Username)
+		assert.Equal(t, int64(500), ns.Receipts[0].Amount)
+		assert.Equal(t, "usd", ns.Receipts[0].Currency)
+
+		// Sent entries are not sent again
+		sent, err = om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), sent)
+		assert.Equal(t, 1, len(ns.Receipts))
+	})
+	t.Run("Looks Up Recipient When Sent", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		ns := conveytest.NewNotificationSender()
+		nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+		om := conveyearthgo.NewOutboxManager(db, ns, 3, time.Minute)
+
+		assert.NoError(t, nm.NotifyPurchase(acc, 1000, 500, "usd", 1000))
+
+		// Email changed while the entry was queued
+		db.AccountEmail[acc.Username] = "changed@example.com"
+
+		sent, err := om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), sent)
+		assert.Equal(t, 1, len(ns.Receipts))
+		assert.Equal(t, acc.ID, ns.Receipts[0].Account.ID)
+		assert.Equal(t, "changed@example.com", ns.Receipts[0].Account.Email)
+	})
+	t.Run("Claimed Entries Are Skipped", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		ns := conveytest.NewNotificationSender()
+		nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+		om := conveyearthgo.NewOutboxManager(db, ns, 3, time.Minute)
+
+		assert.NoError(t, nm.NotifyPurchase(acc, 1000, 500, "usd", 1000))
+
+		// Another worker claims the entry
+		now := time.Now()
+		var claimed int
+		assert.NoError(t, db.ClaimDueOutboxEntries(now, now.Add(conveyearthgo.OUTBOX_LEASE), 10, func(int64, string, []byte, int64) error {
+			claimed++
+			return nil
+		}))
+		assert.Equal(t, 1, claimed)
+
+		sent, err := om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), sent)
+		assert.Empty(t, ns.Receipts)
+	})
+	t.Run("Waits Before Retrying", func(t *testing.T) {
+		db := database.NewInMemory()
+		ns := conveytest.NewNotificationSender()
+		ns.Fail = true
+		nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+		om := conveyearthgo.NewOutboxManager(db, ns, 3, time.Hour)
+
+		assert.NoError(t, nm.NotifyNegativeBalance(1, -100, "refund"))
+
+		sent, err := om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), sent)
+
+		// Not yet due
+		ns.Fail = false
+		sent, err = om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), sent)
+		assert.Empty(t, ns.Alerts)
+	})
+	t.Run("Dead Letters Can Be Replayed", func(t *testing.T) {
+		db := database.NewInMemory()
+		ns := conveytest.NewNotificationSender()
+		ns.Fail = true
+		nm := conveyearthgo.NewNotificationManager(db, conveyearthgo.NewOutboxNotificationSender(db))
+		om := conveyearthgo.NewOutboxManager(db, ns, 2, 0)
+
+		assert.NoError(t, nm.NotifyNegativeBalance(1, -100, "refund"))
+
+		for i := 0; i < 3; i++ {
+			sent, err := om.Process(10)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(0), sent)
+		}
+
+		var dead []*conveyearthgo.OutboxEntry
+		assert.NoError(t, om.DeadLetters(func(e *conveyearthgo.OutboxEntry) error {
+			dead = append(dead, e)
+			return nil
+		}))
+		assert.Equal(t, 1, len(dead))
+		assert.Equal(t, conveyearthgo.OUTBOX_ALERT, dead[0].Kind)
+		assert.Equal(t, int64(2), dead[0].Attempts)
+		assert.Equal(t, conveytest.ErrNotificationFailed.Error(), dead[0].LastError)
+
+		ns.Fail = false
+		assert.NoError(t, om.Replay(dead[0].ID))
+		sent, err := om.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), sent)
+		assert.Equal(t, []string{"1 -100 refund"}, ns.Alerts)
+
+		assert.NoError(t, om.DeadLetters(func(e *conveyearthgo.OutboxEntry) error {
+			t.Fatal("Unexpected Dead Letter", e.ID)
+			return nil
+		}))
+
+		// Only dead letters can be replayed
+		assert.Equal(t, conveyearthgo.ErrOutboxEntryNotFound, om.Replay(dead[0].ID))
+	})
+}
@@ -0,0 +1,301 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/transfer"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	PAYOUT_PENDING  = "pending"
+	PAYOUT_PAID     = "paid"
+	PAYOUT_FAILED   = "failed"
+	PAYOUT_REVERSED = "reversed"
+)
+
+const (
+	// PAYOUT_RETRY_DELAY gives the request which created a payout time to finish before its transfer is retried.
+	PAYOUT_RETRY_DELAY = time.Minute
+	// PAYOUT_RETRY_WINDOW is how long Stripe keeps idempotency keys, after which a retry could transfer twice.
+	PAYOUT_RETRY_WINDOW = 24 * time.Hour
+)
+
+var (
+	ErrPayoutBelowMinimum        = errors.New("Payout Below Minimum")
+	ErrPayoutExceedsEligible     = errors.New("Payout Exceeds Eligible Balance")
+	ErrPayoutNotFound            = errors.New("Payout Not Found")
+	ErrStripeAccountNotConnected = errors.New("Stripe Account Not Connected")
+)
+
+type Payout struct {
+	ID             int64
+	Account        *authgo.Account
+	StripeAccount  string
+	StripeTransfer string
+	Currency       string
+	Amount         int64
+	Size           int64
+	Status         string
+	Created        time.Time
+}
+
+type PayoutDatabase interface {
+	AccountDatabase
+	CreatePayout(int64, string, string, int64, int64, time.Time, func() error) (int64, error)
+	UpdatePayoutTransfer(int64, string) (int64, error)
+	UpdatePayoutStatus(int64, string) (int64, error)
+	SelectPayout(int64) (string, string, error)
+	SelectPayouts(int64, func(int64, string, string, string, int64, int64, string, time.Time) error) error
+	SelectPendingPayouts(time.Time, time.Time, func(int64, int64, string, string, int64) error) error
+}
+
+// EligibleBalance is the part of a user's balance which was earned, rather than purchased, and so may be withdrawn.
+func EligibleBalance(db AccountDatabase, user int64) (int64, error) {
+	balance, err := AccountBalance(db, user)
+	if err != nil {
+		return 0, err
+	}
+	yields, err := db.SelectYieldsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	awards, err := db.SelectAwardsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	received, err := db.SelectGiftsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	payouts, err := db.SelectPayoutsForUser(user)
+	if err != nil {
+		return 0, err
+	}
+	earned := yields + awards + received - payouts
+	if balance < earned {
+		earned = balance
+	}
+	if earned < 0 {
+		earned = 0
+	}
+	return earned, nil
+}
+
+type PayoutManager interface {
+	Currency() string
+	Rate() int64
+	Minimum() int64
+	EligibleBalance(int64) (int64, error)
+	NewPayout(*authgo.Account, string, int64) (*Payout, error)
+	ReconcileTransfer(int64, string, string) error
+	RetryTransfers() (int64, error)
+	LookupPayouts(*authgo.Account, func(*Payout) error) error
+}
+
+// RunPayouts retries the transfers of pending payouts every interval until the returned function is called.
+func RunPayouts(m PayoutManager, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := m.RetryTransfers(); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// NewPayoutManager converts coins into Stripe Transfers of the given currency, where rate is the number of coins per minor unit of currency, and minimum is the smallest number of coins which can be withdrawn.
+func NewPayoutManager(db PayoutDatabase, currency string, rate, minimum int64) PayoutManager {
+	return &payoutManager{
+		database: db,
+		currency: currency,
+		rate:     rate,
+		minimum:  minimum,
+	}
+}
+
+type payoutManager struct {
+	database PayoutDatabase
+	currency string
+	rate     int64
+	minimum  int64
+}
+
+func (m *payoutManager) Currency() string {
+	return m.currency
+}
+
+func (m *payoutManager) Rate() int64 {
+	return m.rate
+}
+
+func (m *payoutManager) Minimum() int64 {
+	return m.minimum
+}
+
+func (m *payoutManager) EligibleBalance(user int64) (int64, error) {
+	return EligibleBalance(m.database, user)
+}
+
+func (m *payoutManager) NewPayout(account *authgo.Account, stripeAccount string, size int64) (*Payout, error) {
+	if stripeAccount == "" {
+		return nil, ErrStripeAccountNotConnected
+	}
+	if size < m.minimum {
+		return nil, ErrPayoutBelowMinimum
+	}
+	// Transfers are made in minor units of currency, any remaining coins stay in the balance
+	amount := size / m.rate
+	size = amount * m.rate
+	if amount <= 0 {
+		return nil, ErrPayoutBelowMinimum
+	}
+
+	// Debit the balance before transferring, the database serializes payouts by user so the eligible balance cannot be spent twice
+	created := time.Now()
+	id, err := m.database.CreatePayout(account.ID, stripeAccount, m.currency, amount, size, created, func() error {
+		eligible, err := m.EligibleBalance(account.ID)
+		if err != nil {
+			return err
+		}
+		if size > eligible {
+			return ErrPayoutExceedsEligible
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created Payout", id)
+
+	payout := &Payout{
+		ID:            id,
+		Account:       account,
+		StripeAccount: stripeAccount,
+		Currency:      m.currency,
+		Amount:        amount,
+		Size:          size,
+		Status:        PAYOUT_PENDING,
+		Created:       created,
+	}
+
+	t, err := m.transfer(id, account.ID, stripeAccount, m.currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	payout.StripeTransfer = t
+	return payout, nil
+}
+
+// RetryTransfers retries the transfers of pending payouts which were not created, or whose outcome is unknown, with the same idempotency key so Stripe makes each transfer at most once.
+func (m *payoutManager) RetryTransfers() (int64, error) {
+	type pending struct {
+		id, user                int64
+		stripeAccount, currency string
+		amount                  int64
+	}
+	var payouts []*pending
+	now := time.Now()
+	if err := m.database.SelectPendingPayouts(now.Add(-PAYOUT_RETRY_WINDOW), now.Add(-PAYOUT_RETRY_DELAY), func(id, user int64, stripeAccount, currency string, amount int64) error {
+		payouts = append(payouts, &pending{id, user, stripeAccount, currency, amount})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, p := range payouts {
+		if _, err := m.transfer(p.id, p.user, p.stripeAccount, p.currency, p.amount); err != nil {
+			log.Println(err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// transfer creates the Stripe Transfer for a payout, and only credits the balance back when Stripe definitively rejects it.
+func (m *payoutManager) transfer(id, user int64, stripeAccount, currency string, amount int64) (string, error) {
+	params := &stripe.TransferParams{
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(currency),
+		Destination:   stripe.String(stripeAccount),
+		TransferGroup: stripe.String("payout-" + strconv.FormatInt(id, 10)),
+	}
+	params.SetIdempotencyKey("payout-" + strconv.FormatInt(id, 10))
+	params.AddMetadata("domain", Host())
+	params.AddMetadata("account_id", strconv.FormatInt(user, 10))
+	params.AddMetadata("payout_id", strconv.FormatInt(id, 10))
+	t, err := transfer.New(params)
+	if err != nil {
+		if e, ok := err.(*stripe.Error); ok && e.Type == stripe.ErrorTypeInvalidRequest {
+			// Credit the balance back
+			if _, e := m.database.UpdatePayoutStatus(id, PAYOUT_FAILED); e != nil {
+				log.Println(e)
+			}
+		}
+		// Otherwise the transfer may have been created, so the payout stays pending until it is retried or reconciled
+		return "", err
+	}
+	if _, err := m.database.UpdatePayoutTransfer(id, t.ID); err != nil {
+		return "", err
+	}
+	log.Println("Created Transfer", t.ID)
+	return t.ID, nil
+}
+
+// ReconcileTransfer updates the status of the payout from the payout_id in the transfer's metadata, as the transfer's events can arrive before its ID is stored.
+func (m *payoutManager) ReconcileTransfer(id int64, transfer, status string) error {
+	stored, current, err := m.database.SelectPayout(id)
+	if err != nil {
+		log.Println(err)
+		return ErrPayoutNotFound
+	}
+	if stored == "" {
+		if _, err := m.database.UpdatePayoutTransfer(id, transfer); err != nil {
+			return err
+		}
+	} else if stored != transfer {
+		log.Println("Mismatched Transfer", id, stored, transfer)
+		return ErrPayoutNotFound
+	}
+	if current == status || current == PAYOUT_REVERSED || (current == PAYOUT_FAILED && status != PAYOUT_PAID) {
+		// Nothing to do, or the payout has already been credited back
+		return nil
+	}
+	// A payout which was failed can still be paid, if Stripe created its transfer after all, which debits the balance again
+	if _, err := m.database.UpdatePayoutStatus(id, status); err != nil {
+		return err
+	}
+	log.Println("Updated Payout", id, status)
+	return nil
+}
+
+func (m *payoutManager) LookupPayouts(account *authgo.Account, callback func(*Payout) error) error {
+	return m.database.SelectPayouts(account.ID, func(id int64, stripeAccount, stripeTransfer, currency string, amount, size int64, status string, created time.Time) error {
+		return callback(&Payout{
+			ID:             id,
+			Account:        account,
+			StripeAccount:  stripeAccount,
+			StripeTransfer: stripeTransfer,
+			Currency:       currency,
+			Amount:         amount,
+			Size:           size,
+			Status:         status,
+			Created:        created,
+		})
+	})
+}
@@ -0,0 +1,255 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEligibleBalance(t *testing.T) {
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	acc := authtest.NewTestAccount(t, auth)
+	am := conveyearthgo.NewAccountManager(db)
+
+	// Purchased coins are not eligible
+	assert.NoError(t, am.NewPurchase(acc.ID, "session", "", "", "", 0, 1000))
+	eligible, err := conveyearthgo.EligibleBalance(db, acc.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), eligible)
+
+	// Awarded coins are eligible
+	assert.NoError(t, am.NewAward(acc.ID, "Test", 500))
+	eligible, err = conveyearthgo.EligibleBalance(db, acc.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), eligible)
+}
+
+func TestPayoutManager_NewPayout(t *testing.T) {
+	for name, tt := range map[string]struct {
+		award       int64
+		size        int64
+		fail        bool
+		unavailable bool
+		err         error
+		amount      int64
+		balance     int64
+		transfer    bool
+	}{
+		"Success": {
+			award:    2000,
+			size:     1500,
+			amount:   150,
+			balance:  500,
+			transfer: true,
+		},
+		"Rounds Down To Whole Units": {
+			award:    2000,
+			size:     1505,
+			amount:   150,
+			balance:  500,
+			transfer: true,
+		},
+		"Below Minimum": {
+			award:   2000,
+			size:    999,
+			err:     conveyearthgo.ErrPayoutBelowMinimum,
+			balance: 2000,
+		},
+		"Exceeds Eligible": {
+			award:   1000,
+			size:    1500,
+			err:     conveyearthgo.ErrPayoutExceedsEligible,
+			balance: 1000,
+		},
+		"Transfer Fails": {
+			award:   2000,
+			size:    1500,
+			fail:    true,
+			balance: 2000,
+		},
+		"Transfer Unavailable": {
+			award:       2000,
+			size:        1500,
+			unavailable: true,
+			balance:     500,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := conveytest.NewFakeStripe(t)
+			fake.Fail = tt.fail
+			fake.Unavailable = tt.unavailable
+			db := database.NewInMemory()
+			auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+			acc := authtest.NewTestAccount(t, auth)
+			am := conveyearthgo.NewAccountManager(db)
+			pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+
+			assert.NoError(t, am.NewAward(acc.ID, "Test", tt.award))
+
+			p, err := pm.NewPayout(acc, "acct_fake", tt.size)
+			if tt.fail || tt.unavailable {
+				assert.Error(t, err)
+			} else {
+				assert.Equal(t, tt.err, err)
+			}
+			if tt.transfer {
+				assert.Equal(t, tt.amount, p.Amount)
+				assert.Equal(t, conveyearthgo.PAYOUT_PENDING, p.Status)
+				assert.Equal(t, "tr_fake1", p.StripeTransfer)
+				assert.Equal(t, 1, len(fake.Transfers))
+				assert.Equal(t, "acct_fake", fake.Transfers[0].Get("destination"))
+			}
+
+			balance, err := am.AccountBalance(acc.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance, balance)
+		})
+	}
+}
+
+func TestPayoutManager_RetryTransfers(t *testing.T) {
+	fake := conveytest.NewFakeStripe(t)
+	db := database.NewInMemory()
+	auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+	acc := authtest.NewTestAccount(t, auth)
+	pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+
+	check := func() error {
+		return nil
+	}
+	id, err := db.CreatePayout(acc.ID, "acct_fake", "usd", 150, 1500, time.Now().Add(-time.Hour), check)
+	assert.NoError(t, err)
+	recent, err := db.CreatePayout(acc.ID, "acct_fake", "usd", 150, 1500, time.Now(), check)
+	assert.NoError(t, err)
+	expired, err := db.CreatePayout(acc.ID, "acct_fake", "usd", 150, 1500, time.Now().Add(-2*conveyearthgo.PAYOUT_RETRY_WINDOW), check)
+	assert.NoError(t, err)
+
+	// The transfer is created, but its outcome is unknown so the payout stays pending
+	fake.Unavailable = true
+	count, err := pm.RetryTransfers()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.Equal(t, 1, len(fake.Transfers))
+	assert.Equal(t, "payout-"+strconv.FormatInt(id, 10), fake.Transfers[0].Get("transfer_group"))
+	_, status, err := db.SelectPayout(id)
+	assert.NoError(t, err)
+	assert.Equal(t, conveyearthgo.PAYOUT_PENDING, status)
+
+	// Retrying with the same idempotency key finds the transfer instead of making another
+	fake.Unavailable = false
+	count, err = pm.RetryTransfers()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, 1, len(fake.Transfers))
+	transfer, status, err := db.SelectPayout(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "tr_fake1", transfer)
+	assert.Equal(t, conveyearthgo.PAYOUT_PENDING, status)
+
+	// Payouts which are too recent, or too old, are not retried
+	for _, p := range []int64{recent, expired} {
+		transfer, status, err := db.SelectPayout(p)
+		assert.NoError(t, err)
+		assert.Equal(t, "", transfer)
+		assert.Equal(t, conveyearthgo.PAYOUT_PENDING, status)
+	}
+}
+
+func TestPayoutManager_ReconcileTransfer(t *testing.T) {
+	for name, tt := range map[string]struct {
+		status  string
+		balance int64
+	}{
+		"Paid": {
+			status:  conveyearthgo.PAYOUT_PAID,
+			balance: 500,
+		},
+		"Reversed": {
+			status:  conveyearthgo.PAYOUT_REVERSED,
+			balance: 2000,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conveytest.NewFakeStripe(t)
+			db := database.NewInMemory()
+			auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+			acc := authtest.NewTestAccount(t, auth)
+			am := conveyearthgo.NewAccountManager(db)
+			pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+
+			assert.NoError(t, am.NewAward(acc.ID, "Test", 2000))
+			p, err := pm.NewPayout(acc, "acct_fake", 1500)
+			assert.NoError(t, err)
+
+			assert.NoError(t, pm.ReconcileTransfer(p.ID, p.StripeTransfer, tt.status))
+
+			var statuses []string
+			assert.NoError(t, pm.LookupPayouts(acc, func(p *conveyearthgo.Payout) error {
+				statuses = append(statuses, p.Status)
+				return nil
+			}))
+			assert.Equal(t, []string{tt.status}, statuses)
+
+			balance, err := am.AccountBalance(acc.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.balance, balance)
+		})
+	}
+	t.Run("Transfer Not Yet Stored", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+
+		id, err := db.CreatePayout(acc.ID, "acct_fake", "usd", 150, 1500, time.Now(), func() error {
+			return nil
+		})
+		assert.NoError(t, err)
+
+		// The payout is found by ID, and the transfer recorded
+		assert.NoError(t, pm.ReconcileTransfer(id, "tr_early", conveyearthgo.PAYOUT_PAID))
+		transfer, status, err := db.SelectPayout(id)
+		assert.NoError(t, err)
+		assert.Equal(t, "tr_early", transfer)
+		assert.Equal(t, conveyearthgo.PAYOUT_PAID, status)
+
+		// A different transfer cannot reconcile the payout
+		assert.Equal(t, conveyearthgo.ErrPayoutNotFound, pm.ReconcileTransfer(id, "tr_other", conveyearthgo.PAYOUT_REVERSED))
+	})
+	t.Run("Failed Payout Is Paid", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		am := conveyearthgo.NewAccountManager(db)
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+
+		assert.NoError(t, am.NewAward(acc.ID, "Test", 2000))
+		id, err := db.CreatePayout(acc.ID, "acct_fake", "usd", 150, 1500, time.Now(), func() error {
+			return nil
+		})
+		assert.NoError(t, err)
+		_, err = db.UpdatePayoutStatus(id, conveyearthgo.PAYOUT_FAILED)
+		assert.NoError(t, err)
+
+		// Stripe created the transfer after all, so the balance is debited again
+		assert.NoError(t, pm.ReconcileTransfer(id, "tr_late", conveyearthgo.PAYOUT_PAID))
+		_, status, err := db.SelectPayout(id)
+		assert.NoError(t, err)
+		assert.Equal(t, conveyearthgo.PAYOUT_PAID, status)
+		balance, err := am.AccountBalance(acc.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(500), balance)
+	})
+	t.Run("Unknown Payout", func(t *testing.T) {
+		db := database.NewInMemory()
+		pm := conveyearthgo.NewPayoutManager(db, "usd", 10, 1000)
+		assert.Equal(t, conveyearthgo.ErrPayoutNotFound, pm.ReconcileTransfer(404, "tr_unknown", conveyearthgo.PAYOUT_PAID))
+	})
+}
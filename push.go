@@ -0,0 +1,612 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MAXIMUM_PUSH_ENDPOINT_LENGTH = 1023
+	// Maximum size of a message before encryption, so it fits in a single record
+	MAXIMUM_PUSH_PAYLOAD_LENGTH = 3993
+	PUSH_RECORD_SIZE            = 4096
+	PUSH_TTL                    = 24 * time.Hour
+	VAPID_EXPIRY                = 12 * time.Hour
+)
+
+var (
+	ErrPushSubscriptionInvalid  = errors.New("Invalid Push Subscription")
+	ErrPushSubscriptionNotFound = errors.New("Push Subscription Not Found")
+	ErrPushPayloadTooLarge      = errors.New("Push Payload Too Large")
+	ErrVapidKeyInvalid          = errors.New("Invalid VAPID Key")
+)
+
+// PushSubscription is a browser's push endpoint, along with the keys used to encrypt messages for it.
+type PushSubscription struct {
+	ID       int64
+	User     int64
+	Endpoint string
+	P256dh   string
+	Auth     string
+	Created  time.Time
+}
+
+// PushMessage is the JSON payload shown by the service worker.
+type PushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Link  string `json:"link"`
+}
+
+type PushDatabase interface {
+	CreatePushSubscription(int64, string, string, string, time.Time) (int64, error)
+	DeletePushSubscription(string, time.Time) (int64, error)
+	SelectPushSubscriptions(int64, func(int64, string, string, string, time.Time) error) error
+	CreatePushDelivery(int64, string, time.Time) (int64, error)
+	DeletePushDelivery(int64, string, time.Time) (int64, error)
+	SelectPushDelivery(int64, string, time.Time) (bool, error)
+}
+
+type PushManager interface {
+	PublicKey() string
+	Subscribe(int64, string, string, string) error
+	Unsubscribe(int64, string) error
+	Subscriptions(int64, func(*PushSubscription) error) error
+	Push(int64, *PushMessage) error
+}
+
+// NewPushManager delivers messages to push services, identifying the application server with the given VAPID key and subject.
+// Endpoints are supplied by browsers, so in production the client should be a NewPublicClient.
+func NewPushManager(db PushDatabase, client *http.Client, key *ecdsa.PrivateKey, subject string) PushManager {
+	return &pushManager{
+		database: db,
+		client:   client,
+		key:      key,
+		subject:  subject,
+	}
+}
+
+type pushManager struct {
+	database PushDatabase
+	client   *http.Client
+	key      *ecdsa.PrivateKey
+	subject  string
+}
+
+// PublicKey returns the application server key browsers need to subscribe.
+func (m *pushManager) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(m.key.Curve, m.key.X, m.key.Y))
+}
+
+// Subscribe stores the browser's subscription for the user, replacing any previous subscription for the same endpoint.
+func (m *pushManager) Subscribe(user int64, endpoint, p256dh, auth string) error {
+	if err := ValidatePushSubscription(endpoint, p256dh, auth); err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := m.database.DeletePushSubscription(endpoint, now); err != nil {
+		return err
+	}
+	id, err := m.database.CreatePushSubscription(user, endpoint, p256dh, auth, now)
+	if err != nil {
+		return err
+	}
+	log.Println("Created Push Subscription", id)
+	return nil
+}
+
+func (m *pushManager) Unsubscribe(user int64, endpoint string) error {
+	found := false
+	if err := m.database.SelectPushSubscriptions(user, func(id int64, e, p256dh, auth string, created time.Time) error {
+		if e == endpoint {
+			found = true
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return ErrPushSubscriptionNotFound
+	}
+	if _, err := m.database.DeletePushSubscription(endpoint, time.Now()); err != nil {
+		return err
+	}
+	log.Println("Deleted Push Subscription", user)
+	return nil
+}
+
+func (m *pushManager) Subscriptions(user int64, callback func(*PushSubscription) error) error {
+	return m.database.SelectPushSubscriptions(user, func(id int64, endpoint, p256dh, auth string, created time.Time) error {
+		return callback(&PushSubscription{
+			ID:       id,
+			User:     user,
+			Endpoint: endpoint,
+			P256dh:   p256dh,
+			Auth:     auth,
+			Created:  created,
+		})
+	})
+}
+
+// Push sends the message to each of the user's subscriptions, removing those the push service reports as expired.
+// If any subscription fails the subscriptions which succeeded are recorded, so retrying the same message doesn't push it to them again.
+func (m *pushManager) Push(user int64, message *PushMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MAXIMUM_PUSH_PAYLOAD_LENGTH {
+		return ErrPushPayloadTooLarge
+	}
+	var subscriptions []*PushSubscription
+	if err := m.Subscriptions(user, func(s *PushSubscription) error {
+		subscriptions = append(subscriptions, s)
+		return nil
+	}); err != nil {
+		return err
+	}
+	hash := sha256.Sum256(payload)
+	digest := hex.EncodeToString(hash[:])
+	now := time.Now()
+	var (
+		last      error
+		delivered []int64
+	)
+	for _, s := range subscriptions {
+		// Messages older than their TTL have been discarded by the push service, so are sent again
+		sent, err := m.database.SelectPushDelivery(s.ID, digest, now.Add(-PUSH_TTL))
+		if err != nil {
+			return err
+		}
+		if sent {
+			delivered = append(delivered, s.ID)
+			continue
+		}
+		status, err := m.post(s, payload)
+		switch status {
+		case http.StatusNotFound, http.StatusGone:
+			if _, err := m.database.DeletePushSubscription(s.Endpoint, time.Now()); err != nil {
+				return err
+			}
+			log.Println("Expired Push Subscription", s.ID)
+			continue
+		}
+		if err != nil {
+			log.Println("Push Subscription", s.ID, "Failed:", err)
+			last = err
+			continue
+		}
+		if _, err := m.database.CreatePushDelivery(s.ID, digest, time.Now()); err != nil {
+			return err
+		}
+		delivered = append(delivered, s.ID)
+	}
+	if last != nil {
+		return last
+	}
+	// Every subscription has the message, so an identical message sent later is new rather than a retry
+	for _, id := range delivered {
+		if _, err := m.database.DeletePushDelivery(id, digest, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *pushManager) post(subscription *PushSubscription, payload []byte) (int, error) {
+	body, err := encryptPush(subscription.P256dh, subscription.Auth, payload)
+	if err != nil {
+		return 0, err
+	}
+	authorization, err := m.authorization(subscription.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("TTL", fmt.Sprintf("%d", int64(PUSH_TTL/time.Second)))
+	request.Header.Set("Authorization", authorization)
+	response, err := m.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("Unexpected Status: %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// authorization returns the VAPID header (RFC 8292) for the push service hosting the endpoint.
+func (m *pushManager) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{
+		"typ": "JWT",
+		"alg": "ES256",
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(VAPID_EXPIRY).Unix(),
+		"sub": m.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, m.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return fmt.Sprintf("vapid t=%s, k=%s", token, m.PublicKey()), nil
+}
+
+// encryptPush encrypts the payload for the subscription's user agent, as described in RFC 8291.
+func encryptPush(p256dh, auth string, payload []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	receiver, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return nil, ErrPushSubscriptionInvalid
+	}
+	rx, ry := elliptic.Unmarshal(curve, receiver)
+	if rx == nil {
+		return nil, ErrPushSubscriptionInvalid
+	}
+	secret, err := decodeBase64URL(auth)
+	if err != nil || len(secret) != 16 {
+		return nil, ErrPushSubscriptionInvalid
+	}
+	ephemeral, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sender := elliptic.Marshal(curve, ephemeral.X, ephemeral.Y)
+	sx, _ := curve.ScalarMult(rx, ry, ephemeral.D.Bytes())
+	shared := make([]byte, 32)
+	sx.FillBytes(shared)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	var info bytes.Buffer
+	info.WriteString("WebPush: info")
+	info.WriteByte(0)
+	info.Write(receiver)
+	info.Write(sender)
+	ikm := hkdf(secret, shared, info.Bytes(), 32)
+	key := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, terminated by the last record delimiter
+	plaintext := append(append([]byte{}, payload...), 2)
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(PUSH_RECORD_SIZE))
+	body.WriteByte(byte(len(sender)))
+	body.Write(sender)
+	body.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return body.Bytes(), nil
+}
+
+// hkdf derives a key of the given length (at most 32 bytes) as described in RFC 5869.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func ValidatePushSubscription(endpoint, p256dh, auth string) error {
+	if len(endpoint) > MAXIMUM_PUSH_ENDPOINT_LENGTH {
+		return ErrPushSubscriptionInvalid
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrPushSubscriptionInvalid
+	}
+	receiver, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return ErrPushSubscriptionInvalid
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), receiver); x == nil {
+		return ErrPushSubscriptionInvalid
+	}
+	secret, err := decodeBase64URL(auth)
+	if err != nil || len(secret) != 16 {
+		return ErrPushSubscriptionInvalid
+	}
+	return nil
+}
+
+func GenerateVapidKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// EncodeVapidKey returns the private key in the form read by ParseVapidKey.
+func EncodeVapidKey(key *ecdsa.PrivateKey) string {
+	d := make([]byte, 32)
+	key.D.FillBytes(d)
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+func ParseVapidKey(encoded string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64URL(encoded)
+	if err != nil || len(d) != 32 {
+		return nil, ErrVapidKeyInvalid
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{
+		D: new(big.Int).SetBytes(d),
+	}
+	key.Curve = curve
+	if key.D.Sign() == 0 || key.D.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrVapidKeyInvalid
+	}
+	key.X, key.Y = curve.ScalarBaseMult(d)
+	return key, nil
+}
+
+// NewPushNotificationSender sends notifications to the browsers the user subscribed, with links to the given host.
+func NewPushNotificationSender(pm PushManager, scheme, host string) NotificationSender {
+	return &pushNotificationSender{
+		manager: pm,
+		scheme:  scheme,
+		host:    host,
+	}
+}
+
+type pushNotificationSender struct {
+	manager PushManager
+	scheme,
+	host string
+}
+
+func (s *pushNotificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("%s responded to you", responder),
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("%s mentioned you", mentioner),
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("%s gifted you %d¤", gifter, amount),
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendYieldNotification(account *authgo.Account, replier, topic string, conversation, message, amount int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("You earned %d¤ from a reply by %s", amount, replier),
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendReplyNotification(account *authgo.Account, replier, topic string, conversation, message int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("%s replied", replier),
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendPublicationNotification(account *authgo.Account, author, topic string, conversation int64) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  fmt.Sprintf("%s published a new conversation", author),
+		Link:  createLink(s.scheme, s.host, conversation, 0),
+	})
+}
+
+func (s *pushNotificationSender) SendReversalNotification(account *authgo.Account, reason, topic string, conversation, message, amount int64) error {
+	body := fmt.Sprintf("You were charged %d¤: %s", -amount, reason)
+	if amount > 0 {
+		body = fmt.Sprintf("You were credited %d¤: %s", amount, reason)
+	}
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: topic,
+		Body:  body,
+		Link:  createLink(s.scheme, s.host, conversation, message),
+	})
+}
+
+func (s *pushNotificationSender) SendNegativeBalanceAlert(user, balance int64, reason string) error {
+	// Operators are alerted by email
+	return nil
+}
+
+func (s *pushNotificationSender) SendPurchaseReceipt(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	// The code follows the amount, so the symbol cannot be mistaken for another currency
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: "Purchase Complete",
+		Body:  fmt.Sprintf("You bought %d¤ for %s %s, your balance is %d¤", size, FormatLocalAmount(amount, currency, currency), strings.ToUpper(currency), balance),
+		Link:  fmt.Sprintf("%s://%s/account", s.scheme, s.host),
+	})
+}
+
+func (s *pushNotificationSender) SendDigestNotification(account *authgo.Account, edition string) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: "New Digest",
+		Body:  fmt.Sprintf("The %s edition is available", edition),
+		Link:  fmt.Sprintf("%s://%s/digest?edition=%s", s.scheme, s.host, url.QueryEscape(edition)),
+	})
+}
+
+func (s *pushNotificationSender) SendNotificationSummary(account *authgo.Account, frequency string, notifications []*Notification) error {
+	return s.manager.Push(account.ID, &PushMessage{
+		Title: "New Notifications",
+		Body:  fmt.Sprintf("You have %d new notifications", len(notifications)),
+		Link:  fmt.Sprintf("%s://%s/notifications", s.scheme, s.host),
+	})
+}
+
+// NewFanoutNotificationSender sends each notification by email, by push, or both, according to the channels in the user's notification preferences.
+// Email is sent first and its failures are returned so the notification is retried, while push failures are only returned when email is disabled, so a retry never duplicates an email.
+func NewFanoutNotificationSender(db NotificationDatabase, email, push NotificationSender) NotificationSender {
+	return &fanoutNotificationSender{
+		database: db,
+		email:    email,
+		push:     push,
+	}
+}
+
+type fanoutNotificationSender struct {
+	database NotificationDatabase
+	email    NotificationSender
+	push     NotificationSender
+}
+
+func (s *fanoutNotificationSender) SendResponseNotification(account *authgo.Account, responder, topic string, conversation, message int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendResponseNotification(account, responder, topic, conversation, message)
+	})
+}
+
+func (s *fanoutNotificationSender) SendMentionNotification(account *authgo.Account, mentioner, topic string, conversation, message int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendMentionNotification(account, mentioner, topic, conversation, message)
+	})
+}
+
+func (s *fanoutNotificationSender) SendGiftNotification(account *authgo.Account, gifter, topic string, conversation, message, amount int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendGiftNotification(account, gifter, topic, conversation, message, amount)
+	})
+}
+
+func (s *fanoutNotificationSender) SendYieldNotification(account *authgo.Account, replier, topic string, conversation, message, amount int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendYieldNotification(account, replier, topic, conversation, message, amount)
+	})
+}
+
+func (s *fanoutNotificationSender) SendReplyNotification(account *authgo.Account, replier, topic string, conversation, message int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendReplyNotification(account, replier, topic, conversation, message)
+	})
+}
+
+func (s *fanoutNotificationSender) SendPublicationNotification(account *authgo.Account, author, topic string, conversation int64) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendPublicationNotification(account, author, topic, conversation)
+	})
+}
+
+func (s *fanoutNotificationSender) SendReversalNotification(account *authgo.Account, reason, topic string, conversation, message, amount int64) error {
+	// Changes to a user's balance are always emailed
+	return s.send(account, true, func(ns NotificationSender) error {
+		return ns.SendReversalNotification(account, reason, topic, conversation, message, amount)
+	})
+}
+
+func (s *fanoutNotificationSender) SendNegativeBalanceAlert(user, balance int64, reason string) error {
+	// Operators are alerted by email
+	return s.email.SendNegativeBalanceAlert(user, balance, reason)
+}
+
+func (s *fanoutNotificationSender) SendPurchaseReceipt(account *authgo.Account, size, amount int64, currency string, balance int64) error {
+	// Receipts are always emailed
+	return s.send(account, true, func(ns NotificationSender) error {
+		return ns.SendPurchaseReceipt(account, size, amount, currency, balance)
+	})
+}
+
+func (s *fanoutNotificationSender) SendDigestNotification(account *authgo.Account, edition string) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendDigestNotification(account, edition)
+	})
+}
+
+func (s *fanoutNotificationSender) SendNotificationSummary(account *authgo.Account, frequency string, notifications []*Notification) error {
+	return s.send(account, false, func(ns NotificationSender) error {
+		return ns.SendNotificationSummary(account, frequency, notifications)
+	})
+}
+
+func (s *fanoutNotificationSender) send(account *authgo.Account, always bool, callback func(NotificationSender) error) error {
+	p, err := s.database.SelectNotificationPreferences(account.ID)
+	if err != nil {
+		return err
+	}
+	email := p.Email || always
+	push := p.Push
+	if email {
+		if err := callback(s.email); err != nil {
+			return err
+		}
+	}
+	if push {
+		if err := callback(s.push); err != nil {
+			if email {
+				log.Println(err)
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/conveytest"
+	"aletheiaware.com/conveyearthgo/database"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pushReceiver holds the keys of a browser subscription, so tests can decrypt the messages sent to it.
+type pushReceiver struct {
+	key    *ecdsa.PrivateKey
+	secret []byte
+}
+
+func newPushReceiver(t *testing.T) *pushReceiver {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	secret := make([]byte, 16)
+	_, err = rand.Read(secret)
+	assert.Nil(t, err)
+	return &pushReceiver{
+		key:    key,
+		secret: secret,
+	}
+}
+
+func (r *pushReceiver) P256dh() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), r.key.X, r.key.Y))
+}
+
+func (r *pushReceiver) Auth() string {
+	return base64.RawURLEncoding.EncodeToString(r.secret)
+}
+
+func (r *pushReceiver) decrypt(t *testing.T, body []byte) *conveyearthgo.PushMessage {
+	t.Helper()
+	curve := elliptic.P256()
+	salt := body[:16]
+	assert.Equal(t, uint32(conveyearthgo.PUSH_RECORD_SIZE), binary.BigEndian.Uint32(body[16:20]))
+	length := int(body[20])
+	sender := body[21 : 21+length]
+	sx, sy := elliptic.Unmarshal(curve, sender)
+	assert.NotNil(t, sx)
+	x, _ := curve.ScalarMult(sx, sy, r.key.D.Bytes())
+	shared := make([]byte, 32)
+	x.FillBytes(shared)
+	var info bytes.Buffer
+	info.WriteString("WebPush: info\x00")
+	info.Write(elliptic.Marshal(curve, r.key.X, r.key.Y))
+	info.Write(sender)
+	ikm := testHKDF(r.secret, shared, info.Bytes(), 32)
+	block, err := aes.NewCipher(testHKDF(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	assert.Nil(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.Nil(t, err)
+	plaintext, err := gcm.Open(nil, testHKDF(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12), body[21+length:], nil)
+	assert.Nil(t, err)
+	// Last record delimiter
+	assert.Equal(t, byte(2), plaintext[len(plaintext)-1])
+	message := &conveyearthgo.PushMessage{}
+	assert.Nil(t, json.Unmarshal(plaintext[:len(plaintext)-1], message))
+	return message
+}
+
+func testHKDF(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+func TestValidatePushSubscription(t *testing.T) {
+	receiver := newPushReceiver(t)
+	for name, tt := range map[string]struct {
+		endpoint string
+		p256dh   string
+		auth     string
+		err      error
+	}{
+		"Valid":        {endpoint: "https://push.example.com/abc", p256dh: receiver.P256dh(), auth: receiver.Auth()},
+		"Padded":       {endpoint: "https://push.example.com/abc", p256dh: receiver.P256dh(), auth: receiver.Auth() + "=="},
+		"Insecure":     {endpoint: "http://push.example.com/abc", p256dh: receiver.P256dh(), auth: receiver.Auth(), err: conveyearthgo.ErrPushSubscriptionInvalid},
+		"Too Long":     {endpoint: "https://push.example.com/" + strings.Repeat("x", conveyearthgo.MAXIMUM_PUSH_ENDPOINT_LENGTH), p256dh: receiver.P256dh(), auth: receiver.Auth(), err: conveyearthgo.ErrPushSubscriptionInvalid},
+		"Invalid Key":  {endpoint: "https://push.example.com/abc", p256dh: "abc", auth: receiver.Auth(), err: conveyearthgo.ErrPushSubscriptionInvalid},
+		"Invalid Auth": {endpoint: "https://push.example.com/abc", p256dh: receiver.P256dh(), auth: "abc", err: conveyearthgo.ErrPushSubscriptionInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.err, conveyearthgo.ValidatePushSubscription(tt.endpoint, tt.p256dh, tt.auth))
+		})
+	}
+}
+
+func TestVapidKey(t *testing.T) {
+	t.Run("Round Trip", func(t *testing.T) {
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		parsed, err := conveyearthgo.ParseVapidKey(conveyearthgo.EncodeVapidKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, key.D, parsed.D)
+		assert.Equal(t, key.X, parsed.X)
+		assert.Equal(t, key.Y, parsed.Y)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := conveyearthgo.ParseVapidKey("abc")
+		assert.Equal(t, conveyearthgo.ErrVapidKeyInvalid, err)
+	})
+}
+
+func TestPushManager(t *testing.T) {
+	t.Run("Encrypted Delivery", func(t *testing.T) {
+		db := database.NewInMemory()
+		server := httptest.NewTLSServer(&webhookReceiver{
+			status: http.StatusCreated,
+		})
+		defer server.Close()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, server.Client(), key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, server.URL+"/push/abc", receiver.P256dh(), receiver.Auth()))
+		message := &conveyearthgo.PushMessage{
+			Title: "Topic",
+			Body:  "Alice replied",
+			Link:  "https://example.com/conversation?id=1",
+		}
+		assert.Nil(t, pm.Push(1, message))
+
+		requests, bodies := server.Config.Handler.(*webhookReceiver).received()
+		assert.Equal(t, 1, len(requests))
+		assert.Equal(t, "/push/abc", requests[0].URL.Path)
+		assert.Equal(t, "aes128gcm", requests[0].Header.Get("Content-Encoding"))
+		assert.NotEmpty(t, requests[0].Header.Get("TTL"))
+		assert.Equal(t, message, receiver.decrypt(t, bodies[0]))
+
+		// Authorization is a JWT signed by the VAPID key
+		authorization := requests[0].Header.Get("Authorization")
+		assert.True(t, strings.HasPrefix(authorization, "vapid t="))
+		assert.True(t, strings.HasSuffix(authorization, ", k="+pm.PublicKey()))
+		token := strings.TrimSuffix(strings.TrimPrefix(authorization, "vapid t="), ", k="+pm.PublicKey())
+		parts := strings.Split(token, ".")
+		assert.Equal(t, 3, len(parts))
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.Nil(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.True(t, ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+		encoded, err := base64.RawURLEncoding.DecodeString(parts[1])
+		assert.Nil(t, err)
+		claims := make(map[string]interface{})
+		assert.Nil(t, json.Unmarshal(encoded, &claims))
+		assert.Equal(t, server.URL, claims["aud"])
+		assert.Equal(t, "mailto:operator@example.com", claims["sub"])
+	})
+	t.Run("Expired Subscription Removed", func(t *testing.T) {
+		db := database.NewInMemory()
+		server := httptest.NewTLSServer(&webhookReceiver{
+			status: http.StatusGone,
+		})
+		defer server.Close()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, server.Client(), key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, server.URL+"/push/abc", receiver.P256dh(), receiver.Auth()))
+		assert.Nil(t, pm.Push(1, &conveyearthgo.PushMessage{}))
+		assert.Nil(t, pm.Subscriptions(1, func(s *conveyearthgo.PushSubscription) error {
+			t.Fatal("Unexpected Subscription", s.ID)
+			return nil
+		}))
+	})
+	t.Run("Failure Returned", func(t *testing.T) {
+		db := database.NewInMemory()
+		server := httptest.NewTLSServer(&webhookReceiver{
+			status: http.StatusInternalServerError,
+		})
+		defer server.Close()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, server.Client(), key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, server.URL+"/push/abc", receiver.P256dh(), receiver.Auth()))
+		assert.NotNil(t, pm.Push(1, &conveyearthgo.PushMessage{}))
+		var count int
+		assert.Nil(t, pm.Subscriptions(1, func(s *conveyearthgo.PushSubscription) error {
+			count++
+			return nil
+		}))
+		assert.Equal(t, 1, count)
+	})
+	t.Run("Retry Skips Delivered Subscriptions", func(t *testing.T) {
+		db := database.NewInMemory()
+		succeeding := &webhookReceiver{}
+		good := httptest.NewTLSServer(succeeding)
+		defer good.Close()
+		failing := &webhookReceiver{
+			status: http.StatusInternalServerError,
+		}
+		bad := httptest.NewTLSServer(failing)
+		defer bad.Close()
+		// Both servers use the same test certificate
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, good.Client(), key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, good.URL+"/push/abc", receiver.P256dh(), receiver.Auth()))
+		assert.Nil(t, pm.Subscribe(1, bad.URL+"/push/def", receiver.P256dh(), receiver.Auth()))
+		message := &conveyearthgo.PushMessage{
+			Title: "Test",
+		}
+		assert.NotNil(t, pm.Push(1, message))
+		requests, _ := succeeding.received()
+		assert.Equal(t, 1, len(requests))
+		requests, _ = failing.received()
+		assert.Equal(t, 1, len(requests))
+
+		// The retry only goes to the subscription which failed
+		failing.respond(http.StatusCreated)
+		assert.Nil(t, pm.Push(1, message))
+		requests, _ = succeeding.received()
+		assert.Equal(t, 1, len(requests))
+		requests, _ = failing.received()
+		assert.Equal(t, 2, len(requests))
+
+		// Once delivered everywhere, the same message can be sent again
+		assert.Nil(t, pm.Push(1, message))
+		requests, _ = succeeding.received()
+		assert.Equal(t, 2, len(requests))
+		requests, _ = failing.received()
+		assert.Equal(t, 3, len(requests))
+	})
+	t.Run("Does Not Connect To Private Addresses", func(t *testing.T) {
+		db := database.NewInMemory()
+		server := httptest.NewTLSServer(&webhookReceiver{})
+		defer server.Close()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, conveyearthgo.NewPublicClient(time.Second), key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		// The server is on a loopback address
+		assert.Nil(t, pm.Subscribe(1, server.URL+"/push/abc", receiver.P256dh(), receiver.Auth()))
+		err = pm.Push(1, &conveyearthgo.PushMessage{})
+		assert.True(t, errors.Is(err, conveyearthgo.ErrAddressForbidden))
+	})
+	t.Run("Resubscribe Moves Endpoint", func(t *testing.T) {
+		db := database.NewInMemory()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, http.DefaultClient, key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, "https://push.example.com/abc", receiver.P256dh(), receiver.Auth()))
+		assert.Nil(t, pm.Subscribe(2, "https://push.example.com/abc", receiver.P256dh(), receiver.Auth()))
+		assert.Nil(t, pm.Subscriptions(1, func(s *conveyearthgo.PushSubscription) error {
+			t.Fatal("Unexpected Subscription", s.ID)
+			return nil
+		}))
+		var endpoints []string
+		assert.Nil(t, pm.Subscriptions(2, func(s *conveyearthgo.PushSubscription) error {
+			endpoints = append(endpoints, s.Endpoint)
+			return nil
+		}))
+		assert.Equal(t, []string{"https://push.example.com/abc"}, endpoints)
+	})
+	t.Run("Unsubscribe", func(t *testing.T) {
+		db := database.NewInMemory()
+		key, err := conveyearthgo.GenerateVapidKey()
+		assert.Nil(t, err)
+		pm := conveyearthgo.NewPushManager(db, http.DefaultClient, key, "mailto:operator@example.com")
+		receiver := newPushReceiver(t)
+		assert.Nil(t, pm.Subscribe(1, "https://push.example.com/abc", receiver.P256dh(), receiver.Auth()))
+		// Only the owner can unsubscribe
+		assert.Equal(t, conveyearthgo.ErrPushSubscriptionNotFound, pm.Unsubscribe(2, "https://push.example.com/abc"))
+		assert.Nil(t, pm.Unsubscribe(1, "https://push.example.com/abc"))
+		assert.Equal(t, conveyearthgo.ErrPushSubscriptionNotFound, pm.Unsubscribe(1, "https://push.example.com/abc"))
+	})
+}
+
+func TestFanoutNotificationSender(t *testing.T) {
+	for name, tt := range map[string]struct {
+		email     bool
+		push      bool
+		emailFail bool
+		pushFail  bool
+		emailed   int
+		pushed    int
+		err       error
+	}{
+		"Email": {
+			email:   true,
+			emailed: 1,
+		},
+		"Push": {
+			push:   true,
+			pushed: 1,
+		},
+		"Both": {
+			email:   true,
+			push:    true,
+			emailed: 1,
+			pushed:  1,
+		},
+		"Neither": {},
+		"Email Failure Returned": {
+			email:     true,
+			push:      true,
+			emailFail: true,
+			err:       conveytest.ErrNotificationFailed,
+		},
+		"Push Failure Ignored When Emailed": {
+			email:    true,
+			push:     true,
+			pushFail: true,
+			emailed:  1,
+		},
+		"Push Failure Returned When Not Emailed": {
+			push:     true,
+			pushFail: true,
+			err:      conveytest.ErrNotificationFailed,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := database.NewInMemory()
+			auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+			acc := authtest.NewTestAccount(t, auth)
+			p := conveyearthgo.NewNotificationPreferences(acc.ID)
+			p.Email = tt.email
+			p.Push = tt.push
+			_, err := db.UpdateNotificationPreferences(p)
+			assert.Nil(t, err)
+			email := conveytest.NewNotificationSender()
+			email.Fail = tt.emailFail
+			push := conveytest.NewNotificationSender()
+			push.Fail = tt.pushFail
+			ns := conveyearthgo.NewFanoutNotificationSender(db, email, push)
+			assert.Equal(t, tt.err, ns.SendDigestNotification(acc, "2022-01"))
+			assert.Equal(t, tt.emailed, len(email.Digests))
+			assert.Equal(t, tt.pushed, len(push.Digests))
+		})
+	}
+	t.Run("Receipts Always Emailed", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		p := conveyearthgo.NewNotificationPreferences(acc.ID)
+		p.Email = false
+		p.Push = false
+		_, err := db.UpdateNotificationPreferences(p)
+		assert.Nil(t, err)
+		email := conveytest.NewNotificationSender()
+		push := conveytest.NewNotificationSender()
+		ns := conveyearthgo.NewFanoutNotificationSender(db, email, push)
+		assert.Nil(t, ns.SendPurchaseReceipt(acc, 100, 200, "usd", 100))
+		assert.Equal(t, 1, len(email.Receipts))
+		assert.Equal(t, 0, len(push.Receipts))
+	})
+	t.Run("Channels Default To Enabled", func(t *testing.T) {
+		db := database.NewInMemory()
+		p, err := db.SelectNotificationPreferences(1)
+		assert.Nil(t, err)
+		assert.True(t, p.Email)
+		assert.True(t, p.Push)
+	})
+}
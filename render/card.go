@@ -0,0 +1,105 @@
+package render
+
+import (
+	"fmt"
+	"github.com/goki/freetype"
+	"github.com/goki/freetype/truetype"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const ELLIPSIS = "…"
+
+// Card lays out the topic of a conversation under a logo, with the author and yield along the bottom.
+type Card struct {
+	Width, Height int
+	Margin        int
+	Background    color.Color
+	Emojis        string
+	Logo          image.Image
+	Topic         string
+	TopicFont     *truetype.Font
+	TopicSize     float64
+	TopicColor    color.Color
+	TopicLines    int
+	Author        string
+	Yield         int64
+	BylineFont    *truetype.Font
+	BylineSize    float64
+	BylineColor   color.Color
+}
+
+func (c *Card) Image() image.Image {
+	bounds := image.Rect(0, 0, c.Width, c.Height)
+	rgba := image.NewRGBA(bounds)
+
+	// Background
+	draw.Draw(rgba, bounds, image.NewUniform(c.Background), image.Point{}, draw.Src)
+
+	// Logo
+	var logoHeight int
+	if c.Logo != nil {
+		logoBounds := c.Logo.Bounds()
+		logoHeight = logoBounds.Dy()
+		draw.Draw(rgba, image.Rect(c.Margin, c.Margin, c.Margin+logoBounds.Dx(), c.Margin+logoHeight), c.Logo, logoBounds.Min, draw.Over)
+	}
+
+	// Byline
+	bylineFace := truetype.NewFace(c.BylineFont, &truetype.Options{
+		Size: c.BylineSize,
+		DPI:  96,
+	})
+	bylineMetrics := bylineFace.Metrics()
+	bylineHeight := bylineMetrics.Height.Ceil()
+	bylineY := c.Height - c.Margin - bylineMetrics.Descent.Ceil()
+	bylineEmojis := &Emojis{
+		Directory: c.Emojis,
+		Size:      int(c.BylineSize),
+	}
+	bylineColor := image.NewUniform(c.BylineColor)
+	DrawString(rgba, bylineColor, c.BylineFont, bylineFace, bylineEmojis.Image, []rune(c.Author), freetype.Pt(c.Margin, bylineY))
+	if c.Yield > 0 {
+		yield := []rune(fmt.Sprintf("%d¤", c.Yield))
+		yieldWidth := MeasureString(c.BylineFont, bylineFace, nil, yield)
+		DrawString(rgba, bylineColor, c.BylineFont, bylineFace, nil, yield, freetype.Pt(c.Width-c.Margin-yieldWidth, bylineY))
+	}
+
+	// Topic
+	topicFace := truetype.NewFace(c.TopicFont, &truetype.Options{
+		Size: c.TopicSize,
+		DPI:  96,
+	})
+	topicMetrics := topicFace.Metrics()
+	topicAscent := topicMetrics.Ascent.Ceil()
+	topicHeight := topicMetrics.Height.Ceil()
+	topicEmojis := &Emojis{
+		Directory: c.Emojis,
+		Size:      int(c.TopicSize),
+	}
+	topicMeasurer := func(s []rune) int {
+		return MeasureString(c.TopicFont, topicFace, topicEmojis.Advance, s)
+	}
+	topicWidth := c.Width - 2*c.Margin
+	lines := SplitLines(c.Topic, topicWidth, topicMeasurer)
+	if c.TopicLines > 0 && len(lines) > c.TopicLines {
+		lines = lines[:c.TopicLines]
+		last := lines[len(lines)-1]
+		runes := []rune(last.Text + ELLIPSIS)
+		for len(runes) > 1 && topicMeasurer(runes) > topicWidth {
+			runes = append(runes[:len(runes)-2], runes[len(runes)-1])
+		}
+		last.Text = string(runes)
+		last.Width = topicMeasurer(runes)
+	}
+	top := c.Margin + logoHeight
+	bottom := c.Height - c.Margin - bylineHeight
+	y := top + (bottom-top-len(lines)*topicHeight)/2 + topicAscent
+	topicColor := image.NewUniform(c.TopicColor)
+	for _, l := range lines {
+		DrawString(rgba, topicColor, c.TopicFont, topicFace, topicEmojis.Image, []rune(l.Text), freetype.Pt(c.Margin, y))
+		y += topicHeight
+	}
+
+	return rgba
+}
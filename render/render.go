@@ -0,0 +1,219 @@
+package render
+
+import (
+	"fmt"
+	"github.com/goki/freetype/truetype"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path"
+	"unicode"
+)
+
+func LoadFont(name string) (*truetype.Font, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseFont(file)
+}
+
+func ParseFont(reader io.Reader) (*truetype.Font, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return truetype.Parse(data)
+}
+
+// Rasterize draws the SVG read from reader into an image of the given size.
+func Rasterize(reader io.Reader, width, height int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(reader)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(int(icon.ViewBox.W), int(icon.ViewBox.H), img, img.Bounds())
+	raster := rasterx.NewDasher(width, height, scanner)
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	icon.Draw(raster, 1)
+	return img, nil
+}
+
+// Emojis draws the runes missing from a font with the PNG images in Directory, named as in Noto Emoji, eg. emoji_u1f600.png
+type Emojis struct {
+	Directory string
+	Size      int
+}
+
+func (e *Emojis) name(r rune) string {
+	return path.Join(e.Directory, fmt.Sprintf("emoji_u%x.png", r))
+}
+
+func (e *Emojis) Advance(r rune) (fixed.Int26_6, bool) {
+	if e.Directory == "" {
+		return 0, false
+	}
+	if _, err := os.Stat(e.name(r)); err != nil {
+		return 0, false
+	}
+	return fixed.I(e.Size), true
+}
+
+func (e *Emojis) Image(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, bool) {
+	if e.Directory == "" {
+		return image.Rectangle{}, nil, false
+	}
+	file, err := os.Open(e.name(r))
+	if err != nil {
+		log.Println(err)
+		return image.Rectangle{}, nil, false
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		log.Println(err)
+		return image.Rectangle{}, nil, false
+	}
+	bounds := image.Rect(0, 0, e.Size, e.Size)
+	out := image.NewRGBA(bounds)
+	xdraw.BiLinear.Scale(out, bounds, img, img.Bounds(), draw.Over, nil)
+	x := dot.X.Ceil()
+	y := dot.Y.Ceil()
+	rect := image.Rect(x, y-e.Size, x+e.Size, y)
+	return rect, out, true
+}
+
+func MeasureString(font *truetype.Font, face font.Face, emoji func(rune) (fixed.Int26_6, bool), runes []rune) int {
+	var advance fixed.Int26_6
+	previous := rune(-1)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if previous >= 0 {
+			advance += face.Kern(previous, r)
+		}
+		index := font.Index(r)
+		if index > 0 {
+			a, ok := face.GlyphAdvance(r)
+			if !ok {
+				log.Println("Couldn't find Glyph Advance for", r)
+				continue
+			}
+			advance += a
+		} else if emoji != nil {
+			a, ok := emoji(r)
+			if !ok {
+				log.Println("Couldn't find Emoji for", r)
+			}
+			advance += a
+		}
+		previous = r
+	}
+	return advance.Ceil()
+}
+
+func DrawString(dest draw.Image, src image.Image, font *truetype.Font, face font.Face, emoji func(fixed.Point26_6, rune) (image.Rectangle, image.Image, bool), runes []rune, dot fixed.Point26_6) {
+	var (
+		ok        bool
+		rect      image.Rectangle
+		mask      image.Image
+		maskPoint image.Point
+		advance   fixed.Int26_6
+	)
+	previous := rune(-1)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if previous >= 0 {
+			dot.X += face.Kern(previous, r)
+		}
+		index := font.Index(r)
+		if index > 0 {
+			rect, mask, maskPoint, advance, ok = face.Glyph(dot, r)
+			if !ok {
+				log.Println("Couldn't find Glyph for", r)
+				continue
+			}
+			draw.DrawMask(dest, rect, src, image.Point{}, mask, maskPoint, draw.Over)
+		} else {
+			if emoji == nil {
+				log.Println("Couldn't find Glyph for", r)
+				continue
+			}
+			rect, src, ok := emoji(dot, r)
+			if !ok {
+				log.Println("Couldn't find Emoji for", r)
+				continue
+			}
+			draw.Draw(dest, rect, src, image.Point{}, draw.Over)
+			advance = fixed.I(rect.Dx())
+		}
+
+		dot.X += advance
+		previous = r
+	}
+}
+
+type Line struct {
+	Text  string
+	Width int
+}
+
+func SplitLines(text string, maxWidth int, measurer func([]rune) int) (lines []*Line) {
+	textWidth := measurer([]rune(text))
+	delta := maxWidth - textWidth
+	if delta < 0 {
+		// Split line
+		wrappoint := -1
+		start := 0
+		end := 0
+		runes := []rune(text)
+		for end < len(runes) {
+			c := runes[end]
+			if unicode.IsSpace(c) {
+				wrappoint = end
+			}
+			textWidth := measurer(runes[start : end+1])
+			delta := maxWidth - textWidth
+			if delta < 0 {
+				var substring []rune
+				if wrappoint == -1 {
+					substring = runes[start:end]
+					end++
+				} else {
+					substring = runes[start:wrappoint]
+					end = wrappoint + 1
+				}
+				lines = append(lines, &Line{
+					Text:  string(substring),
+					Width: measurer(substring),
+				})
+				start = end
+				wrappoint = -1
+			} else {
+				end++
+			}
+		}
+		if end-start > 0 {
+			substring := runes[start:end]
+			lines = append(lines, &Line{
+				Text:  string(substring),
+				Width: measurer(substring),
+			})
+		}
+	} else {
+		lines = append(lines, &Line{
+			Text:  text,
+			Width: textWidth,
+		})
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package render_test
+
+import (
+	"aletheiaware.com/conveyearthgo/render"
+	"github.com/stretchr/testify/assert"
+	"image/color"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	// Each rune is one unit wide
+	measurer := func(s []rune) int {
+		return len(s)
+	}
+	for name, tt := range map[string]struct {
+		text     string
+		width    int
+		expected []string
+	}{
+		"Fits": {
+			text:     "Hello World",
+			width:    20,
+			expected: []string{"Hello World"},
+		},
+		"Wraps At Space": {
+			text:     "Hello World",
+			width:    8,
+			expected: []string{"Hello", "World"},
+		},
+		"Wraps Many": {
+			text:     "The quick brown fox jumps",
+			width:    10,
+			expected: []string{"The quick", "brown fox", "jumps"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var actual []string
+			for _, l := range render.SplitLines(tt.text, tt.width, measurer) {
+				assert.Equal(t, len([]rune(l.Text)), l.Width)
+				actual = append(actual, l.Text)
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestCard(t *testing.T) {
+	topicFont, err := render.LoadFont("../cmd/server/assets/html/static/NotoSerif-ExtraBold.ttf")
+	assert.NoError(t, err)
+	bylineFont, err := render.LoadFont("../cmd/server/assets/html/static/NotoSerif-Regular.ttf")
+	assert.NoError(t, err)
+	card := &render.Card{
+		Width:       1200,
+		Height:      630,
+		Margin:      60,
+		Background:  color.White,
+		Topic:       "The quick brown fox jumps over the lazy dog, again and again and again and again and again and again and again",
+		TopicFont:   topicFont,
+		TopicSize:   64,
+		TopicColor:  color.Black,
+		TopicLines:  2,
+		Author:      "alice",
+		Yield:       123,
+		BylineFont:  bylineFont,
+		BylineSize:  32,
+		BylineColor: color.Black,
+	}
+	img := card.Image()
+	assert.Equal(t, 1200, img.Bounds().Dx())
+	assert.Equal(t, 630, img.Bounds().Dy())
+	r, g, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+}
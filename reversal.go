@@ -0,0 +1,61 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"errors"
+	"time"
+)
+
+const (
+	REVERSAL_CHARGE_REFUND  = "Charge Refund"
+	REVERSAL_YIELD_REVERSAL = "Yield Reversal"
+	REVERSAL_GIFT_REFUND    = "Gift Refund"
+	REVERSAL_GIFT_REVERSAL  = "Gift Reversal"
+)
+
+var ErrRefundPolicyUnrecognized = errors.New("Unrecognized Refund Policy")
+
+// Reversal records coins returned to, or reclaimed from, an account when content is deleted.
+// A positive Amount credits the account, a negative Amount debits it.
+type Reversal struct {
+	ID             int64
+	Account        *authgo.Account
+	ConversationID int64
+	MessageID      int64
+	GiftID         int64
+	Reason         string
+	Amount         int64
+	Created        time.Time
+}
+
+// RefundPolicy decides whether deleting content created at the first time, and deleted at the second time, reverses the coins it moved.
+type RefundPolicy func(time.Time, time.Time) bool
+
+func FullRefund(created, deleted time.Time) bool {
+	return true
+}
+
+func NoRefund(created, deleted time.Time) bool {
+	return false
+}
+
+func RefundWindow(window time.Duration) RefundPolicy {
+	return func(created, deleted time.Time) bool {
+		return deleted.Sub(created) <= window
+	}
+}
+
+// ParseRefundPolicy accepts "full", "none", or a duration (such as "24h") for a time-limited refund window.
+func ParseRefundPolicy(policy string) (RefundPolicy, error) {
+	switch policy {
+	case "", "full":
+		return FullRefund, nil
+	case "none":
+		return NoRefund, nil
+	}
+	window, err := time.ParseDuration(policy)
+	if err != nil || window < 0 {
+		return nil, ErrRefundPolicyUnrecognized
+	}
+	return RefundWindow(window), nil
+}
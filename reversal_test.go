@@ -0,0 +1,50 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseRefundPolicy(t *testing.T) {
+	created := time.Now()
+	for name, tt := range map[string]struct {
+		policy   string
+		deleted  time.Time
+		expected bool
+	}{
+		"Default": {
+			deleted:  created.Add(time.Hour),
+			expected: true,
+		},
+		"Full": {
+			policy:   "full",
+			deleted:  created.Add(time.Hour),
+			expected: true,
+		},
+		"None": {
+			policy:  "none",
+			deleted: created,
+		},
+		"Window_Inside": {
+			policy:   "24h",
+			deleted:  created.Add(time.Hour),
+			expected: true,
+		},
+		"Window_Outside": {
+			policy:  "24h",
+			deleted: created.Add(25 * time.Hour),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			policy, err := conveyearthgo.ParseRefundPolicy(tt.policy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, policy(created, tt.deleted))
+		})
+	}
+	t.Run("Unrecognized", func(t *testing.T) {
+		_, err := conveyearthgo.ParseRefundPolicy("sometimes")
+		assert.Equal(t, conveyearthgo.ErrRefundPolicyUnrecognized, err)
+	})
+}
@@ -2,46 +2,12 @@ package conveyearthgo
 
 import (
 	"aletheiaware.com/authgo"
-	"fmt"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/account"
 	"log"
-	"strings"
 	"time"
 )
 
-func FormatStripeAmount(amount float64, currency stripe.Currency) string {
-	switch currency {
-	case stripe.CurrencyBGN:
-		// Format as decimal with lev size
-		price := fmt.Sprintf("%.2f", amount/100.0)
-		// Remove trailing zeros
-		price = strings.TrimRight(price, "0")
-		// Remove trailing point
-		price = strings.TrimRight(price, ".")
-		return price + " лв."
-	case stripe.CurrencyGBP:
-		// Format as decimal with pound size
-		price := fmt.Sprintf("£%.2f", amount/100.0)
-		// Remove trailing zeros
-		price = strings.TrimRight(price, "0")
-		// Remove trailing point
-		price = strings.TrimRight(price, ".")
-		return price
-	case stripe.CurrencyUSD:
-		// Format as decimal with dollar size
-		price := fmt.Sprintf("$%.2f", amount/100.0)
-		// Remove trailing zeros
-		price = strings.TrimRight(price, "0")
-		// Remove trailing point
-		price = strings.TrimRight(price, ".")
-		return price
-	default:
-		log.Println("Unhandled currency:", currency)
-	}
-	return ""
-}
-
 type StripeDatabase interface {
 	CreateStripeAccount(int64, string, time.Time) (int64, error)
 	SelectStripeAccount(int64) (string, time.Time, error)
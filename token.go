@@ -0,0 +1,211 @@
+package conveyearthgo
+
+import (
+	"aletheiaware.com/authgo"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	TOKEN_SCOPE_READ    = "read"
+	TOKEN_SCOPE_PUBLISH = "publish"
+	TOKEN_SCOPE_REPLY   = "reply"
+	TOKEN_SCOPE_GIFT    = "gift"
+	TOKEN_SCOPE_WRITE   = "write"
+)
+
+const (
+	MAXIMUM_TOKENS            = 10
+	MAXIMUM_TOKEN_NAME_LENGTH = 64
+	TOKEN_PREFIX              = "convey_"
+)
+
+// TokenScopes lists the scopes which can be granted to a token.
+var TokenScopes = []string{
+	TOKEN_SCOPE_READ,
+	TOKEN_SCOPE_PUBLISH,
+	TOKEN_SCOPE_REPLY,
+	TOKEN_SCOPE_GIFT,
+	TOKEN_SCOPE_WRITE,
+}
+
+var (
+	ErrTokenNotFound      = errors.New("Token Not Found")
+	ErrTokenInvalid       = errors.New("Invalid Token")
+	ErrTokenNameInvalid   = errors.New("Token Name Must Be Between 1 and 64 Characters")
+	ErrTokenScopeInvalid  = errors.New("Invalid Token Scope")
+	ErrTokenLimitExceeded = errors.New("Token Limit Exceeded")
+)
+
+// Token grants programmatic access to a user's account, limited to its scopes.
+type Token struct {
+	ID      int64
+	User    int64
+	Name    string
+	Scopes  []string
+	Created time.Time
+}
+
+// HasScope returns true if the token was granted the scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type TokenDatabase interface {
+	CreateToken(int64, string, string, string, time.Time) (int64, error)
+	DeleteToken(int64, int64, time.Time) (int64, error)
+	SelectToken(string) (int64, int64, string, string, time.Time, error)
+	SelectTokens(int64, func(int64, string, string, time.Time) error) error
+	SelectUserByID(int64) (string, string, time.Time, error)
+}
+
+type TokenManager interface {
+	Create(int64, string, []string) (*Token, string, error)
+	Revoke(int64, int64) error
+	Tokens(int64, func(*Token) error) error
+	Authenticate(string) (*authgo.Account, *Token, error)
+}
+
+func NewTokenManager(db TokenDatabase) TokenManager {
+	return &tokenManager{
+		database: db,
+	}
+}
+
+type tokenManager struct {
+	database TokenDatabase
+}
+
+// Create issues a token for the user with the given name and scopes, and returns it along with its secret, which is only stored hashed so cannot be retrieved again.
+func (m *tokenManager) Create(user int64, name string, scopes []string) (*Token, string, error) {
+	if err := ValidateTokenName(name); err != nil {
+		return nil, "", err
+	}
+	scopes, err := ValidateTokenScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	var count int
+	if err := m.database.SelectTokens(user, func(int64, string, string, time.Time) error {
+		count++
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	if count >= MAXIMUM_TOKENS {
+		return nil, "", ErrTokenLimitExceeded
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	secret := TOKEN_PREFIX + hex.EncodeToString(key)
+	created := time.Now()
+	id, err := m.database.CreateToken(user, name, hashToken(secret), strings.Join(scopes, " "), created)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Println("Created Token", id)
+	return &Token{
+		ID:      id,
+		User:    user,
+		Name:    name,
+		Scopes:  scopes,
+		Created: created,
+	}, secret, nil
+}
+
+func (m *tokenManager) Revoke(user, id int64) error {
+	count, err := m.database.DeleteToken(user, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrTokenNotFound
+	}
+	log.Println("Deleted Token", id)
+	return nil
+}
+
+func (m *tokenManager) Tokens(user int64, callback func(*Token) error) error {
+	return m.database.SelectTokens(user, func(id int64, name, scopes string, created time.Time) error {
+		return callback(&Token{
+			ID:      id,
+			User:    user,
+			Name:    name,
+			Scopes:  strings.Fields(scopes),
+			Created: created,
+		})
+	})
+}
+
+// Authenticate returns the account and token identified by the secret.
+func (m *tokenManager) Authenticate(secret string) (*authgo.Account, *Token, error) {
+	if !strings.HasPrefix(secret, TOKEN_PREFIX) {
+		return nil, nil, ErrTokenInvalid
+	}
+	id, user, name, scopes, created, err := m.database.SelectToken(hashToken(secret))
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrTokenInvalid
+	}
+	username, email, joined, err := m.database.SelectUserByID(user)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrTokenInvalid
+	}
+	return &authgo.Account{
+		ID:       user,
+		Username: username,
+		Email:    email,
+		Created:  joined,
+	}, &Token{
+		ID:      id,
+		User:    user,
+		Name:    name,
+		Scopes:  strings.Fields(scopes),
+		Created: created,
+	}, nil
+}
+
+func ValidateTokenName(name string) error {
+	if name == "" || len(name) > MAXIMUM_TOKEN_NAME_LENGTH {
+		return ErrTokenNameInvalid
+	}
+	return nil
+}
+
+// ValidateTokenScopes returns the given scopes in canonical order without duplicates, or an error if any are unknown or none are given.
+func ValidateTokenScopes(scopes []string) ([]string, error) {
+	requested := make(map[string]bool)
+	for _, s := range scopes {
+		requested[s] = true
+	}
+	var valid []string
+	for _, s := range TokenScopes {
+		if requested[s] {
+			valid = append(valid, s)
+			delete(requested, s)
+		}
+	}
+	if len(valid) == 0 || len(requested) > 0 {
+		return nil, ErrTokenScopeInvalid
+	}
+	return valid, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of the secret. Secrets are random so do not need a slow hash.
+func hashToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,116 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidateTokenScopes(t *testing.T) {
+	for name, tt := range map[string]struct {
+		scopes []string
+		want   []string
+		err    error
+	}{
+		"Single":    {scopes: []string{"read"}, want: []string{"read"}},
+		"Ordered":   {scopes: []string{"gift", "read", "publish"}, want: []string{"read", "publish", "gift"}},
+		"Duplicate": {scopes: []string{"reply", "reply"}, want: []string{"reply"}},
+		"Empty":     {err: conveyearthgo.ErrTokenScopeInvalid},
+		"Unknown":   {scopes: []string{"read", "admin"}, err: conveyearthgo.ErrTokenScopeInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scopes, err := conveyearthgo.ValidateTokenScopes(tt.scopes)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, scopes)
+		})
+	}
+}
+
+func TestTokenManager(t *testing.T) {
+	t.Run("Create", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		tm := conveyearthgo.NewTokenManager(db)
+		token, secret, err := tm.Create(acc.ID, "Script", []string{"publish", "read"})
+		assert.Nil(t, err)
+		assert.NotZero(t, token.ID)
+		assert.Equal(t, acc.ID, token.User)
+		assert.Equal(t, "Script", token.Name)
+		assert.Equal(t, []string{"read", "publish"}, token.Scopes)
+		assert.True(t, strings.HasPrefix(secret, conveyearthgo.TOKEN_PREFIX))
+		// Only the hash of the secret is stored
+		for _, h := range db.TokenHash {
+			assert.NotContains(t, h, strings.TrimPrefix(secret, conveyearthgo.TOKEN_PREFIX))
+		}
+		var tokens []*conveyearthgo.Token
+		assert.Nil(t, tm.Tokens(acc.ID, func(tk *conveyearthgo.Token) error {
+			tokens = append(tokens, tk)
+			return nil
+		}))
+		assert.Equal(t, 1, len(tokens))
+		assert.Equal(t, token.ID, tokens[0].ID)
+		assert.Equal(t, token.Scopes, tokens[0].Scopes)
+	})
+	t.Run("Create Rejects Invalid Name", func(t *testing.T) {
+		db := database.NewInMemory()
+		tm := conveyearthgo.NewTokenManager(db)
+		_, _, err := tm.Create(1, "", []string{"read"})
+		assert.Equal(t, conveyearthgo.ErrTokenNameInvalid, err)
+		_, _, err = tm.Create(1, strings.Repeat("x", conveyearthgo.MAXIMUM_TOKEN_NAME_LENGTH+1), []string{"read"})
+		assert.Equal(t, conveyearthgo.ErrTokenNameInvalid, err)
+	})
+	t.Run("Create Enforces Limit", func(t *testing.T) {
+		db := database.NewInMemory()
+		tm := conveyearthgo.NewTokenManager(db)
+		for i := 0; i < conveyearthgo.MAXIMUM_TOKENS; i++ {
+			_, _, err := tm.Create(1, fmt.Sprintf("Token%d", i), []string{"read"})
+			assert.Nil(t, err)
+		}
+		_, _, err := tm.Create(1, "Another", []string{"read"})
+		assert.Equal(t, conveyearthgo.ErrTokenLimitExceeded, err)
+	})
+	t.Run("Authenticate", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		tm := conveyearthgo.NewTokenManager(db)
+		created, secret, err := tm.Create(acc.ID, "Script", []string{"gift"})
+		assert.Nil(t, err)
+		account, token, err := tm.Authenticate(secret)
+		assert.Nil(t, err)
+		assert.Equal(t, acc.ID, account.ID)
+		assert.Equal(t, acc.Username, account.Username)
+		assert.Equal(t, created.ID, token.ID)
+		assert.True(t, token.HasScope(conveyearthgo.TOKEN_SCOPE_GIFT))
+		assert.False(t, token.HasScope(conveyearthgo.TOKEN_SCOPE_PUBLISH))
+
+		_, _, err = tm.Authenticate(secret + "0")
+		assert.Equal(t, conveyearthgo.ErrTokenInvalid, err)
+		_, _, err = tm.Authenticate(strings.TrimPrefix(secret, conveyearthgo.TOKEN_PREFIX))
+		assert.Equal(t, conveyearthgo.ErrTokenInvalid, err)
+	})
+	t.Run("Revoke", func(t *testing.T) {
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		tm := conveyearthgo.NewTokenManager(db)
+		token, secret, err := tm.Create(acc.ID, "Script", []string{"read"})
+		assert.Nil(t, err)
+		// Tokens can only be revoked by their owner
+		assert.Equal(t, conveyearthgo.ErrTokenNotFound, tm.Revoke(acc.ID+1, token.ID))
+		assert.Nil(t, tm.Revoke(acc.ID, token.ID))
+		assert.Equal(t, conveyearthgo.ErrTokenNotFound, tm.Revoke(acc.ID, token.ID))
+		_, _, err = tm.Authenticate(secret)
+		assert.Equal(t, conveyearthgo.ErrTokenInvalid, err)
+		assert.Nil(t, tm.Tokens(acc.ID, func(*conveyearthgo.Token) error {
+			t.Fatal("Unexpected Token")
+			return nil
+		}))
+	})
+}
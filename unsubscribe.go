@@ -0,0 +1,79 @@
+package conveyearthgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UNSUBSCRIBE_RESPONSES    = "responses"
+	UNSUBSCRIBE_MENTIONS     = "mentions"
+	UNSUBSCRIBE_GIFTS        = "gifts"
+	UNSUBSCRIBE_YIELDS       = "yields"
+	UNSUBSCRIBE_DIGESTS      = "digests"
+	UNSUBSCRIBE_REPLIES      = "replies"
+	UNSUBSCRIBE_PUBLICATIONS = "publications"
+	UNSUBSCRIBE_SUMMARIES    = "summaries"
+)
+
+// UNSUBSCRIBE_TOKEN_VALIDITY is how long an unsubscribe link works after the email is sent, comfortably longer than the 30 days required of commercial email.
+const UNSUBSCRIBE_TOKEN_VALIDITY = 60 * 24 * time.Hour
+
+var (
+	ErrUnsubscribeCategoryUnrecognized = errors.New("Unrecognized Unsubscribe Category")
+	ErrUnsubscribeTokenInvalid         = errors.New("Invalid Unsubscribe Token")
+	ErrUnsubscribeTokenExpired         = errors.New("Unsubscribe Token Expired")
+)
+
+// UnsubscribeToken identifies the user and category, and is signed so an unsubscribe link can be trusted without a session until it expires.
+func UnsubscribeToken(secret []byte, user int64, category string, expiry time.Time) string {
+	payload := fmt.Sprintf("%d.%s.%d", user, category, expiry.Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signUnsubscribe(secret, payload))
+}
+
+// ParseUnsubscribeToken verifies the token's signature and expiry, and returns the user and category it identifies.
+func ParseUnsubscribeToken(secret []byte, token string, now time.Time) (int64, string, error) {
+	if len(secret) == 0 {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	if !hmac.Equal(signature, signUnsubscribe(secret, strings.Join(parts[:3], "."))) {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	user, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, "", ErrUnsubscribeTokenInvalid
+	}
+	if now.After(time.Unix(expiry, 0)) {
+		return 0, "", ErrUnsubscribeTokenExpired
+	}
+	return user, parts[1], nil
+}
+
+// UnsubscribeLink returns a link to unsubscribe the user from the category, which expires after UNSUBSCRIBE_TOKEN_VALIDITY.
+func UnsubscribeLink(scheme, host string, secret []byte, user int64, category string) string {
+	return fmt.Sprintf("%s://%s/unsubscribe?token=%s", scheme, host, UnsubscribeToken(secret, user, category, time.Now().Add(UNSUBSCRIBE_TOKEN_VALIDITY)))
+}
+
+func signUnsubscribe(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
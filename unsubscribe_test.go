@@ -0,0 +1,79 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/conveyearthgo"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	expiry := now.Add(time.Hour)
+	token := conveyearthgo.UnsubscribeToken(secret, 42, conveyearthgo.UNSUBSCRIBE_GIFTS, expiry)
+	for name, tt := range map[string]struct {
+		secret   []byte
+		token    string
+		user     int64
+		category string
+		err      error
+	}{
+		"Valid": {
+			secret:   secret,
+			token:    token,
+			user:     42,
+			category: conveyearthgo.UNSUBSCRIBE_GIFTS,
+		},
+		"Wrong Secret": {
+			secret: []byte("other"),
+			token:  token,
+			err:    conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+		"No Secret": {
+			token: token,
+			err:   conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+		"Different User": {
+			secret: secret,
+			token:  strings.Replace(token, "42.", "43.", 1),
+			err:    conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+		"Different Category": {
+			secret: secret,
+			token:  strings.Replace(token, ".gifts.", ".digests.", 1),
+			err:    conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+		"Expired": {
+			secret: secret,
+			token:  conveyearthgo.UnsubscribeToken(secret, 42, conveyearthgo.UNSUBSCRIBE_GIFTS, now.Add(-time.Second)),
+			err:    conveyearthgo.ErrUnsubscribeTokenExpired,
+		},
+		"Extended Expiry": {
+			secret: secret,
+			token:  strings.Replace(token, strconv.FormatInt(expiry.Unix(), 10), strconv.FormatInt(expiry.Add(24*time.Hour).Unix(), 10), 1),
+			err:    conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+		"Malformed": {
+			secret: secret,
+			token:  "garbage",
+			err:    conveyearthgo.ErrUnsubscribeTokenInvalid,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			user, category, err := conveyearthgo.ParseUnsubscribeToken(tt.secret, tt.token, now)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.user, user)
+			assert.Equal(t, tt.category, category)
+		})
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	link := conveyearthgo.UnsubscribeLink("https", "convey.earth", []byte("secret"), 42, conveyearthgo.UNSUBSCRIBE_DIGESTS)
+	assert.True(t, strings.HasPrefix(link, "https://convey.earth/unsubscribe?token=42.digests."))
+	// Links are included verbatim in plain text emails and headers
+	assert.False(t, strings.ContainsAny(link, "&<> "))
+}
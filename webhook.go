@@ -0,0 +1,385 @@
+package conveyearthgo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	WEBHOOK_CONVERSATION = "conversation"
+	WEBHOOK_REPLY        = "reply"
+	WEBHOOK_GIFT         = "gift"
+	WEBHOOK_DELETION     = "deletion"
+)
+
+const (
+	WEBHOOK_HEADER_EVENT     = "X-Convey-Event"
+	WEBHOOK_HEADER_DELIVERY  = "X-Convey-Delivery"
+	WEBHOOK_HEADER_SIGNATURE = "X-Convey-Signature"
+	WEBHOOK_HEADER_TIMESTAMP = "X-Convey-Timestamp"
+)
+
+// WEBHOOK_TOLERANCE is how far a delivery's timestamp may be from the receiver's clock before the delivery should be rejected as a replay.
+const WEBHOOK_TOLERANCE = 5 * time.Minute
+
+// WEBHOOK_LEASE is how long a claimed delivery is hidden from other workers while it is being posted.
+const WEBHOOK_LEASE = 10 * time.Minute
+
+const (
+	MAXIMUM_WEBHOOKS           = 10
+	MAXIMUM_WEBHOOK_URL_LENGTH = 255
+)
+
+var (
+	ErrWebhookNotFound      = errors.New("Webhook Not Found")
+	ErrWebhookURLInvalid    = errors.New("Invalid Webhook URL")
+	ErrWebhookLimitExceeded = errors.New("Webhook Limit Exceeded")
+	ErrWebhookUnreachable   = errors.New("Webhook Unreachable")
+	ErrWebhookSignature     = errors.New("Invalid Webhook Signature")
+	ErrWebhookExpired       = errors.New("Webhook Timestamp Outside Tolerance")
+)
+
+// Webhook is an endpoint which receives events. Webhooks without a user belong to operators and receive every event.
+type Webhook struct {
+	ID      int64
+	User    int64
+	URL     string
+	Secret  string
+	Created time.Time
+}
+
+// WebhookEvent is the JSON payload posted to webhooks.
+type WebhookEvent struct {
+	Kind         string    `json:"event"`
+	Actor        string    `json:"actor"`
+	Conversation int64     `json:"conversation"`
+	Message      int64     `json:"message,omitempty"`
+	Gift         int64     `json:"gift,omitempty"`
+	Topic        string    `json:"topic"`
+	Amount       int64     `json:"amount,omitempty"`
+	Link         string    `json:"link"`
+	Created      time.Time `json:"created"`
+}
+
+// WebhookDelivery is an attempt to post an event to a webhook.
+type WebhookDelivery struct {
+	ID         int64
+	Kind       string
+	Payload    []byte
+	Attempts   int64
+	StatusCode int64
+	LastError  string
+	Created    time.Time
+	Delivered  time.Time
+	Dead       time.Time
+}
+
+type WebhookDatabase interface {
+	CreateWebhook(int64, string, string, time.Time) (int64, error)
+	DeleteWebhook(int64, int64, time.Time) (int64, error)
+	SelectWebhook(int64) (int64, string, string, time.Time, error)
+	SelectWebhooks(int64, func(int64, string, string, time.Time) error) error
+	SelectEventWebhooks([]int64, func(int64) error) error
+	CreateWebhookDelivery(int64, string, []byte, time.Time) (int64, error)
+	ClaimDueWebhookDeliveries(time.Time, time.Time, int64, func(int64, string, string, string, []byte, int64) error) error
+	SelectWebhookDeliveries(int64, int64, func(int64, string, []byte, int64, int64, string, time.Time, time.Time, time.Time) error) error
+	UpdateWebhookDeliverySent(int64, int64, time.Time) (int64, error)
+	UpdateWebhookDeliveryRetry(int64, int64, int64, time.Time, string) (int64, error)
+	UpdateWebhookDeliveryDead(int64, int64, int64, time.Time, string) (int64, error)
+}
+
+type WebhookManager interface {
+	Register(int64, string) (*Webhook, error)
+	Remove(int64, int64) error
+	Webhooks(int64, func(*Webhook) error) error
+	Deliveries(int64, int64, int64, func(*WebhookDelivery) error) error
+	Emit(*WebhookEvent, ...int64) error
+	Process(int64) (int64, error)
+}
+
+// NewWebhookManager posts events to webhooks with the given client, retrying failures with exponential backoff until the maximum attempts are reached.
+// Webhook URLs are supplied by users, so in production the client should be a NewPublicClient.
+func NewWebhookManager(db WebhookDatabase, client *http.Client, attempts int64, backoff time.Duration) WebhookManager {
+	return &webhookManager{
+		database: db,
+		client:   client,
+		attempts: attempts,
+		backoff:  backoff,
+	}
+}
+
+type webhookManager struct {
+	database WebhookDatabase
+	client   *http.Client
+	attempts int64
+	backoff  time.Duration
+}
+
+// Register creates a webhook for the user, or for operators if the user is zero, with a new secret used to sign deliveries.
+func (m *webhookManager) Register(user int64, address string) (*Webhook, error) {
+	if err := ValidateWebhookURL(address); err != nil {
+		return nil, err
+	}
+	if user != 0 {
+		var count int
+		if err := m.database.SelectWebhooks(user, func(int64, string, string, time.Time) error {
+			count++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if count >= MAXIMUM_WEBHOOKS {
+			return nil, ErrWebhookLimitExceeded
+		}
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(key)
+	created := time.Now()
+	id, err := m.database.CreateWebhook(user, address, secret, created)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created Webhook", id)
+	return &Webhook{
+		ID:      id,
+		User:    user,
+		URL:     address,
+		Secret:  secret,
+		Created: created,
+	}, nil
+}
+
+func (m *webhookManager) Remove(user, id int64) error {
+	count, err := m.database.DeleteWebhook(user, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWebhookNotFound
+	}
+	log.Println("Deleted Webhook", id)
+	return nil
+}
+
+func (m *webhookManager) Webhooks(user int64, callback func(*Webhook) error) error {
+	return m.database.SelectWebhooks(user, func(id int64, address, secret string, created time.Time) error {
+		return callback(&Webhook{
+			ID:      id,
+			User:    user,
+			URL:     address,
+			Secret:  secret,
+			Created: created,
+		})
+	})
+}
+
+// Deliveries calls the callback with the most recent deliveries to the user's webhook, newest first.
+func (m *webhookManager) Deliveries(user, webhook, limit int64, callback func(*WebhookDelivery) error) error {
+	owner, _, _, _, err := m.database.SelectWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	if owner != user {
+		return ErrWebhookNotFound
+	}
+	return m.database.SelectWebhookDeliveries(webhook, limit, func(id int64, kind string, payload []byte, attempts, status int64, lastError string, created, delivered, dead time.Time) error {
+		return callback(&WebhookDelivery{
+			ID:         id,
+			Kind:       kind,
+			Payload:    payload,
+			Attempts:   attempts,
+			StatusCode: status,
+			LastError:  lastError,
+			Created:    created,
+			Delivered:  delivered,
+			Dead:       dead,
+		})
+	})
+}
+
+// Emit queues a delivery of the event to each operator webhook, and to the webhooks of the given users.
+func (m *webhookManager) Emit(event *WebhookEvent, users ...int64) error {
+	if event.Created.IsZero() {
+		event.Created = time.Now()
+	}
+	if event.Link == "" {
+		event.Link = createLink(Scheme(), Host(), event.Conversation, event.Message)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var webhooks []int64
+	if err := m.database.SelectEventWebhooks(users, func(id int64) error {
+		webhooks = append(webhooks, id)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, w := range webhooks {
+		id, err := m.database.CreateWebhookDelivery(w, event.Kind, payload, event.Created)
+		if err != nil {
+			return err
+		}
+		log.Println("Created Webhook Delivery", id, event.Kind)
+	}
+	return nil
+}
+
+// Process posts up to limit deliveries that are due, and returns the number delivered.
+func (m *webhookManager) Process(limit int64) (int64, error) {
+	now := time.Now()
+	type due struct {
+		id       int64
+		address  string
+		secret   string
+		kind     string
+		payload  []byte
+		attempts int64
+	}
+	var deliveries []*due
+	if err := m.database.ClaimDueWebhookDeliveries(now, now.Add(WEBHOOK_LEASE), limit, func(id int64, address, secret, kind string, payload []byte, attempts int64) error {
+		deliveries = append(deliveries, &due{
+			id:       id,
+			address:  address,
+			secret:   secret,
+			kind:     kind,
+			payload:  payload,
+			attempts: attempts,
+		})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	var delivered int64
+	for _, d := range deliveries {
+		status, err := m.post(d.id, d.address, d.secret, d.kind, d.payload)
+		if err == nil {
+			if _, err := m.database.UpdateWebhookDeliverySent(d.id, status, time.Now()); err != nil {
+				return delivered, err
+			}
+			delivered++
+			continue
+		}
+		log.Println("Webhook Delivery", d.id, "Failed:", err)
+		reason := err.Error()
+		attempts := d.attempts + 1
+		if attempts >= m.attempts {
+			if _, err := m.database.UpdateWebhookDeliveryDead(d.id, attempts, status, time.Now(), reason); err != nil {
+				return delivered, err
+			}
+			log.Println("Webhook Delivery", d.id, "Abandoned After", attempts, "Attempts")
+			continue
+		}
+		if _, err := m.database.UpdateWebhookDeliveryRetry(d.id, attempts, status, now.Add(Backoff(m.backoff, attempts)), reason); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+func (m *webhookManager) post(id int64, address, secret, kind string, payload []byte) (int64, error) {
+	request, err := http.NewRequest(http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WEBHOOK_HEADER_EVENT, kind)
+	request.Header.Set(WEBHOOK_HEADER_DELIVERY, strconv.FormatInt(id, 10))
+	timestamp := time.Now().Unix()
+	request.Header.Set(WEBHOOK_HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(WEBHOOK_HEADER_SIGNATURE, SignWebhook(secret, timestamp, payload))
+	response, err := m.client.Do(request)
+	if err != nil {
+		// The cause can describe the network behind the endpoint, so users are only shown a summary
+		log.Println("Webhook Delivery", id, err)
+		if errors.Is(err, ErrAddressForbidden) {
+			return 0, ErrAddressForbidden
+		}
+		return 0, ErrWebhookUnreachable
+	}
+	defer response.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	status := int64(response.StatusCode)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return status, fmt.Errorf("Unexpected Status: %s", response.Status)
+	}
+	return status, nil
+}
+
+// SignWebhook returns the signature of the timestamp and payload, joined by a period, which receivers can recompute with the webhook's secret to verify a delivery.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature and timestamp headers of a delivery, rejecting deliveries signed more than WEBHOOK_TOLERANCE from now so a captured request cannot be replayed later.
+func VerifyWebhook(secret, timestamp, signature string, payload []byte, now time.Time) error {
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, t, payload))) {
+		return ErrWebhookSignature
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > WEBHOOK_TOLERANCE || d < -WEBHOOK_TOLERANCE {
+		return ErrWebhookExpired
+	}
+	return nil
+}
+
+// ValidateWebhookURL accepts absolute https URLs. Whether the host is public is checked when connecting, as that is when it is resolved.
+func ValidateWebhookURL(address string) error {
+	if len(address) > MAXIMUM_WEBHOOK_URL_LENGTH {
+		return ErrWebhookURLInvalid
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return ErrWebhookURLInvalid
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return ErrWebhookURLInvalid
+	}
+	return nil
+}
+
+// RunWebhooks processes webhook deliveries at the given interval, until the returned function is called.
+func RunWebhooks(m WebhookManager, interval time.Duration, limit int64) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := m.Process(limit); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,347 @@
+package conveyearthgo_test
+
+import (
+	"aletheiaware.com/authgo"
+	"aletheiaware.com/authgo/authtest"
+	"aletheiaware.com/conveyearthgo"
+	"aletheiaware.com/conveyearthgo/database"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func (r *webhookReceiver) received() ([]*http.Request, [][]byte) {
+	r.Lock()
+	defer r.Unlock()
+	return r.requests, r.bodies
+}
+
+func (r *webhookReceiver) respond(status int) {
+	r.Lock()
+	defer r.Unlock()
+	r.status = status
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	for name, tt := range map[string]struct {
+		url string
+		err error
+	}{
+		"HTTPS":    {url: "https://example.com/hook"},
+		"HTTP":     {url: "http://example.com/hook", err: conveyearthgo.ErrWebhookURLInvalid},
+		"Empty":    {url: "", err: conveyearthgo.ErrWebhookURLInvalid},
+		"Relative": {url: "/hook", err: conveyearthgo.ErrWebhookURLInvalid},
+		"Scheme":   {url: "ftp://example.com/hook", err: conveyearthgo.ErrWebhookURLInvalid},
+		"Too Long": {url: "https://example.com/" + strings.Repeat("x", conveyearthgo.MAXIMUM_WEBHOOK_URL_LENGTH), err: conveyearthgo.ErrWebhookURLInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.err, conveyearthgo.ValidateWebhookURL(tt.url))
+		})
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := "secret"
+	payload := []byte(`{"event":"gift"}`)
+	now := time.Unix(1600000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := conveyearthgo.SignWebhook(secret, now.Unix(), payload)
+	for name, tt := range map[string]struct {
+		secret    string
+		timestamp string
+		signature string
+		payload   []byte
+		now       time.Time
+		err       error
+	}{
+		"Valid":             {secret: secret, timestamp: timestamp, signature: signature, payload: payload, now: now},
+		"Within Tolerance":  {secret: secret, timestamp: timestamp, signature: signature, payload: payload, now: now.Add(conveyearthgo.WEBHOOK_TOLERANCE)},
+		"Replayed":          {secret: secret, timestamp: timestamp, signature: signature, payload: payload, now: now.Add(conveyearthgo.WEBHOOK_TOLERANCE + time.Second), err: conveyearthgo.ErrWebhookExpired},
+		"From Future":       {secret: secret, timestamp: timestamp, signature: signature, payload: payload, now: now.Add(-conveyearthgo.WEBHOOK_TOLERANCE - time.Second), err: conveyearthgo.ErrWebhookExpired},
+		"Wrong Secret":      {secret: "other", timestamp: timestamp, signature: signature, payload: payload, now: now, err: conveyearthgo.ErrWebhookSignature},
+		"Altered Payload":   {secret: secret, timestamp: timestamp, signature: signature, payload: []byte(`{"event":"reply"}`), now: now, err: conveyearthgo.ErrWebhookSignature},
+		"Altered Timestamp": {secret: secret, timestamp: strconv.FormatInt(now.Unix()+1, 10), signature: signature, payload: payload, now: now, err: conveyearthgo.ErrWebhookSignature},
+		"Missing Timestamp": {secret: secret, signature: signature, payload: payload, now: now, err: conveyearthgo.ErrWebhookSignature},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.err, conveyearthgo.VerifyWebhook(tt.secret, tt.timestamp, tt.signature, tt.payload, tt.now))
+		})
+	}
+}
+
+func TestWebhookManager(t *testing.T) {
+	t.Run("Delivers Signed Events", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		wm := conveyearthgo.NewWebhookManager(db, server.Client(), 3, time.Minute)
+
+		webhook, err := wm.Register(acc.ID, server.URL)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, webhook.Secret)
+
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_GIFT,
+			Actor:        "alice",
+			Conversation: 1,
+			Message:      2,
+			Gift:         3,
+			Topic:        "Test",
+			Amount:       50,
+		}, acc.ID))
+
+		// Events are only sent by the worker
+		requests, _ := receiver.received()
+		assert.Empty(t, requests)
+
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), delivered)
+		requests, bodies := receiver.received()
+		assert.Equal(t, 1, len(requests))
+		request := requests[0]
+		body := bodies[0]
+		assert.Equal(t, http.MethodPost, request.Method)
+		assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+		assert.Equal(t, conveyearthgo.WEBHOOK_GIFT, request.Header.Get(conveyearthgo.WEBHOOK_HEADER_EVENT))
+		assert.NotEmpty(t, request.Header.Get(conveyearthgo.WEBHOOK_HEADER_DELIVERY))
+		assert.NoError(t, conveyearthgo.VerifyWebhook(webhook.Secret, request.Header.Get(conveyearthgo.WEBHOOK_HEADER_TIMESTAMP), request.Header.Get(conveyearthgo.WEBHOOK_HEADER_SIGNATURE), body, time.Now()))
+
+		event := &conveyearthgo.WebhookEvent{}
+		assert.NoError(t, json.Unmarshal(body, event))
+		assert.Equal(t, conveyearthgo.WEBHOOK_GIFT, event.Kind)
+		assert.Equal(t, "alice", event.Actor)
+		assert.Equal(t, int64(1), event.Conversation)
+		assert.Equal(t, int64(2), event.Message)
+		assert.Equal(t, int64(3), event.Gift)
+		assert.Equal(t, int64(50), event.Amount)
+		assert.True(t, strings.HasSuffix(event.Link, "/conversation?id=1#message2"))
+
+		var deliveries []*conveyearthgo.WebhookDelivery
+		assert.NoError(t, wm.Deliveries(acc.ID, webhook.ID, 10, func(d *conveyearthgo.WebhookDelivery) error {
+			deliveries = append(deliveries, d)
+			return nil
+		}))
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, int64(http.StatusOK), deliveries[0].StatusCode)
+		assert.False(t, deliveries[0].Delivered.IsZero())
+
+		// Delivered events are not sent again
+		delivered, err = wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		requests, _ = receiver.received()
+		assert.Equal(t, 1, len(requests))
+	})
+	t.Run("Only Sends Events To Involved Users And Operators", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		involved := authtest.NewTestAccount(t, auth)
+		uninvolved, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.NoError(t, err)
+		wm := conveyearthgo.NewWebhookManager(db, server.Client(), 3, time.Minute)
+
+		_, err = wm.Register(involved.ID, server.URL+"/involved")
+		assert.NoError(t, err)
+		_, err = wm.Register(uninvolved.ID, server.URL+"/uninvolved")
+		assert.NoError(t, err)
+		_, err = wm.Register(0, server.URL+"/operator")
+		assert.NoError(t, err)
+
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_CONVERSATION,
+			Conversation: 1,
+		}, involved.ID))
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), delivered)
+		var paths []string
+		requests, _ := receiver.received()
+		for _, r := range requests {
+			paths = append(paths, r.URL.Path)
+		}
+		assert.ElementsMatch(t, []string{"/involved", "/operator"}, paths)
+	})
+	t.Run("Retries Failed Deliveries", func(t *testing.T) {
+		receiver := &webhookReceiver{
+			status: http.StatusInternalServerError,
+		}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		wm := conveyearthgo.NewWebhookManager(db, server.Client(), 2, 0)
+
+		webhook, err := wm.Register(0, server.URL)
+		assert.NoError(t, err)
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_REPLY,
+			Conversation: 1,
+			Message:      2,
+		}))
+
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+
+		var deliveries []*conveyearthgo.WebhookDelivery
+		assert.NoError(t, wm.Deliveries(0, webhook.ID, 10, func(d *conveyearthgo.WebhookDelivery) error {
+			deliveries = append(deliveries, d)
+			return nil
+		}))
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, int64(1), deliveries[0].Attempts)
+		assert.Equal(t, int64(http.StatusInternalServerError), deliveries[0].StatusCode)
+		assert.NotEmpty(t, deliveries[0].LastError)
+		assert.True(t, deliveries[0].Dead.IsZero())
+
+		// Abandoned once the maximum attempts are reached
+		delivered, err = wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		deliveries = nil
+		assert.NoError(t, wm.Deliveries(0, webhook.ID, 10, func(d *conveyearthgo.WebhookDelivery) error {
+			deliveries = append(deliveries, d)
+			return nil
+		}))
+		assert.Equal(t, int64(2), deliveries[0].Attempts)
+		assert.False(t, deliveries[0].Dead.IsZero())
+
+		receiver.respond(http.StatusOK)
+		delivered, err = wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		requests, _ := receiver.received()
+		assert.Equal(t, 2, len(requests))
+	})
+	t.Run("Removed Webhooks Receive Nothing", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		auth := authgo.NewAuthenticator(db, authtest.NewEmailVerifier())
+		acc := authtest.NewTestAccount(t, auth)
+		other, err := auth.NewAccount("2"+authtest.TEST_EMAIL, authtest.TEST_USERNAME+"2", []byte(authtest.TEST_PASSWORD))
+		assert.NoError(t, err)
+		wm := conveyearthgo.NewWebhookManager(db, server.Client(), 3, time.Minute)
+
+		webhook, err := wm.Register(acc.ID, server.URL)
+		assert.NoError(t, err)
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_DELETION,
+			Conversation: 1,
+		}, acc.ID))
+
+		// Users cannot remove, or inspect, the webhooks of others
+		assert.Equal(t, conveyearthgo.ErrWebhookNotFound, wm.Remove(other.ID, webhook.ID))
+		assert.Equal(t, conveyearthgo.ErrWebhookNotFound, wm.Deliveries(other.ID, webhook.ID, 10, func(*conveyearthgo.WebhookDelivery) error {
+			return nil
+		}))
+
+		assert.NoError(t, wm.Remove(acc.ID, webhook.ID))
+		assert.Equal(t, conveyearthgo.ErrWebhookNotFound, wm.Remove(acc.ID, webhook.ID))
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		requests, _ := receiver.received()
+		assert.Empty(t, requests)
+	})
+	t.Run("Does Not Connect To Private Addresses", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		wm := conveyearthgo.NewWebhookManager(db, conveyearthgo.NewPublicClient(time.Second), 1, 0)
+
+		// The server is on a loopback address
+		webhook, err := wm.Register(0, server.URL)
+		assert.NoError(t, err)
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_CONVERSATION,
+			Conversation: 1,
+		}))
+
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		requests, _ := receiver.received()
+		assert.Empty(t, requests)
+
+		var deliveries []*conveyearthgo.WebhookDelivery
+		assert.NoError(t, wm.Deliveries(0, webhook.ID, 10, func(d *conveyearthgo.WebhookDelivery) error {
+			deliveries = append(deliveries, d)
+			return nil
+		}))
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, conveyearthgo.ErrAddressForbidden.Error(), deliveries[0].LastError)
+	})
+	t.Run("Claimed Deliveries Are Skipped", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		server := httptest.NewTLSServer(receiver)
+		defer server.Close()
+		db := database.NewInMemory()
+		wm := conveyearthgo.NewWebhookManager(db, server.Client(), 3, time.Minute)
+
+		_, err := wm.Register(0, server.URL)
+		assert.NoError(t, err)
+		assert.NoError(t, wm.Emit(&conveyearthgo.WebhookEvent{
+			Kind:         conveyearthgo.WEBHOOK_CONVERSATION,
+			Conversation: 1,
+		}))
+
+		// Another worker claims the delivery
+		now := time.Now()
+		var claimed int
+		assert.NoError(t, db.ClaimDueWebhookDeliveries(now, now.Add(conveyearthgo.WEBHOOK_LEASE), 10, func(int64, string, string, string, []byte, int64) error {
+			claimed++
+			return nil
+		}))
+		assert.Equal(t, 1, claimed)
+
+		delivered, err := wm.Process(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), delivered)
+		requests, _ := receiver.received()
+		assert.Empty(t, requests)
+	})
+	t.Run("Limits Webhooks Per User", func(t *testing.T) {
+		db := database.NewInMemory()
+		wm := conveyearthgo.NewWebhookManager(db, http.DefaultClient, 3, time.Minute)
+		for i := 0; i < conveyearthgo.MAXIMUM_WEBHOOKS; i++ {
+			_, err := wm.Register(1, "https://example.com/hook")
+			assert.NoError(t, err)
+		}
+		_, err := wm.Register(1, "https://example.com/hook")
+		assert.Equal(t, conveyearthgo.ErrWebhookLimitExceeded, err)
+	})
+}